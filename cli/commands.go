@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/casey/govalent/server/client"
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/models"
+)
+
+// The server's upper bound on the count query parameter
+const MAX_PAGE_COUNT = 1000
+
+// Repeatable string flag
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(val string) error {
+	*f = append(*f, val)
+	return nil
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// Parse the flags of a command, which must leave nargs positional args
+func parseArgs(fs *flag.FlagSet, args []string, nargs int) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &usageError{msg: err.Error()}
+	}
+	if fs.NArg() != nargs {
+		return usageErrorf("expected %d arguments, got %d", nargs, fs.NArg())
+	}
+	return nil
+}
+
+// govalent submit manifest.json
+func runSubmit(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("submit")
+	key := fs.String("key", "", "idempotency key; resubmissions with the same key return the original dispatch")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var manifest models.DispatchSchema
+	if err := json.NewDecoder(f).Decode(&manifest); err != nil {
+		return fmt.Errorf("Error reading manifest %s: %w", fs.Arg(0), err)
+	}
+	var res *models.DispatchSchema
+	if len(*key) > 0 {
+		res, err = a.client.SubmitDispatchWithKey(ctx, &manifest, *key)
+	} else {
+		res, err = a.client.SubmitDispatch(ctx, &manifest)
+	}
+	if err != nil {
+		return err
+	}
+	if a.output == OUTPUT_JSON {
+		return a.writeJSON(res)
+	}
+	return a.writeTable(dispatchHeader, [][]string{dispatchRow(&res.Metadata)})
+}
+
+// govalent ls
+func runList(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("ls")
+	var q client.DispatchQuery
+	var statuses stringsFlag
+	fs.Var(&statuses, "status", "only dispatches with this status; repeatable")
+	fs.StringVar(&q.Name, "name", "", "only workflows whose name contains this")
+	fs.IntVar(&q.Page, "page", 0, "zero-based page number")
+	fs.IntVar(&q.Count, "count", 20, fmt.Sprintf("dispatches per page, at most %d", MAX_PAGE_COUNT))
+	if err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	q.Status = statuses
+	res, err := a.client.ListDispatches(ctx, q)
+	if err != nil {
+		return err
+	}
+	if a.output == OUTPUT_JSON {
+		return a.writeJSON(res)
+	}
+	rows := make([][]string, len(res.Records))
+	for i := range res.Records {
+		rows[i] = dispatchRow(&res.Records[i])
+	}
+	if err := a.writeTable(dispatchHeader, rows); err != nil {
+		return err
+	}
+	if shown := q.Page*q.Count + len(res.Records); shown < res.Total {
+		fmt.Fprintf(a.out, "\n%d of %d dispatches; see --page\n", shown, res.Total)
+	}
+	return nil
+}
+
+type statusOutput struct {
+	Dispatch  *models.DispatchMeta    `json:"dispatch"`
+	Electrons []models.ElectronSchema `json:"electrons"`
+}
+
+// govalent status <dispatch_id>
+func runStatus(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("status")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	dispatch_id := fs.Arg(0)
+	meta, err := a.client.GetDispatch(ctx, dispatch_id)
+	if err != nil {
+		return err
+	}
+	res := statusOutput{Dispatch: meta, Electrons: []models.ElectronSchema{}}
+	q := client.ElectronQuery{Count: MAX_PAGE_COUNT, ExcludeAssets: true}
+	for {
+		page, err := a.client.ListElectrons(ctx, dispatch_id, q)
+		if err != nil {
+			return err
+		}
+		res.Electrons = append(res.Electrons, page.Records...)
+		if len(page.Records) < q.Count || len(res.Electrons) >= page.Total {
+			break
+		}
+		q.Page++
+	}
+	if a.output == OUTPUT_JSON {
+		return a.writeJSON(&res)
+	}
+	if err := a.writeTable(dispatchHeader, [][]string{dispatchRow(meta)}); err != nil {
+		return err
+	}
+	fmt.Fprintln(a.out)
+	rows := make([][]string, len(res.Electrons))
+	for i := range res.Electrons {
+		rows[i] = electronRow(&res.Electrons[i])
+	}
+	return a.writeTable(electronHeader, rows)
+}
+
+// govalent watch <dispatch_id>
+//
+// Ends once the dispatch reaches a terminal status or on interrupt
+func runWatch(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("watch")
+	after := fs.Int64("after", 0, "only events after this one; 0 replays the whole history")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	// Events are printed as they arrive, so columns have fixed widths
+	const row_format = "%-8s  %-19s  %-8s  %s\n"
+	if a.output == OUTPUT_TABLE {
+		fmt.Fprintf(a.out, row_format, eventHeader[0], eventHeader[1], eventHeader[2], eventHeader[3])
+	}
+	enc := json.NewEncoder(a.out)
+	var write_err error
+	err := a.client.StreamEvents(ctx, fs.Arg(0), *after, func(ev *models.StatusEvent) bool {
+		if a.output == OUTPUT_JSON {
+			// One event per line
+			write_err = enc.Encode(ev)
+		} else {
+			row := eventRow(ev)
+			_, write_err = fmt.Fprintf(a.out, row_format, row[0], row[1], row[2], row[3])
+		}
+		if write_err != nil {
+			return false
+		}
+		return !(ev.Kind == models.EVENT_KIND_DISPATCH_STATUS && common.IsTerminalStatus(ev.Status))
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return write_err
+}
+
+// govalent cancel <dispatch_id>
+//
+// Only records the CANCELLED status; the server does not yet submit
+// tasks to executors, so tasks already running are not stopped. Their
+// later status reports are rejected once the dispatch has finished.
+func runCancel(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("cancel")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	meta, err := a.client.GetDispatch(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if common.IsTerminalStatus(meta.Status) {
+		return fmt.Errorf("Dispatch %s has already finished with status %s", meta.DispatchId, meta.Status)
+	}
+	now := time.Now().UTC()
+	update := models.DispatchStatusUpdate{Status: common.STATUS_CANCELLED, StartTime: meta.StartTime, EndTime: &now}
+	ev, err := a.client.UpdateDispatchStatus(ctx, meta.DispatchId, update)
+	if err != nil {
+		return err
+	}
+	if a.output == OUTPUT_JSON {
+		return a.writeJSON(ev)
+	}
+	return a.writeTable(eventHeader, [][]string{eventRow(ev)})
+}
+
+// govalent rm <dispatch_id>
+func runRemove(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("rm")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	dispatch_id := fs.Arg(0)
+	if err := a.client.DeleteDispatch(ctx, dispatch_id); err != nil {
+		return err
+	}
+	if a.output == OUTPUT_JSON {
+		return a.writeJSON(map[string]string{"deleted": dispatch_id})
+	}
+	fmt.Fprintf(a.out, "Deleted %s\n", dispatch_id)
+	return nil
+}
+
+// govalent export <dispatch_id>
+//
+// The manifest is printed as JSON in either output mode
+func runExport(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("export")
+	if err := parseArgs(fs, args, 1); err != nil {
+		return err
+	}
+	manifest, err := a.client.ExportDispatch(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	return a.writeJSON(manifest)
+}
+
+// Node argument of download naming the dispatch's own assets
+const DISPATCH_NODE = "dispatch"
+
+// govalent download <dispatch_id> <node_id|dispatch> <asset>
+//
+// Writes the asset's contents to stdout unless --dest is given
+func runDownload(ctx context.Context, a *app, args []string) error {
+	fs := newFlagSet("download")
+	dest := fs.String("dest", "", "file to write; defaults to stdout")
+	if err := parseArgs(fs, args, 3); err != nil {
+		return err
+	}
+	dispatch_id, node, name := fs.Arg(0), fs.Arg(1), fs.Arg(2)
+	var links *models.AssetLinksResponse
+	var err error
+	if node == DISPATCH_NODE {
+		links, err = a.client.GetDispatchAssets(ctx, dispatch_id)
+	} else {
+		node_id, conv_err := strconv.Atoi(node)
+		if conv_err != nil {
+			return usageErrorf("expected an integer node id or %q, got %q", DISPATCH_NODE, node)
+		}
+		links, err = a.client.GetElectronAssets(ctx, dispatch_id, node_id)
+	}
+	if err != nil {
+		return err
+	}
+	var asset *models.AssetDetails
+	names := make([]string, 0, len(links.Records))
+	for i := range links.Records {
+		names = append(names, links.Records[i].Name)
+		if links.Records[i].Name == name {
+			asset = &links.Records[i].Asset
+		}
+	}
+	if asset == nil {
+		return fmt.Errorf("No asset %q; expected one of %s", name, strings.Join(names, ", "))
+	}
+	source := client.AssetSource(asset)
+	if len(source) == 0 {
+		return fmt.Errorf("Asset %q is empty", name)
+	}
+
+	if len(*dest) == 0 {
+		return a.client.DownloadAsset(ctx, source, a.out)
+	}
+	f, err := os.Create(*dest)
+	if err != nil {
+		return err
+	}
+	if err := a.client.DownloadAsset(ctx, source, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
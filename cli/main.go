@@ -0,0 +1,170 @@
+// Command-line client of the govalent API
+//
+//	govalent [flags] <command> [args]
+//
+// The server, token and namespace are taken from flags or the
+// GOVALENT_URL, GOVALENT_API_PREFIX, GOVALENT_TOKEN and
+// GOVALENT_NAMESPACE environment variables.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/casey/govalent/server/client"
+	"github.com/casey/govalent/server/common"
+)
+
+const (
+	URL_ENV        = "GOVALENT_URL"
+	API_PREFIX_ENV = "GOVALENT_API_PREFIX"
+	TOKEN_ENV      = "GOVALENT_TOKEN"
+	NAMESPACE_ENV  = "GOVALENT_NAMESPACE"
+)
+
+const (
+	OUTPUT_TABLE = "table"
+	OUTPUT_JSON  = "json"
+)
+
+// Exit codes
+const (
+	EXIT_OK    = 0
+	EXIT_ERROR = 1
+	EXIT_USAGE = 2
+)
+
+var DEFAULT_URL = fmt.Sprintf("http://localhost:%d", common.DEFAULT_PORT)
+
+// Reported with EXIT_USAGE
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...any) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+type command struct {
+	args    string
+	summary string
+	run     func(ctx context.Context, a *app, args []string) error
+}
+
+var commands = map[string]command{
+	"submit":   {args: "[--key KEY] <manifest.json>", summary: "Submit a manifest and upload the local files it refers to", run: runSubmit},
+	"ls":       {args: "[--status STATUS]... [--page N] [--count N]", summary: "List dispatches, newest first", run: runList},
+	"status":   {args: "<dispatch_id>", summary: "Show the status of a dispatch and its electrons", run: runStatus},
+	"watch":    {args: "[--after EVENT_ID] <dispatch_id>", summary: "Stream status events until the dispatch finishes", run: runWatch},
+	"cancel":   {args: "<dispatch_id>", summary: "Mark a dispatch as cancelled; running tasks are not stopped", run: runCancel},
+	"rm":       {args: "<dispatch_id>", summary: "Delete a dispatch and its assets", run: runRemove},
+	"export":   {args: "<dispatch_id>", summary: "Print the manifest of a dispatch", run: runExport},
+	"download": {args: "[--dest FILE] <dispatch_id> <node_id|dispatch> <asset>", summary: "Download an asset, e.g. an electron's output or the dispatch's result", run: runDownload},
+}
+
+// State shared by the commands
+type app struct {
+	client *client.Client
+	out    io.Writer
+	output string
+}
+
+func envOr(key string, default_value string) string {
+	if val, ok := os.LookupEnv(key); ok {
+		return val
+	}
+	return default_value
+}
+
+func usage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: govalent [flags] <command> [args]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-9s %s\n  %-9s   %s %s\n", name, commands[name].summary, "", name, commands[name].args)
+	}
+	fmt.Fprintf(w, "\nFlags:\n")
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+}
+
+// Run the command line args, writing results to stdout
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	opts := client.DefaultOptions()
+	a := app{out: stdout}
+	fs := flag.NewFlagSet("govalent", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&opts.BaseURL, "url", envOr(URL_ENV, DEFAULT_URL), "server URL")
+	fs.StringVar(&opts.APIPrefix, "api-prefix", envOr(API_PREFIX_ENV, ""), "API prefix of the server")
+	fs.StringVar(&opts.Token, "token", envOr(TOKEN_ENV, ""), "bearer token")
+	fs.StringVar(&opts.Namespace, "namespace", envOr(NAMESPACE_ENV, ""), "namespace; defaults to that of the token")
+	fs.IntVar(&opts.MaxRetries, "retries", opts.MaxRetries, "retries of failed requests which are safe to repeat")
+	fs.StringVar(&a.output, "output", OUTPUT_TABLE, "output format: table or json")
+	fs.StringVar(&a.output, "o", OUTPUT_TABLE, "shorthand for --output")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			usage(stdout, fs)
+			return EXIT_OK
+		}
+		fmt.Fprintf(stderr, "govalent: %s\n", err)
+		return EXIT_USAGE
+	}
+	if fs.NArg() == 0 {
+		usage(stderr, fs)
+		return EXIT_USAGE
+	}
+	if a.output != OUTPUT_TABLE && a.output != OUTPUT_JSON {
+		fmt.Fprintf(stderr, "govalent: unknown output format %q\n", a.output)
+		return EXIT_USAGE
+	}
+	name := fs.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "govalent: unknown command %q\n\n", name)
+		usage(stderr, fs)
+		return EXIT_USAGE
+	}
+	c, err := client.New(opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "govalent: %s\n", err)
+		return EXIT_USAGE
+	}
+	a.client = c
+
+	err = cmd.run(ctx, &a, fs.Args()[1:])
+	var usage_err *usageError
+	switch {
+	case err == nil:
+		return EXIT_OK
+	case errors.Is(err, flag.ErrHelp):
+		fmt.Fprintf(stdout, "Usage: govalent %s %s\n", name, cmd.args)
+		return EXIT_OK
+	case errors.As(err, &usage_err):
+		fmt.Fprintf(stderr, "govalent %s: %s\nUsage: govalent %s %s\n", name, err, name, cmd.args)
+		return EXIT_USAGE
+	default:
+		fmt.Fprintf(stderr, "govalent %s: %s\n", name, err)
+		return EXIT_ERROR
+	}
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/casey/govalent/server/api"
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/db"
+	"github.com/casey/govalent/server/models"
+)
+
+const TEST_ADMIN_TOKEN = "test-admin-token"
+
+// Runs the CLI against an in-process API server
+type testCLI struct {
+	t   *testing.T
+	url string
+}
+
+func newTestCLI(t *testing.T) *testCLI {
+	c, _, err := common.LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	dir := t.TempDir()
+	c.Dsn = filepath.Join(dir, "govalent.db")
+	c.StoragePath = filepath.Join(dir, "storage")
+	c.AdminToken = TEST_ADMIN_TOKEN
+	pool, err := db.GetDB(&c)
+	if err != nil {
+		t.Fatalf("Error establishing DB connection: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	if err := db.EmitDDL(pool); err != nil {
+		t.Fatalf("Error initializing DB: %v", err)
+	}
+	live := common.NewLiveConfig(c)
+	m := api.NewGovalentAPIServer(live, ":0")
+	m.AddRoutes(live, pool)
+	srv := httptest.NewServer(m.Srv.Handler)
+	t.Cleanup(srv.Close)
+	return &testCLI{t: t, url: srv.URL}
+}
+
+// Stdout, stderr and exit code of govalent args
+func (c *testCLI) run(args ...string) (string, string, int) {
+	var stdout, stderr bytes.Buffer
+	all := append([]string{"--url", c.url, "--token", TEST_ADMIN_TOKEN}, args...)
+	code := run(context.Background(), all, &stdout, &stderr)
+	return stdout.String(), stderr.String(), code
+}
+
+func (c *testCLI) mustRun(args ...string) string {
+	stdout, stderr, code := c.run(args...)
+	if code != EXIT_OK {
+		c.t.Fatalf("govalent %v exited with %d: %s", args, code, stderr)
+	}
+	return stdout
+}
+
+func writeTestManifest(t *testing.T, result string) string {
+	dir := t.TempDir()
+	result_path := filepath.Join(dir, "output")
+	if err := os.WriteFile(result_path, []byte(result), 0o644); err != nil {
+		t.Fatalf("Error writing asset: %v", err)
+	}
+	manifest := models.DispatchSchema{
+		Metadata: models.DispatchMeta{Status: common.STATUS_NEW},
+		Lattice: models.LatticeSchema{
+			Metadata: models.LatticeMeta{Name: "cli-workflow", Executor: "local"},
+			TransportGraph: models.Graph{
+				Nodes: []models.ElectronSchema{{
+					Metadata: models.ElectronMeta{Name: "task", Executor: "local", Status: common.STATUS_NEW},
+					Assets: models.ElectronAssets{
+						Output: models.AssetDetails{Uri: "file://" + result_path, Size: len(result)},
+					},
+				}},
+				Links: []models.Edge{},
+			},
+		},
+	}
+	path := filepath.Join(dir, "manifest.json")
+	serialized, err := json.Marshal(&manifest)
+	if err != nil {
+		t.Fatalf("Error encoding manifest: %v", err)
+	}
+	if err := os.WriteFile(path, serialized, 0o644); err != nil {
+		t.Fatalf("Error writing manifest: %v", err)
+	}
+	return path
+}
+
+func TestDispatchLifecycle(t *testing.T) {
+	c := newTestCLI(t)
+
+	var submitted models.DispatchSchema
+	out := c.mustRun("-o", "json", "submit", writeTestManifest(t, "42"))
+	if err := json.Unmarshal([]byte(out), &submitted); err != nil {
+		t.Fatalf("Error decoding submit output %q: %v", out, err)
+	}
+	dispatch_id := submitted.Metadata.DispatchId
+
+	out = c.mustRun("ls")
+	if !strings.HasPrefix(out, "DISPATCH ID") || !strings.Contains(out, dispatch_id) {
+		t.Errorf("Expected a table listing %s, got %q", dispatch_id, out)
+	}
+	var list models.GetBulkDispatchesResponse
+	if err := json.Unmarshal([]byte(c.mustRun("--output", "json", "ls", "--status", common.STATUS_NEW)), &list); err != nil || list.Total != 1 {
+		t.Errorf("Expected 1 dispatch, got %+v (%v)", list, err)
+	}
+
+	out = c.mustRun("status", dispatch_id)
+	if !strings.Contains(out, "NODE") || !strings.Contains(out, "task") {
+		t.Errorf("Expected electrons in status output, got %q", out)
+	}
+	if out = c.mustRun("download", dispatch_id, "0", "output"); out != "42" {
+		t.Errorf("Expected downloaded output, got %q", out)
+	}
+
+	c.mustRun("cancel", dispatch_id)
+	if _, stderr, code := c.run("cancel", dispatch_id); code != EXIT_ERROR || !strings.Contains(stderr, common.STATUS_CANCELLED) {
+		t.Errorf("Expected cancelling twice to fail, got %d: %s", code, stderr)
+	}
+	// Returns once the cancellation has been replayed
+	out = c.mustRun("-o", "json", "watch", dispatch_id)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	var last models.StatusEvent
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil || last.Status != common.STATUS_CANCELLED {
+		t.Errorf("Expected the stream to end with the cancellation, got %q", out)
+	}
+
+	var exported models.DispatchSchema
+	if err := json.Unmarshal([]byte(c.mustRun("export", dispatch_id)), &exported); err != nil || exported.Metadata.Status != common.STATUS_CANCELLED {
+		t.Errorf("Expected exported manifest to be cancelled, got %+v (%v)", exported.Metadata, err)
+	}
+
+	c.mustRun("rm", dispatch_id)
+	if _, stderr, code := c.run("status", dispatch_id); code != EXIT_ERROR || !strings.Contains(stderr, "404") {
+		t.Errorf("Expected 404 after rm, got %d: %s", code, stderr)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	c := newTestCLI(t)
+	for _, args := range [][]string{
+		{},
+		{"bogus"},
+		{"status"},
+		{"download", "d", "x", "output"},
+		{"-o", "yaml", "ls"},
+		{"ls", "--bogus"},
+	} {
+		if _, _, code := c.run(args...); code != EXIT_USAGE {
+			t.Errorf("Expected usage error for %v, got %d", args, code)
+		}
+	}
+	if out, _, code := c.run("--help"); code != EXIT_OK || !strings.Contains(out, "download") {
+		t.Errorf("Expected usage on stdout, got %d: %q", code, out)
+	}
+	var stderr bytes.Buffer
+	if code := run(context.Background(), []string{"--url", c.url, "--token", "wrong", "ls"}, &bytes.Buffer{}, &stderr); code != EXIT_ERROR {
+		t.Errorf("Expected error with a wrong token, got %d: %s", code, stderr.String())
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/casey/govalent/server/models"
+)
+
+// Printed for absent values in tables
+const MISSING_VALUE = "-"
+
+func (a *app) writeJSON(v any) error {
+	enc := json.NewEncoder(a.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// Tab-separated rows aligned into columns
+func (a *app) writeTable(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(a.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+func formatTime(ts *time.Time) string {
+	if ts == nil || ts.IsZero() {
+		return MISSING_VALUE
+	}
+	return ts.Local().Format(time.DateTime)
+}
+
+func formatString(s string) string {
+	if len(s) == 0 {
+		return MISSING_VALUE
+	}
+	return s
+}
+
+var dispatchHeader = []string{"DISPATCH ID", "STATUS", "OWNER", "NAMESPACE", "CREATED", "STARTED", "ENDED"}
+
+func dispatchRow(d *models.DispatchMeta) []string {
+	return []string{
+		d.DispatchId,
+		d.Status,
+		formatString(d.Owner),
+		formatString(d.Namespace),
+		formatTime(&d.CreatedAt),
+		formatTime(d.StartTime),
+		formatTime(d.EndTime),
+	}
+}
+
+var electronHeader = []string{"NODE", "NAME", "STATUS", "EXECUTOR", "STARTED", "ENDED"}
+
+func electronRow(e *models.ElectronSchema) []string {
+	return []string{
+		strconv.Itoa(e.NodeId),
+		e.Metadata.Name,
+		e.Metadata.Status,
+		formatString(e.Metadata.Executor),
+		formatTime(e.Metadata.StartTime),
+		formatTime(e.Metadata.EndTime),
+	}
+}
+
+var eventHeader = []string{"EVENT", "TIME", "NODE", "STATUS"}
+
+func eventRow(ev *models.StatusEvent) []string {
+	node := "dispatch"
+	if ev.NodeId != nil {
+		node = strconv.Itoa(*ev.NodeId)
+	}
+	return []string{strconv.FormatInt(ev.Id, 10), formatTime(&ev.CreatedAt), node, ev.Status}
+}
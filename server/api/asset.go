@@ -3,6 +3,7 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"log"
@@ -13,12 +14,12 @@ import (
 	"github.com/casey/govalent/server/models"
 )
 
-func importAssets(c *common.Config, d *sql.DB, assets []models.AssetPublicSchema) ([]models.AssetPublicSchema, *models.APIError) {
-	t, db_err := d.Begin()
+func importAssets(ctx context.Context, c *common.Config, d *sql.DB, namespace string, assets []models.AssetPublicSchema) ([]models.AssetPublicSchema, *models.APIError) {
+	t, db_err := crud.BeginTx(ctx, d)
 	if db_err != nil {
 		return nil, models.NewGenericServerError(db_err)
 	}
-	_, err := crud.CreateAssets(c, t, assets)
+	_, err := crud.CreateAssets(ctx, c, t, namespace, assets)
 	if err != nil {
 		t.Rollback()
 		return nil, err
@@ -37,7 +38,7 @@ func handleCreateAssets(c *common.Config, d *sql.DB, w http.ResponseWriter, r *h
 		models.WriteError(w, err)
 		return err.StatusCode
 	}
-	assets, err := importAssets(c, d, reqBody.Assets)
+	assets, err := importAssets(r.Context(), c, d, namespaceFromRequest(r), reqBody.Assets)
 	if err != nil {
 		models.WriteError(w, err)
 		return err.StatusCode
@@ -47,17 +48,19 @@ func handleCreateAssets(c *common.Config, d *sql.DB, w http.ResponseWriter, r *h
 }
 
 func exportAssets(
+	ctx context.Context,
 	c *common.Config,
 	d *sql.DB,
+	namespace string,
 	prefix string,
 	limit int,
 	offset int,
 ) ([]models.AssetPublicSchema, *models.APIError) {
-	tx, db_err := d.Begin()
+	tx, db_err := crud.BeginTx(ctx, d)
 	if db_err != nil {
 		return nil, models.NewGenericServerError(db_err)
 	}
-	ents, api_err := crud.GetAssetEntitiesByPrefix(tx, prefix, limit, offset)
+	ents, api_err := crud.GetAssetEntitiesByPrefix(tx, namespace, prefix, limit, offset)
 	if api_err != nil {
 		tx.Rollback()
 		return nil, api_err
@@ -84,7 +87,7 @@ func handleExportAssets(c *common.Config, d *sql.DB, w http.ResponseWriter, r *h
 	}
 	limit := params.Count
 	offset := params.Page * limit
-	assets, api_err := exportAssets(c, d, prefix, limit, offset)
+	assets, api_err := exportAssets(r.Context(), c, d, namespaceFromRequest(r), prefix, limit, offset)
 	if api_err != nil {
 		models.WriteError(w, api_err)
 		return api_err.StatusCode
@@ -94,23 +97,24 @@ func handleExportAssets(c *common.Config, d *sql.DB, w http.ResponseWriter, r *h
 	return writeJSONResponse(w, &respBody)
 }
 
-func getDispatchAssetLinks(c *common.Config, d *sql.DB, dispatch_id string) ([]models.AssetLink, *models.APIError) {
-	tx, db_err := d.Begin()
+func getDispatchAssetLinks(ctx context.Context, c *common.Config, d *sql.DB, dispatch_id string) ([]models.AssetLink, *models.APIError) {
+	tx, db_err := crud.BeginTx(ctx, d)
 	if db_err != nil {
 		return nil, models.NewGenericServerError(db_err)
 	}
+	defer tx.Rollback()
+	if _, err := crud.GetDispatch(c, tx, dispatch_id, false); err != nil {
+		return nil, err
+	}
 	dispatch_assets, err := crud.GetDispatchAssets(c, tx, dispatch_id)
-	// TODO: handle dispatch not found
 	if err != nil {
-		tx.Rollback()
-		return nil, models.NewGenericServerError(err)
+		return nil, err
 	}
 	links := make([]models.AssetLink, len(dispatch_assets))
 	for i := range links {
 		links[i].Asset = dispatch_assets[i].Asset.GetPublicEntity(c).AssetDetails
 		links[i].Name = dispatch_assets[i].Name
 	}
-	tx.Rollback()
 	return links, nil
 }
 
@@ -121,7 +125,7 @@ func handleGetDispatchAssetLinks(c *common.Config, d *sql.DB, w http.ResponseWri
 		models.WriteError(w, err)
 		return err.StatusCode
 	}
-	links, err := getDispatchAssetLinks(c, d, dispatch_id)
+	links, err := getDispatchAssetLinks(r.Context(), c, d, dispatch_id)
 	if err != nil {
 		models.WriteError(w, err)
 		return err.StatusCode
@@ -130,23 +134,24 @@ func handleGetDispatchAssetLinks(c *common.Config, d *sql.DB, w http.ResponseWri
 	return writeJSONResponse(w, &respBody)
 }
 
-func getAssetLinks(c *common.Config, d *sql.DB, dispatch_id string, node_id int) ([]models.AssetLink, *models.APIError) {
-	tx, db_err := d.Begin()
+func getAssetLinks(ctx context.Context, c *common.Config, d *sql.DB, dispatch_id string, node_id int) ([]models.AssetLink, *models.APIError) {
+	tx, db_err := crud.BeginTx(ctx, d)
 	if db_err != nil {
 		return nil, models.NewGenericServerError(db_err)
 	}
+	defer tx.Rollback()
+	if _, err := crud.GetElectronMetadata(tx, dispatch_id, node_id); err != nil {
+		return nil, err
+	}
 	electron_assets, err := crud.GetElectronAssets(c, tx, dispatch_id, node_id)
-	// TODO: handle dispatch not found
 	if err != nil {
-		tx.Rollback()
-		return nil, models.NewGenericServerError(err)
+		return nil, err
 	}
 	links := make([]models.AssetLink, len(electron_assets))
 	for i := range links {
 		links[i].Asset = electron_assets[i].Asset.GetPublicEntity(c).AssetDetails
 		links[i].Name = electron_assets[i].Name
 	}
-	tx.Rollback()
 	return links, nil
 }
 
@@ -162,7 +167,7 @@ func handleGetElectronAssetLinks(c *common.Config, d *sql.DB, w http.ResponseWri
 		models.WriteError(w, err)
 		return err.StatusCode
 	}
-	links, err := getAssetLinks(c, d, dispatch_id, node_id)
+	links, err := getAssetLinks(r.Context(), c, d, dispatch_id, node_id)
 	if err != nil {
 		models.WriteError(w, err)
 		return err.StatusCode
@@ -0,0 +1,127 @@
+// Audit trail of denied requests and mutating operations
+
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/crud"
+	"github.com/casey/govalent/server/models"
+)
+
+var auditOutcomes = map[string]bool{
+	models.AUDIT_OUTCOME_DENIED:    true,
+	models.AUDIT_OUTCOME_SUCCEEDED: true,
+	models.AUDIT_OUTCOME_FAILED:    true,
+}
+
+type auditKey struct{}
+
+// The pending audit entry of an audited request, for handlers to add
+// the dispatch id or detail. Unaudited requests get a throwaway entry.
+func auditEntryFromRequest(r *http.Request) *models.AuditEntry {
+	entry, _ := r.Context().Value(auditKey{}).(*models.AuditEntry)
+	if entry == nil {
+		return &models.AuditEntry{}
+	}
+	return entry
+}
+
+// Record the outcome of f in the audit log once it returns. The entry
+// is written in its own transaction so that failures are recorded too.
+func audited(action string, f handlerFunc) handlerFunc {
+	return func(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+		p := principalFromRequest(r)
+		entry := models.AuditEntry{
+			RequestId:  r.Header.Get(models.REQUEST_ID_HEADER),
+			User:       p.User,
+			TokenId:    p.TokenId,
+			Role:       p.Role,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Action:     action,
+			Namespace:  namespaceFromRequest(r),
+			DispatchId: r.PathValue("dispatch_id"),
+		}
+		code := f(c, d, w, r.WithContext(context.WithValue(r.Context(), auditKey{}, &entry)))
+		entry.CreatedAt = time.Now().UTC()
+		entry.StatusCode = code
+		entry.Outcome = models.AUDIT_OUTCOME_SUCCEEDED
+		if code >= 400 {
+			entry.Outcome = models.AUDIT_OUTCOME_FAILED
+		}
+		recordAuditEntry(r.Context(), d, &entry)
+		return code
+	}
+}
+
+// Failures to record the entry are logged but do not change the
+// response
+func recordAuditEntry(ctx context.Context, d *sql.DB, entry *models.AuditEntry) {
+	t, db_err := crud.BeginTx(ctx, d)
+	if db_err != nil {
+		slog.ErrorContext(ctx, fmt.Sprintf("Error recording audit entry: %s", db_err.Error()))
+		return
+	}
+	defer t.Rollback()
+	if err := crud.AppendAuditEntry(t, entry); err != nil {
+		return
+	}
+	if db_err := t.Commit(); db_err != nil {
+		slog.ErrorContext(ctx, fmt.Sprintf("Error recording audit entry: %s", db_err.Error()))
+	}
+}
+
+// GET /audit
+//
+// Query parameters (all optional), newest entries first:
+//
+//	user, action, outcome, namespace, dispatch_id: exact matches
+//	since, until: RFC 3339 timestamps bounding the entry time
+//	page, count: pagination
+func handleGetAuditLog(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	pagination, err := NewPaginationParamsFromReq(r)
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	q := crud.AuditQuery{Page: pagination.Page, Count: pagination.Count}
+	q.User, _ = extractQueryString(r, "user", "")
+	q.Action, _ = extractQueryString(r, "action", "")
+	q.Outcome, _ = extractQueryString(r, "outcome", "")
+	q.Namespace, _ = extractQueryString(r, "namespace", "")
+	q.DispatchId, _ = extractQueryString(r, "dispatch_id", "")
+	if len(q.Outcome) > 0 && !auditOutcomes[q.Outcome] {
+		err := models.NewValidationError(models.NewSingleValidationError("query", "outcome", models.ERROR_DETAIL_INVALID))
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	if q.Since, err = extractQueryTime(r, "since"); err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	if q.Until, err = extractQueryTime(r, "until"); err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	respBody, err := crud.SearchAuditLog(t, q)
+	t.Rollback()
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	return writeJSONResponse(w, &respBody)
+}
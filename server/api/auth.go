@@ -0,0 +1,235 @@
+// Bearer token authentication and token management routes
+
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/crud"
+	"github.com/casey/govalent/server/models"
+)
+
+const AUTHORIZATION_HEADER = "Authorization"
+const BEARER_PREFIX = "Bearer "
+
+// Generated tokens are this prefix followed by API_TOKEN_BYTES random
+// bytes in hex
+const API_TOKEN_PREFIX = "gvt_"
+const API_TOKEN_BYTES = 32
+
+// User name of the principal authenticated by the bootstrap admin token
+const BOOTSTRAP_ADMIN_USER = "admin"
+
+type principalKey struct{}
+
+// The caller of r, as established by RequestHandler.ServeHTTP
+func principalFromRequest(r *http.Request) models.Principal {
+	p, _ := r.Context().Value(principalKey{}).(models.Principal)
+	return p
+}
+
+func withPrincipal(r *http.Request, p models.Principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p))
+}
+
+func authenticate(c *common.Config, d *sql.DB, r *http.Request) (models.Principal, *models.APIError) {
+	if !c.AuthEnabled {
+		return models.Principal{Role: models.ROLE_ADMIN}, nil
+	}
+	header := r.Header.Get(AUTHORIZATION_HEADER)
+	if len(header) == 0 {
+		return models.Principal{}, models.NewUnauthorizedError(errors.New("Missing bearer token"))
+	}
+	token, ok := strings.CutPrefix(header, BEARER_PREFIX)
+	if !ok || len(token) == 0 {
+		return models.Principal{}, models.NewUnauthorizedError(errors.New("Expected Authorization: Bearer <token>"))
+	}
+	if len(c.AdminToken) > 0 && subtle.ConstantTimeCompare([]byte(token), []byte(c.AdminToken)) == 1 {
+		return models.Principal{User: BOOTSTRAP_ADMIN_USER, Role: models.ROLE_ADMIN}, nil
+	}
+
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		return models.Principal{}, models.NewGenericServerError(db_err)
+	}
+	defer t.Rollback()
+	p, found, err := crud.GetPrincipalByToken(t, token)
+	if err != nil {
+		return models.Principal{}, err
+	}
+	if !found {
+		return models.Principal{}, models.NewUnauthorizedError(errors.New("Invalid bearer token"))
+	}
+	return p, nil
+}
+
+// The caller must hold every scope of the route; denials are recorded
+// in the audit log. Routes under /dispatches/{dispatch_id} are further
+// limited to dispatches in namespace ns and, unless the caller acts on
+// all dispatches, to those it owns. Other dispatches are reported as
+// not found so that their ids are not disclosed.
+func authorize(d *sql.DB, h *RequestHandler, r *http.Request, p *models.Principal, ns string) *models.APIError {
+	for _, scope := range h.scopes {
+		if !p.HasScope(scope) {
+			auditDenied(d, r, p, ns, fmt.Sprintf("missing scope %s", scope))
+			return models.NewForbiddenError(fmt.Errorf("Scope %s required", scope))
+		}
+	}
+	dispatch_id := r.PathValue("dispatch_id")
+	if len(dispatch_id) == 0 {
+		return nil
+	}
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		return models.NewGenericServerError(db_err)
+	}
+	defer t.Rollback()
+	owner, err := crud.GetDispatchOwner(t, ns, dispatch_id)
+	if err != nil {
+		return err
+	}
+	if !p.Owns(owner) {
+		return models.NewNotFoundError(fmt.Errorf("Dispatch %s not found", dispatch_id))
+	}
+	return nil
+}
+
+func auditDenied(d *sql.DB, r *http.Request, p *models.Principal, ns string, detail string) {
+	entry := models.AuditEntry{
+		CreatedAt:  time.Now().UTC(),
+		RequestId:  r.Header.Get(models.REQUEST_ID_HEADER),
+		User:       p.User,
+		TokenId:    p.TokenId,
+		Role:       p.Role,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Outcome:    models.AUDIT_OUTCOME_DENIED,
+		Detail:     detail,
+		Namespace:  ns,
+		DispatchId: r.PathValue("dispatch_id"),
+		StatusCode: http.StatusForbidden,
+	}
+	slog.WarnContext(r.Context(), fmt.Sprintf("Denied %s %s to %s (%s): %s", r.Method, r.URL.Path, p.User, p.Role, detail))
+	recordAuditEntry(r.Context(), d, &entry)
+}
+
+func writeAuthError(w http.ResponseWriter, err *models.APIError) {
+	if err.StatusCode == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="govalent"`)
+	}
+	models.WriteError(w, err)
+}
+
+func generateAPIToken() (string, error) {
+	buf := make([]byte, API_TOKEN_BYTES)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return API_TOKEN_PREFIX + hex.EncodeToString(buf), nil
+}
+
+// POST /tokens
+//
+// The response includes the bearer token; it is not returned again.
+func handleCreateToken(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	var reqBody models.TokenCreateRequest
+	if err := (&reqBody).DecodeJSON(json.NewDecoder(r.Body)); err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	token, gen_err := generateAPIToken()
+	if gen_err != nil {
+		api_err := models.NewGenericServerError(gen_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	respBody := models.APIToken{
+		User:      reqBody.User,
+		Name:      reqBody.Name,
+		Role:      reqBody.Role,
+		Namespace: reqBody.Namespace,
+		Token:     token,
+		CreatedAt: time.Now().UTC(),
+	}
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	defer t.Rollback()
+	if err := crud.CreateAPIToken(t, &respBody); err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	auditEntryFromRequest(r).Detail = fmt.Sprintf("token %d: %s %s", respBody.Id, respBody.Role, respBody.User)
+	if db_err := t.Commit(); db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := (&respBody).EncodeJSON(json.NewEncoder(w)); err != nil {
+		return http.StatusInternalServerError
+	}
+	return http.StatusCreated
+}
+
+// GET /tokens
+func handleGetTokens(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	tokens, err := crud.GetAPITokens(t)
+	t.Rollback()
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	respBody := models.GetBulkTokensResponse{Records: tokens}
+	return writeJSONResponse(w, &respBody)
+}
+
+// DELETE /tokens/{token_id}
+func handleDeleteToken(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	token_id, err := extractPathInt(r, "token_id")
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	auditEntryFromRequest(r).Detail = fmt.Sprintf("token %d", token_id)
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	defer t.Rollback()
+	if err := crud.DeleteAPIToken(t, int64(token_id)); err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	if db_err := t.Commit(); db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return http.StatusNoContent
+}
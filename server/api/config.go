@@ -0,0 +1,53 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/models"
+)
+
+const IMMUTABLE_SETTING_DETAIL = "Cannot be changed without a restart"
+
+// PUT /config
+//
+// Changes reloadable settings for subsequent requests; other settings
+// are rejected. Responds with the live configuration.
+func (m *GovalentAPIServer) handleUpdateConfig(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	var reqBody models.ConfigUpdateRequest
+	if err := (&reqBody).DecodeJSON(json.NewDecoder(r.Body)); err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	changed, err := m.config.Set(reqBody.Values)
+	if err != nil {
+		api_err := configError(err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	if len(changed) > 0 {
+		slog.InfoContext(r.Context(), fmt.Sprintf("Updated settings %s", strings.Join(changed, ", ")))
+	}
+	auditEntryFromRequest(r).Detail = strings.Join(changed, ",")
+	respBody := models.NewConfigResponse(m.config.Get())
+	return writeJSONResponse(w, &respBody)
+}
+
+// Immutable settings are reported individually
+func configError(err error) *models.APIError {
+	var immutable *common.ImmutableSettingsError
+	if !errors.As(err, &immutable) {
+		return models.NewValidationError(err)
+	}
+	errs := models.ValidationError{}
+	for _, key := range immutable.Keys {
+		errs.Add("body", key, IMMUTABLE_SETTING_DETAIL)
+	}
+	return models.NewValidationError(&errs)
+}
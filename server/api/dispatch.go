@@ -1,36 +1,214 @@
 package api
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/casey/govalent/server/common"
 	"github.com/casey/govalent/server/crud"
 	"github.com/casey/govalent/server/models"
+	"github.com/casey/govalent/server/tracing"
 )
 
-// POST /dispatches
-func importManifest(c *common.Config, d *sql.DB, manifest *models.DispatchSchema) (*models.DispatchSchema, *models.APIError) {
+// Clients may set this header on POST /dispatches so that retries of
+// a submission return the original dispatch instead of creating a new one
+const IDEMPOTENCY_KEY_HEADER = "Idempotency-Key"
+
+// Set on responses to retried submissions
+const IDEMPOTENT_REPLAYED_HEADER = "Idempotent-Replayed"
+
+// Read the remainder of the body, so that bodies over the size limit
+// are rejected even if they hold a complete manifest
+func drainBody(body io.Reader) *models.APIError {
+	_, err := io.Copy(io.Discard, body)
+	var size_err *http.MaxBytesError
+	if errors.As(err, &size_err) {
+		return models.NewPayloadTooLargeError(fmt.Errorf("Request body exceeds %d bytes", size_err.Limit))
+	}
+	if err != nil {
+		return models.NewGenericClientError(fmt.Sprintf("Error reading request body: %s", err.Error()))
+	}
+	return nil
+}
+
+// Hashes the re-encoded header, nodes and links of a manifest as they
+// are decoded, before passing them on to next if set. Submissions
+// differing only in whitespace, key order or number formatting have
+// the same digest. Nodes and links are hashed one at a time into
+// separate hashes, so the digest does not depend on how the decoder
+// batches them or on which of the two comes first in the body.
+type digestSink struct {
+	header hash.Hash
+	nodes  hash.Hash
+	links  hash.Hash
+	next   models.DispatchSchemaSink
+}
+
+func newDigestSink(next models.DispatchSchemaSink) *digestSink {
+	return &digestSink{header: sha256.New(), nodes: sha256.New(), links: sha256.New(), next: next}
+}
+
+func writeDigest(h hash.Hash, v any) *models.APIError {
+	if err := json.NewEncoder(h).Encode(v); err != nil {
+		return models.NewGenericServerError(err)
+	}
+	return nil
+}
+
+// The sink may modify what it is given, so it is hashed first. The id
+// and timestamps are left out, as the server assigns them to each
+// request that omits them.
+func (s *digestSink) WriteHeader(d *models.DispatchSchema) *models.APIError {
+	canonical := *d
+	canonical.Metadata.DispatchId = ""
+	canonical.Metadata.CreatedAt = time.Time{}
+	canonical.Metadata.UpdatedAt = time.Time{}
+	if err := writeDigest(s.header, &canonical); err != nil || s.next == nil {
+		return err
+	}
+	return s.next.WriteHeader(d)
+}
+
+func (s *digestSink) WriteNodes(nodes []models.ElectronSchema) *models.APIError {
+	for i := range nodes {
+		if err := writeDigest(s.nodes, &nodes[i]); err != nil {
+			return err
+		}
+	}
+	if s.next == nil {
+		return nil
+	}
+	return s.next.WriteNodes(nodes)
+}
 
-	// Set root_dispatch_id
-	manifest.Metadata.RootDispatchId = manifest.Metadata.DispatchId
+func (s *digestSink) WriteLinks(links []models.Edge) *models.APIError {
+	for i := range links {
+		if err := writeDigest(s.links, &links[i]); err != nil {
+			return err
+		}
+	}
+	if s.next == nil {
+		return nil
+	}
+	return s.next.WriteLinks(links)
+}
+
+func (s *digestSink) Digest() string {
+	h := sha256.New()
+	for _, part := range []hash.Hash{s.header, s.nodes, s.links} {
+		h.Write(part.Sum(nil))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Decode the manifest in body, passing it to sink
+func decodeManifest(c *common.Config, body io.Reader, sink models.DispatchSchemaSink) (*models.DispatchSchema, *models.APIError) {
+	limits := models.ManifestLimits{MaxNodes: c.MaxManifestNodes}
+	header, err := models.DecodeDispatchSchemaStream(json.NewDecoder(body), limits, sink)
+	if err != nil {
+		return nil, err
+	}
+	if err := drainBody(body); err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// A stored submission by the same owner in the same namespace matching
+// digest is a retry; anything else is a conflict
+func checkResubmission(t *sql.Tx, sub crud.SubmissionEntity, found bool, digest string, owner string, namespace string, what string) *models.APIError {
+	conflict := models.NewConflictError(fmt.Errorf("%s was already used for a different manifest", what))
+	if !found || sub.Digest != digest {
+		return conflict
+	}
+	dispatch_owner, err := crud.GetDispatchOwner(t, namespace, sub.DispatchId)
+	if err != nil && err.StatusCode == http.StatusNotFound {
+		return conflict
+	}
+	if err != nil {
+		return err
+	}
+	if dispatch_owner != owner {
+		return conflict
+	}
+	return nil
+}
 
-	t, db_err := d.Begin()
+// POST /dispatches
+//
+// Returns the id of the stored dispatch and whether this request
+// created it. Retried submissions -- those with an Idempotency-Key the
+// owner already used in the namespace, or a client-supplied
+// dispatch_id, and the same manifest -- resolve to the original
+// dispatch; any other reuse is a 409.
+func importManifest(ctx context.Context, c *common.Config, d *sql.DB, body io.Reader, idempotency_key string, owner string, namespace string) (string, bool, *models.APIError) {
+	t, db_err := crud.BeginTx(ctx, d)
 	if db_err != nil {
-		return nil, models.NewGenericServerError(db_err)
+		return "", false, models.NewGenericServerError(db_err)
+	}
+	defer t.Rollback()
+
+	if len(idempotency_key) > 0 {
+		sub, found, err := crud.GetSubmissionByIdempotencyKey(t, idempotency_key, owner, namespace)
+		if err != nil {
+			return "", false, err
+		}
+		if found {
+			// Only the digest is needed
+			digest := newDigestSink(nil)
+			if _, err := decodeManifest(c, body, digest); err != nil {
+				return "", false, err
+			}
+			err := checkResubmission(t, sub, found, digest.Digest(), owner, namespace, fmt.Sprintf("Idempotency-Key %s", idempotency_key))
+			if err != nil {
+				return "", false, err
+			}
+			return sub.DispatchId, false, nil
+		}
 	}
-	err := crud.ImportManifest(c, t, manifest)
 
-	// TODO: differentiate between 4xx and 5xx errors
+	// Nodes and edges are inserted in batches while the body is decoded
+	// The span of the decode encloses the span of each batch
+	ctx, span := tracing.Start(ctx, "DecodeDispatchSchemaStream")
+	importer := crud.NewManifestImporter(ctx, c, t, "", owner, namespace)
+	digest := newDigestSink(importer)
+	header, err := decodeManifest(c, body, digest)
+	tracing.End(span, err)
 	if err != nil {
-		t.Rollback()
-		return nil, models.NewGenericServerError(err)
+		return "", false, err
 	}
-	t.Commit()
-	return manifest, nil
+	dispatch_id := header.Metadata.DispatchId
+
+	if importer.Duplicate() {
+		sub, found, err := crud.GetSubmission(t, dispatch_id)
+		if err != nil {
+			return "", false, err
+		}
+		err = checkResubmission(t, sub, found, digest.Digest(), owner, namespace, fmt.Sprintf("Dispatch id %s", dispatch_id))
+		if err != nil {
+			return "", false, err
+		}
+		return dispatch_id, false, nil
+	}
+
+	sub := crud.NewSubmissionEntity(dispatch_id, idempotency_key, owner, namespace, digest.Digest())
+	if err := crud.CreateSubmission(t, &sub); err != nil {
+		return "", false, err
+	}
+	if db_err := t.Commit(); db_err != nil {
+		return "", false, models.NewGenericServerError(db_err)
+	}
+	return dispatch_id, true, nil
 }
 
 func handleImportManifest(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
@@ -38,45 +216,44 @@ func handleImportManifest(c *common.Config, d *sql.DB, w http.ResponseWriter, r
 	// Apply middleware
 	// Call business logic
 	// Serialize response
-	var reqBody models.DispatchSchema
-	var respBody *models.DispatchSchema
-
-	slog.Info(fmt.Sprint("Received POST /dispatches"))
-	dec := json.NewDecoder(r.Body)
-
-	err := (&reqBody).DecodeJSON(dec)
-	if err != nil {
-		models.WriteError(w, err)
-		return err.StatusCode
+	slog.InfoContext(r.Context(), fmt.Sprint("Received POST /dispatches"))
+	if c.MaxRequestBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, c.MaxRequestBodyBytes)
 	}
 
-	respBody, err = importManifest(c, d, &reqBody)
+	idempotency_key := r.Header.Get(IDEMPOTENCY_KEY_HEADER)
+	p := principalFromRequest(r)
+	dispatch_id, created, err := importManifest(r.Context(), c, d, r.Body, idempotency_key, p.User, namespaceFromRequest(r))
 	if err != nil {
-		slog.Info(fmt.Sprint("Error importing manifest:", err.Error()))
+		slog.InfoContext(r.Context(), fmt.Sprint("Error importing manifest:", err.Error()))
 		models.WriteError(w, err)
 		return err.StatusCode
 	}
-	return writeJSONResponse(w, respBody)
-}
+	entry := auditEntryFromRequest(r)
+	entry.DispatchId = dispatch_id
+	if !created {
+		slog.InfoContext(r.Context(), fmt.Sprintf("Replaying submission of dispatch %s", dispatch_id))
+		w.Header().Set(IDEMPOTENT_REPLAYED_HEADER, "true")
+		entry.Detail = "replayed"
+	}
 
-// GET /dispatches/{dispatch_id}
-func exportManifest(c *common.Config, d *sql.DB, dispatch_id string) (*models.DispatchSchema, *models.APIError) {
-	t, db_err := d.Begin()
+	// Respond with the stored manifest, including asset upload URIs
+	t, db_err := crud.BeginTx(r.Context(), d)
 	if db_err != nil {
-		return nil, models.NewGenericServerError(db_err)
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
 	}
-	manifest, err := crud.ExportManifest(c, t, dispatch_id)
-
-	// TODO: distinguish between 4xx and 5xx errors
+	defer t.Rollback()
+	respBody, err := crud.StreamManifest(r.Context(), c, t, dispatch_id)
 	if err != nil {
-		t.Rollback()
-		return nil, models.NewGenericServerError(err)
+		models.WriteError(w, err)
+		return err.StatusCode
 	}
-	t.Rollback()
-
-	return &manifest, nil
+	return writeJSONStreamResponse(w, respBody)
 }
 
+// GET /dispatches/{dispatch_id}
 func handleExportManifest(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
 	dispatch_id, err := extractPathString(r, "dispatch_id")
 	if err != nil {
@@ -84,27 +261,98 @@ func handleExportManifest(c *common.Config, d *sql.DB, w http.ResponseWriter, r
 		return err.StatusCode
 	}
 
-	respBody, err := exportManifest(c, d, dispatch_id)
+	// Nodes and edges are streamed from the database while the
+	// transaction remains open
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	defer t.Rollback()
+	respBody, err := crud.StreamManifest(r.Context(), c, t, dispatch_id)
 	if err != nil {
-		// TODO: improve error handling
 		models.WriteError(w, err)
 		return err.StatusCode
 	}
-	return writeJSONResponse(w, respBody)
+	return writeJSONStreamResponse(w, respBody)
 }
 
-// TODO: GET /dispatches?page=<page>&count=<count>
+func newDispatchQueryFromReq(r *http.Request) (crud.DispatchQuery, *models.APIError) {
+	var err *models.APIError
+	q := crud.DispatchQuery{}
+
+	pagination, err := NewPaginationParamsFromReq(r)
+	if err != nil {
+		return q, err
+	}
+	q.Page = pagination.Page
+	q.Count = pagination.Count
+
+	q.DispatchId, _ = extractQueryString(r, "dispatch_id", "")
+	q.RootDispatchId, _ = extractQueryString(r, "root_dispatch_id", "")
+	q.Name, _ = extractQueryString(r, "name", "")
+	q.Executor, _ = extractQueryString(r, "executor", "")
+	q.Owner, _ = extractQueryString(r, "owner", "")
+	q.Statuses, err = extractQueryStatuses(r, "status")
+	if err != nil {
+		return q, err
+	}
+
+	time_params := []struct {
+		key string
+		ref **time.Time
+	}{
+		{"created_after", &q.CreatedAfter},
+		{"created_before", &q.CreatedBefore},
+		{"started_after", &q.StartedAfter},
+		{"started_before", &q.StartedBefore},
+		{"ended_after", &q.EndedAfter},
+		{"ended_before", &q.EndedBefore},
+	}
+	for _, param := range time_params {
+		*param.ref, err = extractQueryTime(r, param.key)
+		if err != nil {
+			return q, err
+		}
+	}
+
+	q.SortKey, q.Ascending, err = extractSortParams(r, "created_at", false)
+	return q, err
+}
+
+// GET /dispatches
+//
+// Query parameters (all optional):
+//
+//	page, count: pagination
+//	dispatch_id, root_dispatch_id: exact match
+//	status: repeatable or comma-separated; matches any
+//	name: workflow name substring
+//	executor: lattice or workflow executor
+//	owner: exact match; ignored for users, who only see their own
+//	created_after, created_before, started_after, started_before,
+//	ended_after, ended_before: inclusive RFC 3339 bounds
+//	sort: one of created_at (default), updated_at, start_time, end_time, name, status
+//	direction: asc or desc (default)
 func handleGetDispatches(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
-	pagination, _ := NewPaginationParamsFromReq(r)
-	t, db_err := d.Begin()
+	q, err := newDispatchQueryFromReq(r)
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	if p := principalFromRequest(r); !p.AllDispatches() {
+		q.Owner = p.User
+	}
+	q.Namespace = namespaceFromRequest(r)
+	t, db_err := crud.BeginTx(r.Context(), d)
 	if db_err != nil {
 		api_err := models.NewGenericServerError(db_err)
 		models.WriteError(w, api_err)
 		return api_err.StatusCode
 	}
-	dispatch_id, _ := extractQueryString(r, "dispatch_id", "")
 
-	respBody, err := crud.GetDispatchSummaries(t, dispatch_id, pagination.Page, pagination.Count)
+	respBody, err := crud.SearchDispatches(t, q)
 	t.Rollback()
 	if err != nil {
 		models.WriteError(w, err)
@@ -113,17 +361,19 @@ func handleGetDispatches(c *common.Config, d *sql.DB, w http.ResponseWriter, r *
 	return writeJSONResponse(w, &respBody)
 }
 
-func deleteDispatch(c *common.Config, d *sql.DB, dispatch_id string) *models.APIError {
-	t, db_err := d.Begin()
+func deleteDispatch(ctx context.Context, c *common.Config, d *sql.DB, dispatch_id string) *models.APIError {
+	t, db_err := crud.BeginTx(ctx, d)
 	if db_err != nil {
 		return models.NewGenericServerError(db_err)
 	}
 	err := crud.DeleteDispatch(t, dispatch_id)
 	if err != nil {
 		t.Rollback()
-		return models.NewGenericServerError(err)
+		return err
+	}
+	if db_err := t.Commit(); db_err != nil {
+		return models.NewGenericServerError(db_err)
 	}
-	t.Commit()
 	return nil
 }
 
@@ -134,12 +384,12 @@ func handleDeleteDispatch(c *common.Config, d *sql.DB, w http.ResponseWriter, r
 		models.WriteError(w, err)
 		return err.StatusCode
 	}
-	err = deleteDispatch(c, d, dispatch_id)
+	err = deleteDispatch(r.Context(), c, d, dispatch_id)
 	if err != nil {
 		models.WriteError(w, err)
 		return err.StatusCode
 	}
 
 	w.WriteHeader(http.StatusAccepted)
-	return http.StatusNoContent
+	return http.StatusAccepted
 }
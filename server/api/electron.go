@@ -0,0 +1,125 @@
+// Routes for reading electrons
+
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/crud"
+	"github.com/casey/govalent/server/models"
+)
+
+// Asset details are included unless include_assets=false, which makes
+// polling cheaper
+func extractIncludeAssets(r *http.Request) (bool, *models.APIError) {
+	val, _ := extractQueryString(r, "include_assets", "true")
+	include, err := strconv.ParseBool(val)
+	if err != nil {
+		detail := models.NewSingleValidationError("query", "include_assets", "Expected boolean")
+		return false, models.NewValidationError(detail)
+	}
+	return include, nil
+}
+
+func newElectronQueryFromReq(r *http.Request) (crud.ElectronQuery, *models.APIError) {
+	var err *models.APIError
+	q := crud.ElectronQuery{}
+
+	pagination, err := NewPaginationParamsFromReq(r)
+	if err != nil {
+		return q, err
+	}
+	q.Page = pagination.Page
+	q.Count = pagination.Count
+
+	q.Statuses, err = extractQueryStatuses(r, "status")
+	if err != nil {
+		return q, err
+	}
+	if len(r.FormValue("task_group_id")) > 0 {
+		task_group_id, err := extractQueryInt(r, "task_group_id", 0)
+		if err != nil {
+			return q, err
+		}
+		q.TaskGroupId = &task_group_id
+	}
+	q.Executor, _ = extractQueryString(r, "executor", "")
+	q.LoadAssets, err = extractIncludeAssets(r)
+	if err != nil {
+		return q, err
+	}
+
+	q.SortKey, q.Ascending, err = extractSortParams(r, "node_id", true)
+	return q, err
+}
+
+// GET /dispatches/{dispatch_id}/electrons
+//
+// Query parameters (all optional):
+//
+//	page, count: pagination
+//	status: repeatable or comma-separated; matches any
+//	task_group_id, executor: exact match
+//	include_assets: true (default) or false
+//	sort: one of node_id (default), task_group_id, status, start_time, end_time
+//	direction: asc (default) or desc
+func handleGetElectrons(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	dispatch_id, err := extractPathString(r, "dispatch_id")
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	q, err := newElectronQueryFromReq(r)
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	respBody, err := crud.SearchElectrons(c, t, dispatch_id, q)
+	t.Rollback()
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	return writeJSONResponse(w, &respBody)
+}
+
+// GET /dispatches/{dispatch_id}/electrons/{node_id}
+func handleGetElectron(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	dispatch_id, err := extractPathString(r, "dispatch_id")
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	node_id, err := extractPathInt(r, "node_id")
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	include_assets, err := extractIncludeAssets(r)
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	respBody, err := crud.GetElectron(c, t, dispatch_id, node_id, include_assets)
+	t.Rollback()
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	return writeJSONResponse(w, &respBody)
+}
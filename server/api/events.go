@@ -0,0 +1,290 @@
+// Routes for status updates and event streaming
+
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/crud"
+	"github.com/casey/govalent/server/events"
+	"github.com/casey/govalent/server/models"
+)
+
+// Interval between comments sent to keep idle event streams open
+const SSE_KEEPALIVE_INTERVAL = 15 * time.Second
+
+// Commit t and publish the events it recorded
+func commitAndPublish(t *sql.Tx, bus *events.Bus, evs ...models.StatusEvent) *models.APIError {
+	if db_err := t.Commit(); db_err != nil {
+		return models.NewGenericServerError(db_err)
+	}
+	if bus != nil {
+		bus.Publish(evs...)
+	}
+	return nil
+}
+
+func updateDispatchStatus(
+	ctx context.Context,
+	c *common.Config,
+	d *sql.DB,
+	bus *events.Bus,
+	dispatch_id string,
+	update *models.DispatchStatusUpdate,
+) (models.StatusEvent, *models.APIError) {
+	t, db_err := crud.BeginTx(ctx, d)
+	if db_err != nil {
+		return models.StatusEvent{}, models.NewGenericServerError(db_err)
+	}
+	defer t.Rollback()
+	current, err := crud.GetDispatch(c, t, dispatch_id, false)
+	if err != nil {
+		return models.StatusEvent{}, err
+	}
+	// A finished dispatch keeps its status, so that a late report from
+	// an executor cannot undo a cancellation
+	if common.IsTerminalStatus(current.Metadata.Status) {
+		return models.StatusEvent{}, models.NewConflictError(fmt.Errorf("Dispatch %s has already finished with status %s", dispatch_id, current.Metadata.Status))
+	}
+	ev, err := crud.UpdateDispatch(t, dispatch_id, update.Status, update.StartTime, update.EndTime)
+	if err != nil {
+		return models.StatusEvent{}, err
+	}
+	return ev, commitAndPublish(t, bus, ev)
+}
+
+// PUT /dispatches/{dispatch_id}/status
+//
+// The route only requires SCOPE_DISPATCHES_CANCEL; other transitions
+// also require SCOPE_STATUS_WRITE.
+func (m *GovalentAPIServer) handleUpdateDispatchStatus(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	dispatch_id, err := extractPathString(r, "dispatch_id")
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	var update models.DispatchStatusUpdate
+	if err := (&update).DecodeJSON(json.NewDecoder(r.Body)); err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	auditEntryFromRequest(r).Detail = update.Status
+	if p := principalFromRequest(r); update.Status != common.STATUS_CANCELLED && !p.HasScope(models.SCOPE_STATUS_WRITE) {
+		err := models.NewForbiddenError(fmt.Errorf("Scope %s required", models.SCOPE_STATUS_WRITE))
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	respBody, err := updateDispatchStatus(r.Context(), c, d, m.Events, dispatch_id, &update)
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	return writeJSONResponse(w, &respBody)
+}
+
+func updateElectronStatus(
+	ctx context.Context,
+	d *sql.DB,
+	bus *events.Bus,
+	dispatch_id string,
+	node_id int,
+	update *models.ElectronStatusUpdate,
+) (models.StatusEvent, *models.APIError) {
+	t, db_err := crud.BeginTx(ctx, d)
+	if db_err != nil {
+		return models.StatusEvent{}, models.NewGenericServerError(db_err)
+	}
+	defer t.Rollback()
+	dispatch_status, err := crud.GetDispatchStatus(t, dispatch_id)
+	if err != nil {
+		return models.StatusEvent{}, err
+	}
+	// Including electrons still running when their dispatch was
+	// cancelled
+	if common.IsTerminalStatus(dispatch_status) {
+		return models.StatusEvent{}, models.NewConflictError(fmt.Errorf("Dispatch %s has already finished with status %s", dispatch_id, dispatch_status))
+	}
+	current, err := crud.GetElectronMetadata(t, dispatch_id, node_id)
+	if err != nil {
+		return models.StatusEvent{}, err
+	}
+	if common.IsTerminalStatus(current.Status) {
+		return models.StatusEvent{}, models.NewConflictError(fmt.Errorf("Electron %d of dispatch %s has already finished with status %s", node_id, dispatch_id, current.Status))
+	}
+	ev, err := crud.UpdateElectronMetadata(t, dispatch_id, node_id, *update)
+	if err != nil {
+		return models.StatusEvent{}, err
+	}
+	return ev, commitAndPublish(t, bus, ev)
+}
+
+// PATCH /dispatches/{dispatch_id}/electrons/{node_id}
+func (m *GovalentAPIServer) handleUpdateElectronStatus(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	dispatch_id, err := extractPathString(r, "dispatch_id")
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	node_id, err := extractPathInt(r, "node_id")
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	var update models.ElectronStatusUpdate
+	if err := (&update).DecodeJSON(json.NewDecoder(r.Body)); err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	auditEntryFromRequest(r).Detail = fmt.Sprintf("node %d: %s", node_id, update.Status)
+	respBody, err := updateElectronStatus(r.Context(), d, m.Events, dispatch_id, node_id, &update)
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	return writeJSONResponse(w, &respBody)
+}
+
+// Resume point from the Last-Event-ID header, or the last_event_id
+// query parameter for clients which cannot set headers. 0 replays the
+// whole log.
+func extractLastEventId(r *http.Request) (int64, *models.APIError) {
+	val := r.Header.Get("Last-Event-ID")
+	if len(val) == 0 {
+		val = r.FormValue("last_event_id")
+	}
+	if len(val) == 0 {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || id < 0 {
+		detail := models.NewSingleValidationError("header", "Last-Event-ID", "Expected non-negative integer")
+		return 0, models.NewValidationError(detail)
+	}
+	return id, nil
+}
+
+func writeEvent(w http.ResponseWriter, ev *models.StatusEvent) error {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Id, ev.Kind, data)
+	return err
+}
+
+// Write the logged events after *last_id, advancing it
+func replayEvents(ctx context.Context, d *sql.DB, w http.ResponseWriter, dispatch_id string, last_id *int64) error {
+	for {
+		t, err := crud.BeginTx(ctx, d)
+		if err != nil {
+			return err
+		}
+		evs, api_err := crud.GetEvents(t, dispatch_id, *last_id, crud.MAX_EVENTS_PER_QUERY)
+		t.Rollback()
+		if api_err != nil {
+			return api_err
+		}
+		for i := range evs {
+			if err := writeEvent(w, &evs[i]); err != nil {
+				return err
+			}
+			*last_id = evs[i].Id
+		}
+		if len(evs) < crud.MAX_EVENTS_PER_QUERY {
+			return nil
+		}
+	}
+}
+
+// GET /dispatches/{dispatch_id}/events
+//
+// Server-Sent Events stream of the dispatch's status transitions. Each
+// event's id is its position in the event log; reconnecting with
+// Last-Event-ID resumes after that event. Without it the whole log,
+// starting with the dispatch's initial status, is replayed first.
+func (m *GovalentAPIServer) handleStreamEvents(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	dispatch_id, err := extractPathString(r, "dispatch_id")
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	last_id, err := extractLastEventId(r)
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	_, err = crud.GetDispatch(c, t, dispatch_id, false)
+	t.Rollback()
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+
+	// Subscribe before replaying so that no committed event is missed;
+	// events already replayed are skipped by id
+	sub := m.Events.Subscribe(dispatch_id)
+	defer sub.Close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fail := func(err error) int {
+		slog.InfoContext(r.Context(), fmt.Sprintf("Closing event stream for dispatch %s: %s", dispatch_id, err.Error()))
+		return http.StatusOK
+	}
+	if err := replayEvents(r.Context(), d, w, dispatch_id, &last_id); err != nil {
+		return fail(err)
+	}
+	if err := rc.Flush(); err != nil {
+		return fail(err)
+	}
+
+	keepalive := time.NewTicker(SSE_KEEPALIVE_INTERVAL)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return http.StatusOK
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return fail(err)
+			}
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return http.StatusOK
+			}
+			if sub.Lagged() {
+				// Events were dropped; the log has all of them in order
+				if err := replayEvents(r.Context(), d, w, dispatch_id, &last_id); err != nil {
+					return fail(err)
+				}
+			} else if ev.Id > last_id {
+				if err := writeEvent(w, &ev); err != nil {
+					return fail(err)
+				}
+				last_id = ev.Id
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return fail(err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+// Liveness and readiness probes
+
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/db"
+	"github.com/casey/govalent/server/models"
+)
+
+// Upper bound on the database checks of GET /readyz
+const READINESS_TIMEOUT = 2 * time.Second
+
+// GET /healthz
+//
+// The process is up and serving requests.
+func handleHealth(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	respBody := models.HealthResponse{Status: models.HEALTH_STATUS_OK}
+	return writeJSONResponse(w, &respBody)
+}
+
+// GET /readyz
+//
+// Responds 503 once shutdown has begun, and otherwise unless the
+// database is reachable, its schema is current and the storage path is
+// writable.
+func (m *GovalentAPIServer) handleReady(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	ctx, cancel := context.WithTimeout(r.Context(), READINESS_TIMEOUT)
+	defer cancel()
+	respBody := checkReadiness(ctx, c, d, m.draining.Load())
+	code := http.StatusOK
+	if !respBody.Ready() {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := (&respBody).EncodeJSON(json.NewEncoder(w)); err != nil {
+		return http.StatusInternalServerError
+	}
+	return code
+}
+
+func checkReadiness(ctx context.Context, c *common.Config, d *sql.DB, draining bool) models.ReadinessResponse {
+	res := models.ReadinessResponse{Status: models.HEALTH_STATUS_OK, Checks: []models.HealthCheck{}}
+	if draining {
+		res.Add(models.HealthCheck{Name: "shutdown", Status: models.HEALTH_STATUS_FAILED, Detail: "Server is shutting down"})
+	} else {
+		res.Add(models.HealthCheck{Name: "shutdown", Status: models.HEALTH_STATUS_OK})
+	}
+	database := newHealthCheck("database", d.PingContext(ctx))
+	res.Add(database)
+	if database.Status == models.HEALTH_STATUS_OK {
+		res.Add(newHealthCheck("schema", db.CheckSchema(ctx, d)))
+	} else {
+		res.Add(models.HealthCheck{Name: "schema", Status: models.HEALTH_STATUS_FAILED, Detail: "Database unreachable"})
+	}
+	res.Add(newHealthCheck("storage", checkStorageWritable(c.StoragePath)))
+	// Executors are not yet registered with the server
+	res.Add(models.HealthCheck{Name: "executors", Status: models.HEALTH_STATUS_SKIPPED, Detail: "No executor registry configured"})
+	return res
+}
+
+func newHealthCheck(name string, err error) models.HealthCheck {
+	if err != nil {
+		return models.HealthCheck{Name: name, Status: models.HEALTH_STATUS_FAILED, Detail: err.Error()}
+	}
+	return models.HealthCheck{Name: name, Status: models.HEALTH_STATUS_OK}
+}
+
+// Create and remove a file in the storage path, creating the path if
+// necessary
+func checkStorageWritable(storage_path string) error {
+	if err := os.MkdirAll(storage_path, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(storage_path, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/db"
+)
+
+func TestReadinessWhileDraining(t *testing.T) {
+	c := common.Config{Dsn: ":memory:", StoragePath: t.TempDir()}
+	d, err := db.GetDB(&c)
+	if err != nil {
+		t.Fatalf("Error establishing DB connection: %v", err)
+	}
+	defer d.Close()
+	d.SetMaxOpenConns(1)
+	if err := db.EmitDDL(d); err != nil {
+		t.Fatalf("Error emitting DDL: %v", err)
+	}
+
+	if res := checkReadiness(context.Background(), &c, d, false); !res.Ready() {
+		t.Fatalf("Expected to be ready, got %+v", res)
+	}
+	if res := checkReadiness(context.Background(), &c, d, true); res.Ready() {
+		t.Fatalf("Expected not to be ready while draining, got %+v", res)
+	}
+}
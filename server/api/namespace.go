@@ -0,0 +1,129 @@
+// Namespace resolution and namespace management routes
+
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/crud"
+	"github.com/casey/govalent/server/models"
+)
+
+type namespaceKey struct{}
+
+// The namespace of r, as established by RequestHandler.ServeHTTP
+func namespaceFromRequest(r *http.Request) string {
+	ns, _ := r.Context().Value(namespaceKey{}).(string)
+	return ns
+}
+
+func withNamespace(r *http.Request, ns string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), namespaceKey{}, ns))
+}
+
+// The {namespace} path value takes precedence over the namespace
+// header; otherwise the namespace of the caller's token applies. A
+// namespace the caller is not bound to is reported as not found.
+func resolveNamespace(r *http.Request, p *models.Principal) (string, *models.APIError) {
+	loc, attr := "path", "namespace"
+	ns := r.PathValue("namespace")
+	if len(ns) == 0 {
+		loc, attr = "header", models.NAMESPACE_HEADER
+		ns = r.Header.Get(models.NAMESPACE_HEADER)
+	}
+	if len(ns) == 0 {
+		ns = p.Namespace
+	}
+	if len(ns) == 0 {
+		ns = models.DEFAULT_NAMESPACE
+	}
+	if !models.ValidateNamespace(ns) {
+		return "", models.NewValidationError(models.NewSingleValidationError(loc, attr, models.ERROR_DETAIL_INVALID))
+	}
+	if !p.InNamespace(ns) {
+		return "", models.NewNotFoundError(fmt.Errorf("Namespace %s not found", ns))
+	}
+	return ns, nil
+}
+
+// GET /namespaces
+//
+// Callers bound to a namespace only see their own.
+func handleGetNamespaces(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	defer t.Rollback()
+	var records []models.Namespace
+	var err *models.APIError
+	if p := principalFromRequest(r); len(p.Namespace) > 0 {
+		var ns models.Namespace
+		ns, err = crud.GetNamespace(t, p.Namespace)
+		records = []models.Namespace{ns}
+		if err != nil && err.StatusCode == http.StatusNotFound {
+			records, err = []models.Namespace{}, nil
+		}
+	} else {
+		records, err = crud.GetNamespaces(t)
+	}
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	respBody := models.GetBulkNamespacesResponse{Records: records}
+	return writeJSONResponse(w, &respBody)
+}
+
+// GET /namespaces/{namespace}
+func handleGetNamespace(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	respBody, err := crud.GetNamespace(t, namespaceFromRequest(r))
+	t.Rollback()
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	return writeJSONResponse(w, &respBody)
+}
+
+// PUT /namespaces/{namespace}
+//
+// Sets the quotas of the namespace, creating it if necessary.
+func handleUpdateNamespace(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	var reqBody models.NamespaceUpdateRequest
+	if err := (&reqBody).DecodeJSON(json.NewDecoder(r.Body)); err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	defer t.Rollback()
+	respBody, err := crud.UpdateNamespace(t, namespaceFromRequest(r), &reqBody)
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	if db_err := t.Commit(); db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	return writeJSONResponse(w, &respBody)
+}
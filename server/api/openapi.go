@@ -0,0 +1,408 @@
+// OpenAPI description of the registered routes
+
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/models"
+	"github.com/casey/govalent/server/openapi"
+)
+
+const API_TITLE = "Govalent"
+
+// Version of the API described at /openapi.json
+const API_VERSION = "0.1.0"
+
+const BEARER_AUTH_SCHEME = "bearerAuth"
+
+// Request and response bodies of a route; path parameters are derived
+// from the route itself
+type operationSpec struct {
+	id      string
+	summary string
+	query   []openapi.Parameter
+	headers []openapi.Parameter
+	// Zero values of the body types; nil if there is no body
+	request  any
+	response any
+	// Of a successful response; defaults to 200
+	status int
+	// Of the response if not JSON
+	content_type string
+}
+
+// Path parameters that are not strings
+var integerPathParams = map[string]bool{
+	"node_id":    true,
+	"webhook_id": true,
+	"token_id":   true,
+}
+
+func stringParam(in string, name string, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: in, Description: description, Schema: &openapi.Schema{Type: "string"}}
+}
+
+func integerParam(in string, name string, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: in, Description: description, Schema: &openapi.Schema{Type: "integer"}}
+}
+
+func timeParam(name string, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: &openapi.Schema{Type: "string", Format: "date-time"}}
+}
+
+var paginationParams = []openapi.Parameter{
+	integerParam("query", "page", "Zero-based page number"),
+	integerParam("query", "count", fmt.Sprintf("Records per page, at most %d; defaults to 10", MAX_PAGE_COUNT)),
+}
+
+var sortParams = []openapi.Parameter{
+	stringParam("query", "sort", "Sort key"),
+	stringParam("query", "direction", "asc or desc"),
+}
+
+func withPagination(params ...openapi.Parameter) []openapi.Parameter {
+	return append(append([]openapi.Parameter{}, paginationParams...), params...)
+}
+
+// Keyed by method and path without the API prefix, as passed to
+// AddRoute
+var operationSpecs = map[string]operationSpec{
+	"GET /config": {
+		id:       "getConfig",
+		summary:  "Live configuration",
+		response: models.ConfigResponse{},
+	},
+	"PUT /config": {
+		id:       "updateConfig",
+		summary:  "Change reloadable settings, given as an object of setting keys to values",
+		request:  map[string]any{},
+		response: models.ConfigResponse{},
+	},
+	"GET /metrics": {
+		id:           "getMetrics",
+		summary:      "Prometheus metrics",
+		response:     "",
+		content_type: "text/plain",
+	},
+	"POST /dispatches": {
+		id:      "createDispatch",
+		summary: "Submit a dispatch manifest",
+		headers: []openapi.Parameter{
+			stringParam("header", IDEMPOTENCY_KEY_HEADER, "Resubmissions with the same key return the original dispatch"),
+		},
+		request:  models.DispatchSchema{},
+		response: models.DispatchSchema{},
+	},
+	"GET /dispatches": {
+		id:      "searchDispatches",
+		summary: "Search dispatches",
+		query: withPagination(append([]openapi.Parameter{
+			stringParam("query", "dispatch_id", "Exact match"),
+			stringParam("query", "root_dispatch_id", "Exact match"),
+			stringParam("query", "status", "Repeatable or comma-separated; matches any"),
+			stringParam("query", "name", "Workflow name substring"),
+			stringParam("query", "executor", "Lattice or workflow executor"),
+			stringParam("query", "owner", "Exact match; ignored for users, who only see their own"),
+			timeParam("created_after", "Inclusive bound"),
+			timeParam("created_before", "Inclusive bound"),
+			timeParam("started_after", "Inclusive bound"),
+			timeParam("started_before", "Inclusive bound"),
+			timeParam("ended_after", "Inclusive bound"),
+			timeParam("ended_before", "Inclusive bound"),
+		}, sortParams...)...),
+		response: models.GetBulkDispatchesResponse{},
+	},
+	"DELETE /dispatches/{dispatch_id}": {
+		id:      "deleteDispatch",
+		summary: "Delete a dispatch and its assets",
+		status:  http.StatusAccepted,
+	},
+	"GET /dispatches/{dispatch_id}": {
+		id:       "getDispatch",
+		summary:  "Export a dispatch manifest",
+		response: models.DispatchSchema{},
+	},
+	"GET /dispatches/{dispatch_id}/assets": {
+		id:       "getDispatchAssets",
+		summary:  "Assets of a dispatch",
+		response: models.AssetLinksResponse{},
+	},
+	"PUT /dispatches/{dispatch_id}/status": {
+		id:       "updateDispatchStatus",
+		summary:  "Record a dispatch status transition; statuses other than CANCELLED require status:write",
+		request:  models.DispatchStatusUpdate{},
+		response: models.StatusEvent{},
+	},
+	"GET /dispatches/{dispatch_id}/events": {
+		id:      "streamDispatchEvents",
+		summary: "Server-sent status events of a dispatch, each with a StatusEvent as data",
+		query: []openapi.Parameter{
+			integerParam("query", "last_event_id", "Resume after this event, for clients which cannot set Last-Event-ID"),
+		},
+		headers: []openapi.Parameter{
+			integerParam("header", "Last-Event-ID", "Resume after this event"),
+		},
+		response:     models.StatusEvent{},
+		content_type: "text/event-stream",
+	},
+	"GET /dispatches/{dispatch_id}/electrons": {
+		id:      "searchElectrons",
+		summary: "Search the electrons of a dispatch",
+		query: withPagination(append([]openapi.Parameter{
+			stringParam("query", "status", "Repeatable or comma-separated; matches any"),
+			integerParam("query", "task_group_id", "Exact match"),
+			stringParam("query", "executor", "Exact match"),
+			{Name: "include_assets", In: "query", Description: "Defaults to true", Schema: &openapi.Schema{Type: "boolean"}},
+		}, sortParams...)...),
+		response: models.GetBulkElectronsResponse{},
+	},
+	"GET /dispatches/{dispatch_id}/electrons/{node_id}": {
+		id:      "getElectron",
+		summary: "An electron of a dispatch",
+		query: []openapi.Parameter{
+			{Name: "include_assets", In: "query", Description: "Defaults to true", Schema: &openapi.Schema{Type: "boolean"}},
+		},
+		response: models.ElectronSchema{},
+	},
+	"PATCH /dispatches/{dispatch_id}/electrons/{node_id}": {
+		id:       "updateElectronStatus",
+		summary:  "Record an electron status transition",
+		request:  models.ElectronStatusUpdate{},
+		response: models.StatusEvent{},
+	},
+	"GET /dispatches/{dispatch_id}/electrons/{node_id}/assets": {
+		id:       "getElectronAssets",
+		summary:  "Assets of an electron",
+		response: models.AssetLinksResponse{},
+	},
+	"POST /assets": {
+		id:       "createAssets",
+		summary:  "Register assets",
+		request:  models.BulkAssetPostBody{},
+		response: models.BulkAssetPostResponse{},
+	},
+	"GET /assets": {
+		id:       "searchAssets",
+		summary:  "Assets by key prefix",
+		query:    withPagination(stringParam("query", "prefix", "Key prefix")),
+		response: models.BulkAssetGetResponse{},
+	},
+	"POST /webhooks": {
+		id:       "createWebhook",
+		summary:  "Subscribe to status events",
+		request:  models.WebhookCreateRequest{},
+		response: models.Webhook{},
+		status:   http.StatusCreated,
+	},
+	"GET /webhooks": {
+		id:       "getWebhooks",
+		summary:  "Webhooks of the caller",
+		query:    []openapi.Parameter{stringParam("query", "dispatch_id", "Only webhooks scoped to this dispatch")},
+		response: models.GetBulkWebhooksResponse{},
+	},
+	"GET /webhooks/{webhook_id}": {
+		id:       "getWebhook",
+		summary:  "A webhook",
+		response: models.Webhook{},
+	},
+	"DELETE /webhooks/{webhook_id}": {
+		id:      "deleteWebhook",
+		summary: "Delete a webhook",
+		status:  http.StatusNoContent,
+	},
+	"GET /webhooks/{webhook_id}/deliveries": {
+		id:       "getWebhookDeliveries",
+		summary:  "Delivery attempts of a webhook",
+		query:    withPagination(stringParam("query", "state", "Delivery state")),
+		response: models.GetBulkWebhookDeliveriesResponse{},
+	},
+	"GET /namespaces": {
+		id:       "getNamespaces",
+		summary:  "Namespaces with their quotas and usage",
+		response: models.GetBulkNamespacesResponse{},
+	},
+	"GET /namespaces/{namespace}": {
+		id:       "getNamespace",
+		summary:  "A namespace with its quotas and usage",
+		response: models.Namespace{},
+	},
+	"PUT /namespaces/{namespace}": {
+		id:       "updateNamespace",
+		summary:  "Set the quotas of a namespace",
+		request:  models.NamespaceUpdateRequest{},
+		response: models.Namespace{},
+	},
+	"POST /tokens": {
+		id:       "createToken",
+		summary:  "Issue an API token; the secret is only returned here",
+		request:  models.TokenCreateRequest{},
+		response: models.APIToken{},
+		status:   http.StatusCreated,
+	},
+	"GET /tokens": {
+		id:       "getTokens",
+		summary:  "API tokens",
+		response: models.GetBulkTokensResponse{},
+	},
+	"DELETE /tokens/{token_id}": {
+		id:      "deleteToken",
+		summary: "Revoke an API token",
+		status:  http.StatusNoContent,
+	},
+	"GET /audit": {
+		id:      "searchAuditLog",
+		summary: "Search the audit log, newest entries first",
+		query: withPagination(
+			stringParam("query", "user", "Exact match"),
+			stringParam("query", "action", "Exact match"),
+			stringParam("query", "outcome", "denied, succeeded or failed"),
+			stringParam("query", "namespace", "Exact match"),
+			stringParam("query", "dispatch_id", "Exact match"),
+			timeParam("since", "Inclusive bound"),
+			timeParam("until", "Inclusive bound"),
+		),
+		response: models.GetBulkAuditEntriesResponse{},
+	},
+	"GET /healthz": {
+		id:       "getHealth",
+		summary:  "Liveness probe",
+		response: models.HealthResponse{},
+	},
+	"GET /readyz": {
+		id:       "getReadiness",
+		summary:  "Readiness probe; responds 503 with the same body when not ready",
+		response: models.ReadinessResponse{},
+	},
+	"GET /openapi.json": {
+		id:       "getOpenAPI",
+		summary:  "This document",
+		response: map[string]any{},
+	},
+	"GET /introspection": {
+		id:       "getRoutes",
+		summary:  "Registered route patterns",
+		response: models.APIIntrospectionResponse{},
+	},
+}
+
+func routeKey(verb string, path string) string {
+	return fmt.Sprintf("%s %s", verb, path)
+}
+
+// The OpenAPI document of the registered routes. Fails if a route has
+// no operationSpecs entry.
+func (m *GovalentAPIServer) OpenAPI() (*openapi.Document, error) {
+	g := openapi.NewGenerator()
+	doc := openapi.Document{
+		OpenAPI: openapi.OPENAPI_VERSION,
+		Info:    openapi.Info{Title: API_TITLE, Version: API_VERSION},
+		Paths:   make(map[string]openapi.PathItem),
+		Security: []openapi.SecurityReq{
+			{BEARER_AUTH_SCHEME: {}},
+		},
+	}
+	error_schema := g.SchemaOf(models.ErrorResponse{})
+	prefix := m.config.Get().APIPrefix
+	for _, route := range m.routes {
+		spec, ok := operationSpecs[routeKey(route.verb, route.path)]
+		if !ok {
+			return nil, fmt.Errorf("Route %s has no OpenAPI description", routeKey(route.verb, route.path))
+		}
+		op := newOperation(g, route, &spec)
+		op.Responses["default"] = openapi.Response{
+			Description: "Error",
+			Content:     map[string]openapi.MediaType{"application/json": {Schema: error_schema}},
+		}
+		full_path := route.path
+		if !route.unprefixed {
+			full_path = prefix + route.path
+		}
+		item, ok := doc.Paths[full_path]
+		if !ok {
+			item = make(openapi.PathItem)
+			doc.Paths[full_path] = item
+		}
+		item[strings.ToLower(route.verb)] = op
+	}
+	doc.Components = openapi.Components{
+		Schemas: g.Schemas,
+		SecuritySchemes: map[string]openapi.SecurityScheme{
+			BEARER_AUTH_SCHEME: {Type: "http", Scheme: "bearer"},
+		},
+	}
+	return &doc, nil
+}
+
+func newOperation(g *openapi.Generator, route apiRoute, spec *operationSpec) *openapi.Operation {
+	op := openapi.Operation{
+		OperationId: spec.id,
+		Summary:     spec.summary,
+		Tags:        []string{strings.Split(strings.TrimPrefix(route.path, "/"), "/")[0]},
+		Responses:   make(map[string]openapi.Response),
+		Security:    []openapi.SecurityReq{{BEARER_AUTH_SCHEME: {}}},
+		Scopes:      route.scopes,
+	}
+	if route.public {
+		op.Security = []openapi.SecurityReq{}
+	}
+	for _, segment := range strings.Split(route.path, "/") {
+		if !strings.HasPrefix(segment, "{") {
+			continue
+		}
+		name := strings.Trim(segment, "{}")
+		param := stringParam("path", name, "")
+		if integerPathParams[name] {
+			param = integerParam("path", name, "")
+		}
+		param.Required = true
+		op.Parameters = append(op.Parameters, param)
+	}
+	op.Parameters = append(op.Parameters, spec.query...)
+	op.Parameters = append(op.Parameters, spec.headers...)
+	if !route.public && !route.unprefixed && !strings.Contains(route.path, "{namespace}") {
+		op.Parameters = append(op.Parameters, stringParam("header", models.NAMESPACE_HEADER, "Namespace of the request; defaults to that of the caller's token"))
+	}
+
+	if spec.request != nil {
+		op.RequestBody = &openapi.RequestBody{
+			Required: true,
+			Content:  map[string]openapi.MediaType{"application/json": {Schema: g.SchemaOf(spec.request)}},
+		}
+	}
+	status := spec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	resp := openapi.Response{Description: http.StatusText(status)}
+	if spec.response != nil {
+		content_type := spec.content_type
+		if len(content_type) == 0 {
+			content_type = "application/json"
+		}
+		resp.Content = map[string]openapi.MediaType{content_type: {Schema: g.SchemaOf(spec.response)}}
+	}
+	op.Responses[strconv.Itoa(status)] = resp
+	return &op
+}
+
+// GET /openapi.json
+func (m *GovalentAPIServer) handleOpenAPI(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	doc, err := m.OpenAPI()
+	if err != nil {
+		api_err := models.NewGenericServerError(err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(doc); err != nil {
+		return http.StatusInternalServerError
+	}
+	return http.StatusOK
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/openapi"
+)
+
+func newTestServer(t *testing.T, prefix string) *GovalentAPIServer {
+	c, _, err := common.LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	c.APIPrefix = prefix
+	live := common.NewLiveConfig(c)
+	m := NewGovalentAPIServer(live, ":0")
+	m.AddRoutes(live, nil)
+	return m
+}
+
+// Routes with specs, sorted
+func specifiedRoutes() []string {
+	keys := make([]string, 0, len(operationSpecs))
+	for key := range operationSpecs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Every registered route must be described, and every description must
+// belong to a route
+func TestOpenAPICoversRoutes(t *testing.T) {
+	m := newTestServer(t, "/api/v0")
+	doc, err := m.OpenAPI()
+	if err != nil {
+		t.Fatalf("Error generating OpenAPI document: %v", err)
+	}
+
+	registered := make(map[string]bool)
+	for _, route := range m.routes {
+		registered[routeKey(route.verb, route.path)] = true
+		path := route.path
+		if !route.unprefixed {
+			path = "/api/v0" + path
+		}
+		if _, ok := doc.Paths[path][strings.ToLower(route.verb)]; !ok {
+			t.Errorf("No operation for %s %s", route.verb, path)
+		}
+	}
+	for _, key := range specifiedRoutes() {
+		if !registered[key] {
+			t.Errorf("Stale OpenAPI description of %s", key)
+		}
+	}
+
+	ids := make(map[string]string)
+	for path, item := range doc.Paths {
+		for method, op := range item {
+			if prev, ok := ids[op.OperationId]; ok || len(op.OperationId) == 0 {
+				t.Errorf("Operation id %q of %s %s not unique (also %s)", op.OperationId, method, path, prev)
+			}
+			ids[op.OperationId] = method + " " + path
+		}
+	}
+}
+
+func TestOpenAPIRefsResolve(t *testing.T) {
+	m := newTestServer(t, "")
+	doc, err := m.OpenAPI()
+	if err != nil {
+		t.Fatalf("Error generating OpenAPI document: %v", err)
+	}
+	var check func(where string, s *openapi.Schema)
+	check = func(where string, s *openapi.Schema) {
+		if s == nil {
+			return
+		}
+		if len(s.Ref) > 0 {
+			if _, ok := doc.Components.Schemas[strings.TrimPrefix(s.Ref, openapi.SCHEMA_REF_PREFIX)]; !ok {
+				t.Errorf("Dangling reference %s in %s", s.Ref, where)
+			}
+		}
+		check(where, s.Items)
+		check(where, s.AdditionalProperties)
+		for name, prop := range s.Properties {
+			check(where+"."+name, prop)
+		}
+	}
+	for path, item := range doc.Paths {
+		for method, op := range item {
+			where := method + " " + path
+			for _, p := range op.Parameters {
+				check(where, p.Schema)
+			}
+			if op.RequestBody != nil {
+				for _, mt := range op.RequestBody.Content {
+					check(where, mt.Schema)
+				}
+			}
+			for _, resp := range op.Responses {
+				for _, mt := range resp.Content {
+					check(where, mt.Schema)
+				}
+			}
+		}
+	}
+	for name, s := range doc.Components.Schemas {
+		check(name, s)
+	}
+
+	dispatch, ok := doc.Components.Schemas["DispatchSchema"]
+	if !ok {
+		t.Fatalf("DispatchSchema is not a component")
+	}
+	if _, ok := dispatch.Properties["metadata"]; !ok {
+		t.Errorf("DispatchSchema has no metadata property: %v", dispatch.Properties)
+	}
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	m := newTestServer(t, "")
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	m.mux.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var doc openapi.Document
+	if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+		t.Fatalf("Error decoding document: %v", err)
+	}
+	if doc.OpenAPI != openapi.OPENAPI_VERSION {
+		t.Errorf("Expected openapi %s, got %s", openapi.OPENAPI_VERSION, doc.OpenAPI)
+	}
+	op := doc.Paths["/healthz"]["get"]
+	if op == nil || len(op.Security) != 0 {
+		t.Errorf("Expected public /healthz operation, got %+v", op)
+	}
+	op = doc.Paths["/dispatches"]["post"]
+	if op == nil || len(op.Scopes) == 0 {
+		t.Errorf("Expected scoped POST /dispatches operation, got %+v", op)
+	}
+}
+
+// Introspection is served under the API prefix like the routes it lists
+func TestIntrospectionPrefixed(t *testing.T) {
+	m := newTestServer(t, "/api/v0")
+	found := false
+	for _, pattern := range m.patterns {
+		found = found || pattern == "GET /api/v0/introspection"
+	}
+	if !found {
+		t.Errorf("Expected GET /api/v0/introspection among %v", m.patterns)
+	}
+}
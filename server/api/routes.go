@@ -4,20 +4,37 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/events"
+	"github.com/casey/govalent/server/metrics"
 	"github.com/casey/govalent/server/models"
+	"github.com/casey/govalent/server/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Dispatch management
 
 type RequestHandler struct {
-	config      *common.Config
+	// Snapshot passed to handlerFunc for each request
+	config      *common.LiveConfig
 	dbPool      *sql.DB
 	handlerFunc func(*common.Config, *sql.DB, http.ResponseWriter, *http.Request) int
+	// Required of the caller; set by GovalentAPIServer.AddRoute
+	scopes []string
+	// Path pattern without the API prefix, used as the route label of
+	// request metrics
+	route string
+	// Served without authentication, e.g. to health probes
+	public bool
 }
 
 type PaginationParams struct {
@@ -35,52 +52,193 @@ type JSONResponse interface {
 	EncodeJSON(*json.Encoder) *models.APIError
 }
 
+type JSONStreamResponse interface {
+	// Validate and JSON-encode incrementally
+	EncodeJSONStream(io.Writer) *models.APIError
+}
+
 type GovalentAPIServer struct {
 	Srv      *http.Server
 	mux      *http.ServeMux
 	patterns []string
-	config   *common.Config
+	// Registered routes, described by OpenAPI
+	routes []apiRoute
+	config *common.LiveConfig
+	// Status transitions, published once committed
+	Events *events.Bus
+	// Set once Shutdown begins
+	draining atomic.Bool
 }
 
-func NewGovalentAPIServer(c *common.Config, addr string) *GovalentAPIServer {
+func NewGovalentAPIServer(c *common.LiveConfig, addr string) *GovalentAPIServer {
 	mux := http.NewServeMux()
 	return &GovalentAPIServer{
 		Srv:      &http.Server{Addr: addr, Handler: mux},
 		mux:      mux,
 		patterns: make([]string, 0),
 		config:   c,
+		Events:   events.NewBus(),
 	}
 }
 
-func (m *GovalentAPIServer) AddRoute(verb string, path string, handler RequestHandler) {
-	pattern := fmt.Sprintf("%s %s%s", verb, m.config.APIPrefix, path)
+type apiRoute struct {
+	verb string
+	// Without the API prefix
+	path   string
+	scopes []string
+	// Served at path rather than under the API prefix
+	unprefixed bool
+	public     bool
+}
+
+// Routes without scopes only require an authenticated caller
+func (m *GovalentAPIServer) AddRoute(verb string, path string, handler RequestHandler, scopes ...string) {
+	handler.scopes = scopes
+	handler.route = path
+	pattern := fmt.Sprintf("%s %s%s", verb, m.config.Get().APIPrefix, path)
 	m.mux.Handle(pattern, handler)
 	m.patterns = append(m.patterns, pattern)
-	// TODO: add instrospection route
+	m.routes = append(m.routes, apiRoute{verb: verb, path: path, scopes: scopes})
 }
 
+// Serve handler at path regardless of the API prefix, e.g. for probes
+func (m *GovalentAPIServer) addUnprefixedRoute(verb string, path string, handler RequestHandler) {
+	handler.route = path
+	m.mux.Handle(fmt.Sprintf("%s %s", verb, path), handler)
+	m.routes = append(m.routes, apiRoute{verb: verb, path: path, unprefixed: true, public: handler.public})
+}
+
+// Upper bound on the count query parameter
+const MAX_PAGE_COUNT = 1000
+
 func NewPaginationParamsFromReq(r *http.Request) (PaginationParams, *models.APIError) {
 	count, err := extractQueryInt(r, "count", 10)
 	if err != nil {
 		return PaginationParams{}, err
 	}
+	if count < 1 || count > MAX_PAGE_COUNT {
+		detail := fmt.Sprintf("Expected integer between 1 and %d", MAX_PAGE_COUNT)
+		return PaginationParams{}, models.NewValidationError(models.NewSingleValidationError("query", "count", detail))
+	}
 	page, err := extractQueryInt(r, "page", 0)
 	if err != nil {
 		return PaginationParams{}, err
 	}
+	if page < 0 {
+		detail := "Expected non-negative integer"
+		return PaginationParams{}, models.NewValidationError(models.NewSingleValidationError("query", "page", detail))
+	}
 	return PaginationParams{Count: count, Page: page}, nil
 }
 
-func (h RequestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	code := h.handlerFunc(h.config, h.dbPool, w, r)
-	slog.Info(fmt.Sprintf("%s %s %s %d\n", r.Method, r.URL.Path, r.Proto, code))
+// Longest client-supplied request id that is propagated
+const MAX_REQUEST_ID_LENGTH = 128
+
+// Echo the client's request id if present and well formed; otherwise
+// generate one. The id is included in error bodies written by
+// models.WriteError, set on r for later stages and added to log lines
+// through the returned request's context.
+func setRequestId(w http.ResponseWriter, r *http.Request) *http.Request {
+	request_id := r.Header.Get(models.REQUEST_ID_HEADER)
+	if !validRequestId(request_id) {
+		request_id = uuid.NewString()
+	}
+	w.Header().Set(models.REQUEST_ID_HEADER, request_id)
+	r.Header.Set(models.REQUEST_ID_HEADER, request_id)
+	return r.WithContext(common.WithRequestId(r.Context(), request_id))
+}
+
+// Printable ASCII without spaces
+func validRequestId(request_id string) bool {
+	if len(request_id) == 0 || len(request_id) > MAX_REQUEST_ID_LENGTH {
+		return false
+	}
+	for _, ch := range request_id {
+		if ch <= ' ' || ch > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// Counts the bytes of the response body for the access log
+type responseRecorder struct {
+	http.ResponseWriter
+	bytes int64
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Lets http.ResponseController reach the underlying writer, e.g. to
+// flush event streams
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Authenticates and authorizes the request before calling the handler;
+// handlers read the caller with principalFromRequest and the namespace
+// with namespaceFromRequest. Public handlers are called directly.
+func (h RequestHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	w := &responseRecorder{ResponseWriter: rw}
+	r, span := tracing.StartRequest(r, h.route)
+	defer span.End()
+	r = setRequestId(w, r)
+	c := h.config.Get()
+	if h.public {
+		code := h.handlerFunc(c, h.dbPool, w, r)
+		h.logRequest(r, w, nil, code, start)
+		return
+	}
+	var ns string
+	p, err := authenticate(c, h.dbPool, r)
+	if err == nil {
+		ns, err = resolveNamespace(r, &p)
+	}
+	if err == nil {
+		err = authorize(h.dbPool, &h, r, &p, ns)
+	}
+	if err != nil {
+		writeAuthError(w, err)
+		h.logRequest(r, w, &p, err.StatusCode, start)
+		return
+	}
+	code := h.handlerFunc(c, h.dbPool, w, withNamespace(withPrincipal(r, p), ns))
+	h.logRequest(r, w, &p, code, start)
+}
+
+// Access log line, request metrics and span status. p is the caller,
+// if known.
+func (h *RequestHandler) logRequest(r *http.Request, w *responseRecorder, p *models.Principal, code int, start time.Time) {
+	duration := time.Since(start)
+	attrs := []slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.String("route", h.route),
+		slog.String("proto", r.Proto),
+		slog.Int("status", code),
+		slog.Float64("duration_ms", float64(duration.Microseconds())/1000),
+		slog.Int64("bytes", w.bytes),
+		slog.String("client", r.RemoteAddr),
+	}
+	if p != nil && len(p.User) > 0 {
+		attrs = append(attrs, slog.String("user", p.User))
+	}
+	slog.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+	metrics.ObserveRequest(h.route, r.Method, code, duration)
+	tracing.SetResponseStatus(trace.SpanFromContext(r.Context()), code)
 }
 
-// Introspection route
-func (s *GovalentAPIServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+// GET /introspection
+//
+// The patterns of the routes served under the API prefix
+func (s *GovalentAPIServer) handleIntrospection(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
 	respBody := models.APIIntrospectionResponse{Routes: s.patterns}
-	code := writeJSONResponse(w, &respBody)
-	slog.Info(fmt.Sprintf("%s %s %s %d\n", r.Method, r.URL.Path, r.Proto, code))
+	return writeJSONResponse(w, &respBody)
 }
 
 func writeJSONResponse(w http.ResponseWriter, respBody JSONResponse) int {
@@ -94,6 +252,17 @@ func writeJSONResponse(w http.ResponseWriter, respBody JSONResponse) int {
 	return http.StatusOK
 }
 
+// Errors raised after the first byte has been written can no longer
+// change the status code; the response is truncated instead.
+func writeJSONStreamResponse(w http.ResponseWriter, respBody JSONStreamResponse) int {
+	err := respBody.EncodeJSONStream(w)
+	if err != nil {
+		slog.Error(fmt.Sprint("Error streaming response:", err.Error()))
+		return http.StatusInternalServerError
+	}
+	return http.StatusOK
+}
+
 // Parsing and validation utility functions
 func extractQueryString(r *http.Request, key string, default_value string) (string, *models.APIError) {
 	val := r.FormValue(key)
@@ -111,16 +280,79 @@ func extractQueryInt(r *http.Request, key string, default_value int) (int, *mode
 	}
 	i, err := strconv.Atoi(val)
 	if err != nil {
-		return 0, models.NewValidationError(err)
+		return 0, models.NewValidationError(
+			models.NewSingleValidationError("query", key, "Expected integer"),
+		)
 	}
 	return i, nil
 }
 
+// Values of a repeatable query parameter; each occurrence may also hold
+// a comma-separated list
+func extractQueryStrings(r *http.Request, key string) []string {
+	if err := r.ParseForm(); err != nil {
+		return nil
+	}
+	var vals []string
+	for _, item := range r.Form[key] {
+		for _, val := range strings.Split(item, ",") {
+			if val = strings.TrimSpace(val); len(val) > 0 {
+				vals = append(vals, val)
+			}
+		}
+	}
+	return vals
+}
+
+// Repeatable status filter; every value must be a known status
+func extractQueryStatuses(r *http.Request, key string) ([]string, *models.APIError) {
+	statuses := extractQueryStrings(r, key)
+	for _, status := range statuses {
+		if !common.ValidateStatus(status) {
+			detail := models.NewSingleValidationError("query", key, models.ERROR_DETAIL_INVALID)
+			return nil, models.NewValidationError(detail)
+		}
+	}
+	return statuses, nil
+}
+
+// The sort and direction (asc or desc) query parameters
+func extractSortParams(r *http.Request, default_key string, default_ascending bool) (string, bool, *models.APIError) {
+	sort_key, _ := extractQueryString(r, "sort", default_key)
+	direction, _ := extractQueryString(r, "direction", "")
+	switch strings.ToLower(direction) {
+	case "":
+		return sort_key, default_ascending, nil
+	case "asc":
+		return sort_key, true, nil
+	case "desc":
+		return sort_key, false, nil
+	default:
+		detail := models.NewSingleValidationError("query", "direction", models.ERROR_DETAIL_INVALID)
+		return "", false, models.NewValidationError(detail)
+	}
+}
+
+// RFC 3339 timestamp; nil if the parameter is absent
+func extractQueryTime(r *http.Request, key string) (*time.Time, *models.APIError) {
+	val := r.FormValue(key)
+	if len(val) == 0 {
+		return nil, nil
+	}
+	ts, err := time.Parse(time.RFC3339Nano, val)
+	if err != nil {
+		return nil, models.NewValidationError(
+			models.NewSingleValidationError("query", key, "Expected RFC 3339 timestamp"),
+		)
+	}
+	return &ts, nil
+}
+
 func extractPathString(r *http.Request, key string) (string, *models.APIError) {
 	val := r.PathValue(key)
 	if len(val) == 0 {
-		e := fmt.Errorf("Expected path parameter %s", key)
-		return "", &models.APIError{StatusCode: 422, Err: e}
+		e := models.NewSingleValidationError("path", key, models.ERROR_DETAIL_MISSING)
+		return "", models.NewValidationError(e)
 	} else {
 		return val, nil
 	}
@@ -129,42 +361,63 @@ func extractPathString(r *http.Request, key string) (string, *models.APIError) {
 func extractPathInt(r *http.Request, key string) (int, *models.APIError) {
 	val := r.PathValue(key)
 	if len(val) == 0 {
-		e := fmt.Errorf("Expected path parameter %s", key)
-		return 0, &models.APIError{StatusCode: 422, Err: e}
+		e := models.NewSingleValidationError("path", key, models.ERROR_DETAIL_MISSING)
+		return 0, models.NewValidationError(e)
 	}
 	i, err := strconv.Atoi(val)
 	if err != nil {
-		e := fmt.Errorf("Expected integer for path parameter %s", key)
-		return 0, &models.APIError{StatusCode: 422, Err: e}
+		e := models.NewSingleValidationError("path", key, "Expected integer")
+		return 0, models.NewValidationError(e)
 	} else {
 		return i, nil
 	}
 }
 
+// Fallback for unmatched routes so that clients always receive a JSON
+// error body
+func handleNotFound(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	err := models.NewNotFoundError(fmt.Errorf("No route for %s %s", r.Method, r.URL.Path))
+	models.WriteError(w, err)
+	return err.StatusCode
+}
+
+// GET /metrics
+func handleMetrics(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	metrics.Handler().ServeHTTP(w, r)
+	return http.StatusOK
+}
+
 // GET /config
 func handleGetConfig(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
-	configResponse := models.ConfigResponse{Config: c}
+	configResponse := models.NewConfigResponse(c)
 	writeJSONResponse(w, &configResponse)
 	return http.StatusOK
 }
 
-// TODO: PUT /dispatches/{dispatch_id}/status
-// TODO: PATCH /dispatches/{dispatch_id}/electrons/{node_id}
-
 // Assets
 //
 // POST /assets
 
-func (m *GovalentAPIServer) AddRoutes(c *common.Config, d *sql.DB) {
+func (m *GovalentAPIServer) AddRoutes(c *common.LiveConfig, d *sql.DB) {
 	dump_config_handler := RequestHandler{
 		config:      c,
 		dbPool:      d,
 		handlerFunc: handleGetConfig,
 	}
+	update_config_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: audited(models.AUDIT_ACTION_UPDATE_CONFIG, m.handleUpdateConfig),
+	}
+	metrics_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: handleMetrics,
+	}
 	create_dispatch_handler := RequestHandler{
 		config:      c,
 		dbPool:      d,
-		handlerFunc: handleImportManifest,
+		handlerFunc: audited(models.AUDIT_ACTION_IMPORT_DISPATCH, m.unlessDraining(handleImportManifest)),
 	}
 	bulk_get_dispatches_handler := RequestHandler{
 		config:      c,
@@ -174,7 +427,7 @@ func (m *GovalentAPIServer) AddRoutes(c *common.Config, d *sql.DB) {
 	delete_dispatch_handler := RequestHandler{
 		config:      c,
 		dbPool:      d,
-		handlerFunc: handleDeleteDispatch,
+		handlerFunc: audited(models.AUDIT_ACTION_DELETE_DISPATCH, handleDeleteDispatch),
 	}
 	export_manifest_handler := RequestHandler{
 		config:      c,
@@ -197,24 +450,140 @@ func (m *GovalentAPIServer) AddRoutes(c *common.Config, d *sql.DB) {
 		handlerFunc: handleExportAssets,
 	}
 
+	update_dispatch_status_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: audited(models.AUDIT_ACTION_DISPATCH_STATUS, m.handleUpdateDispatchStatus),
+	}
+	stream_events_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: m.handleStreamEvents,
+	}
+	update_electron_status_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: audited(models.AUDIT_ACTION_ELECTRON_STATUS, m.handleUpdateElectronStatus),
+	}
+	get_electrons_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: handleGetElectrons,
+	}
+	get_electron_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: handleGetElectron,
+	}
 	get_electron_asset_links_handler := RequestHandler{
 		config:      c,
 		dbPool:      d,
 		handlerFunc: handleGetElectronAssetLinks,
 	}
+	create_webhook_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: audited(models.AUDIT_ACTION_CREATE_WEBHOOK, handleCreateWebhook),
+	}
+	get_webhooks_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: handleGetWebhooks,
+	}
+	get_webhook_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: handleGetWebhook,
+	}
+	delete_webhook_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: audited(models.AUDIT_ACTION_DELETE_WEBHOOK, handleDeleteWebhook),
+	}
+	get_webhook_deliveries_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: handleGetWebhookDeliveries,
+	}
+	get_namespaces_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: handleGetNamespaces,
+	}
+	get_namespace_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: handleGetNamespace,
+	}
+	update_namespace_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: audited(models.AUDIT_ACTION_UPDATE_NAMESPACE, handleUpdateNamespace),
+	}
+	create_token_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: audited(models.AUDIT_ACTION_CREATE_TOKEN, handleCreateToken),
+	}
+	get_tokens_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: handleGetTokens,
+	}
+	delete_token_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: audited(models.AUDIT_ACTION_DELETE_TOKEN, handleDeleteToken),
+	}
+	get_audit_log_handler := RequestHandler{
+		config:      c,
+		dbPool:      d,
+		handlerFunc: handleGetAuditLog,
+	}
+
+	m.AddRoute("GET", "/config", dump_config_handler, models.SCOPE_CONFIG)
+	m.AddRoute("PUT", "/config", update_config_handler, models.SCOPE_ADMIN)
+	m.AddRoute("GET", "/metrics", metrics_handler, models.SCOPE_METRICS)
+	m.AddRoute("POST", "/dispatches", create_dispatch_handler, models.SCOPE_DISPATCHES_WRITE)
+	m.AddRoute("GET", "/dispatches", bulk_get_dispatches_handler, models.SCOPE_DISPATCHES_READ)
+	m.AddRoute("DELETE", "/dispatches/{dispatch_id}", delete_dispatch_handler, models.SCOPE_DISPATCHES_DELETE)
+	m.AddRoute("GET", "/dispatches/{dispatch_id}", export_manifest_handler, models.SCOPE_DISPATCHES_READ)
+	m.AddRoute("GET", "/dispatches/{dispatch_id}/assets", get_dispatch_asset_links_handler, models.SCOPE_DISPATCHES_READ)
+
+	m.AddRoute("PUT", "/dispatches/{dispatch_id}/status", update_dispatch_status_handler, models.SCOPE_DISPATCHES_CANCEL)
+	m.AddRoute("GET", "/dispatches/{dispatch_id}/events", stream_events_handler, models.SCOPE_DISPATCHES_READ)
+
+	m.AddRoute("GET", "/dispatches/{dispatch_id}/electrons", get_electrons_handler, models.SCOPE_DISPATCHES_READ)
+	m.AddRoute("GET", "/dispatches/{dispatch_id}/electrons/{node_id}", get_electron_handler, models.SCOPE_DISPATCHES_READ)
+	m.AddRoute("PATCH", "/dispatches/{dispatch_id}/electrons/{node_id}", update_electron_status_handler, models.SCOPE_STATUS_WRITE)
+	m.AddRoute("GET", "/dispatches/{dispatch_id}/electrons/{node_id}/assets", get_electron_asset_links_handler, models.SCOPE_DISPATCHES_READ)
+
+	m.AddRoute("POST", "/assets", create_assets_handler, models.SCOPE_ASSETS_WRITE)
+	m.AddRoute("GET", "/assets", export_assets_handler, models.SCOPE_ASSETS_READ)
+
+	m.AddRoute("POST", "/webhooks", create_webhook_handler, models.SCOPE_WEBHOOKS)
+	m.AddRoute("GET", "/webhooks", get_webhooks_handler, models.SCOPE_WEBHOOKS)
+	m.AddRoute("GET", "/webhooks/{webhook_id}", get_webhook_handler, models.SCOPE_WEBHOOKS)
+	m.AddRoute("DELETE", "/webhooks/{webhook_id}", delete_webhook_handler, models.SCOPE_WEBHOOKS)
+	m.AddRoute("GET", "/webhooks/{webhook_id}/deliveries", get_webhook_deliveries_handler, models.SCOPE_WEBHOOKS)
+
+	m.AddRoute("GET", "/namespaces", get_namespaces_handler, models.SCOPE_DISPATCHES_READ)
+	m.AddRoute("GET", "/namespaces/{namespace}", get_namespace_handler, models.SCOPE_DISPATCHES_READ)
+	m.AddRoute("PUT", "/namespaces/{namespace}", update_namespace_handler, models.SCOPE_ADMIN)
+
+	m.AddRoute("POST", "/tokens", create_token_handler, models.SCOPE_TOKENS)
+	m.AddRoute("GET", "/tokens", get_tokens_handler, models.SCOPE_TOKENS)
+	m.AddRoute("DELETE", "/tokens/{token_id}", delete_token_handler, models.SCOPE_TOKENS)
 
-	m.AddRoute("GET", "/config", dump_config_handler)
-	m.AddRoute("POST", "/dispatches", create_dispatch_handler)
-	m.AddRoute("GET", "/dispatches", bulk_get_dispatches_handler)
-	m.AddRoute("DELETE", "/dispatches/{dispatch_id}", delete_dispatch_handler)
-	m.AddRoute("GET", "/dispatches/{dispatch_id}", export_manifest_handler)
-	m.AddRoute("GET", "/dispatches/{dispatch_id}/assets", get_dispatch_asset_links_handler)
+	m.AddRoute("GET", "/audit", get_audit_log_handler, models.SCOPE_AUDIT)
 
-	m.AddRoute("GET", "/dispatches/{dispatch_id}/electrons/{node_id}/assets", get_electron_asset_links_handler)
+	m.AddRoute("GET", "/introspection", RequestHandler{config: c, dbPool: d, handlerFunc: m.handleIntrospection})
 
-	m.AddRoute("POST", "/assets", create_assets_handler)
-	m.AddRoute("GET", "/assets", export_assets_handler)
+	// Probes and the API description are served without the API prefix
+	// or authentication
+	m.addUnprefixedRoute("GET", "/healthz", RequestHandler{config: c, dbPool: d, handlerFunc: handleHealth, public: true})
+	m.addUnprefixedRoute("GET", "/readyz", RequestHandler{config: c, dbPool: d, handlerFunc: m.handleReady, public: true})
+	m.addUnprefixedRoute("GET", "/openapi.json", RequestHandler{config: c, dbPool: d, handlerFunc: m.handleOpenAPI, public: true})
 
-	// TODO: add introspection route
-	m.mux.Handle("GET /introspection", m)
+	m.mux.Handle("/", RequestHandler{config: c, dbPool: d, handlerFunc: handleNotFound, route: "unmatched"})
 }
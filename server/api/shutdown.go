@@ -0,0 +1,44 @@
+// Graceful shutdown of the API server
+
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/models"
+)
+
+// Seconds clients are asked to wait before retrying requests rejected
+// during shutdown
+const SHUTDOWN_RETRY_AFTER = 5
+
+type handlerFunc = func(*common.Config, *sql.DB, http.ResponseWriter, *http.Request) int
+
+// Stop accepting new work and wait until in-flight requests complete
+// or ctx expires. Event streams are closed since they would otherwise
+// last until the deadline.
+func (m *GovalentAPIServer) Shutdown(ctx context.Context) error {
+	m.draining.Store(true)
+	m.Events.Close()
+	return m.Srv.Shutdown(ctx)
+}
+
+// Respond 503 once Shutdown has begun, e.g. to requests arriving on a
+// kept-alive connection, instead of calling f
+func (m *GovalentAPIServer) unlessDraining(f handlerFunc) handlerFunc {
+	return func(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+		if !m.draining.Load() {
+			return f(c, d, w, r)
+		}
+		err := models.NewServiceUnavailableError(errors.New("Server is shutting down"))
+		w.Header().Set("Retry-After", strconv.Itoa(SHUTDOWN_RETRY_AFTER))
+		w.Header().Set("Connection", "close")
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+}
@@ -0,0 +1,238 @@
+// Routes for managing webhook subscriptions
+
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/crud"
+	"github.com/casey/govalent/server/models"
+)
+
+// Random bytes in a generated webhook secret
+const WEBHOOK_SECRET_BYTES = 32
+
+// Webhooks receive the events of namespace; those of non-admins only
+// the events of their own dispatches
+func createWebhook(ctx context.Context, c *common.Config, d *sql.DB, req *models.WebhookCreateRequest, p *models.Principal, namespace string) (models.Webhook, *models.APIError) {
+	h := models.Webhook{
+		Url:        req.Url,
+		DispatchId: req.DispatchId,
+		EventKinds: req.EventKinds,
+		Statuses:   req.Statuses,
+		Secret:     req.Secret,
+		Namespace:  namespace,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if !p.IsAdmin() {
+		h.Owner = p.User
+	}
+	if h.EventKinds == nil {
+		h.EventKinds = []string{}
+	}
+	if h.Statuses == nil {
+		h.Statuses = []string{}
+	}
+	if len(h.Secret) == 0 {
+		buf := make([]byte, WEBHOOK_SECRET_BYTES)
+		if _, err := rand.Read(buf); err != nil {
+			return h, models.NewGenericServerError(err)
+		}
+		h.Secret = hex.EncodeToString(buf)
+	}
+
+	t, db_err := crud.BeginTx(ctx, d)
+	if db_err != nil {
+		return h, models.NewGenericServerError(db_err)
+	}
+	defer t.Rollback()
+	if h.DispatchId != nil {
+		owner, err := crud.GetDispatchOwner(t, namespace, *h.DispatchId)
+		if err != nil {
+			return h, err
+		}
+		if !p.Owns(owner) {
+			return h, models.NewNotFoundError(fmt.Errorf("Dispatch %s not found", *h.DispatchId))
+		}
+	}
+	if err := crud.CreateWebhook(t, &h); err != nil {
+		return h, err
+	}
+	if db_err := t.Commit(); db_err != nil {
+		return h, models.NewGenericServerError(db_err)
+	}
+	return h, nil
+}
+
+// POST /webhooks
+//
+// The response includes the signing secret; it is not returned again.
+func handleCreateWebhook(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	var reqBody models.WebhookCreateRequest
+	if err := (&reqBody).DecodeJSON(json.NewDecoder(r.Body)); err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	p := principalFromRequest(r)
+	respBody, err := createWebhook(r.Context(), c, d, &reqBody, &p, namespaceFromRequest(r))
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	auditEntryFromRequest(r).Detail = fmt.Sprintf("webhook %d", respBody.Id)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := (&respBody).EncodeJSON(json.NewEncoder(w)); err != nil {
+		return http.StatusInternalServerError
+	}
+	return http.StatusCreated
+}
+
+// Users other than admins may only access the webhooks they created.
+// Webhooks of other namespaces are not found.
+func getOwnedWebhook(t *sql.Tx, r *http.Request, webhook_id int64) (models.Webhook, *models.APIError) {
+	h, err := crud.GetWebhook(t, webhook_id)
+	if err != nil {
+		return h, err
+	}
+	if h.Namespace != namespaceFromRequest(r) {
+		return models.Webhook{}, models.NewNotFoundError(fmt.Errorf("Webhook %d not found", webhook_id))
+	}
+	if p := principalFromRequest(r); !p.IsAdmin() && h.Owner != p.User {
+		return models.Webhook{}, models.NewNotFoundError(fmt.Errorf("Webhook %d not found", webhook_id))
+	}
+	return h, nil
+}
+
+// GET /webhooks
+//
+// Query parameters (all optional):
+//
+//	dispatch_id: only webhooks scoped to this dispatch
+func handleGetWebhooks(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	dispatch_id, _ := extractQueryString(r, "dispatch_id", "")
+	owner := ""
+	if p := principalFromRequest(r); !p.IsAdmin() {
+		owner = p.User
+	}
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	hooks, err := crud.GetWebhooks(t, namespaceFromRequest(r), dispatch_id, owner)
+	t.Rollback()
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	respBody := models.GetBulkWebhooksResponse{Records: hooks}
+	return writeJSONResponse(w, &respBody)
+}
+
+// GET /webhooks/{webhook_id}
+func handleGetWebhook(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	webhook_id, err := extractPathInt(r, "webhook_id")
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	respBody, err := getOwnedWebhook(t, r, int64(webhook_id))
+	t.Rollback()
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	return writeJSONResponse(w, &respBody)
+}
+
+// DELETE /webhooks/{webhook_id}
+//
+// Pending deliveries are discarded.
+func handleDeleteWebhook(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	webhook_id, err := extractPathInt(r, "webhook_id")
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	auditEntryFromRequest(r).Detail = fmt.Sprintf("webhook %d", webhook_id)
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	defer t.Rollback()
+	if _, err := getOwnedWebhook(t, r, int64(webhook_id)); err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	if err := crud.DeleteWebhook(t, int64(webhook_id)); err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	if db_err := t.Commit(); db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return http.StatusNoContent
+}
+
+// GET /webhooks/{webhook_id}/deliveries
+//
+// Query parameters (all optional):
+//
+//	page, count: pagination
+//	state: one of pending, delivered, dead_lettered
+func handleGetWebhookDeliveries(c *common.Config, d *sql.DB, w http.ResponseWriter, r *http.Request) int {
+	webhook_id, err := extractPathInt(r, "webhook_id")
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	pagination, err := NewPaginationParamsFromReq(r)
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	state, _ := extractQueryString(r, "state", "")
+	if len(state) > 0 && !models.ValidateWebhookDeliveryState(state) {
+		err := models.NewValidationError(models.NewSingleValidationError("query", "state", models.ERROR_DETAIL_INVALID))
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	t, db_err := crud.BeginTx(r.Context(), d)
+	if db_err != nil {
+		api_err := models.NewGenericServerError(db_err)
+		models.WriteError(w, api_err)
+		return api_err.StatusCode
+	}
+	defer t.Rollback()
+	if _, err := getOwnedWebhook(t, r, int64(webhook_id)); err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	respBody, err := crud.SearchWebhookDeliveries(t, int64(webhook_id), state, pagination.Page, pagination.Count)
+	if err != nil {
+		models.WriteError(w, err)
+		return err.StatusCode
+	}
+	return writeJSONResponse(w, &respBody)
+}
@@ -0,0 +1,218 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/casey/govalent/server/models"
+	"github.com/google/uuid"
+)
+
+// POST /assets
+//
+// The response carries an upload URI for each asset of positive size
+// without a Uri.
+func (c *Client) RegisterAssets(ctx context.Context, assets []models.AssetPublicSchema) (*models.BulkAssetPostResponse, error) {
+	req := request{method: http.MethodPost, path: "/assets", body: &models.BulkAssetPostBody{Assets: assets}}
+	var res models.BulkAssetPostResponse
+	if err := c.do(ctx, &req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// GET /assets
+func (c *Client) ListAssets(ctx context.Context, prefix string, page int, count int) (*models.BulkAssetGetResponse, error) {
+	query := url.Values{}
+	setPagination(query, page, count)
+	setString(query, "prefix", prefix)
+	var res models.BulkAssetGetResponse
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/assets", query: query}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// GET /dispatches/{dispatch_id}/assets
+func (c *Client) GetDispatchAssets(ctx context.Context, dispatch_id string) (*models.AssetLinksResponse, error) {
+	var res models.AssetLinksResponse
+	if err := c.do(ctx, &request{method: http.MethodGet, path: dispatchPath(dispatch_id) + "/assets"}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// GET /dispatches/{dispatch_id}/electrons/{node_id}/assets
+func (c *Client) GetElectronAssets(ctx context.Context, dispatch_id string, node_id int) (*models.AssetLinksResponse, error) {
+	var res models.AssetLinksResponse
+	if err := c.do(ctx, &request{method: http.MethodGet, path: electronPath(dispatch_id, node_id) + "/assets"}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Write the contents of an asset to its remote URI.
+//
+// file URIs, which the server issues for its own storage directory, are
+// written directly and so require access to that filesystem; http(s)
+// URIs are PUT without credentials. Uploads are not retried.
+func (c *Client) UploadAsset(ctx context.Context, remote_uri string, r io.Reader, size int64) error {
+	u, err := url.Parse(remote_uri)
+	if err != nil {
+		return fmt.Errorf("Invalid asset URI %q: %w", remote_uri, err)
+	}
+	switch u.Scheme {
+	case "file":
+		if err := os.MkdirAll(filepath.Dir(u.Path), 0o755); err != nil {
+			return err
+		}
+		f, err := os.Create(u.Path)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, remote_uri, r)
+		if err != nil {
+			return err
+		}
+		req.ContentLength = size
+		req.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("Error uploading asset to %s: %s", remote_uri, resp.Status)
+		}
+		return nil
+	default:
+		return fmt.Errorf("Unsupported asset URI scheme %q", u.Scheme)
+	}
+}
+
+// Copy the contents of an asset from its URI to w; the same schemes
+// as UploadAsset are supported
+func (c *Client) DownloadAsset(ctx context.Context, uri string, w io.Writer) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("Invalid asset URI %q: %w", uri, err)
+	}
+	switch u.Scheme {
+	case "file":
+		f, err := os.Open(u.Path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("Error downloading asset from %s: %s", uri, resp.Status)
+		}
+		_, err = io.Copy(w, resp.Body)
+		return err
+	default:
+		return fmt.Errorf("Unsupported asset URI scheme %q", u.Scheme)
+	}
+}
+
+// Where the contents of an asset can be read from: the server's copy
+// if it has one, otherwise the URI it was registered with
+func AssetSource(a *models.AssetDetails) string {
+	if len(a.RemoteUri) > 0 {
+		return a.RemoteUri
+	}
+	return a.Uri
+}
+
+// Assets of a manifest keyed by their position in it, e.g. lattice/inputs
+// or node/3/output
+func manifestAssets(m *models.DispatchSchema) map[string]*models.AssetDetails {
+	assets := make(map[string]*models.AssetDetails)
+	for name, a := range m.Assets.AttrsByName() {
+		assets["dispatch/"+name] = a
+	}
+	for name, a := range m.Lattice.Assets.AttrsByName() {
+		assets["lattice/"+name] = a
+	}
+	for i := range m.Lattice.TransportGraph.Nodes {
+		node := &m.Lattice.TransportGraph.Nodes[i]
+		for name, a := range node.Assets.AttrsByName() {
+			assets[fmt.Sprintf("node/%d/%s", node.NodeId, name)] = a
+		}
+	}
+	return assets
+}
+
+// Import a manifest whose assets refer to local files by file URI, then
+// upload those files to the URIs the server returns.
+//
+// Local URIs are withheld from the import, as the server only issues
+// upload URIs for assets without one; manifest is left unchanged.
+// Assets with other URIs are registered as they are.
+func (c *Client) SubmitDispatch(ctx context.Context, manifest *models.DispatchSchema) (*models.DispatchSchema, error) {
+	return c.SubmitDispatchWithKey(ctx, manifest, uuid.NewString())
+}
+
+// Resubmissions with the same idempotency key return the dispatch
+// created by the first; its assets are uploaded again
+func (c *Client) SubmitDispatchWithKey(ctx context.Context, manifest *models.DispatchSchema, idempotency_key string) (*models.DispatchSchema, error) {
+	local := make(map[string]string)
+	for key, a := range manifestAssets(manifest) {
+		if u, err := url.Parse(a.Uri); err == nil && u.Scheme == "file" {
+			local[key] = u.Path
+			defer func(a *models.AssetDetails, uri string) { a.Uri = uri }(a, a.Uri)
+			a.Uri = ""
+		}
+	}
+	res, err := c.ImportDispatchWithKey(ctx, manifest, idempotency_key)
+	if err != nil {
+		return nil, err
+	}
+	for key, a := range manifestAssets(res) {
+		path, ok := local[key]
+		if !ok || len(a.RemoteUri) == 0 {
+			continue
+		}
+		if err := c.uploadFile(ctx, a.RemoteUri, path); err != nil {
+			return res, fmt.Errorf("Error uploading %s of dispatch %s: %w", key, res.Metadata.DispatchId, err)
+		}
+	}
+	return res, nil
+}
+
+func (c *Client) uploadFile(ctx context.Context, remote_uri string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return c.UploadAsset(ctx, remote_uri, f, info.Size())
+}
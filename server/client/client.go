@@ -0,0 +1,288 @@
+// Go client of the govalent API
+//
+// Methods take and return the server's own models types. Requests
+// which are safe to repeat are retried with exponential backoff after
+// transport errors and 429, 502, 503 and 504 responses; other failures
+// are returned as *Error when the server sent an error body.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/casey/govalent/server/models"
+)
+
+const (
+	DEFAULT_MAX_RETRIES      = 3
+	DEFAULT_RETRY_BASE_DELAY = 200 * time.Millisecond
+	DEFAULT_RETRY_MAX_DELAY  = 5 * time.Second
+)
+
+type Options struct {
+	// Scheme and host of the server, e.g. http://localhost:48008
+	BaseURL string
+	// Must match the server's api_prefix, e.g. /api/v0
+	APIPrefix string
+	// Bearer token; empty if the server has authentication disabled
+	Token string
+	// Sent as X-Govalent-Namespace; empty uses that of the token
+	Namespace string
+	// Defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// Of each repeatable request; 0 disables retries
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+}
+
+func DefaultOptions() Options {
+	return Options{
+		MaxRetries:     DEFAULT_MAX_RETRIES,
+		RetryBaseDelay: DEFAULT_RETRY_BASE_DELAY,
+		RetryMaxDelay:  DEFAULT_RETRY_MAX_DELAY,
+	}
+}
+
+// Delay before the given retry, starting at 1
+func (o *Options) Backoff(retry int) time.Duration {
+	delay := o.RetryBaseDelay
+	for i := 1; i < retry; i++ {
+		delay *= 2
+		if delay >= o.RetryMaxDelay {
+			return o.RetryMaxDelay
+		}
+	}
+	return min(delay, o.RetryMaxDelay)
+}
+
+type Client struct {
+	opts Options
+	base *url.URL
+	http *http.Client
+}
+
+func New(opts Options) (*Client, error) {
+	base, err := url.Parse(opts.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid base URL: %w", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" || len(base.Host) == 0 {
+		return nil, fmt.Errorf("Invalid base URL %q: expected http(s)://host[:port]", opts.BaseURL)
+	}
+	if len(opts.APIPrefix) > 0 && (!strings.HasPrefix(opts.APIPrefix, "/") || strings.HasSuffix(opts.APIPrefix, "/")) {
+		return nil, fmt.Errorf("Invalid API prefix %q: expected a leading and no trailing slash", opts.APIPrefix)
+	}
+	if opts.MaxRetries < 0 || opts.RetryBaseDelay < 0 || opts.RetryMaxDelay < opts.RetryBaseDelay {
+		return nil, errors.New("Invalid retry options")
+	}
+	base.Path = strings.TrimSuffix(base.Path, "/")
+	client := opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Client{opts: opts, base: base, http: client}, nil
+}
+
+// An error response of the server
+type Error struct {
+	StatusCode int
+	models.ErrorResponse
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Message)
+	for _, detail := range e.Details {
+		if len(detail.Attr) > 0 {
+			msg += fmt.Sprintf("; %s %s: %s", detail.Location, detail.Attr, detail.Detail)
+		} else {
+			msg += fmt.Sprintf("; %s: %s", detail.Location, detail.Detail)
+		}
+	}
+	if len(e.RequestId) > 0 {
+		msg += fmt.Sprintf(" (request %s)", e.RequestId)
+	}
+	return msg
+}
+
+// Whether err is an error response with the given status code
+func IsStatus(err error, code int) bool {
+	var api_err *Error
+	return errors.As(err, &api_err) && api_err.StatusCode == code
+}
+
+type request struct {
+	method string
+	// Under the API prefix unless unprefixed
+	path       string
+	unprefixed bool
+	query      url.Values
+	header     http.Header
+	// JSON-encoded as the request body unless nil
+	body any
+	// Safe to send more than once
+	repeatable bool
+}
+
+func (c *Client) url(req *request) string {
+	u := *c.base
+	if req.unprefixed {
+		u.Path += req.path
+	} else {
+		u.Path += c.opts.APIPrefix + req.path
+	}
+	u.RawQuery = req.query.Encode()
+	return u.String()
+}
+
+func (c *Client) newRequest(ctx context.Context, req *request, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	r, err := http.NewRequestWithContext(ctx, req.method, c.url(req), reader)
+	if err != nil {
+		return nil, err
+	}
+	for key, vals := range req.header {
+		r.Header[key] = vals
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r.Header.Set("Accept", "application/json")
+	if len(c.opts.Token) > 0 {
+		r.Header.Set("Authorization", "Bearer "+c.opts.Token)
+	}
+	if len(c.opts.Namespace) > 0 {
+		r.Header.Set(models.NAMESPACE_HEADER, c.opts.Namespace)
+	}
+	return r, nil
+}
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Send req, retrying if it is repeatable, and return the response to
+// the first attempt that was not retried. Error responses are returned
+// as *Error with the body closed.
+func (c *Client) send(ctx context.Context, req *request) (*http.Response, error) {
+	var body []byte
+	if req.body != nil {
+		var err error
+		if body, err = json.Marshal(req.body); err != nil {
+			return nil, err
+		}
+	}
+	max_retries := 0
+	if req.repeatable || req.method == http.MethodGet || req.method == http.MethodDelete {
+		max_retries = c.opts.MaxRetries
+	}
+	for retry := 0; ; retry++ {
+		r, err := c.newRequest(ctx, req, body)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := c.http.Do(r)
+		var delay time.Duration
+		switch {
+		case err != nil:
+			if ctx.Err() != nil || retry >= max_retries {
+				return nil, err
+			}
+		case resp.StatusCode >= 400:
+			api_err := readError(resp)
+			if !retryableStatus(resp.StatusCode) || retry >= max_retries {
+				return nil, api_err
+			}
+			if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				delay = time.Duration(secs) * time.Second
+			}
+		default:
+			return resp, nil
+		}
+		delay = max(delay, c.opts.Backoff(retry+1))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// Closes the body of resp
+func readError(resp *http.Response) *Error {
+	defer resp.Body.Close()
+	api_err := Error{StatusCode: resp.StatusCode}
+	if err := json.NewDecoder(resp.Body).Decode(&api_err.ErrorResponse); err != nil || len(api_err.Code) == 0 {
+		// Not from the API server, e.g. from a proxy
+		api_err.Code = (&models.APIError{StatusCode: resp.StatusCode}).ErrorCode()
+		api_err.Message = http.StatusText(resp.StatusCode)
+	}
+	if len(api_err.RequestId) == 0 {
+		api_err.RequestId = resp.Header.Get(models.REQUEST_ID_HEADER)
+	}
+	return &api_err
+}
+
+// Send req and decode the JSON response into out unless nil
+func (c *Client) do(ctx context.Context, req *request, out any) error {
+	resp, err := c.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("Error decoding response to %s %s: %w", req.method, req.path, err)
+	}
+	return nil
+}
+
+func setPagination(query url.Values, page int, count int) {
+	if page > 0 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if count > 0 {
+		query.Set("count", strconv.Itoa(count))
+	}
+}
+
+func setString(query url.Values, key string, val string) {
+	if len(val) > 0 {
+		query.Set(key, val)
+	}
+}
+
+func setTime(query url.Values, key string, val *time.Time) {
+	if val != nil {
+		query.Set(key, val.Format(time.RFC3339Nano))
+	}
+}
+
+// GET /healthz
+func (c *Client) Health(ctx context.Context) (*models.HealthResponse, error) {
+	var res models.HealthResponse
+	err := c.do(ctx, &request{method: http.MethodGet, path: "/healthz", unprefixed: true}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
@@ -0,0 +1,537 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/casey/govalent/server/api"
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/db"
+	"github.com/casey/govalent/server/models"
+)
+
+const TEST_ADMIN_TOKEN = "test-admin-token"
+
+const TEST_API_PREFIX = "/api/v0"
+
+// An in-process API server with its own database and storage directory
+func newTestServer(t *testing.T) *httptest.Server {
+	c, _, err := common.LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	dir := t.TempDir()
+	c.Dsn = filepath.Join(dir, "govalent.db")
+	c.StoragePath = filepath.Join(dir, "storage")
+	c.AdminToken = TEST_ADMIN_TOKEN
+	c.APIPrefix = TEST_API_PREFIX
+	pool, err := db.GetDB(&c)
+	if err != nil {
+		t.Fatalf("Error establishing DB connection: %v", err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	if err := db.EmitDDL(pool); err != nil {
+		t.Fatalf("Error initializing DB: %v", err)
+	}
+	live := common.NewLiveConfig(c)
+	m := api.NewGovalentAPIServer(live, ":0")
+	m.AddRoutes(live, pool)
+	srv := httptest.NewServer(m.Srv.Handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newTestClient(t *testing.T, base_url string) *Client {
+	opts := DefaultOptions()
+	opts.BaseURL = base_url
+	opts.APIPrefix = TEST_API_PREFIX
+	opts.Token = TEST_ADMIN_TOKEN
+	opts.RetryBaseDelay = time.Millisecond
+	opts.RetryMaxDelay = 10 * time.Millisecond
+	c, err := New(opts)
+	if err != nil {
+		t.Fatalf("Error creating client: %v", err)
+	}
+	return c
+}
+
+// A manifest with one electron whose assets refer to local files
+func newTestManifest(t *testing.T, contents map[string]string) *models.DispatchSchema {
+	dir := t.TempDir()
+	local := func(name string) models.AssetDetails {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(contents[name]), 0o644); err != nil {
+			t.Fatalf("Error writing asset: %v", err)
+		}
+		return models.AssetDetails{Uri: "file://" + path, Size: len(contents[name])}
+	}
+	manifest := models.DispatchSchema{
+		Metadata: models.DispatchMeta{Status: common.STATUS_NEW},
+		Lattice: models.LatticeSchema{
+			Metadata: models.LatticeMeta{Name: "test-workflow", Executor: "local"},
+			Assets:   models.LatticeAssets{Inputs: local("inputs")},
+			TransportGraph: models.Graph{
+				Nodes: []models.ElectronSchema{{
+					NodeId:   0,
+					Metadata: models.ElectronMeta{Name: "task", Executor: "local", Status: common.STATUS_NEW},
+					Assets:   models.ElectronAssets{Function: local("function")},
+				}},
+				Links: []models.Edge{},
+			},
+		},
+	}
+	return &manifest
+}
+
+func download(t *testing.T, c *Client, uri string) string {
+	var buf bytes.Buffer
+	if err := c.DownloadAsset(context.Background(), uri, &buf); err != nil {
+		t.Fatalf("Error downloading %s: %v", uri, err)
+	}
+	return buf.String()
+}
+
+func TestSubmitExportListDelete(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv.URL)
+	ctx := context.Background()
+
+	manifest := newTestManifest(t, map[string]string{"inputs": "[1, 2]", "function": "def task(x): return x"})
+	local_uri := manifest.Lattice.Assets.Inputs.Uri
+	submitted, err := c.SubmitDispatch(ctx, manifest)
+	if err != nil {
+		t.Fatalf("Error submitting dispatch: %v", err)
+	}
+	if manifest.Lattice.Assets.Inputs.Uri != local_uri {
+		t.Errorf("Expected manifest to be left unchanged, got uri %s", manifest.Lattice.Assets.Inputs.Uri)
+	}
+	dispatch_id := submitted.Metadata.DispatchId
+	if len(dispatch_id) == 0 {
+		t.Fatalf("Expected a dispatch id")
+	}
+
+	exported, err := c.ExportDispatch(ctx, dispatch_id)
+	if err != nil {
+		t.Fatalf("Error exporting dispatch: %v", err)
+	}
+	if len(exported.Lattice.TransportGraph.Nodes) != 1 {
+		t.Fatalf("Expected 1 node, got %d", len(exported.Lattice.TransportGraph.Nodes))
+	}
+	if got := download(t, c, AssetSource(&exported.Lattice.Assets.Inputs)); got != "[1, 2]" {
+		t.Errorf("Expected uploaded inputs, got %q", got)
+	}
+
+	links, err := c.GetElectronAssets(ctx, dispatch_id, 0)
+	if err != nil {
+		t.Fatalf("Error getting electron assets: %v", err)
+	}
+	found := false
+	for _, link := range links.Records {
+		if link.Name == "function" {
+			found = true
+			if got := download(t, c, AssetSource(&link.Asset)); got != "def task(x): return x" {
+				t.Errorf("Expected uploaded function, got %q", got)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("No function asset in %+v", links.Records)
+	}
+
+	list, err := c.ListDispatches(ctx, DispatchQuery{Status: []string{common.STATUS_NEW}, Name: "test"})
+	if err != nil {
+		t.Fatalf("Error listing dispatches: %v", err)
+	}
+	if list.Total != 1 || list.Records[0].DispatchId != dispatch_id {
+		t.Errorf("Expected the submitted dispatch, got %+v", list)
+	}
+	meta, err := c.GetDispatch(ctx, dispatch_id)
+	if err != nil || meta.Status != common.STATUS_NEW {
+		t.Errorf("Expected status %s, got %+v (%v)", common.STATUS_NEW, meta, err)
+	}
+
+	if err := c.DeleteDispatch(ctx, dispatch_id); err != nil {
+		t.Fatalf("Error deleting dispatch: %v", err)
+	}
+	if _, err := c.ExportDispatch(ctx, dispatch_id); !IsStatus(err, http.StatusNotFound) {
+		t.Errorf("Expected 404 after deletion, got %v", err)
+	}
+	if _, err := c.GetDispatch(ctx, dispatch_id); !IsStatus(err, http.StatusNotFound) {
+		t.Errorf("Expected 404 after deletion, got %v", err)
+	}
+}
+
+func TestRegisterAndUploadAssets(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv.URL)
+	ctx := context.Background()
+
+	contents := "asset contents"
+	assets := []models.AssetPublicSchema{{Key: "standalone/asset", AssetDetails: models.AssetDetails{Size: len(contents)}}}
+	res, err := c.RegisterAssets(ctx, assets)
+	if err != nil {
+		t.Fatalf("Error registering assets: %v", err)
+	}
+	remote_uri := res.Assets[0].RemoteUri
+	if len(remote_uri) == 0 {
+		t.Fatalf("Expected an upload URI, got %+v", res.Assets[0])
+	}
+	if err := c.UploadAsset(ctx, remote_uri, bytes.NewBufferString(contents), int64(len(contents))); err != nil {
+		t.Fatalf("Error uploading asset: %v", err)
+	}
+	listed, err := c.ListAssets(ctx, "standalone/", 0, 0)
+	if err != nil {
+		t.Fatalf("Error listing assets: %v", err)
+	}
+	if len(listed.Assets) != 1 {
+		t.Fatalf("Expected 1 asset, got %+v", listed.Assets)
+	}
+	if got := download(t, c, AssetSource(&listed.Assets[0].AssetDetails)); got != contents {
+		t.Errorf("Expected %q, got %q", contents, got)
+	}
+}
+
+func TestStatusUpdatesAndEvents(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	submitted, err := c.SubmitDispatch(ctx, newTestManifest(t, map[string]string{"inputs": "[]", "function": "f"}))
+	if err != nil {
+		t.Fatalf("Error submitting dispatch: %v", err)
+	}
+	dispatch_id := submitted.Metadata.DispatchId
+
+	received := make(chan models.StatusEvent, 10)
+	stream_err := make(chan error, 1)
+	go func() {
+		stream_err <- c.StreamEvents(ctx, dispatch_id, 0, func(ev *models.StatusEvent) bool {
+			received <- *ev
+			return !(ev.Kind == models.EVENT_KIND_DISPATCH_STATUS && ev.Status == common.STATUS_COMPLETED)
+		})
+	}()
+
+	now := time.Now().UTC()
+	ev, err := c.UpdateElectronStatus(ctx, dispatch_id, 0, models.ElectronStatusUpdate{Status: common.STATUS_RUNNING, StartTime: &now})
+	if err != nil {
+		t.Fatalf("Error updating electron status: %v", err)
+	}
+	if ev.Kind != models.EVENT_KIND_ELECTRON_STATUS || ev.NodeId == nil || *ev.NodeId != 0 {
+		t.Errorf("Unexpected electron event %+v", ev)
+	}
+	if _, err := c.UpdateDispatchStatus(ctx, dispatch_id, models.DispatchStatusUpdate{Status: common.STATUS_COMPLETED}); err != nil {
+		t.Fatalf("Error updating dispatch status: %v", err)
+	}
+	if err := <-stream_err; err != nil {
+		t.Fatalf("Error streaming events: %v", err)
+	}
+	close(received)
+	var statuses []string
+	for ev := range received {
+		statuses = append(statuses, ev.Kind+":"+ev.Status)
+	}
+	last := statuses[len(statuses)-2:]
+	if last[0] != models.EVENT_KIND_ELECTRON_STATUS+":"+common.STATUS_RUNNING || last[1] != models.EVENT_KIND_DISPATCH_STATUS+":"+common.STATUS_COMPLETED {
+		t.Errorf("Unexpected events %v", statuses)
+	}
+
+	_, err = c.UpdateDispatchStatus(ctx, dispatch_id, models.DispatchStatusUpdate{Status: "BOGUS"})
+	var api_err *Error
+	if !IsStatus(err, http.StatusUnprocessableEntity) || !errors.As(err, &api_err) || len(api_err.Details) == 0 {
+		t.Errorf("Expected validation error with details, got %v", err)
+	}
+
+}
+
+// Finished dispatches and electrons, and the electrons of finished
+// dispatches, keep their status
+func TestFinishedStatuses(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv.URL)
+	ctx := context.Background()
+
+	manifest := newTestManifest(t, map[string]string{"inputs": "[1]", "function": "def task(x): return x"})
+	nodes := &manifest.Lattice.TransportGraph.Nodes
+	*nodes = append(*nodes, (*nodes)[0])
+	(*nodes)[1].NodeId = 1
+	submitted, err := c.SubmitDispatch(ctx, manifest)
+	if err != nil {
+		t.Fatalf("Error submitting dispatch: %v", err)
+	}
+	dispatch_id := submitted.Metadata.DispatchId
+
+	if _, err := c.UpdateElectronStatus(ctx, dispatch_id, 0, models.ElectronStatusUpdate{Status: common.STATUS_COMPLETED}); err != nil {
+		t.Fatalf("Error updating electron status: %v", err)
+	}
+	_, err = c.UpdateElectronStatus(ctx, dispatch_id, 0, models.ElectronStatusUpdate{Status: common.STATUS_RUNNING})
+	if !IsStatus(err, http.StatusConflict) {
+		t.Errorf("Expected conflict updating a completed electron, got %v", err)
+	}
+	if _, err := c.UpdateElectronStatus(ctx, dispatch_id, 1, models.ElectronStatusUpdate{Status: common.STATUS_RUNNING}); err != nil {
+		t.Fatalf("Error updating electron status: %v", err)
+	}
+
+	if _, err := c.UpdateDispatchStatus(ctx, dispatch_id, models.DispatchStatusUpdate{Status: common.STATUS_CANCELLED}); err != nil {
+		t.Fatalf("Error cancelling dispatch: %v", err)
+	}
+	_, err = c.UpdateDispatchStatus(ctx, dispatch_id, models.DispatchStatusUpdate{Status: common.STATUS_COMPLETED})
+	if !IsStatus(err, http.StatusConflict) {
+		t.Errorf("Expected conflict updating a cancelled dispatch, got %v", err)
+	}
+	_, err = c.UpdateElectronStatus(ctx, dispatch_id, 1, models.ElectronStatusUpdate{Status: common.STATUS_COMPLETED})
+	if !IsStatus(err, http.StatusConflict) {
+		t.Errorf("Expected conflict updating an electron of a cancelled dispatch, got %v", err)
+	}
+	electron, err := c.GetElectron(ctx, dispatch_id, 1)
+	if err != nil || electron.Metadata.Status != common.STATUS_RUNNING {
+		t.Errorf("Expected electron to remain running, got %+v (%v)", electron.Metadata, err)
+	}
+}
+
+func TestRetries(t *testing.T) {
+	var attempts atomic.Int32
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := attempts.Add(1)
+		keys = append(keys, r.Header.Get(IDEMPOTENCY_KEY_HEADER))
+		if n < 3 {
+			models.WriteError(w, &models.APIError{StatusCode: http.StatusServiceUnavailable, Err: context.DeadlineExceeded})
+			return
+		}
+		json.NewEncoder(w).Encode(&models.DispatchSchema{Metadata: models.DispatchMeta{DispatchId: "d1"}})
+	}))
+	defer srv.Close()
+	c := newTestClient(t, srv.URL)
+	ctx := context.Background()
+
+	res, err := c.ImportDispatch(ctx, &models.DispatchSchema{})
+	if err != nil {
+		t.Fatalf("Expected import to succeed after retries: %v", err)
+	}
+	if res.Metadata.DispatchId != "d1" || attempts.Load() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts.Load())
+	}
+	if keys[0] == "" || keys[0] != keys[1] || keys[1] != keys[2] {
+		t.Errorf("Expected the same idempotency key on each attempt, got %v", keys)
+	}
+
+	// Status updates record a transition each time and are not retried
+	attempts.Store(0)
+	_, err = c.UpdateElectronStatus(ctx, "d1", 0, models.ElectronStatusUpdate{Status: common.STATUS_RUNNING})
+	if !IsStatus(err, http.StatusServiceUnavailable) || attempts.Load() != 1 {
+		t.Errorf("Expected a single failed attempt, got %d: %v", attempts.Load(), err)
+	}
+
+	c.opts.MaxRetries = 1
+	attempts.Store(0)
+	if _, err := c.ExportDispatch(ctx, "d1"); !IsStatus(err, http.StatusServiceUnavailable) || attempts.Load() != 2 {
+		t.Errorf("Expected 2 failed attempts, got %d: %v", attempts.Load(), err)
+	}
+}
+
+func TestAuthAndOptions(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv.URL)
+	c.opts.Token = "wrong"
+	if _, err := c.ListDispatches(context.Background(), DispatchQuery{}); !IsStatus(err, http.StatusUnauthorized) {
+		t.Errorf("Expected 401, got %v", err)
+	}
+	if _, err := c.Health(context.Background()); err != nil {
+		t.Errorf("Expected health probe without credentials to succeed: %v", err)
+	}
+
+	// /assets is not filtered by owner, so only admins and executors may
+	// use it
+	c.opts.Token = TEST_ADMIN_TOKEN
+	var token models.APIToken
+	req := request{method: http.MethodPost, path: "/tokens", body: &models.TokenCreateRequest{User: "alice"}}
+	if err := c.do(context.Background(), &req, &token); err != nil {
+		t.Fatalf("Error creating token: %v", err)
+	}
+	c.opts.Token = token.Token
+	if _, err := c.ListAssets(context.Background(), "", 0, 0); !IsStatus(err, http.StatusForbidden) {
+		t.Errorf("Expected 403 listing assets as a user, got %v", err)
+	}
+	if _, err := c.RegisterAssets(context.Background(), []models.AssetPublicSchema{{Key: "alice/asset"}}); !IsStatus(err, http.StatusForbidden) {
+		t.Errorf("Expected 403 registering assets as a user, got %v", err)
+	}
+
+	// Users may cancel their dispatches but not report progress
+	submitted, err := c.ImportDispatch(context.Background(), newTestManifest(t, nil))
+	if err != nil {
+		t.Fatalf("Error importing dispatch: %v", err)
+	}
+	dispatch_id := submitted.Metadata.DispatchId
+	if _, err := c.UpdateDispatchStatus(context.Background(), dispatch_id, models.DispatchStatusUpdate{Status: common.STATUS_COMPLETED}); !IsStatus(err, http.StatusForbidden) {
+		t.Errorf("Expected 403 completing a dispatch as a user, got %v", err)
+	}
+	if _, err := c.UpdateElectronStatus(context.Background(), dispatch_id, 0, models.ElectronStatusUpdate{Status: common.STATUS_COMPLETED}); !IsStatus(err, http.StatusForbidden) {
+		t.Errorf("Expected 403 updating an electron as a user, got %v", err)
+	}
+	if _, err := c.UpdateDispatchStatus(context.Background(), dispatch_id, models.DispatchStatusUpdate{Status: common.STATUS_CANCELLED}); err != nil {
+		t.Errorf("Error cancelling a dispatch as a user: %v", err)
+	}
+
+	for _, opts := range []Options{
+		{BaseURL: "localhost:48008"},
+		{BaseURL: "http://localhost:48008", APIPrefix: "api"},
+		{BaseURL: "http://localhost:48008", APIPrefix: "/api/"},
+		{BaseURL: "http://localhost:48008", MaxRetries: -1},
+	} {
+		if _, err := New(opts); err == nil {
+			t.Errorf("Expected invalid options %+v to be rejected", opts)
+		}
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv.URL)
+	ctx := context.Background()
+
+	res, err := c.ImportDispatch(ctx, newTestManifest(t, nil))
+	if err != nil {
+		t.Fatalf("Error importing dispatch: %v", err)
+	}
+	if err := c.DeleteDispatch(ctx, res.Metadata.DispatchId); err != nil {
+		t.Fatalf("Error deleting dispatch: %v", err)
+	}
+	var token models.APIToken
+	if err := c.do(ctx, &request{method: http.MethodPost, path: "/tokens", body: &models.TokenCreateRequest{User: "alice"}}, &token); err != nil {
+		t.Fatalf("Error creating token: %v", err)
+	}
+	if err := c.do(ctx, &request{method: http.MethodDelete, path: fmt.Sprintf("/tokens/%d", token.Id)}, nil); err != nil {
+		t.Fatalf("Error deleting token: %v", err)
+	}
+
+	var log models.GetBulkAuditEntriesResponse
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/audit"}, &log); err != nil {
+		t.Fatalf("Error reading audit log: %v", err)
+	}
+	codes := make(map[string]int)
+	for _, entry := range log.Records {
+		codes[entry.Action] = entry.StatusCode
+	}
+	for action, code := range map[string]int{
+		models.AUDIT_ACTION_DELETE_DISPATCH: http.StatusAccepted,
+		models.AUDIT_ACTION_CREATE_TOKEN:    http.StatusCreated,
+		models.AUDIT_ACTION_DELETE_TOKEN:    http.StatusNoContent,
+	} {
+		if codes[action] != code {
+			t.Errorf("Expected %s to be audited with status %d, got %v", action, code, codes)
+		}
+	}
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv.URL)
+	ctx := context.Background()
+
+	manifest := newTestManifest(t, nil)
+	first, err := c.ImportDispatchWithKey(ctx, manifest, "key-1")
+	if err != nil {
+		t.Fatalf("Error importing dispatch: %v", err)
+	}
+
+	// The same manifest with its keys in another order is a retry
+	serialized, _ := json.Marshal(manifest)
+	var reordered map[string]any
+	if err := json.Unmarshal(serialized, &reordered); err != nil {
+		t.Fatalf("Error decoding manifest: %v", err)
+	}
+	req := request{
+		method: http.MethodPost,
+		path:   "/dispatches",
+		header: http.Header{IDEMPOTENCY_KEY_HEADER: []string{"key-1"}},
+		body:   reordered,
+	}
+	var retried models.DispatchSchema
+	if err := c.do(ctx, &req, &retried); err != nil {
+		t.Fatalf("Error retrying import: %v", err)
+	}
+	if retried.Metadata.DispatchId != first.Metadata.DispatchId {
+		t.Errorf("Expected the retry to return %s, got %s", first.Metadata.DispatchId, retried.Metadata.DispatchId)
+	}
+	manifest.Lattice.Metadata.Name = "changed"
+	if _, err := c.ImportDispatchWithKey(ctx, manifest, "key-1"); !IsStatus(err, http.StatusConflict) {
+		t.Errorf("Expected 409 for a different manifest, got %v", err)
+	}
+
+	// Keys are scoped to their owner
+	var token models.APIToken
+	if err := c.do(ctx, &request{method: http.MethodPost, path: "/tokens", body: &models.TokenCreateRequest{User: "bob"}}, &token); err != nil {
+		t.Fatalf("Error creating token: %v", err)
+	}
+	c.opts.Token = token.Token
+	other, err := c.ImportDispatchWithKey(ctx, manifest, "key-1")
+	if err != nil {
+		t.Fatalf("Error importing dispatch as another user: %v", err)
+	}
+	if other.Metadata.DispatchId == first.Metadata.DispatchId {
+		t.Errorf("Expected a new dispatch for another user's key")
+	}
+}
+
+// Manifests too large for the decoder to hand over in one batch, sent
+// with their sections in different orders, are still the same
+func TestIdempotencyKeysLargeManifest(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestClient(t, srv.URL)
+	ctx := context.Background()
+
+	manifest := newTestManifest(t, nil)
+	graph := &manifest.Lattice.TransportGraph
+	n_nodes := models.MANIFEST_STREAM_BATCH_SIZE + 500
+	for i := 1; i < n_nodes; i++ {
+		node := graph.Nodes[0]
+		node.NodeId = i
+		graph.Nodes = append(graph.Nodes, node)
+		graph.Links = append(graph.Links, models.Edge{
+			Source:   i - 1,
+			Target:   i,
+			Metadata: models.EdgeMetadata{Name: "x", ParamType: common.PARAM_TYPE_ARG},
+		})
+	}
+	first, err := c.ImportDispatchWithKey(ctx, manifest, "key-1")
+	if err != nil {
+		t.Fatalf("Error importing dispatch: %v", err)
+	}
+
+	// Sorted keys put the links before the nodes and the lattice
+	// before the dispatch metadata
+	serialized, _ := json.Marshal(manifest)
+	var reordered map[string]any
+	if err := json.Unmarshal(serialized, &reordered); err != nil {
+		t.Fatalf("Error decoding manifest: %v", err)
+	}
+	req := request{
+		method: http.MethodPost,
+		path:   "/dispatches",
+		header: http.Header{IDEMPOTENCY_KEY_HEADER: []string{"key-1"}},
+		body:   reordered,
+	}
+	var retried models.DispatchSchema
+	if err := c.do(ctx, &req, &retried); err != nil {
+		t.Fatalf("Error retrying import: %v", err)
+	}
+	if retried.Metadata.DispatchId != first.Metadata.DispatchId {
+		t.Errorf("Expected the retry to return %s, got %s", first.Metadata.DispatchId, retried.Metadata.DispatchId)
+	}
+
+	graph.Links[len(graph.Links)-1].Metadata.Name = "y"
+	if _, err := c.ImportDispatchWithKey(ctx, manifest, "key-1"); !IsStatus(err, http.StatusConflict) {
+		t.Errorf("Expected 409 for a different manifest, got %v", err)
+	}
+}
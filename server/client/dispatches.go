@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/casey/govalent/server/models"
+	"github.com/google/uuid"
+)
+
+// Same as the server's; makes dispatch imports safe to retry
+const IDEMPOTENCY_KEY_HEADER = "Idempotency-Key"
+
+// Filters of ListDispatches; zero values are omitted
+type DispatchQuery struct {
+	Page  int
+	Count int
+	// Matches any
+	Status         []string
+	DispatchId     string
+	RootDispatchId string
+	// Substring of the workflow name
+	Name     string
+	Executor string
+	Owner    string
+
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	StartedAfter  *time.Time
+	StartedBefore *time.Time
+	EndedAfter    *time.Time
+	EndedBefore   *time.Time
+
+	Sort string
+	// asc or desc
+	Direction string
+}
+
+func (q *DispatchQuery) values() url.Values {
+	query := url.Values{}
+	setPagination(query, q.Page, q.Count)
+	for _, status := range q.Status {
+		query.Add("status", status)
+	}
+	setString(query, "dispatch_id", q.DispatchId)
+	setString(query, "root_dispatch_id", q.RootDispatchId)
+	setString(query, "name", q.Name)
+	setString(query, "executor", q.Executor)
+	setString(query, "owner", q.Owner)
+	setTime(query, "created_after", q.CreatedAfter)
+	setTime(query, "created_before", q.CreatedBefore)
+	setTime(query, "started_after", q.StartedAfter)
+	setTime(query, "started_before", q.StartedBefore)
+	setTime(query, "ended_after", q.EndedAfter)
+	setTime(query, "ended_before", q.EndedBefore)
+	setString(query, "sort", q.Sort)
+	setString(query, "direction", q.Direction)
+	return query
+}
+
+// Filters of ListElectrons; zero values are omitted
+type ElectronQuery struct {
+	Page  int
+	Count int
+	// Matches any
+	Status      []string
+	TaskGroupId *int
+	Executor    string
+	// Omit asset details from the records
+	ExcludeAssets bool
+
+	Sort string
+	// asc or desc
+	Direction string
+}
+
+func (q *ElectronQuery) values() url.Values {
+	query := url.Values{}
+	setPagination(query, q.Page, q.Count)
+	for _, status := range q.Status {
+		query.Add("status", status)
+	}
+	if q.TaskGroupId != nil {
+		query.Set("task_group_id", strconv.Itoa(*q.TaskGroupId))
+	}
+	setString(query, "executor", q.Executor)
+	if q.ExcludeAssets {
+		query.Set("include_assets", "false")
+	}
+	setString(query, "sort", q.Sort)
+	setString(query, "direction", q.Direction)
+	return query
+}
+
+func dispatchPath(dispatch_id string) string {
+	return "/dispatches/" + url.PathEscape(dispatch_id)
+}
+
+func electronPath(dispatch_id string, node_id int) string {
+	return dispatchPath(dispatch_id) + "/electrons/" + strconv.Itoa(node_id)
+}
+
+// POST /dispatches
+//
+// The response carries upload URIs for the manifest's assets; see
+// SubmitDispatch to upload them as well. A fresh idempotency key is
+// sent so that the import can be retried.
+func (c *Client) ImportDispatch(ctx context.Context, manifest *models.DispatchSchema) (*models.DispatchSchema, error) {
+	return c.ImportDispatchWithKey(ctx, manifest, uuid.NewString())
+}
+
+// Resubmissions with the same idempotency key return the dispatch
+// created by the first
+func (c *Client) ImportDispatchWithKey(ctx context.Context, manifest *models.DispatchSchema, idempotency_key string) (*models.DispatchSchema, error) {
+	req := request{
+		method:     http.MethodPost,
+		path:       "/dispatches",
+		header:     http.Header{IDEMPOTENCY_KEY_HEADER: []string{idempotency_key}},
+		body:       manifest,
+		repeatable: true,
+	}
+	var res models.DispatchSchema
+	if err := c.do(ctx, &req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// GET /dispatches/{dispatch_id}
+func (c *Client) ExportDispatch(ctx context.Context, dispatch_id string) (*models.DispatchSchema, error) {
+	var res models.DispatchSchema
+	if err := c.do(ctx, &request{method: http.MethodGet, path: dispatchPath(dispatch_id)}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// GET /dispatches
+func (c *Client) ListDispatches(ctx context.Context, q DispatchQuery) (*models.GetBulkDispatchesResponse, error) {
+	var res models.GetBulkDispatchesResponse
+	if err := c.do(ctx, &request{method: http.MethodGet, path: "/dispatches", query: q.values()}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Metadata of a dispatch, without its transport graph
+func (c *Client) GetDispatch(ctx context.Context, dispatch_id string) (*models.DispatchMeta, error) {
+	res, err := c.ListDispatches(ctx, DispatchQuery{DispatchId: dispatch_id, Count: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Records) == 0 {
+		return nil, &Error{
+			StatusCode:    http.StatusNotFound,
+			ErrorResponse: models.ErrorResponse{Code: models.ERROR_CODE_NOT_FOUND, Message: "Dispatch " + dispatch_id + " not found"},
+		}
+	}
+	return &res.Records[0], nil
+}
+
+// DELETE /dispatches/{dispatch_id}
+func (c *Client) DeleteDispatch(ctx context.Context, dispatch_id string) error {
+	return c.do(ctx, &request{method: http.MethodDelete, path: dispatchPath(dispatch_id)}, nil)
+}
+
+// PUT /dispatches/{dispatch_id}/status
+func (c *Client) UpdateDispatchStatus(ctx context.Context, dispatch_id string, update models.DispatchStatusUpdate) (*models.StatusEvent, error) {
+	req := request{method: http.MethodPut, path: dispatchPath(dispatch_id) + "/status", body: &update}
+	var res models.StatusEvent
+	if err := c.do(ctx, &req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// GET /dispatches/{dispatch_id}/electrons
+func (c *Client) ListElectrons(ctx context.Context, dispatch_id string, q ElectronQuery) (*models.GetBulkElectronsResponse, error) {
+	req := request{method: http.MethodGet, path: dispatchPath(dispatch_id) + "/electrons", query: q.values()}
+	var res models.GetBulkElectronsResponse
+	if err := c.do(ctx, &req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// GET /dispatches/{dispatch_id}/electrons/{node_id}
+func (c *Client) GetElectron(ctx context.Context, dispatch_id string, node_id int) (*models.ElectronSchema, error) {
+	var res models.ElectronSchema
+	if err := c.do(ctx, &request{method: http.MethodGet, path: electronPath(dispatch_id, node_id)}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// PATCH /dispatches/{dispatch_id}/electrons/{node_id}
+//
+// Not retried, as each request records a transition.
+func (c *Client) UpdateElectronStatus(ctx context.Context, dispatch_id string, node_id int, update models.ElectronStatusUpdate) (*models.StatusEvent, error) {
+	req := request{method: http.MethodPatch, path: electronPath(dispatch_id, node_id), body: &update}
+	var res models.StatusEvent
+	if err := c.do(ctx, &req, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
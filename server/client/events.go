@@ -0,0 +1,114 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/casey/govalent/server/models"
+)
+
+// Longest line of an event stream
+const MAX_EVENT_LINE_BYTES = 1 << 20
+
+// GET /dispatches/{dispatch_id}/events
+//
+// Calls fn with each status event of the dispatch after the event with
+// id after_id, in order, until fn returns false or ctx is done; 0
+// replays the whole log. Dropped connections are resumed after the last
+// event received, up to MaxRetries times in a row.
+func (c *Client) StreamEvents(ctx context.Context, dispatch_id string, after_id int64, fn func(*models.StatusEvent) bool) error {
+	last_id := after_id
+	failures := 0
+	for {
+		received, done, err := c.streamEvents(ctx, dispatch_id, &last_id, fn)
+		if done {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var api_err *Error
+		if errors.As(err, &api_err) && !retryableStatus(api_err.StatusCode) {
+			return err
+		}
+		if received {
+			failures = 0
+		}
+		failures++
+		if failures > c.opts.MaxRetries {
+			if err == nil {
+				err = fmt.Errorf("Event stream of dispatch %s closed by the server", dispatch_id)
+			}
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.opts.Backoff(failures)):
+		}
+	}
+}
+
+// One connection of StreamEvents; reports whether any event was
+// received and whether fn asked to stop
+func (c *Client) streamEvents(ctx context.Context, dispatch_id string, last_id *int64, fn func(*models.StatusEvent) bool) (bool, bool, error) {
+	req := request{method: http.MethodGet, path: dispatchPath(dispatch_id) + "/events"}
+	if *last_id > 0 {
+		req.header = http.Header{"Last-Event-Id": []string{strconv.FormatInt(*last_id, 10)}}
+	}
+	r, err := c.newRequest(ctx, &req, nil)
+	if err != nil {
+		return false, false, err
+	}
+	r.Header.Set("Accept", "text/event-stream")
+	resp, err := c.http.Do(r)
+	if err != nil {
+		return false, false, err
+	}
+	if resp.StatusCode >= 400 {
+		return false, false, readError(resp)
+	}
+	defer resp.Body.Close()
+
+	received := false
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 4096), MAX_EVENT_LINE_BYTES)
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch {
+		case len(line) == 0:
+			// End of an event
+			if data.Len() == 0 {
+				continue
+			}
+			var ev models.StatusEvent
+			if err := json.Unmarshal([]byte(data.String()), &ev); err != nil {
+				return received, false, fmt.Errorf("Error decoding event of dispatch %s: %w", dispatch_id, err)
+			}
+			data.Reset()
+			received = true
+			*last_id = ev.Id
+			if !fn(&ev) {
+				return received, true, nil
+			}
+		case len(field) == 0:
+			// Comment, e.g. a keep-alive
+		case field == "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+		}
+	}
+	return received, false, scanner.Err()
+}
@@ -1,43 +1,109 @@
 package common
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
-// Settings:
-// port
-// db connection string
+// Settings are read, from lowest to highest precedence, from the
+// defaults, the config file, GOVALENT_* environment variables and
+// command-line flags. See the settings table for their names.
 
 const DEFAULT_PORT = 48008
 const DEFAULT_DSN = ":memory:"
+const DEFAULT_MAX_REQUEST_BODY_BYTES = 256 << 20
+const DEFAULT_MAX_MANIFEST_NODES = 100000
+const DEFAULT_WEBHOOK_MAX_ATTEMPTS = 8
+const DEFAULT_WEBHOOK_RETRY_BASE_DELAY = time.Second
+const DEFAULT_WEBHOOK_RETRY_MAX_DELAY = 5 * time.Minute
+const DEFAULT_WEBHOOK_TIMEOUT = 10 * time.Second
+const DEFAULT_SHUTDOWN_TIMEOUT = 30 * time.Second
+
+// Trace exporters
+const (
+	TRACE_EXPORTER_NONE = "none"
+	TRACE_EXPORTER_OTLP = "otlp"
+	TRACE_EXPORTER_FILE = "file"
+)
+
+var trace_exporters = map[string]bool{
+	TRACE_EXPORTER_NONE: true,
+	TRACE_EXPORTER_OTLP: true,
+	TRACE_EXPORTER_FILE: true,
+}
 
 var log_level_mapping = map[string]slog.Level{
 	"DEBUG": slog.LevelDebug,
 	"INFO":  slog.LevelInfo,
 	"WARN":  slog.LevelWarn,
+	"ERROR": slog.LevelError,
 }
 
+// Config file used when --config is not given
+const CONFIG_FILE_ENV = "GOVALENT_CONFIG"
+
+// Shown by --print-config in place of secrets
+const REDACTED = "REDACTED"
+
 type Config struct {
 	Port        int        `json:"port"`
 	Dsn         string     `json:"dsn"`
 	StoragePath string     `json:"storage_path"`
 	LogLevel    slog.Level `json:"log_level"`
 	APIPrefix   string     `json:"api_prefix"`
+
+	// Request limits; non-positive values disable the limit
+	MaxRequestBodyBytes int64 `json:"max_request_body_bytes"`
+	MaxManifestNodes    int   `json:"max_manifest_nodes"`
+
+	// Webhook delivery; the delay before retry n is
+	// WebhookRetryBaseDelay * 2^(n-1), capped at WebhookRetryMaxDelay
+	WebhookMaxAttempts    int           `json:"webhook_max_attempts"`
+	WebhookRetryBaseDelay time.Duration `json:"webhook_retry_base_delay"`
+	WebhookRetryMaxDelay  time.Duration `json:"webhook_retry_max_delay"`
+	WebhookTimeout        time.Duration `json:"webhook_timeout"`
+
+	// When disabled every request acts as an admin
+	AuthEnabled bool `json:"auth_enabled"`
+	// Bootstrap admin bearer token, accepted in addition to the tokens
+	// stored in the database
+	AdminToken string `json:"-"`
+
+	// If set, /metrics is also served without authentication on this
+	// port
+	MetricsPort int `json:"metrics_port"`
+
+	// One of TRACE_EXPORTER_*. The OTLP endpoint is read from the
+	// standard OTEL_EXPORTER_OTLP_* variables; the file exporter
+	// appends JSON spans to TraceFile.
+	TraceExporter string `json:"trace_exporter"`
+	TraceFile     string `json:"trace_file"`
+
+	// Time allowed on SIGINT or SIGTERM for in-flight requests to
+	// complete, and again for pending webhook deliveries
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"`
 }
 
+// Empty if HOME is not set; Validate then requires an explicit
+// storage path
 func defaultStoragePath() string {
-	home_dir := os.Getenv("HOME")
-	if len(home_dir) == 0 {
-		slog.Error("Error: environment variable HOME not set\n")
-		os.Exit(1)
-	}
 	data_home := os.Getenv("XDG_DATA_HOME")
 	if len(data_home) == 0 {
+		home_dir := os.Getenv("HOME")
+		if len(home_dir) == 0 {
+			return ""
+		}
 		data_home = path.Join(home_dir, ".local/share")
 	}
 	return path.Join(data_home, "govalent", "data")
@@ -50,43 +116,320 @@ func newDefaultConfig() Config {
 		StoragePath: defaultStoragePath(),
 		LogLevel:    slog.LevelInfo,
 		APIPrefix:   "",
+
+		MaxRequestBodyBytes: DEFAULT_MAX_REQUEST_BODY_BYTES,
+		MaxManifestNodes:    DEFAULT_MAX_MANIFEST_NODES,
+
+		WebhookMaxAttempts:    DEFAULT_WEBHOOK_MAX_ATTEMPTS,
+		WebhookRetryBaseDelay: DEFAULT_WEBHOOK_RETRY_BASE_DELAY,
+		WebhookRetryMaxDelay:  DEFAULT_WEBHOOK_RETRY_MAX_DELAY,
+		WebhookTimeout:        DEFAULT_WEBHOOK_TIMEOUT,
+
+		AuthEnabled: true,
+
+		TraceExporter: TRACE_EXPORTER_NONE,
+
+		ShutdownTimeout: DEFAULT_SHUTDOWN_TIMEOUT,
+	}
+}
+
+// A setting that can be given in the config file, as an environment
+// variable or as a command-line flag
+type setting struct {
+	// Config file key; the flag name is the key with dashes
+	key   string
+	env   string
+	usage string
+	// Secrets are redacted by --print-config and cannot be passed as
+	// flags, which other users could read from the process list
+	secret bool
+	// Can be changed while the server runs; see LiveConfig
+	reloadable bool
+	// Pointer to the Config field
+	field func(c *Config) any
+}
+
+var settings = []setting{
+	{key: "port", env: "GOVALENT_PORT", usage: "port of the API server", field: func(c *Config) any { return &c.Port }},
+	{key: "dsn", env: "GOVALENT_DSN", usage: "sqlite database path", field: func(c *Config) any { return &c.Dsn }},
+	{key: "storage_path", env: "GOVALENT_DATA_DIR", usage: "directory of stored assets", field: func(c *Config) any { return &c.StoragePath }},
+	{key: "log_level", env: "GOVALENT_LOG_LEVEL", usage: "one of DEBUG, INFO, WARN or ERROR", reloadable: true, field: func(c *Config) any { return &c.LogLevel }},
+	{key: "api_prefix", env: "GOVALENT_API_PREFIX", usage: "path prefix of API routes", field: func(c *Config) any { return &c.APIPrefix }},
+	{key: "max_request_body_bytes", env: "GOVALENT_MAX_REQUEST_BODY_BYTES", usage: "maximum request body size; 0 for no limit", reloadable: true, field: func(c *Config) any { return &c.MaxRequestBodyBytes }},
+	{key: "max_manifest_nodes", env: "GOVALENT_MAX_MANIFEST_NODES", usage: "maximum nodes in a manifest; 0 for no limit", reloadable: true, field: func(c *Config) any { return &c.MaxManifestNodes }},
+	{key: "webhook_max_attempts", env: "GOVALENT_WEBHOOK_MAX_ATTEMPTS", usage: "attempts per webhook delivery", reloadable: true, field: func(c *Config) any { return &c.WebhookMaxAttempts }},
+	{key: "webhook_retry_base_delay", env: "GOVALENT_WEBHOOK_RETRY_BASE_DELAY", usage: "delay before the first webhook retry", reloadable: true, field: func(c *Config) any { return &c.WebhookRetryBaseDelay }},
+	{key: "webhook_retry_max_delay", env: "GOVALENT_WEBHOOK_RETRY_MAX_DELAY", usage: "maximum delay between webhook retries", reloadable: true, field: func(c *Config) any { return &c.WebhookRetryMaxDelay }},
+	{key: "webhook_timeout", env: "GOVALENT_WEBHOOK_TIMEOUT", usage: "timeout of a webhook delivery", reloadable: true, field: func(c *Config) any { return &c.WebhookTimeout }},
+	{key: "auth_enabled", env: "GOVALENT_AUTH_ENABLED", usage: "require bearer tokens", field: func(c *Config) any { return &c.AuthEnabled }},
+	{key: "admin_token", env: "GOVALENT_ADMIN_TOKEN", usage: "bootstrap admin bearer token", secret: true, field: func(c *Config) any { return &c.AdminToken }},
+	{key: "metrics_port", env: "GOVALENT_METRICS_PORT", usage: "port of the unauthenticated metrics server; 0 to disable", field: func(c *Config) any { return &c.MetricsPort }},
+	{key: "trace_exporter", env: "GOVALENT_TRACE_EXPORTER", usage: "one of none, otlp or file", field: func(c *Config) any { return &c.TraceExporter }},
+	{key: "trace_file", env: "GOVALENT_TRACE_FILE", usage: "file appended to by the file trace exporter", field: func(c *Config) any { return &c.TraceFile }},
+	{key: "shutdown_timeout", env: "GOVALENT_SHUTDOWN_TIMEOUT", usage: "time allowed on shutdown for in-flight requests, and again for webhook deliveries", reloadable: true, field: func(c *Config) any { return &c.ShutdownTimeout }},
+}
+
+func (s *setting) flagName() string {
+	return strings.ReplaceAll(s.key, "_", "-")
+}
+
+// Parse val into the field of s
+func (s *setting) set(c *Config, val string) error {
+	if s.secret && val == REDACTED {
+		// Output of --print-config used as is
+		return fmt.Errorf("Redacted value for %s", s.key)
+	}
+	var err error
+	switch field := s.field(c).(type) {
+	case *string:
+		*field = val
+	case *int:
+		*field, err = strconv.Atoi(val)
+	case *int64:
+		*field, err = strconv.ParseInt(val, 10, 64)
+	case *bool:
+		*field, err = strconv.ParseBool(val)
+	case *time.Duration:
+		*field, err = time.ParseDuration(val)
+	case *slog.Level:
+		level, ok := log_level_mapping[strings.ToUpper(val)]
+		if !ok {
+			err = errors.New("unknown log level")
+		}
+		*field = level
+	}
+	if err != nil {
+		return fmt.Errorf("Invalid value %q for %s: %w", val, s.key, err)
 	}
+	return nil
 }
 
-// TODO
-func NewConfigFromEnv() Config {
+func (s *setting) format(c *Config) string {
+	switch field := s.field(c).(type) {
+	case *string:
+		if s.secret && len(*field) > 0 {
+			return REDACTED
+		}
+		return *field
+	case *int:
+		return strconv.Itoa(*field)
+	case *int64:
+		return strconv.FormatInt(*field, 10)
+	case *bool:
+		return strconv.FormatBool(*field)
+	case *time.Duration:
+		return field.String()
+	case *slog.Level:
+		return field.String()
+	}
+	return ""
+}
+
+// Command-line options other than settings
+type Options struct {
+	// Overrides GOVALENT_CONFIG
+	ConfigFile string
+	// Print the effective settings and exit
+	PrintConfig bool
+}
+
+// Build the configuration from the defaults, the config file, the
+// environment and the command-line arguments args, in increasing order
+// of precedence, and validate it. The returned error is flag.ErrHelp
+// if args requested usage.
+func LoadConfig(args []string) (Config, Options, error) {
+	var opts Options
+	fs := flag.NewFlagSet("govalent-server", flag.ContinueOnError)
+	fs.StringVar(&opts.ConfigFile, "config", os.Getenv(CONFIG_FILE_ENV), "YAML config file")
+	fs.BoolVar(&opts.PrintConfig, "print-config", false, "print the effective settings, with secrets redacted, and exit")
+	// Flags are applied last, after the config file named by --config
+	type flagValue struct {
+		s   *setting
+		val string
+	}
+	var flag_values []flagValue
+	for i := range settings {
+		s := &settings[i]
+		if s.secret {
+			continue
+		}
+		fs.Func(s.flagName(), fmt.Sprintf("%s (%s)", s.usage, s.env), func(val string) error {
+			flag_values = append(flag_values, flagValue{s: s, val: val})
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, opts, err
+	}
+	if fs.NArg() > 0 {
+		return Config{}, opts, fmt.Errorf("Unexpected argument %s", fs.Arg(0))
+	}
+
 	c := newDefaultConfig()
-	dsn := os.Getenv("GOVALENT_DSN")
-	if len(dsn) > 0 {
-		c.Dsn = dsn
-	}
-	port := os.Getenv("GOVALENT_PORT")
-	if len(port) > 0 {
-		portNum, err := strconv.Atoi(port)
-		if err != nil {
-			slog.Error(fmt.Sprint("Error parsing port number: ", err.Error()))
-			os.Exit(1)
+	if len(opts.ConfigFile) > 0 {
+		if err := c.loadFile(opts.ConfigFile); err != nil {
+			return Config{}, opts, err
+		}
+	}
+	if err := c.loadEnv(); err != nil {
+		return Config{}, opts, err
+	}
+	for _, fv := range flag_values {
+		if err := fv.s.set(&c, fv.val); err != nil {
+			return Config{}, opts, err
 		}
-		c.Port = portNum
 	}
+	if err := c.Validate(); err != nil {
+		return Config{}, opts, err
+	}
+	return c, opts, nil
+}
 
-	data_dir := os.Getenv("GOVALENT_DATA_DIR")
-	if len(data_dir) > 0 {
-		c.StoragePath = data_dir
+// Settings from a YAML mapping of setting keys to scalars
+func (c *Config) loadFile(file_path string) error {
+	data, err := os.ReadFile(file_path)
+	if err != nil {
+		return fmt.Errorf("Error reading config file: %w", err)
 	}
+	values := make(map[string]string)
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("Error parsing config file %s: %w", file_path, err)
+	}
+	if err := c.Set(values); err != nil {
+		return fmt.Errorf("Config file %s: %w", file_path, err)
+	}
+	return nil
+}
 
-	log_level := os.Getenv("GOVALENT_LOG_LEVEL")
-	if len(log_level) > 0 {
-		level, ok := log_level_mapping[strings.ToUpper(log_level)]
-		if !ok {
-			slog.Error(fmt.Sprint("Invalid log level ", log_level))
-			os.Exit(1)
+// Parse values, keyed by setting, into c. Unknown keys are rejected so
+// that typos do not go unnoticed.
+func (c *Config) Set(values map[string]string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := findSetting(key)
+		if s == nil {
+			return fmt.Errorf("Unknown setting %s", key)
+		}
+		if err := s.set(c, values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	for i := range settings {
+		s := &settings[i]
+		val := os.Getenv(s.env)
+		if len(val) == 0 {
+			continue
+		}
+		if err := s.set(c, val); err != nil {
+			return fmt.Errorf("%s: %w", s.env, err)
+		}
+	}
+	return nil
+}
+
+func findSetting(key string) *setting {
+	for i := range settings {
+		if settings[i].key == key {
+			return &settings[i]
+		}
+	}
+	return nil
+}
+
+// Check the consistency of the settings, reporting every problem
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Port < 1 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port %d out of range", c.Port))
+	}
+	if c.MetricsPort < 0 || c.MetricsPort > 65535 {
+		errs = append(errs, fmt.Errorf("metrics_port %d out of range", c.MetricsPort))
+	} else if c.MetricsPort == c.Port {
+		errs = append(errs, errors.New("metrics_port must differ from port"))
+	}
+	if len(c.Dsn) == 0 {
+		errs = append(errs, errors.New("dsn is required"))
+	}
+	if len(c.StoragePath) == 0 {
+		errs = append(errs, errors.New("storage_path is required when HOME is not set"))
+	}
+	if len(c.APIPrefix) > 0 && (!strings.HasPrefix(c.APIPrefix, "/") || strings.HasSuffix(c.APIPrefix, "/")) {
+		errs = append(errs, fmt.Errorf("api_prefix %s must start and not end with /", c.APIPrefix))
+	}
+	if c.WebhookMaxAttempts < 1 {
+		errs = append(errs, errors.New("webhook_max_attempts must be at least 1"))
+	}
+	for _, d := range []struct {
+		key string
+		val time.Duration
+	}{
+		{"webhook_retry_base_delay", c.WebhookRetryBaseDelay},
+		{"webhook_retry_max_delay", c.WebhookRetryMaxDelay},
+		{"webhook_timeout", c.WebhookTimeout},
+		{"shutdown_timeout", c.ShutdownTimeout},
+	} {
+		if d.val <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be positive", d.key))
+		}
+	}
+	if c.WebhookRetryMaxDelay < c.WebhookRetryBaseDelay {
+		errs = append(errs, errors.New("webhook_retry_max_delay must not be less than webhook_retry_base_delay"))
+	}
+	if !trace_exporters[c.TraceExporter] {
+		errs = append(errs, fmt.Errorf("Unknown trace_exporter %s", c.TraceExporter))
+	}
+	if c.TraceExporter == TRACE_EXPORTER_FILE && len(c.TraceFile) == 0 {
+		errs = append(errs, errors.New("trace_file is required by the file trace exporter"))
+	}
+	return errors.Join(errs...)
+}
+
+// The settings other than secrets, keyed as in the config file.
+// Durations and log levels are in the string form the config file
+// accepts; numbers and booleans keep their type.
+func (c *Config) Settings() map[string]any {
+	values := make(map[string]any, len(settings))
+	for i := range settings {
+		s := &settings[i]
+		if s.secret {
+			continue
+		}
+		switch field := s.field(c).(type) {
+		case *int:
+			values[s.key] = *field
+		case *int64:
+			values[s.key] = *field
+		case *bool:
+			values[s.key] = *field
+		default:
+			values[s.key] = s.format(c)
+		}
+	}
+	return values
+}
+
+// Write the settings as a config file, with secrets redacted
+func (c *Config) Print(w io.Writer) error {
+	doc := yaml.Node{Kind: yaml.MappingNode}
+	for i := range settings {
+		s := &settings[i]
+		val := yaml.Node{Kind: yaml.ScalarNode, Value: s.format(c)}
+		if _, ok := s.field(c).(*string); ok {
+			val.Tag = "!!str"
 		}
-		c.LogLevel = level
+		doc.Content = append(doc.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: s.key}, &val)
 	}
-	api_prefix := os.Getenv("GOVALENT_API_PREFIX")
-	if len(api_prefix) > 0 {
-		c.APIPrefix = api_prefix
+	enc := yaml.NewEncoder(w)
+	if err := enc.Encode(&doc); err != nil {
+		return err
 	}
-	return c
+	return enc.Close()
 }
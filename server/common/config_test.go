@@ -0,0 +1,197 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, contents string) string {
+	file_path := filepath.Join(t.TempDir(), "govalent.yaml")
+	if err := os.WriteFile(file_path, []byte(contents), 0600); err != nil {
+		t.Fatalf("Error writing config file: %v", err)
+	}
+	return file_path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	file_path := writeConfigFile(t, `
+port: 9000
+dsn: /var/lib/govalent/file.db
+log_level: debug
+webhook_timeout: 20s
+metrics_port: 9100
+`)
+	t.Setenv("HOME", "/home/test")
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("GOVALENT_DSN", "/var/lib/govalent/env.db")
+	t.Setenv("GOVALENT_PORT", "9001")
+	t.Setenv("GOVALENT_ADMIN_TOKEN", "secret")
+
+	c, opts, err := LoadConfig([]string{"--config", file_path, "--port", "9002"})
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	assert.Equal(t, file_path, opts.ConfigFile)
+	assert.False(t, opts.PrintConfig)
+	// flags > env > file > defaults
+	assert.Equal(t, 9002, c.Port)
+	assert.Equal(t, "/var/lib/govalent/env.db", c.Dsn)
+	assert.Equal(t, slog.LevelDebug, c.LogLevel)
+	assert.Equal(t, 20*time.Second, c.WebhookTimeout)
+	assert.Equal(t, 9100, c.MetricsPort)
+	assert.Equal(t, DEFAULT_WEBHOOK_MAX_ATTEMPTS, c.WebhookMaxAttempts)
+	assert.Equal(t, "/home/test/.local/share/govalent/data", c.StoragePath)
+	assert.Equal(t, "secret", c.AdminToken)
+
+	// The config file may also be named in the environment
+	t.Setenv(CONFIG_FILE_ENV, file_path)
+	t.Setenv("GOVALENT_PORT", "")
+	c, _, err = LoadConfig(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 9000, c.Port)
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+
+	_, _, err := LoadConfig([]string{"--config", writeConfigFile(t, "prot: 9000\n")})
+	assert.ErrorContains(t, err, "Unknown setting prot")
+
+	_, _, err = LoadConfig([]string{"--webhook-timeout", "soon"})
+	assert.ErrorContains(t, err, "webhook_timeout")
+
+	// Secrets are not accepted on the command line
+	_, _, err = LoadConfig([]string{"--admin-token", "secret"})
+	assert.NotNil(t, err)
+
+	t.Setenv("GOVALENT_LOG_LEVEL", "chatty")
+	_, _, err = LoadConfig(nil)
+	assert.ErrorContains(t, err, "GOVALENT_LOG_LEVEL")
+	t.Setenv("GOVALENT_LOG_LEVEL", "")
+
+	_, _, err = LoadConfig([]string{"-h"})
+	assert.True(t, errors.Is(err, flag.ErrHelp))
+
+	// Validation reports every problem
+	_, _, err = LoadConfig([]string{"--port", "0", "--trace-exporter", "file", "--webhook-max-attempts", "0"})
+	assert.ErrorContains(t, err, "port 0 out of range")
+	assert.ErrorContains(t, err, "trace_file is required")
+	assert.ErrorContains(t, err, "webhook_max_attempts")
+
+	t.Setenv("HOME", "")
+	t.Setenv("XDG_DATA_HOME", "")
+	_, _, err = LoadConfig(nil)
+	assert.ErrorContains(t, err, "storage_path is required")
+	_, _, err = LoadConfig([]string{"--storage-path", t.TempDir()})
+	assert.Nil(t, err)
+}
+
+func TestPrintConfig(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	t.Setenv("GOVALENT_ADMIN_TOKEN", "secret")
+	c, _, err := LoadConfig([]string{"--print-config", "--api-prefix", "/api/v0"})
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := c.Print(&buf); err != nil {
+		t.Fatalf("Error printing config: %v", err)
+	}
+	assert.NotContains(t, buf.String(), "secret")
+	assert.Contains(t, buf.String(), "admin_token: "+REDACTED)
+	assert.Contains(t, buf.String(), "shutdown_timeout: 30s")
+
+	// Redacted secrets must be replaced before the output is reused
+	_, _, err = LoadConfig([]string{"--config", writeConfigFile(t, buf.String())})
+	assert.ErrorContains(t, err, "Redacted value for admin_token")
+
+	t.Setenv("GOVALENT_ADMIN_TOKEN", "")
+	c, _, err = LoadConfig([]string{"--api-prefix", "/api/v0"})
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	buf.Reset()
+	c.Print(&buf)
+	printed, _, err := LoadConfig([]string{"--config", writeConfigFile(t, buf.String())})
+	if err != nil {
+		t.Fatalf("Error loading printed config: %v", err)
+	}
+	assert.Equal(t, c, printed)
+}
+
+func TestSettings(t *testing.T) {
+	t.Setenv("GOVALENT_ADMIN_TOKEN", "secret")
+	c, _, err := LoadConfig([]string{"--storage-path", "/tmp/govalent"})
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	values := c.Settings()
+	assert.NotContains(t, values, "admin_token")
+	assert.Equal(t, "10s", values["webhook_timeout"])
+	assert.Equal(t, "INFO", values["log_level"])
+	assert.Equal(t, DEFAULT_PORT, values["port"])
+
+	// Reloadable settings can be set back as they are reported
+	reloadable := make(map[string]string)
+	for _, key := range ReloadableSettings() {
+		reloadable[key] = fmt.Sprint(values[key])
+	}
+	live := NewLiveConfig(c)
+	changed, err := live.Set(reloadable)
+	assert.NoError(t, err)
+	assert.Empty(t, changed)
+}
+
+func TestLiveConfig(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	c, _, err := LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	live := NewLiveConfig(c)
+	var notified *Config
+	live.OnChange(func(c *Config) { notified = c })
+
+	changed, err := live.Set(map[string]string{"log_level": "debug", "webhook_timeout": "1m"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"log_level", "webhook_timeout"}, changed)
+	assert.Equal(t, slog.LevelDebug, live.Get().LogLevel)
+	assert.Equal(t, time.Minute, notified.WebhookTimeout)
+	// Earlier snapshots are unaffected
+	assert.Equal(t, slog.LevelInfo, c.LogLevel)
+
+	notified = nil
+	changed, err = live.Set(map[string]string{"log_level": "DEBUG"})
+	assert.Nil(t, err)
+	assert.Empty(t, changed)
+	assert.Nil(t, notified)
+
+	_, err = live.Set(map[string]string{"port": "9000", "dsn": "other.db", "max_manifest_nodes": "10"})
+	var immutable *ImmutableSettingsError
+	if assert.True(t, errors.As(err, &immutable)) {
+		assert.Equal(t, []string{"port", "dsn"}, immutable.Keys)
+	}
+	assert.Equal(t, DEFAULT_MAX_MANIFEST_NODES, live.Get().MaxManifestNodes)
+
+	_, err = live.Set(map[string]string{"webhook_max_attempts": "0"})
+	assert.ErrorContains(t, err, "webhook_max_attempts")
+	_, err = live.Set(map[string]string{"verbosity": "3"})
+	assert.ErrorContains(t, err, "Unknown setting verbosity")
+	assert.Equal(t, DEFAULT_WEBHOOK_MAX_ATTEMPTS, live.Get().WebhookMaxAttempts)
+
+	// A reload keeps unchanged immutable settings
+	next := *live.Get()
+	next.MaxRequestBodyBytes = 1024
+	changed, err = live.Update(next)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"max_request_body_bytes"}, changed)
+}
@@ -9,6 +9,7 @@ const STATUS_DISPATCHING = "DISPATCHING"
 // Terminal statuses
 const STATUS_COMPLETED = "COMPLETED"
 const STATUS_FAILED = "FAILED"
+const STATUS_CANCELLED = "CANCELLED"
 
 var validStatuses = map[string]bool{
 	STATUS_NEW:         true,
@@ -17,9 +18,34 @@ var validStatuses = map[string]bool{
 	STATUS_DISPATCHING: true,
 	STATUS_COMPLETED:   true,
 	STATUS_FAILED:      true,
+	STATUS_CANCELLED:   true,
 }
 
 func ValidateStatus(s string) bool {
 	_, ok := validStatuses[s]
 	return ok
 }
+
+func IsTerminalStatus(s string) bool {
+	return s == STATUS_COMPLETED || s == STATUS_FAILED || s == STATUS_CANCELLED
+}
+
+// Edge parameter types
+const PARAM_TYPE_ARG = "arg"
+const PARAM_TYPE_KWARG = "kwarg"
+const PARAM_TYPE_VAR_ARG = "var_arg"
+const PARAM_TYPE_VAR_KWARG = "var_kwarg"
+const PARAM_TYPE_WAIT_FOR = "wait_for"
+
+var validParamTypes = map[string]bool{
+	PARAM_TYPE_ARG:       true,
+	PARAM_TYPE_KWARG:     true,
+	PARAM_TYPE_VAR_ARG:   true,
+	PARAM_TYPE_VAR_KWARG: true,
+	PARAM_TYPE_WAIT_FOR:  true,
+}
+
+func ValidateParamType(s string) bool {
+	_, ok := validParamTypes[s]
+	return ok
+}
@@ -0,0 +1,43 @@
+package common
+
+import (
+	"context"
+	"log/slog"
+)
+
+type requestIdKey struct{}
+
+func WithRequestId(ctx context.Context, request_id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, request_id)
+}
+
+// Empty outside of API requests
+func RequestIdFromContext(ctx context.Context) string {
+	request_id, _ := ctx.Value(requestIdKey{}).(string)
+	return request_id
+}
+
+// A slog.Handler that adds the request id of the context, if any, to
+// each record logged with slog.InfoContext and friends
+type ContextHandler struct {
+	slog.Handler
+}
+
+func NewContextHandler(h slog.Handler) *ContextHandler {
+	return &ContextHandler{Handler: h}
+}
+
+func (h *ContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if request_id := RequestIdFromContext(ctx); len(request_id) > 0 {
+		r.AddAttrs(slog.String("request_id", request_id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithGroup(name)}
+}
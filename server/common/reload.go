@@ -0,0 +1,108 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Settings that differ between two configurations but cannot be
+// changed while the server runs
+type ImmutableSettingsError struct {
+	Keys []string
+}
+
+func (e *ImmutableSettingsError) Error() string {
+	return fmt.Sprintf("Cannot change %s without a restart", strings.Join(e.Keys, ", "))
+}
+
+// Keys of the settings that can be changed while the server runs
+func ReloadableSettings() []string {
+	var keys []string
+	for i := range settings {
+		if settings[i].reloadable {
+			keys = append(keys, settings[i].key)
+		}
+	}
+	return keys
+}
+
+// The configuration of a running server. Readers take a snapshot with
+// Get; reloadable settings are changed with Update, which notifies the
+// listeners registered with OnChange.
+type LiveConfig struct {
+	current atomic.Pointer[Config]
+	// Serializes updates and guards listeners
+	mu        sync.Mutex
+	listeners []func(c *Config)
+}
+
+func NewLiveConfig(c Config) *LiveConfig {
+	live := &LiveConfig{}
+	live.current.Store(&c)
+	return live
+}
+
+// The current settings, which must not be modified
+func (l *LiveConfig) Get() *Config {
+	return l.current.Load()
+}
+
+// f is called with the new settings after each update
+func (l *LiveConfig) OnChange(f func(c *Config)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.listeners = append(l.listeners, f)
+}
+
+// Replace the settings with next, which may only differ in reloadable
+// settings, and return the keys of the settings that changed
+func (l *LiveConfig) Update(next Config) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.update(next)
+}
+
+// Update the settings in values, keyed as in the config file
+func (l *LiveConfig) Set(values map[string]string) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	next := *l.current.Load()
+	if err := next.Set(values); err != nil {
+		return nil, err
+	}
+	return l.update(next)
+}
+
+// Called with mu held
+func (l *LiveConfig) update(next Config) ([]string, error) {
+	current := l.current.Load()
+	var changed, immutable []string
+	for i := range settings {
+		s := &settings[i]
+		if reflect.ValueOf(s.field(current)).Elem().Interface() == reflect.ValueOf(s.field(&next)).Elem().Interface() {
+			continue
+		}
+		if s.reloadable {
+			changed = append(changed, s.key)
+		} else {
+			immutable = append(immutable, s.key)
+		}
+	}
+	if len(immutable) > 0 {
+		return nil, &ImmutableSettingsError{Keys: immutable}
+	}
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	l.current.Store(&next)
+	for _, f := range l.listeners {
+		f(&next)
+	}
+	return changed, nil
+}
@@ -1,13 +1,17 @@
 package crud
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log/slog"
+	"path/filepath"
 
 	"github.com/casey/govalent/server/common"
 	"github.com/casey/govalent/server/db"
 	"github.com/casey/govalent/server/models"
+	"github.com/casey/govalent/server/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const STORAGE_SCHEME = "file"
@@ -25,6 +29,8 @@ type AssetEntity struct {
 	scheme string
 
 	base_path string
+
+	namespace string
 }
 
 func NewAssetEntity() AssetEntity {
@@ -33,13 +39,15 @@ func NewAssetEntity() AssetEntity {
 	}
 }
 
-func NewAssetEntityFromPublic(c *common.Config, public *models.AssetPublicSchema) AssetEntity {
+// Each namespace stores its assets in its own directory
+func NewAssetEntityFromPublic(c *common.Config, namespace string, public *models.AssetPublicSchema) AssetEntity {
 	scheme := STORAGE_SCHEME
-	base_path := c.StoragePath
+	base_path := filepath.Join(c.StoragePath, namespace)
 	return AssetEntity{
 		public:    public,
 		scheme:    scheme,
 		base_path: base_path,
+		namespace: namespace,
 	}
 }
 
@@ -53,6 +61,7 @@ func (a *AssetEntity) Fields() []string {
 		db.ASSET_TABLE_DIGEST,
 		db.ASSET_TABLE_DIGEST_ALG,
 		db.ASSET_TABLE_REMOTE_URI,
+		db.ASSET_TABLE_NAMESPACE,
 	}
 }
 
@@ -66,6 +75,7 @@ func (a *AssetEntity) Values() []any {
 		a.public.DigestAlg,
 		a.public.Digest,
 		a.public.Uri,
+		a.namespace,
 	}
 }
 
@@ -79,6 +89,7 @@ func (a *AssetEntity) Fieldrefs() []any {
 		&a.public.DigestAlg,
 		&a.public.Digest,
 		&a.public.Uri,
+		&a.namespace,
 	}
 }
 
@@ -102,67 +113,139 @@ func (a *AssetEntity) GetPublicEntity(c *common.Config) *models.AssetPublicSchem
 	return a.public
 }
 
-// Register assets and populate each manifest's RemoteURI with the asset's
-// upload URL
-func CreateAssets(c *common.Config, t *sql.Tx, a []models.AssetPublicSchema) ([]AssetEntity, *models.APIError) {
+// Register assets in namespace and populate each manifest's RemoteURI
+// with the asset's upload URL. An empty namespace is DEFAULT_NAMESPACE.
+func CreateAssets(ctx context.Context, c *common.Config, t *sql.Tx, namespace string, a []models.AssetPublicSchema) (_ []AssetEntity, err *models.APIError) {
+	_, span := tracing.Start(ctx, "CreateAssets", attribute.Int("count", len(a)))
+	defer func() { tracing.End(span, err) }()
+
+	if len(namespace) == 0 {
+		namespace = models.DEFAULT_NAMESPACE
+	}
 	ents := make([]AssetEntity, len(a))
 	for i := range a {
-		ent := NewAssetEntityFromPublic(c, &a[i])
+		ent := NewAssetEntityFromPublic(c, namespace, &a[i])
 		ents[i] = ent
 	}
-	_, err := createAssetsFromEntities(t, ents)
+	_, err = createAssetsFromEntities(t, namespace, ents)
 	if err != nil {
 		return nil, err
 	}
+	if len(ents) > 0 {
+		if err := ensureNamespace(t, namespace); err != nil {
+			return nil, err
+		}
+		if err := checkNamespaceQuota(t, namespace); err != nil {
+			return nil, err
+		}
+	}
 
 	// Only non-null assets will be uploaded
 	for _, ent := range ents {
 		if ent.public.Size > 0 && len(ent.public.Uri) == 0 {
 			ent.public.RemoteUri = ent.GetPublicUri(c)
-			slog.Debug(fmt.Sprintf("Returning upload URI for asset: %s\n", ent.public.RemoteUri))
+			slog.DebugContext(ctx, fmt.Sprintf("Returning upload URI for asset: %s\n", ent.public.RemoteUri))
 		}
 	}
 	return ents, nil
 }
 
-func createAssetsFromEntities(t *sql.Tx, a []AssetEntity) (int, *models.APIError) {
+func createAssetsFromEntities(t *sql.Tx, namespace string, a []AssetEntity) (int, *models.APIError) {
 	if len(a) == 0 {
 		return 0, nil
 	}
-	// TODO: cache this template
-
 	// fields without id which will be autogenerated by the db engine
 	insert_fields := (&a[0]).Fields()[1:]
-	template, _ := generateInsertTemplate(db.ASSET_TABLE, insert_fields)
 
-	stmt, err := t.Prepare(template)
-	if err != nil {
-		slog.Info(fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
-		return 0, models.NewGenericServerError(err)
-	}
-	for i := 0; i < len(a); i++ {
-		// fields without id
-		insert_values := (&a[i]).Values()[1:]
-		res, err := stmt.Exec(insert_values...)
+	// Auto-generated primary keys by asset key. A multi-row INSERT
+	// only reports the last rowid, so ask for all of them.
+	ids_by_key := make(map[string]int64, len(a))
+	inserted := 0
+
+	api_err := forEachInsertBatch(len(a), len(insert_fields), func(start int, end int) *models.APIError {
+		template := fmt.Sprintf(
+			"%s RETURNING %s, %s",
+			generateBatchInsertTemplate(db.ASSET_TABLE, insert_fields, end-start),
+			db.ASSET_TABLE_ID,
+			db.ASSET_TABLE_KEY,
+		)
+		values := make([]any, 0, (end-start)*len(insert_fields))
+		for i := start; i < end; i++ {
+			// fields without id
+			values = append(values, (&a[i]).Values()[1:]...)
+		}
+		stmt, err := prepareStmt(t, template)
 		if err != nil {
-			return i, models.NewGenericServerError(err)
+			slog.InfoContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+			return models.NewGenericServerError(err)
 		}
-		// Retrieve auto-generated primary key
-		lastInsertId, err := res.LastInsertId()
+		rows, err := stmt.Query(values...)
 		if err != nil {
-			return i, models.NewGenericServerError(err)
+			slog.InfoContext(txContext(t), fmt.Sprintf("Error inserting assets: %s\n", err.Error()))
+			return models.NewGenericServerError(err)
 		}
-		a[i].id = lastInsertId
+		defer rows.Close()
+		for rows.Next() {
+			var id int64
+			var key string
+			if err := rows.Scan(&id, &key); err != nil {
+				return models.NewGenericServerError(err)
+			}
+			ids_by_key[key] = id
+			inserted += 1
+		}
+		if err := rows.Err(); err != nil {
+			return models.NewGenericServerError(err)
+		}
+		return nil
+	})
+	if api_err != nil {
+		return inserted, api_err
+	}
+
+	for i := range a {
+		id, ok := ids_by_key[a[i].public.Key]
+		if !ok {
+			// The key already existed and the row was ignored
+			existing_id, api_err := getAssetIdByKey(t, namespace, a[i].public.Key)
+			if api_err != nil {
+				return inserted, api_err
+			}
+			id = existing_id
+		}
+		a[i].id = id
+	}
+	slog.DebugContext(txContext(t), fmt.Sprintf("Inserted %d asset records\n", inserted))
+	return inserted, nil
+}
+
+func getAssetIdByKey(t *sql.Tx, namespace string, key string) (int64, *models.APIError) {
+	var id int64
+	template := fmt.Sprintf(
+		"SELECT %s FROM %s %s",
+		db.ASSET_TABLE_ID,
+		db.ASSET_TABLE,
+		generateWhereString([]string{db.ASSET_TABLE_NAMESPACE, db.ASSET_TABLE_KEY}),
+	)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.InfoContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return 0, models.NewGenericServerError(err)
+	}
+	err = stmt.QueryRow(namespace, key).Scan(&id)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error retrieving asset %s: %s\n", key, err.Error()))
+		return 0, models.NewGenericServerError(err)
 	}
-	slog.Debug(fmt.Sprintf("Inserted %d asset records\n", len(a)))
-	return len(a), nil
+	return id, nil
 }
 
-func GetAssetEntitiesByPrefix(t *sql.Tx, prefix string, limit int, offset int) ([]AssetEntity, *models.APIError) {
+func GetAssetEntitiesByPrefix(t *sql.Tx, namespace string, prefix string, limit int, offset int) ([]AssetEntity, *models.APIError) {
 
 	results := make([]AssetEntity, 0)
 	f := Filters{}
-	(&f).AddLike(db.ASSET_TABLE_KEY, fmt.Sprintf("%s%%", prefix))
+	(&f).AddEq(db.ASSET_TABLE_NAMESPACE, namespace)
+	(&f).AddLike(db.ASSET_TABLE_KEY, fmt.Sprintf("%s%%", EscapeLike(prefix)))
 
 	template := generateSelectTemplate(
 		db.ASSET_TABLE,
@@ -172,9 +255,9 @@ func GetAssetEntitiesByPrefix(t *sql.Tx, prefix string, limit int, offset int) (
 		true,
 		true,
 	)
-	stmt, err := t.Prepare(template)
+	stmt, err := prepareStmt(t, template)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
 		return nil, models.NewGenericServerError(err)
 	}
 
@@ -183,7 +266,7 @@ func GetAssetEntitiesByPrefix(t *sql.Tx, prefix string, limit int, offset int) (
 	params = append(params, offset)
 	rows, err := stmt.Query(params...)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
 		return nil, models.NewGenericServerError(err)
 	}
 
@@ -191,7 +274,7 @@ func GetAssetEntitiesByPrefix(t *sql.Tx, prefix string, limit int, offset int) (
 		ent := AssetEntity{public: &models.AssetPublicSchema{}}
 		err = rows.Scan((&ent).Fieldrefs()...)
 		if err != nil {
-			slog.Error(fmt.Sprintf("Error querying row: %s\n", err.Error()))
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
 			return nil, models.NewGenericServerError(err)
 		}
 		results = append(results, ent)
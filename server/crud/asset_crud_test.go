@@ -1,9 +1,10 @@
 package crud
 
 import (
+	"context"
+	"fmt"
 	"testing"
 
-	"github.com/casey/govalent/server/common"
 	"github.com/casey/govalent/server/models"
 )
 
@@ -23,21 +24,21 @@ func TestCreateAssets(t *testing.T) {
 		"/dispatch-id/node_1/function.tobj",
 		2,
 	)
-	config := common.NewConfigFromEnv()
+	config := newMockConfig(t)
 	d := newMockDB(t)
 	tx, db_err := d.Begin()
 	if db_err != nil {
 		t.Fatalf("Error starting transaction: %v", db_err)
 	}
 
-	created, err := CreateAssets(&config, tx, []models.AssetPublicSchema{asset_body_1, asset_body_2})
+	created, err := CreateAssets(context.Background(), &config, tx, "", []models.AssetPublicSchema{asset_body_1, asset_body_2})
 	if err != nil {
 		t.Fatalf("Error inserting assets: %s\n", err.Error())
 	}
 	if len(created) != 2 {
 		t.Fatalf("Expected to insert %d rows; actually inserted %d rows\n", 2, len(created))
 	}
-	ents, err := GetAssetEntitiesByPrefix(tx, "/dispatch-id", 100, 0)
+	ents, err := GetAssetEntitiesByPrefix(tx, models.DEFAULT_NAMESPACE, "/dispatch-id", 100, 0)
 	if err != nil {
 		t.Fatalf("Error retrieving assets: %s\n", err.Error())
 	}
@@ -47,7 +48,7 @@ func TestCreateAssets(t *testing.T) {
 }
 
 func TestGetDispatchAssets(t *testing.T) {
-	config := common.NewConfigFromEnv()
+	config := newMockConfig(t)
 	d := newMockDB(t)
 	tx, db_err := d.Begin()
 	if db_err != nil {
@@ -55,7 +56,7 @@ func TestGetDispatchAssets(t *testing.T) {
 	}
 
 	dispatch := newMockDispatch(nil, nil)
-	err := ImportManifest(&config, tx, &dispatch)
+	err := ImportManifest(context.Background(), &config, tx, &dispatch)
 	if err != nil {
 		t.Fatalf("%s", err.Error())
 	}
@@ -71,7 +72,7 @@ func TestGetDispatchAssets(t *testing.T) {
 }
 
 func TestCreateGetElectronAssetLinks(t *testing.T) {
-	config := common.NewConfigFromEnv()
+	config := newMockConfig(t)
 	d := newMockDB(t)
 	// asset_body_1 := newMockAsset(
 	// 	"/dispatch-id/node_0/function.tobj",
@@ -85,11 +86,11 @@ func TestCreateGetElectronAssetLinks(t *testing.T) {
 	if db_err != nil {
 		t.Fatalf("Error starting transaction: %v", db_err)
 	}
-	// CreateAssets(&config, tx, []models.AssetPublicSchema{asset_body_1, asset_body_2})
+	// CreateAssets(context.Background(), &config, tx, "", []models.AssetPublicSchema{asset_body_1, asset_body_2})
 
 	electron := newMockElectron(0, newMockElectronMeta(0, "NEW_OBJECT"), models.ElectronAssets{})
 	dispatch := newMockDispatch([]models.ElectronSchema{electron}, nil)
-	err := ImportManifest(&config, tx, &dispatch)
+	err := ImportManifest(context.Background(), &config, tx, &dispatch)
 	if err != nil {
 		t.Fatal(err.Error())
 	}
@@ -106,7 +107,7 @@ func TestCreateGetElectronAssetLinks(t *testing.T) {
 }
 
 func TestGetElectronAssets(t *testing.T) {
-	config := common.NewConfigFromEnv()
+	config := newMockConfig(t)
 	d := newMockDB(t)
 	// asset_body_1 := newMockAsset(
 	// 	"/dispatch-id/node_0/function.tobj",
@@ -120,11 +121,11 @@ func TestGetElectronAssets(t *testing.T) {
 	if db_err != nil {
 		t.Fatalf("Error starting transaction: %v", db_err)
 	}
-	// CreateAssets(&config, tx, []models.AssetPublicSchema{asset_body_1, asset_body_2})
+	// CreateAssets(context.Background(), &config, tx, "", []models.AssetPublicSchema{asset_body_1, asset_body_2})
 
 	electron := newMockElectron(0, newMockElectronMeta(0, "NEW_OBJECT"), models.ElectronAssets{})
 	dispatch := newMockDispatch([]models.ElectronSchema{electron}, nil)
-	err := ImportManifest(&config, tx, &dispatch)
+	err := ImportManifest(context.Background(), &config, tx, &dispatch)
 	if err != nil {
 		t.Fatal(err.Error())
 	}
@@ -139,3 +140,53 @@ func TestGetElectronAssets(t *testing.T) {
 
 	tx.Rollback()
 }
+
+func TestCreateAssetsBatched(t *testing.T) {
+	config := newMockConfig(t)
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+
+	// Span several multi-row INSERT statements
+	n := insertBatchSize(7)*2 + 3
+	assets := make([]models.AssetPublicSchema, n)
+	for i := range assets {
+		assets[i] = newMockAsset(fmt.Sprintf("/dispatch-id/asset_%d", i), i+1)
+	}
+	created, err := CreateAssets(context.Background(), &config, tx, "", assets)
+	if err != nil {
+		t.Fatalf("Error inserting assets: %s\n", err.Error())
+	}
+	if len(created) != n {
+		t.Fatalf("Expected %d entities, got %d", n, len(created))
+	}
+
+	ents, err := GetAssetEntitiesByPrefix(tx, models.DEFAULT_NAMESPACE, "/dispatch-id", 2*n, 0)
+	if err != nil {
+		t.Fatalf("Error retrieving assets: %s\n", err.Error())
+	}
+	if len(ents) != n {
+		t.Fatalf("Expected %d asset records, got %d records", n, len(ents))
+	}
+	ids_by_key := make(map[string]int64)
+	for _, ent := range ents {
+		ids_by_key[ent.public.Key] = ent.id
+	}
+	for _, ent := range created {
+		if ids_by_key[ent.public.Key] != ent.id {
+			t.Fatalf("Wrong id for asset %s: expected %d, actual %d", ent.public.Key, ids_by_key[ent.public.Key], ent.id)
+		}
+	}
+
+	// Re-registering an existing key must resolve to the existing row
+	again, err := CreateAssets(context.Background(), &config, tx, "", []models.AssetPublicSchema{newMockAsset("/dispatch-id/asset_7", 8)})
+	if err != nil {
+		t.Fatalf("Error inserting assets: %s\n", err.Error())
+	}
+	if again[0].id != ids_by_key["/dispatch-id/asset_7"] {
+		t.Fatalf("Expected existing id %d, got %d", ids_by_key["/dispatch-id/asset_7"], again[0].id)
+	}
+	tx.Rollback()
+}
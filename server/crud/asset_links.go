@@ -102,21 +102,16 @@ func createAssetLinks(t *sql.Tx, links []AssetLink) *models.APIError {
 	if len(links) == 0 {
 		return nil
 	}
-	template, _ := generateInsertTemplate(db.ASSET_LINKS_TABLE, (&links[0]).Fields())
-	slog.Debug(fmt.Sprintf("SQL template: %s\n", template))
-	stmt, err := t.Prepare(template)
-	if err != nil {
-		slog.Error(fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
-		return models.NewGenericServerError(err)
+	rows := make([][]any, len(links))
+	for i := range links {
+		rows[i] = (&links[i]).Values()
 	}
-	for _, item := range links {
-		_, err = stmt.Exec((&item).Values()...)
-		if err != nil {
-			slog.Error(fmt.Sprintf("Error inserting row: %s\n", err.Error()))
-			return models.NewGenericServerError(err)
-		}
+	n, err := InsertRows(t, db.ASSET_LINKS_TABLE, (&links[0]).Fields(), rows)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error creating asset links: %s\n", err.Error()))
+		return err
 	}
-	slog.Debug(fmt.Sprintf("Created %d electron asset links\n", len(links)))
+	slog.DebugContext(txContext(t), fmt.Sprintf("Created %d electron asset links\n", n))
 	return nil
 }
 
@@ -135,19 +130,18 @@ func getAssetLinks(t *sql.Tx, dispatch_id string, node_id int) ([]AssetLink, *mo
 		(&f).RenderTemplate(),
 		db.ASSET_LINKS_TABLE_NAME,
 		true,
-		100,
-		0,
+		false,
 	)
-	slog.Debug(fmt.Sprintf("SQL template: %s\n", template))
-	stmt, err := t.Prepare(template)
+	slog.DebugContext(txContext(t), fmt.Sprintf("SQL template: %s\n", template))
+	stmt, err := prepareStmt(t, template)
 	if err != nil {
-		slog.Info(fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		slog.InfoContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
 		return nil, models.NewGenericServerError(err)
 	}
 
 	rows, err := stmt.Query((&f).RenderValues()...)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
 		return nil, models.NewGenericServerError(err)
 	}
 
@@ -155,13 +149,13 @@ func getAssetLinks(t *sql.Tx, dispatch_id string, node_id int) ([]AssetLink, *mo
 		link := AssetLink{}
 		err = rows.Scan((&link).Fieldrefs()...)
 		if err != nil {
-			slog.Error(fmt.Sprintf("Error querying row: %s\n", err.Error()))
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
 			return nil, models.NewGenericServerError(err)
 		}
 		results = append(results, link)
 		count += 1
 	}
-	slog.Debug(fmt.Sprintf("Returning %d electron-asset links\n", count))
+	slog.DebugContext(txContext(t), fmt.Sprintf("Returning %d electron-asset links\n", count))
 	return results, nil
 }
 
@@ -182,8 +176,6 @@ func GetElectronAssets(
 	(&filters).AddEq(strings.Join([]string{db.ASSET_LINKS_TABLE, db.ASSET_LINKS_TABLE_DISPATCH_ID}, "."), dispatch_id)
 	(&filters).AddEq(strings.Join([]string{db.ASSET_LINKS_TABLE, db.ASSET_LINKS_TABLE_NODE_ID}, "."), node_id)
 	sort_key := strings.Join([]string{db.ASSET_LINKS_TABLE, db.ASSET_LINKS_TABLE_NAME}, ".")
-	limit := 100
-	offset := 0
 	template := generateSelectJoinTemplate(
 		table,
 		(&ent).Fields(),
@@ -191,33 +183,136 @@ func GetElectronAssets(
 		(&filters).RenderTemplate(),
 		sort_key,
 		true,
-		limit,
-		offset,
+		false,
 	)
 
 	// Prepare statement and exec query
-	stmt, err := t.Prepare(template)
+	stmt, err := prepareStmt(t, template)
 	if err != nil {
-		slog.Info(fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		slog.InfoContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
 		return nil, models.NewGenericServerError(err)
 	}
 
 	rows, err := stmt.Query((&filters).RenderValues()...)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
 		return nil, models.NewGenericServerError(err)
 	}
 	for rows.Next() {
 		ent = NewNamedAssetEntity()
 		err = rows.Scan((&ent).Fieldrefs()...)
 		if err != nil {
-			slog.Error(fmt.Sprintf("Error querying row: %s\n", err.Error()))
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
 			return nil, models.NewGenericServerError(err)
 		}
 		ents = append(ents, ent)
 		count += 1
 	}
-	slog.Debug(fmt.Sprintf("Returning %d electron-asset links\n", count))
+	slog.DebugContext(txContext(t), fmt.Sprintf("Returning %d electron-asset links\n", count))
 
 	return ents, nil
 }
+
+// A NamedAssetEntity tagged with the node it is linked to
+type NodeAssetEntity struct {
+	NodeId int
+	NamedAssetEntity
+}
+
+func NewNodeAssetEntity() NodeAssetEntity {
+	return NodeAssetEntity{
+		NamedAssetEntity: NewNamedAssetEntity(),
+	}
+}
+
+func (e *NodeAssetEntity) Fields() []string {
+	node_col := strings.Join([]string{db.ASSET_LINKS_TABLE, db.ASSET_LINKS_TABLE_NODE_ID}, ".")
+	return append([]string{node_col}, e.NamedAssetEntity.Fields()...)
+}
+
+func (e *NodeAssetEntity) Fieldrefs() []any {
+	return append([]any{&e.NodeId}, e.NamedAssetEntity.Fieldrefs()...)
+}
+
+func (e *NodeAssetEntity) Values() []any {
+	return append([]any{e.NodeId}, e.NamedAssetEntity.Values()...)
+}
+
+func (e *NodeAssetEntity) Joins() []JoinCondition {
+	return e.NamedAssetEntity.Joins()
+}
+
+// Load the asset links of every node in a dispatch using a single query.
+// Output: map node_id -> [{name, Asset Record}]
+//
+// Workflow-scoped assets are returned under node_id -1.
+func GetAllElectronAssets(
+	c *common.Config,
+	t *sql.Tx,
+	dispatch_id string,
+) (map[int][]NamedAssetEntity, *models.APIError) {
+	return GetElectronAssetsByNode(c, t, dispatch_id, nil)
+}
+
+// Like GetAllElectronAssets but restricted to node_ids; a nil node_ids
+// selects every node
+func GetElectronAssetsByNode(
+	c *common.Config,
+	t *sql.Tx,
+	dispatch_id string,
+	node_ids []int,
+) (map[int][]NamedAssetEntity, *models.APIError) {
+
+	results := make(map[int][]NamedAssetEntity)
+	count := 0
+	ent := NodeAssetEntity{}
+	filters := Filters{}
+	(&filters).AddEq(strings.Join([]string{db.ASSET_LINKS_TABLE, db.ASSET_LINKS_TABLE_DISPATCH_ID}, "."), dispatch_id)
+	if node_ids != nil {
+		vals := make([]any, len(node_ids))
+		for i, node_id := range node_ids {
+			vals[i] = node_id
+		}
+		(&filters).AddIn(strings.Join([]string{db.ASSET_LINKS_TABLE, db.ASSET_LINKS_TABLE_NODE_ID}, "."), vals)
+	}
+	sort_key := strings.Join([]string{db.ASSET_LINKS_TABLE, db.ASSET_LINKS_TABLE_NAME}, ".")
+	template := generateSelectJoinTemplate(
+		db.ASSET_LINKS_TABLE,
+		(&ent).Fields(),
+		(&ent).Joins(),
+		(&filters).RenderTemplate(),
+		sort_key,
+		true,
+		false,
+	)
+
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.InfoContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+
+	rows, err := stmt.Query((&filters).RenderValues()...)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		ent = NewNodeAssetEntity()
+		err = rows.Scan((&ent).Fieldrefs()...)
+		if err != nil {
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
+			return nil, models.NewGenericServerError(err)
+		}
+		results[ent.NodeId] = append(results[ent.NodeId], ent.NamedAssetEntity)
+		count += 1
+	}
+	if err := rows.Err(); err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying rows: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+	slog.DebugContext(txContext(t), fmt.Sprintf("Returning %d asset links for dispatch %s\n", count, dispatch_id))
+
+	return results, nil
+}
@@ -0,0 +1,166 @@
+package crud
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/casey/govalent/server/db"
+	"github.com/casey/govalent/server/models"
+)
+
+var AUDIT_ENTRY_ENTITY_KEYS = []string{
+	db.AUDIT_LOG_TABLE_CREATED_AT,
+	db.AUDIT_LOG_TABLE_REQUEST_ID,
+	db.AUDIT_LOG_TABLE_USER,
+	db.AUDIT_LOG_TABLE_TOKEN_ID,
+	db.AUDIT_LOG_TABLE_ROLE,
+	db.AUDIT_LOG_TABLE_METHOD,
+	db.AUDIT_LOG_TABLE_PATH,
+	db.AUDIT_LOG_TABLE_OUTCOME,
+	db.AUDIT_LOG_TABLE_DETAIL,
+	db.AUDIT_LOG_TABLE_ACTION,
+	db.AUDIT_LOG_TABLE_NAMESPACE,
+	db.AUDIT_LOG_TABLE_DISPATCH_ID,
+	db.AUDIT_LOG_TABLE_STATUS_CODE,
+}
+
+// Mapped to a row in the audit_log table
+type AuditEntryEntity struct {
+	entry *models.AuditEntry
+}
+
+func (e *AuditEntryEntity) Fields() []string {
+	return AUDIT_ENTRY_ENTITY_KEYS
+}
+
+func (e *AuditEntryEntity) Values() []any {
+	return []any{
+		e.entry.CreatedAt,
+		e.entry.RequestId,
+		e.entry.User,
+		e.entry.TokenId,
+		e.entry.Role,
+		e.entry.Method,
+		e.entry.Path,
+		e.entry.Outcome,
+		e.entry.Detail,
+		e.entry.Action,
+		e.entry.Namespace,
+		e.entry.DispatchId,
+		e.entry.StatusCode,
+	}
+}
+
+func (e *AuditEntryEntity) Fieldrefs() []any {
+	return []any{
+		&e.entry.CreatedAt,
+		&e.entry.RequestId,
+		&e.entry.User,
+		&e.entry.TokenId,
+		&e.entry.Role,
+		&e.entry.Method,
+		&e.entry.Path,
+		&e.entry.Outcome,
+		&e.entry.Detail,
+		&e.entry.Action,
+		&e.entry.Namespace,
+		&e.entry.DispatchId,
+		&e.entry.StatusCode,
+	}
+}
+
+func (e *AuditEntryEntity) Joins() []JoinCondition {
+	return []JoinCondition{}
+}
+
+// Record entry in the audit log and set its Id
+func AppendAuditEntry(t *sql.Tx, entry *models.AuditEntry) *models.APIError {
+	ent := AuditEntryEntity{entry: entry}
+	template := generateBatchInsertTemplate(db.AUDIT_LOG_TABLE, (&ent).Fields(), 1)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return models.NewGenericServerError(err)
+	}
+	res, err := stmt.Exec((&ent).Values()...)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error recording audit entry: %s\n", err.Error()))
+		return dbError(err)
+	}
+	entry.Id, err = res.LastInsertId()
+	if err != nil {
+		return models.NewGenericServerError(err)
+	}
+	return nil
+}
+
+// Filters of SearchAuditLog; empty fields match every entry
+type AuditQuery struct {
+	User       string
+	Action     string
+	Outcome    string
+	Namespace  string
+	DispatchId string
+	Since      *time.Time
+	Until      *time.Time
+	Page       int
+	Count      int
+}
+
+// Audit entries matching q, newest first
+func SearchAuditLog(t *sql.Tx, q AuditQuery) (models.GetBulkAuditEntriesResponse, *models.APIError) {
+	f := Filters{}
+	for _, item := range []KeyValue{
+		{Key: db.AUDIT_LOG_TABLE_USER, Value: q.User},
+		{Key: db.AUDIT_LOG_TABLE_ACTION, Value: q.Action},
+		{Key: db.AUDIT_LOG_TABLE_OUTCOME, Value: q.Outcome},
+		{Key: db.AUDIT_LOG_TABLE_NAMESPACE, Value: q.Namespace},
+		{Key: db.AUDIT_LOG_TABLE_DISPATCH_ID, Value: q.DispatchId},
+	} {
+		if item.Value != "" {
+			(&f).AddEq(item.Key, item.Value)
+		}
+	}
+	if q.Since != nil || q.Until != nil {
+		(&f).AddTimeRange(db.AUDIT_LOG_TABLE_CREATED_AT, q.Since, q.Until)
+	}
+	total, err := CountEntities(t, db.AUDIT_LOG_TABLE, f)
+	if err != nil {
+		return models.GetBulkAuditEntriesResponse{}, err
+	}
+	f.Limit = q.Count
+	f.Offset = q.Page * q.Count
+	columns := append([]string{db.AUDIT_LOG_TABLE_ID}, AUDIT_ENTRY_ENTITY_KEYS...)
+	template := generateSelectTemplate(
+		db.AUDIT_LOG_TABLE,
+		columns,
+		(&f).RenderTemplate(),
+		db.AUDIT_LOG_TABLE_ID,
+		false,
+		f.Limit > 0,
+	)
+	stmt, db_err := prepareStmt(t, template)
+	if db_err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", db_err.Error()))
+		return models.GetBulkAuditEntriesResponse{}, models.NewGenericServerError(db_err)
+	}
+	rows, db_err := stmt.Query((&f).RenderValues()...)
+	if db_err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", db_err.Error()))
+		return models.GetBulkAuditEntriesResponse{}, models.NewGenericServerError(db_err)
+	}
+	defer rows.Close()
+	records := make([]models.AuditEntry, 0)
+	for rows.Next() {
+		entry := models.AuditEntry{}
+		ent := AuditEntryEntity{entry: &entry}
+		if db_err := rows.Scan(append([]any{&entry.Id}, (&ent).Fieldrefs()...)...); db_err != nil {
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", db_err.Error()))
+			return models.GetBulkAuditEntriesResponse{}, models.NewGenericServerError(db_err)
+		}
+		records = append(records, entry)
+	}
+	return models.GetBulkAuditEntriesResponse{Records: records, Total: total}, nil
+}
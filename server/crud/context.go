@@ -0,0 +1,51 @@
+package crud
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// Request context and pool of each open transaction begun with
+// BeginTx. crud functions take only the transaction; their log lines
+// recover the request id from it, and prepareStmt the statement cache
+// of its pool.
+var txStates sync.Map
+
+type txState struct {
+	ctx  context.Context
+	pool *sql.DB
+}
+
+// Begin a transaction on behalf of the request in ctx. As with
+// sql.DB.BeginTx, the transaction is rolled back if ctx is cancelled
+// before it is committed.
+func BeginTx(ctx context.Context, d *sql.DB) (*sql.Tx, error) {
+	t, err := d.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Contexts that are never cancelled carry no request, and would
+	// never release the entry
+	if ctx.Done() != nil {
+		txStates.Store(t, txState{ctx: ctx, pool: d})
+		context.AfterFunc(ctx, func() { txStates.Delete(t) })
+	}
+	return t, nil
+}
+
+// Context for log lines concerning t
+func txContext(t *sql.Tx) context.Context {
+	if s, ok := txStates.Load(t); ok {
+		return s.(txState).ctx
+	}
+	return context.Background()
+}
+
+// The pool t was begun on, if known
+func txPool(t *sql.Tx) *sql.DB {
+	if s, ok := txStates.Load(t); ok {
+		return s.(txState).pool
+	}
+	return nil
+}
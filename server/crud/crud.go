@@ -8,6 +8,12 @@ import (
 	"github.com/casey/govalent/server/models"
 )
 
+// Upper bound on the number of rows written by a single multi-row INSERT
+const MAX_INSERT_BATCH_ROWS = 500
+
+// SQLITE_MAX_VARIABLE_NUMBER for SQLite >= 3.32
+const MAX_SQL_VARIABLES = 32766
+
 // Generic CRUD functions
 type KeyValue struct {
 	Key   string
@@ -45,59 +51,138 @@ func UpdateTable(t *sql.Tx, table string, update []KeyValue, where []KeyValue) *
 
 	template, _ := generateUpdateSQLTemplate(table, update_cols, where_cols)
 
-	// Improvement: cache this prepared statement
-	stmt, err := t.Prepare(template)
+	stmt, err := prepareStmt(t, template)
 
 	if err != nil {
-		slog.Info(fmt.Sprintf("Error preparing statement: %s", err.Error()))
+		slog.InfoContext(txContext(t), fmt.Sprintf("Error preparing statement: %s", err.Error()))
 		return models.NewGenericServerError(err)
 	}
 	_, err = stmt.Exec(values...)
 
 	if err != nil {
-		slog.Info(fmt.Sprintf("Error executing update: %s", err.Error()))
-		return models.NewGenericServerError(err)
+		slog.InfoContext(txContext(t), fmt.Sprintf("Error executing update: %s", err.Error()))
+		return dbError(err)
 	}
 	return nil
 }
 
-func InsertEntitiesWithTemplate(t *sql.Tx, template string, entities []DBEntity) (int, *models.APIError) {
-	stmt, err := t.Prepare(template)
-	if err != nil {
-		slog.Info(fmt.Sprintf("Error preparing statement: %s", err.Error()))
-		return 0, models.NewGenericServerError(err)
+// Number of rows to insert per statement for a table with n_cols columns
+func insertBatchSize(n_cols int) int {
+	size := MAX_INSERT_BATCH_ROWS
+	if n_cols > 0 && size*n_cols > MAX_SQL_VARIABLES {
+		size = MAX_SQL_VARIABLES / n_cols
+	}
+	return size
+}
+
+// Call f on consecutive [start, end) slices of n_rows rows, each
+// small enough to fit in a single multi-row INSERT
+func forEachInsertBatch(n_rows int, n_cols int, f func(start int, end int) *models.APIError) *models.APIError {
+	batch_size := insertBatchSize(n_cols)
+	for start := 0; start < n_rows; start += batch_size {
+		end := min(start+batch_size, n_rows)
+		if err := f(start, end); err != nil {
+			return err
+		}
 	}
-	l := len(entities)
-	for i := 0; i < l; i++ {
-		_, err = stmt.Exec(entities[i].Values()...)
+	return nil
+}
+
+// Insert rows using multi-row VALUES clauses. Each row must list its
+// values in the same order as columns.
+func InsertRows(t *sql.Tx, table string, columns []string, rows [][]any) (int, *models.APIError) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	inserted := 0
+	var stmt *sql.Stmt
+	stmt_rows := 0
+	err := forEachInsertBatch(len(rows), len(columns), func(start int, end int) *models.APIError {
+		// Full batches share one statement; only the final partial
+		// batch needs a template of its own
+		if stmt == nil || end-start != stmt_rows {
+			// Closing a statement bound to t leaves any cached statement
+			// it was made from open
+			if stmt != nil {
+				stmt.Close()
+			}
+			template := generateBatchInsertTemplate(table, columns, end-start)
+			var err error
+			stmt, err = prepareStmt(t, template)
+			if err != nil {
+				slog.InfoContext(txContext(t), fmt.Sprintf("Error preparing statement: %s", err.Error()))
+				return models.NewGenericServerError(err)
+			}
+			stmt_rows = end - start
+		}
+		values := make([]any, 0, (end-start)*len(columns))
+		for i := start; i < end; i++ {
+			values = append(values, rows[i]...)
+		}
+		res, err := stmt.Exec(values...)
+		if err != nil {
+			slog.InfoContext(txContext(t), fmt.Sprintf("Error inserting rows: %s", err.Error()))
+			return dbError(err)
+		}
+		n, err := res.RowsAffected()
 		if err != nil {
-			slog.Info(fmt.Sprintf("Error inserting row: %s", err.Error()))
-			return i, models.NewGenericServerError(err)
+			return models.NewGenericServerError(err)
 		}
+		inserted += int(n)
+		return nil
+	})
+	if err != nil {
+		return inserted, err
 	}
-	slog.Debug(fmt.Sprintf("Inserted %d rows", l))
-	return l, nil
+	slog.DebugContext(txContext(t), fmt.Sprintf("Inserted %d rows into %s", inserted, table))
+	return inserted, nil
 }
 
 func InsertEntities(t *sql.Tx, table string, entities []DBEntity) (int, *models.APIError) {
 	if len(entities) > 0 {
-		template, _ := generateInsertTemplate(table, entities[0].Fields())
-		return InsertEntitiesWithTemplate(t, template, entities)
+		rows := make([][]any, len(entities))
+		for i := range entities {
+			rows[i] = entities[i].Values()
+		}
+		return InsertRows(t, table, entities[0].Fields(), rows)
 	} else {
 		return 0, nil
 	}
 }
 
-func DeleteEntities(t *sql.Tx, table string, filters Filters) *models.APIError {
+// Number of rows matching filters; Limit and Offset are ignored
+func CountEntities(t *sql.Tx, table string, filters Filters) (int, *models.APIError) {
+	filters.Limit = 0
+	template := generateCountTemplate(table, filters.RenderTemplate())
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return 0, models.NewGenericServerError(err)
+	}
+	var n int
+	err = stmt.QueryRow(filters.RenderValues()...).Scan(&n)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		return 0, models.NewGenericServerError(err)
+	}
+	return n, nil
+}
+
+// Returns the number of rows deleted
+func DeleteEntities(t *sql.Tx, table string, filters Filters) (int, *models.APIError) {
 	template := generateDeleteTemplate(table, filters.RenderTemplate())
-	stmt, err := t.Prepare(template)
+	stmt, err := prepareStmt(t, template)
 	if err != nil {
-		return models.NewGenericServerError(err)
+		return 0, models.NewGenericServerError(err)
 	}
-	_, err = stmt.Exec(filters.RenderValues()...)
+	res, err := stmt.Exec(filters.RenderValues()...)
 	if err != nil {
-		slog.Error(fmt.Sprint("Error deleting record: ", err.Error()))
-		return models.NewGenericServerError(err)
+		slog.ErrorContext(txContext(t), fmt.Sprint("Error deleting record: ", err.Error()))
+		return 0, dbError(err)
 	}
-	return nil
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, models.NewGenericServerError(err)
+	}
+	return int(n), nil
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/casey/govalent/server/db"
 	"github.com/casey/govalent/server/models"
 	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
 )
 
 func newMockDB(t *testing.T) *sql.DB {
@@ -27,6 +28,15 @@ func newMockDB(t *testing.T) *sql.DB {
 	return d
 }
 
+// Defaults and GOVALENT_* settings of the environment
+func newMockConfig(t testing.TB) common.Config {
+	c, _, err := common.LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	return c
+}
+
 func newUninitializedDB(t *testing.T) *sql.DB {
 	c := common.Config{
 		Dsn:  ":memory:",
@@ -90,6 +100,22 @@ func newMockEdge(source int, target int, name string, param_type string, arg_ind
 	}
 }
 
+// A linear chain of n electrons where node i+1 consumes the output of node i
+func newMockGraph(n int) models.Graph {
+	nodes := make([]models.ElectronSchema, n)
+	edges := make([]models.Edge, 0, n)
+	for i := 0; i < n; i++ {
+		assets := models.ElectronAssets{
+			Function: models.AssetDetails{Size: 10, Digest: "abc", DigestAlg: "md5"},
+		}
+		nodes[i] = newMockElectron(i, newMockElectronMeta(i, "NEW_OBJECT"), assets)
+		if i > 0 {
+			edges = append(edges, newMockEdge(i-1, i, "x", "arg", 0))
+		}
+	}
+	return models.Graph{Nodes: nodes, Links: edges}
+}
+
 func TestCreateDispatchMetadata(t *testing.T) {
 
 	dispatch := newMockDispatch(nil, nil)
@@ -170,6 +196,135 @@ func TestBulkGetDispatches(t *testing.T) {
 
 }
 
+func TestFiltersRender(t *testing.T) {
+	f := Filters{}
+	(&f).AddEq("a", 1)
+	(&f).AddLike("b", "x%")
+	(&f).AddIn("c", []any{"p", "q"})
+	(&f).AddRange("d", 2, nil)
+	alt_1, alt_2 := Filters{}, Filters{}
+	(&alt_1).AddEq("e", 3)
+	(&alt_2).AddEq("f", 4)
+	(&alt_2).AddRange("g", nil, 5)
+	(&f).AddOr(alt_1, alt_2)
+	f.Limit = 10
+	f.Offset = 20
+
+	expected := `WHERE a = ? AND b LIKE ? ESCAPE '\' AND c IN (?, ?) AND d >= ? AND ((e = ?) OR (f = ? AND g <= ?))`
+	assert.Equal(t, expected, f.RenderTemplate())
+	assert.Equal(t, []any{1, "x%", "p", "q", 2, 3, 4, 5, 10, 20}, f.RenderValues())
+
+	empty := Filters{}
+	assert.Equal(t, "", empty.RenderTemplate())
+	assert.Equal(t, `50\%\_off`, EscapeLike("50%_off"))
+}
+
+// Pages of a listing share one cached statement
+func TestSelectJoinTemplateLimits(t *testing.T) {
+	joins := []JoinCondition{{LeftTable: "a", LeftCol: "b_id", RightTable: "b", RightCol: "id"}}
+	template := generateSelectJoinTemplate("a", []string{"a.x"}, joins, "WHERE a.y = ?", "a.x", true, true)
+	assert.Equal(t, "SELECT a.x FROM a JOIN b ON a.b_id = b.id  WHERE a.y = ? ORDER BY a.x ASC LIMIT ? OFFSET ?", template)
+	template = generateSelectJoinTemplate("a", []string{"a.x"}, joins, "WHERE a.y = ?", "a.x", true, false)
+	assert.NotContains(t, template, "LIMIT")
+}
+
+func TestSearchDispatches(t *testing.T) {
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	defer tx.Rollback()
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	statuses := []string{"RUNNING", "COMPLETED", "FAILED", "COMPLETED"}
+	names := []string{"alpha_wf", "beta", "alpha%", "gamma"}
+	ids := make([]string, len(statuses))
+	for i := range statuses {
+		dispatch := newMockDispatch(nil, nil)
+		dispatch.Metadata.Status = statuses[i]
+		dispatch.Metadata.RootDispatchId = dispatch.Metadata.DispatchId
+		dispatch.Metadata.CreatedAt = base.Add(time.Duration(i) * time.Hour)
+		dispatch.Lattice.Metadata.Name = names[i]
+		if i == 3 {
+			dispatch.Lattice.Metadata.Executor = "dask"
+		}
+		if i == 2 {
+			dispatch.Lattice.Metadata.WorkflowExecutor = "dask"
+		}
+		dispatch.Metadata.Owner = "alice"
+		if i%2 == 1 {
+			dispatch.Metadata.Owner = "bob"
+		}
+		if err := CreateDispatchMetadata(tx, &dispatch.Metadata, &dispatch.Lattice.Metadata); err != nil {
+			t.Fatalf("Error creating dispatch: %v", err)
+		}
+		ids[i] = dispatch.Metadata.DispatchId
+	}
+	search := func(q DispatchQuery) models.GetBulkDispatchesResponse {
+		if q.Count == 0 {
+			q.Count = 10
+		}
+		resp, err := SearchDispatches(tx, q)
+		if err != nil {
+			t.Fatalf("Error searching dispatches: %v", err)
+		}
+		return resp
+	}
+	record_ids := func(resp models.GetBulkDispatchesResponse) []string {
+		res := make([]string, len(resp.Records))
+		for i, item := range resp.Records {
+			res[i] = item.DispatchId
+		}
+		return res
+	}
+
+	// Newest first by default
+	resp := search(DispatchQuery{Count: 2})
+	assert.Equal(t, 4, resp.Total)
+	assert.Equal(t, []string{ids[3], ids[2]}, record_ids(resp))
+
+	resp = search(DispatchQuery{Statuses: []string{"COMPLETED", "FAILED"}, Ascending: true})
+	assert.Equal(t, 3, resp.Total)
+	assert.Equal(t, []string{ids[1], ids[2], ids[3]}, record_ids(resp))
+
+	// Wildcards in the name are matched literally
+	resp = search(DispatchQuery{Name: "alpha"})
+	assert.Equal(t, 2, resp.Total)
+	resp = search(DispatchQuery{Name: "a%"})
+	assert.Equal(t, []string{ids[2]}, record_ids(resp))
+
+	resp = search(DispatchQuery{Executor: "dask", Ascending: true})
+	assert.Equal(t, []string{ids[2], ids[3]}, record_ids(resp))
+
+	after := base.Add(time.Hour)
+	before := base.Add(2 * time.Hour)
+	resp = search(DispatchQuery{CreatedAfter: &after, CreatedBefore: &before, Ascending: true})
+	assert.Equal(t, []string{ids[1], ids[2]}, record_ids(resp))
+
+	resp = search(DispatchQuery{RootDispatchId: ids[0]})
+	assert.Equal(t, []string{ids[0]}, record_ids(resp))
+
+	resp = search(DispatchQuery{Owner: "bob", Ascending: true})
+	assert.Equal(t, []string{ids[1], ids[3]}, record_ids(resp))
+	assert.Equal(t, "bob", resp.Records[0].Owner)
+	owner, err := GetDispatchOwner(tx, "", ids[2])
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", owner)
+	_, err = GetDispatchOwner(tx, "", "missing")
+	assert.Equal(t, 404, err.StatusCode)
+
+	resp = search(DispatchQuery{SortKey: "name", Ascending: true, Page: 1, Count: 2})
+	assert.Equal(t, 4, resp.Total)
+	assert.Equal(t, []string{ids[1], ids[3]}, record_ids(resp))
+
+	_, err = SearchDispatches(tx, DispatchQuery{SortKey: "id", Count: 10})
+	if err == nil {
+		t.Fatal("Expected unknown sort key to be rejected")
+	}
+	assert.Equal(t, 422, err.StatusCode)
+}
+
 func TestUpdateDispatch(t *testing.T) {
 	dispatch := newMockDispatch(nil, nil)
 
@@ -183,7 +338,7 @@ func TestUpdateDispatch(t *testing.T) {
 		t.Fatalf("Error creating dispatch: %v", err)
 	}
 
-	err = UpdateDispatch(tx, dispatch.Metadata.DispatchId, "COMPLETED", "", "")
+	_, err = UpdateDispatch(tx, dispatch.Metadata.DispatchId, "COMPLETED", nil, nil)
 	if err != nil {
 		t.Fatalf("Error updating dispatch: %v", err)
 	}
@@ -207,6 +362,8 @@ func TestUpdateDispatch(t *testing.T) {
 
 func TestDeleteDispatch(t *testing.T) {
 	dispatch := newMockDispatch(nil, nil)
+	// Only root dispatches can be deleted
+	dispatch.Metadata.RootDispatchId = dispatch.Metadata.DispatchId
 
 	d := newMockDB(t)
 	tx, db_err := d.Begin()
@@ -223,5 +380,48 @@ func TestDeleteDispatch(t *testing.T) {
 		tx.Rollback()
 		t.Fatal("Error deleting dispatch: ", err.Error())
 	}
+
+	err = DeleteDispatch(tx, dispatch.Metadata.DispatchId)
+	if err == nil {
+		tx.Rollback()
+		t.Fatal("Expected deleting a missing dispatch to fail")
+	}
+	assert.Equal(t, 404, err.StatusCode)
+	assert.Equal(t, models.ERROR_CODE_NOT_FOUND, err.ErrorCode())
 	tx.Rollback()
 }
+
+func TestDispatchErrorCodes(t *testing.T) {
+	dispatch := newMockDispatch(nil, nil)
+
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	defer tx.Rollback()
+
+	_, err := GetDispatch(nil, tx, dispatch.Metadata.DispatchId, false)
+	if err == nil {
+		t.Fatal("Expected missing dispatch to be reported")
+	}
+	assert.Equal(t, 404, err.StatusCode)
+	assert.Equal(t, models.ERROR_CODE_NOT_FOUND, err.ErrorCode())
+
+	_, err = GetElectronMetadata(tx, dispatch.Metadata.DispatchId, 0)
+	if err == nil {
+		t.Fatal("Expected missing electron to be reported")
+	}
+	assert.Equal(t, 404, err.StatusCode)
+
+	err = CreateDispatchMetadata(tx, &dispatch.Metadata, &dispatch.Lattice.Metadata)
+	if err != nil {
+		t.Fatalf("Error creating dispatch: %v", err)
+	}
+	err = CreateDispatchMetadata(tx, &dispatch.Metadata, &dispatch.Lattice.Metadata)
+	if err == nil {
+		t.Fatal("Expected duplicate dispatch to be rejected")
+	}
+	assert.Equal(t, 409, err.StatusCode)
+	assert.Equal(t, models.ERROR_CODE_CONFLICT, err.ErrorCode())
+}
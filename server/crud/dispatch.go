@@ -1,9 +1,11 @@
 package crud
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/casey/govalent/server/common"
 	"github.com/casey/govalent/server/db"
@@ -16,6 +18,8 @@ FROM dispatches
 WHERE dispatch_id = ?
 `
 
+const ERROR_DETAIL_UNKNOWN_SORT_KEY = "Unknown sort key"
+
 var DISPATCH_ENTITY_KEYS = []string{
 	db.DISPATCH_TABLE_ID,
 	db.DISPATCH_TABLE_ROOT_ID,
@@ -31,6 +35,8 @@ var DISPATCH_ENTITY_KEYS = []string{
 	db.DISPATCH_TABLE_END_TIME,
 	db.DISPATCH_TABLE_CREATED_AT,
 	db.DISPATCH_TABLE_UPDATED_AT,
+	db.DISPATCH_TABLE_OWNER,
+	db.DISPATCH_TABLE_NAMESPACE,
 }
 
 type DispatchEntity struct {
@@ -58,6 +64,8 @@ func (m *DispatchEntity) Values() []any {
 		m.d.EndTime,
 		m.d.CreatedAt,
 		m.d.UpdatedAt,
+		m.d.Owner,
+		m.d.Namespace,
 	}
 }
 
@@ -77,6 +85,8 @@ func (m *DispatchEntity) Fieldrefs() []any {
 		&(m.d.EndTime),
 		&(m.d.CreatedAt),
 		&(m.d.UpdatedAt),
+		&(m.d.Owner),
+		&(m.d.Namespace),
 	}
 }
 
@@ -93,14 +103,14 @@ func GetDispatchEntities(t *sql.Tx, f Filters, sort_key string, ascending bool)
 		ascending,
 		f.Limit > 0,
 	)
-	stmt, err := t.Prepare(template)
+	stmt, err := prepareStmt(t, template)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
 		return nil, models.NewGenericServerError(err)
 	}
 	rows, err := stmt.Query((&f).RenderValues()...)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
 		return nil, models.NewGenericServerError(err)
 	}
 	res := make([]DispatchEntity, 0)
@@ -108,7 +118,7 @@ func GetDispatchEntities(t *sql.Tx, f Filters, sort_key string, ascending bool)
 		e := DispatchEntity{d: &models.DispatchMeta{}, l: &models.LatticeMeta{}}
 		err := rows.Scan((&e).Fieldrefs()...)
 		if err != nil {
-			slog.Error(fmt.Sprintf("Error querying row: %s\n", err.Error()))
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
 			return nil, models.NewGenericServerError(err)
 		}
 		res = append(res, e)
@@ -120,7 +130,7 @@ func GetDispatchEntities(t *sql.Tx, f Filters, sort_key string, ascending bool)
 
 // Register assets with workflow scope
 // This will write to the assets and dispatch_assets tables
-func createDispatchAssets(c *common.Config, t *sql.Tx, m *models.DispatchSchema) *models.APIError {
+func createDispatchAssets(ctx context.Context, c *common.Config, t *sql.Tx, m *models.DispatchSchema) *models.APIError {
 
 	// Dynamic assets (result, error)
 
@@ -148,7 +158,7 @@ func createDispatchAssets(c *common.Config, t *sql.Tx, m *models.DispatchSchema)
 		count += 1
 	}
 
-	ents, api_err := CreateAssets(c, t, asset_schemas)
+	ents, api_err := CreateAssets(ctx, c, t, m.Metadata.Namespace, asset_schemas)
 	if api_err != nil {
 		return api_err
 	}
@@ -186,7 +196,7 @@ func createDispatchAssets(c *common.Config, t *sql.Tx, m *models.DispatchSchema)
 		dispatch_links[count].node_id = -1
 		count += 1
 	}
-	ents, api_err = CreateAssets(c, t, asset_schemas)
+	ents, api_err = CreateAssets(ctx, c, t, m.Metadata.Namespace, asset_schemas)
 	if api_err != nil {
 		return api_err
 	}
@@ -207,25 +217,131 @@ func createDispatchAssets(c *common.Config, t *sql.Tx, m *models.DispatchSchema)
 	return nil
 }
 
+// Dispatch ids are unique across namespaces. An empty namespace is
+// DEFAULT_NAMESPACE.
 func CreateDispatchMetadata(t *sql.Tx, d *models.DispatchMeta, l *models.LatticeMeta) *models.APIError {
 	if d != nil && l != nil {
+		if len(d.Namespace) == 0 {
+			d.Namespace = models.DEFAULT_NAMESPACE
+		}
 		entity := DispatchEntity{d: d, l: l}
-		_, err := InsertEntities(t, "dispatches", []DBEntity{&entity})
-		return err
+		n, err := InsertEntities(t, "dispatches", []DBEntity{&entity})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return models.NewConflictError(fmt.Errorf("Dispatch %s already exists", d.DispatchId))
+		}
+		if err := ensureNamespace(t, d.Namespace); err != nil {
+			return err
+		}
+		return checkNamespaceQuota(t, d.Namespace)
 	}
 	return nil
 }
 
-func GetDispatchSummaries(t *sql.Tx, dispatch_id string, page int, count int) (models.GetBulkDispatchesResponse, *models.APIError) {
-	filters := Filters{}
-	// TODO: don't retrieve the whole record
+// Public sort keys accepted by SearchDispatches, mapped to columns
+var DISPATCH_SORT_KEYS = map[string]string{
+	"created_at": db.DISPATCH_TABLE_CREATED_AT,
+	"updated_at": db.DISPATCH_TABLE_UPDATED_AT,
+	"start_time": db.DISPATCH_TABLE_START_TIME,
+	"end_time":   db.DISPATCH_TABLE_END_TIME,
+	"name":       db.DISPATCH_TABLE_NAME,
+	"status":     db.DISPATCH_TABLE_STATUS,
+}
+
+// Criteria for SearchDispatches; zero values are not filtered on
+type DispatchQuery struct {
+	DispatchId     string
+	RootDispatchId string
+	// Any of
+	Statuses []string
+	// Substring of the workflow name
+	Name string
+	// Matches either the lattice or the workflow executor
+	Executor string
+	// Exact match; leave empty to search the dispatches of all users
+	Owner string
+	// Leave empty to search every namespace
+	Namespace string
+
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	StartedAfter  *time.Time
+	StartedBefore *time.Time
+	EndedAfter    *time.Time
+	EndedBefore   *time.Time
+
+	// One of DISPATCH_SORT_KEYS; defaults to created_at
+	SortKey   string
+	Ascending bool
+	Page      int
+	Count     int
+}
 
-	if len(dispatch_id) > 0 {
-		(&filters).AddEq(db.DISPATCH_TABLE_ID, dispatch_id)
+func (q *DispatchQuery) filters() Filters {
+	f := Filters{}
+	if len(q.DispatchId) > 0 {
+		(&f).AddEq(db.DISPATCH_TABLE_ID, q.DispatchId)
 	}
-	filters.Limit = count
-	filters.Offset = page * count
-	ents, err := GetDispatchEntities(t, filters, db.DISPATCH_TABLE_CREATED_AT, false)
+	if len(q.RootDispatchId) > 0 {
+		(&f).AddEq(db.DISPATCH_TABLE_ROOT_ID, q.RootDispatchId)
+	}
+	if len(q.Owner) > 0 {
+		(&f).AddEq(db.DISPATCH_TABLE_OWNER, q.Owner)
+	}
+	if len(q.Namespace) > 0 {
+		(&f).AddEq(db.DISPATCH_TABLE_NAMESPACE, q.Namespace)
+	}
+	if len(q.Name) > 0 {
+		(&f).AddLike(db.DISPATCH_TABLE_NAME, fmt.Sprintf("%%%s%%", EscapeLike(q.Name)))
+	}
+	if len(q.Statuses) > 0 {
+		statuses := make([]any, len(q.Statuses))
+		for i, s := range q.Statuses {
+			statuses[i] = s
+		}
+		(&f).AddIn(db.DISPATCH_TABLE_STATUS, statuses)
+	}
+	if len(q.Executor) > 0 {
+		lattice, workflow := Filters{}, Filters{}
+		(&lattice).AddEq(db.DISPATCH_TABLE_EXECUTOR, q.Executor)
+		(&workflow).AddEq(db.DISPATCH_TABLE_WORKFLOW_EXECUTOR, q.Executor)
+		(&f).AddOr(lattice, workflow)
+	}
+	if q.CreatedAfter != nil || q.CreatedBefore != nil {
+		(&f).AddTimeRange(db.DISPATCH_TABLE_CREATED_AT, q.CreatedAfter, q.CreatedBefore)
+	}
+	if q.StartedAfter != nil || q.StartedBefore != nil {
+		(&f).AddTimeRange(db.DISPATCH_TABLE_START_TIME, q.StartedAfter, q.StartedBefore)
+	}
+	if q.EndedAfter != nil || q.EndedBefore != nil {
+		(&f).AddTimeRange(db.DISPATCH_TABLE_END_TIME, q.EndedAfter, q.EndedBefore)
+	}
+	return f
+}
+
+// Returns one page of matching dispatches along with the total number
+// of matches
+func SearchDispatches(t *sql.Tx, q DispatchQuery) (models.GetBulkDispatchesResponse, *models.APIError) {
+	// TODO: don't retrieve the whole record
+	sort_key := db.DISPATCH_TABLE_CREATED_AT
+	if len(q.SortKey) > 0 {
+		col, ok := DISPATCH_SORT_KEYS[q.SortKey]
+		if !ok {
+			detail := models.NewSingleValidationError("query", "sort", ERROR_DETAIL_UNKNOWN_SORT_KEY)
+			return models.GetBulkDispatchesResponse{}, models.NewValidationError(detail)
+		}
+		sort_key = col
+	}
+	filters := q.filters()
+	total, err := CountEntities(t, db.DISPATCH_TABLE, filters)
+	if err != nil {
+		return models.GetBulkDispatchesResponse{}, err
+	}
+	filters.Limit = q.Count
+	filters.Offset = q.Page * q.Count
+	ents, err := GetDispatchEntities(t, filters, sort_key, q.Ascending)
 	if err != nil {
 		return models.GetBulkDispatchesResponse{}, err
 	}
@@ -234,7 +350,11 @@ func GetDispatchSummaries(t *sql.Tx, dispatch_id string, page int, count int) (m
 		d_meta[i] = *ents[i].d
 	}
 
-	return models.GetBulkDispatchesResponse{Records: d_meta}, nil
+	return models.GetBulkDispatchesResponse{Records: d_meta, Total: total}, nil
+}
+
+func GetDispatchSummaries(t *sql.Tx, dispatch_id string, page int, count int) (models.GetBulkDispatchesResponse, *models.APIError) {
+	return SearchDispatches(t, DispatchQuery{DispatchId: dispatch_id, Page: page, Count: count})
 }
 
 func getDispatchEntity(t *sql.Tx, dispatch_id string) (DispatchEntity, *models.APIError) {
@@ -245,17 +365,93 @@ func getDispatchEntity(t *sql.Tx, dispatch_id string) (DispatchEntity, *models.A
 		return DispatchEntity{}, err
 	}
 	if len(ents) == 0 {
-		return DispatchEntity{}, models.NewGenericClientError(fmt.Sprintf("Dispatch %s not found\n", dispatch_id))
+		return DispatchEntity{}, models.NewNotFoundError(fmt.Errorf("Dispatch %s not found", dispatch_id))
 	}
 	return ents[0], nil
 }
 
+// The user who submitted the dispatch. Dispatches outside namespace
+// are not found unless namespace is empty.
+func GetDispatchOwner(t *sql.Tx, namespace string, dispatch_id string) (string, *models.APIError) {
+	f := Filters{}
+	(&f).AddEq(db.DISPATCH_TABLE_ID, dispatch_id)
+	if len(namespace) > 0 {
+		(&f).AddEq(db.DISPATCH_TABLE_NAMESPACE, namespace)
+	}
+	template := generateSelectTemplate(db.DISPATCH_TABLE, []string{db.DISPATCH_TABLE_OWNER}, (&f).RenderTemplate(), db.DISPATCH_TABLE_ID, true, false)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return "", models.NewGenericServerError(err)
+	}
+	var owner string
+	err = stmt.QueryRow((&f).RenderValues()...).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return "", models.NewNotFoundError(fmt.Errorf("Dispatch %s not found", dispatch_id))
+	}
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
+		return "", models.NewGenericServerError(err)
+	}
+	return owner, nil
+}
+
+// The owner and namespace of a dispatch, whichever namespace it is in
+func getDispatchOwnerAndNamespace(t *sql.Tx, dispatch_id string) (string, string, *models.APIError) {
+	f := Filters{}
+	(&f).AddEq(db.DISPATCH_TABLE_ID, dispatch_id)
+	columns := []string{db.DISPATCH_TABLE_OWNER, db.DISPATCH_TABLE_NAMESPACE}
+	template := generateSelectTemplate(db.DISPATCH_TABLE, columns, (&f).RenderTemplate(), db.DISPATCH_TABLE_ID, true, false)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return "", "", models.NewGenericServerError(err)
+	}
+	var owner, namespace string
+	err = stmt.QueryRow((&f).RenderValues()...).Scan(&owner, &namespace)
+	if err == sql.ErrNoRows {
+		return "", "", models.NewNotFoundError(fmt.Errorf("Dispatch %s not found", dispatch_id))
+	}
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
+		return "", "", models.NewGenericServerError(err)
+	}
+	return owner, namespace, nil
+}
+
+func GetDispatchStatus(t *sql.Tx, dispatch_id string) (string, *models.APIError) {
+	f := Filters{}
+	(&f).AddEq(db.DISPATCH_TABLE_ID, dispatch_id)
+	template := generateSelectTemplate(db.DISPATCH_TABLE, []string{db.DISPATCH_TABLE_STATUS}, (&f).RenderTemplate(), db.DISPATCH_TABLE_ID, true, false)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return "", models.NewGenericServerError(err)
+	}
+	var status string
+	err = stmt.QueryRow((&f).RenderValues()...).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", models.NewNotFoundError(fmt.Errorf("Dispatch %s not found", dispatch_id))
+	}
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
+		return "", models.NewGenericServerError(err)
+	}
+	return status, nil
+}
+
 func GetDispatch(c *common.Config, t *sql.Tx, dispatch_id string, load_assets bool) (models.DispatchSchema, *models.APIError) {
 	d := models.DispatchSchema{}
 	ent, err := getDispatchEntity(t, dispatch_id)
 	if err != nil {
 		return models.DispatchSchema{}, err
 	}
+	d.Metadata = *ent.d
+	d.Lattice.Metadata = *ent.l
+	if !load_assets {
+		return d, nil
+	}
+
 	asset_links, err := GetDispatchAssets(c, t, dispatch_id)
 	if err != nil {
 		return models.DispatchSchema{}, err
@@ -280,28 +476,40 @@ func GetDispatch(c *common.Config, t *sql.Tx, dispatch_id string, load_assets bo
 		}
 	}
 
-	d.Metadata = *ent.d
-	d.Lattice.Metadata = *ent.l
-
 	return d, nil
 }
 
-func UpdateDispatch(t *sql.Tx, dispatch_id string, status string, start_time string, end_time string) *models.APIError {
+// Returns the recorded status event
+func UpdateDispatch(t *sql.Tx, dispatch_id string, status string, start_time *time.Time, end_time *time.Time) (models.StatusEvent, *models.APIError) {
 	where := []KeyValue{{Key: db.DISPATCH_TABLE_ID, Value: dispatch_id}}
 	update := []KeyValue{{Key: db.DISPATCH_TABLE_STATUS, Value: status}}
-	if len(start_time) > 0 {
-		update = append(update, KeyValue{Key: "start_time", Value: start_time})
+	if start_time != nil {
+		update = append(update, KeyValue{Key: db.DISPATCH_TABLE_START_TIME, Value: *start_time})
 	}
-	if len(end_time) > 0 {
-		update = append(update, KeyValue{Key: "end_time", Value: end_time})
-
+	if end_time != nil {
+		update = append(update, KeyValue{Key: db.DISPATCH_TABLE_END_TIME, Value: *end_time})
+	}
+	update = append(update, KeyValue{Key: db.DISPATCH_TABLE_UPDATED_AT, Value: time.Now().UTC()})
+	if err := UpdateTable(t, db.DISPATCH_TABLE, update, where); err != nil {
+		return models.StatusEvent{}, err
 	}
-	return UpdateTable(t, db.DISPATCH_TABLE, update, where)
+	ev := models.NewDispatchStatusEvent(dispatch_id, status, start_time, end_time)
+	if err := AppendEvent(t, &ev); err != nil {
+		return models.StatusEvent{}, err
+	}
+	return ev, nil
 }
 
 func DeleteDispatch(t *sql.Tx, dispatch_id string) *models.APIError {
 	f := Filters{}
 	(&f).AddEq(db.DISPATCH_TABLE_ID, dispatch_id)
 	(&f).AddEq(db.DISPATCH_TABLE_ROOT_ID, dispatch_id)
-	return DeleteEntities(t, db.DISPATCH_TABLE, f)
+	n, err := DeleteEntities(t, db.DISPATCH_TABLE, f)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.NewNotFoundError(fmt.Errorf("Dispatch %s not found", dispatch_id))
+	}
+	return nil
 }
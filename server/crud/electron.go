@@ -1,6 +1,7 @@
 package crud
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log/slog"
@@ -84,14 +85,14 @@ func GetElectronEntities(t *sql.Tx, f Filters, sort_key string, ascending bool)
 		ascending,
 		f.Limit > 0,
 	)
-	stmt, err := t.Prepare(template)
+	stmt, err := prepareStmt(t, template)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
 		return nil, models.NewGenericServerError(err)
 	}
 	rows, err := stmt.Query((&f).RenderValues()...)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
 		return nil, models.NewGenericServerError(err)
 	}
 	res := make([]ElectronEntity, 0)
@@ -99,7 +100,7 @@ func GetElectronEntities(t *sql.Tx, f Filters, sort_key string, ascending bool)
 		e := ElectronEntity{meta: &models.ElectronMeta{}}
 		err := rows.Scan((&e).Fieldrefs()...)
 		if err != nil {
-			slog.Error(fmt.Sprintf("Error querying row: %s\n", err.Error()))
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
 			return nil, models.NewGenericServerError(err)
 		}
 		res = append(res, e)
@@ -146,33 +147,45 @@ func CreateElectronMetadata(t *sql.Tx, dispatch_id string, node_id int, metadata
 	return nil
 }
 
+func createElectrons(t *sql.Tx, dispatch_id string, nodes []models.ElectronSchema) (int, *models.APIError) {
+	ents := make([]DBEntity, len(nodes))
+	for i := range nodes {
+		ent := newElectronEntity(dispatch_id, nodes[i].NodeId, &nodes[i].Metadata)
+		ents[i] = &ent
+	}
+	return InsertEntities(t, db.ELECTRON_TABLE, ents)
+}
+
+// Register the assets of every electron in nodes and link them to
+// their electrons
 func createElectronAssets(
+	ctx context.Context,
 	c *common.Config,
 	t *sql.Tx,
+	namespace string,
 	dispatch_id string,
-	e *models.ElectronSchema,
+	nodes []models.ElectronSchema,
 ) *models.APIError {
-	attrs := e.Assets.AttrsByName()
-	asset_schemas := make([]models.AssetPublicSchema, len(attrs))
-	asset_links := make([]AssetLink, len(attrs))
-	count := 0
+	n_assets := len((&models.ElectronAssets{}).AttrsByName())
+	asset_schemas := make([]models.AssetPublicSchema, 0, n_assets*len(nodes))
+	asset_links := make([]AssetLink, 0, n_assets*len(nodes))
 	key_count_map := make(map[string]int)
 	attrs_by_key := make(map[string]*models.AssetDetails)
-	for name, details := range attrs {
-		key := fmt.Sprintf("%s/node_%d/%s", dispatch_id, e.NodeId, name)
-		key_count_map[key] = count
-		attrs_by_key[key] = attrs[name]
-		asset_schemas[count].Key = key
-		asset_schemas[count].AssetDetails = *details
-		asset_links[count].dispatch_id = dispatch_id
-		asset_links[count].node_id = e.NodeId
-		asset_links[count].Name = name
-		count += 1
+	for i := range nodes {
+		e := &nodes[i]
+		attrs := e.Assets.AttrsByName()
+		for name, details := range attrs {
+			key := fmt.Sprintf("%s/node_%d/%s", dispatch_id, e.NodeId, name)
+			key_count_map[key] = len(asset_schemas)
+			attrs_by_key[key] = attrs[name]
+			asset_schemas = append(asset_schemas, models.AssetPublicSchema{Key: key, AssetDetails: *details})
+			asset_links = append(asset_links, AssetLink{dispatch_id: dispatch_id, node_id: e.NodeId, Name: name})
+		}
 	}
 
 	// asset_schemas are inputs to the asset creation endpoint
 	// ents have remote_uri populated
-	ents, api_err := CreateAssets(c, t, asset_schemas)
+	ents, api_err := CreateAssets(ctx, c, t, namespace, asset_schemas)
 	if api_err != nil {
 		return api_err
 	}
@@ -201,49 +214,44 @@ func GetElectronMetadata(t *sql.Tx, dispatch_id string, node_id int) (models.Ele
 		return models.ElectronMeta{}, err
 	}
 	if len(ents) == 0 {
-		return models.ElectronMeta{}, models.NewNotFoundError(db.ERR_NOT_FOUND)
+		return models.ElectronMeta{}, models.NewNotFoundError(fmt.Errorf("Electron %d of dispatch %s not found", node_id, dispatch_id))
 	}
 	return *ents[0].meta, nil
 }
 
-func getAllElectronMeta(t *sql.Tx, dispatch_id string) ([]models.ElectronMeta, *models.APIError) {
-
+func GetAllElectrons(c *common.Config, t *sql.Tx, dispatch_id string, load_assets bool) ([]models.ElectronSchema, *models.APIError) {
 	filters := Filters{}
 	(&filters).AddEq(db.ELECTRON_TABLE_DISPATCH_ID, dispatch_id)
+
+	// These will be sorted by node_id
 	ents, err := GetElectronEntities(t, filters, db.ELECTRON_TABLE_NODE_ID, true)
 	if err != nil {
-		return nil, err
+		return []models.ElectronSchema{}, err
 	}
-	results := make([]models.ElectronMeta, len(ents))
-	for i := range results {
-		results[i] = *ents[i].meta
+	electrons := make([]models.ElectronSchema, len(ents))
+	for i := range ents {
+		electrons[i].NodeId = ents[i].node_id
+		electrons[i].Metadata = *ents[i].meta
+	}
+	if !load_assets {
+		return electrons, nil
 	}
 
-	return results, nil
-}
-
-func GetAllElectrons(c *common.Config, t *sql.Tx, dispatch_id string, load_assets bool) ([]models.ElectronSchema, *models.APIError) {
-	// TODO: load assets
-	//
-	// These will be sorted by node_id
-	meta_list, err := getAllElectronMeta(t, dispatch_id)
+	// Retrieve the asset links for all nodes at once
+	assets_by_node, err := GetAllElectronAssets(c, t, dispatch_id)
 	if err != nil {
-		return []models.ElectronSchema{}, err
+		return nil, err
 	}
-	electrons := make([]models.ElectronSchema, len(meta_list))
-	for i, item := range meta_list {
-		electrons[i].NodeId = i
-		electrons[i].Metadata = item
+	populateElectronAssets(c, electrons, assets_by_node)
+	return electrons, nil
+}
 
-		// TODO: condition this on load_assets
-		asset_entities, err := GetElectronAssets(c, t, dispatch_id, i)
-		if err != nil {
-			return nil, err
-		}
+func populateElectronAssets(c *common.Config, electrons []models.ElectronSchema, assets_by_node map[int][]NamedAssetEntity) {
+	for i := range electrons {
 		asset_refs_by_name := (&electrons[i].Assets).AttrsByName()
 		asset_details_by_name := make(map[string]*models.AssetPublicSchema)
 		// convert [{name, AssetEntity}] into map {name -> AssetEntity}
-		for _, row := range asset_entities {
+		for _, row := range assets_by_node[electrons[i].NodeId] {
 			asset_details_by_name[row.Name] = (&row.Asset).GetPublicEntity(c)
 		}
 
@@ -255,8 +263,112 @@ func GetAllElectrons(c *common.Config, t *sql.Tx, dispatch_id string, load_asset
 			}
 		}
 	}
-	// retrieve assets
-	return electrons, nil
+}
+
+// Public sort keys accepted by SearchElectrons, mapped to columns
+var ELECTRON_SORT_KEYS = map[string]string{
+	"node_id":       db.ELECTRON_TABLE_NODE_ID,
+	"task_group_id": db.ELECTRON_TABLE_GID,
+	"status":        db.ELECTRON_TABLE_STATUS,
+	"start_time":    db.ELECTRON_TABLE_START_TIME,
+	"end_time":      db.ELECTRON_TABLE_END_TIME,
+}
+
+// Criteria for SearchElectrons; zero values are not filtered on
+type ElectronQuery struct {
+	// Any of
+	Statuses    []string
+	TaskGroupId *int
+	Executor    string
+
+	// One of ELECTRON_SORT_KEYS; defaults to node_id
+	SortKey   string
+	Ascending bool
+	Page      int
+	Count     int
+
+	// Whether to include asset details
+	LoadAssets bool
+}
+
+func (q *ElectronQuery) filters(dispatch_id string) Filters {
+	f := Filters{}
+	(&f).AddEq(db.ELECTRON_TABLE_DISPATCH_ID, dispatch_id)
+	if len(q.Statuses) > 0 {
+		statuses := make([]any, len(q.Statuses))
+		for i, s := range q.Statuses {
+			statuses[i] = s
+		}
+		(&f).AddIn(db.ELECTRON_TABLE_STATUS, statuses)
+	}
+	if q.TaskGroupId != nil {
+		(&f).AddEq(db.ELECTRON_TABLE_GID, *q.TaskGroupId)
+	}
+	if len(q.Executor) > 0 {
+		(&f).AddEq(db.ELECTRON_TABLE_EXECUTOR, q.Executor)
+	}
+	return f
+}
+
+// Returns one page of matching electrons of a dispatch along with the
+// total number of matches. Only the assets of the returned page are
+// loaded.
+func SearchElectrons(c *common.Config, t *sql.Tx, dispatch_id string, q ElectronQuery) (models.GetBulkElectronsResponse, *models.APIError) {
+	sort_key := db.ELECTRON_TABLE_NODE_ID
+	if len(q.SortKey) > 0 {
+		col, ok := ELECTRON_SORT_KEYS[q.SortKey]
+		if !ok {
+			detail := models.NewSingleValidationError("query", "sort", ERROR_DETAIL_UNKNOWN_SORT_KEY)
+			return models.GetBulkElectronsResponse{}, models.NewValidationError(detail)
+		}
+		sort_key = col
+	}
+	if _, err := getDispatchEntity(t, dispatch_id); err != nil {
+		return models.GetBulkElectronsResponse{}, err
+	}
+
+	filters := q.filters(dispatch_id)
+	total, err := CountEntities(t, db.ELECTRON_TABLE, filters)
+	if err != nil {
+		return models.GetBulkElectronsResponse{}, err
+	}
+	filters.Limit = q.Count
+	filters.Offset = q.Page * q.Count
+	ents, err := GetElectronEntities(t, filters, sort_key, q.Ascending)
+	if err != nil {
+		return models.GetBulkElectronsResponse{}, err
+	}
+	electrons := make([]models.ElectronSchema, len(ents))
+	node_ids := make([]int, len(ents))
+	for i := range ents {
+		electrons[i].NodeId = ents[i].node_id
+		electrons[i].Metadata = *ents[i].meta
+		node_ids[i] = ents[i].node_id
+	}
+	if q.LoadAssets && len(electrons) > 0 {
+		assets_by_node, err := GetElectronAssetsByNode(c, t, dispatch_id, node_ids)
+		if err != nil {
+			return models.GetBulkElectronsResponse{}, err
+		}
+		populateElectronAssets(c, electrons, assets_by_node)
+	}
+	return models.GetBulkElectronsResponse{Records: electrons, Total: total}, nil
+}
+
+func GetElectron(c *common.Config, t *sql.Tx, dispatch_id string, node_id int, load_assets bool) (models.ElectronSchema, *models.APIError) {
+	meta, err := GetElectronMetadata(t, dispatch_id, node_id)
+	if err != nil {
+		return models.ElectronSchema{}, err
+	}
+	electrons := []models.ElectronSchema{{NodeId: node_id, Metadata: meta}}
+	if load_assets {
+		assets_by_node, err := GetElectronAssetsByNode(c, t, dispatch_id, []int{node_id})
+		if err != nil {
+			return models.ElectronSchema{}, err
+		}
+		populateElectronAssets(c, electrons, assets_by_node)
+	}
+	return electrons[0], nil
 }
 
 func CanUpdateElectronStatus(db *sql.DB, dispatch_id string, node_id int, update *models.ElectronStatusUpdate) bool {
@@ -268,7 +380,8 @@ func CanUpdateElectronStatus(db *sql.DB, dispatch_id string, node_id int, update
 	panic("Not implemented")
 }
 
-func UpdateElectronMetadata(t *sql.Tx, dispatch_id string, node_id int, update models.ElectronStatusUpdate) *models.APIError {
+// Returns the recorded status event
+func UpdateElectronMetadata(t *sql.Tx, dispatch_id string, node_id int, update models.ElectronStatusUpdate) (models.StatusEvent, *models.APIError) {
 	// Persist an electron status update to the database
 	// Filter illegal updates
 	// Increment resolved_electrons counter
@@ -289,5 +402,12 @@ func UpdateElectronMetadata(t *sql.Tx, dispatch_id string, node_id int, update m
 	if update.EndTime != nil {
 		updates = append(updates, KeyValue{Key: db.ELECTRON_TABLE_END_TIME, Value: update.EndTime})
 	}
-	return UpdateTable(t, db.ELECTRON_TABLE, updates, where)
+	if err := UpdateTable(t, db.ELECTRON_TABLE, updates, where); err != nil {
+		return models.StatusEvent{}, err
+	}
+	ev := models.NewElectronStatusEvent(dispatch_id, node_id, &update)
+	if err := AppendEvent(t, &ev); err != nil {
+		return models.StatusEvent{}, err
+	}
+	return ev, nil
 }
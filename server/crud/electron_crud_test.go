@@ -1,10 +1,13 @@
 package crud
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"testing"
 
-	"github.com/casey/govalent/server/common"
 	"github.com/casey/govalent/server/models"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestCreateElectron(t *testing.T) {
@@ -35,7 +38,7 @@ func TestCreateElectron(t *testing.T) {
 		t.Fatalf("Error retrieving electron: wrong status")
 	}
 
-	err = UpdateElectronMetadata(tx, dispatch.Metadata.DispatchId, 0, models.ElectronStatusUpdate{Status: "RUNNING"})
+	_, err = UpdateElectronMetadata(tx, dispatch.Metadata.DispatchId, 0, models.ElectronStatusUpdate{Status: "RUNNING"})
 	electron2, err = GetElectronMetadata(tx, dispatch.Metadata.DispatchId, 0)
 	if electron2.Status != "RUNNING" {
 		t.Fatalf("Error updating electron: expected status %s, actual status %s", "RUNNING", electron2.Status)
@@ -45,7 +48,7 @@ func TestCreateElectron(t *testing.T) {
 }
 
 func TestGetAllElectrons(t *testing.T) {
-	config := common.NewConfigFromEnv()
+	config := newMockConfig(t)
 	d := newMockDB(t)
 	tx, db_err := d.Begin()
 	if db_err != nil {
@@ -80,3 +83,124 @@ func TestGetAllElectrons(t *testing.T) {
 	tx.Rollback()
 
 }
+
+func TestGetAllElectronsLoadAssets(t *testing.T) {
+	config := newMockConfig(t)
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	dispatch := newMockDispatch(nil, nil)
+	dispatch.Lattice.TransportGraph = newMockGraph(12)
+	err := ImportManifest(context.Background(), &config, tx, &dispatch)
+	if err != nil {
+		t.Fatalf("Error importing manifest: %v", err)
+	}
+
+	results, err := GetAllElectrons(&config, tx, dispatch.Metadata.DispatchId, true)
+	if err != nil {
+		t.Fatalf("Error retrieving electrons: %s", err.Error())
+	}
+	if len(results) != 12 {
+		t.Fatalf("Expected %d electrons, Actual %d electrons", 12, len(results))
+	}
+	for i, item := range results {
+		if item.NodeId != i {
+			t.Fatalf("Expected NodeId %d, Actual NodeId %d", i, item.NodeId)
+		}
+		if item.Assets.Function.Size != 10 {
+			t.Fatalf("Node %d: expected function size %d, actual %d", i, 10, item.Assets.Function.Size)
+		}
+		expected_uri := fmt.Sprintf("node_%d/function", i)
+		if !strings.HasSuffix(item.Assets.Function.RemoteUri, expected_uri) {
+			t.Fatalf("Node %d: unexpected remote uri %s", i, item.Assets.Function.RemoteUri)
+		}
+	}
+
+	results, err = GetAllElectrons(&config, tx, dispatch.Metadata.DispatchId, false)
+	if err != nil {
+		t.Fatalf("Error retrieving electrons: %s", err.Error())
+	}
+	if results[0].Assets.Function.Size != 0 {
+		t.Fatalf("Expected assets not to be loaded")
+	}
+	tx.Rollback()
+}
+
+func TestSearchElectrons(t *testing.T) {
+	config := newMockConfig(t)
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	defer tx.Rollback()
+
+	dispatch := newMockDispatch(nil, nil)
+	dispatch.Lattice.TransportGraph = newMockGraph(6)
+	statuses := []string{"COMPLETED", "RUNNING", "COMPLETED", "FAILED", "RUNNING", "NEW_OBJECT"}
+	for i := range dispatch.Lattice.TransportGraph.Nodes {
+		meta := &dispatch.Lattice.TransportGraph.Nodes[i].Metadata
+		meta.Status = statuses[i]
+		meta.TaskGroupId = i / 2
+		if i%3 == 0 {
+			meta.Executor = "dask"
+		}
+	}
+	if err := ImportManifest(context.Background(), &config, tx, &dispatch); err != nil {
+		t.Fatalf("Error importing manifest: %v", err)
+	}
+	dispatch_id := dispatch.Metadata.DispatchId
+	node_ids := func(resp models.GetBulkElectronsResponse) []int {
+		res := make([]int, len(resp.Records))
+		for i, item := range resp.Records {
+			res[i] = item.NodeId
+		}
+		return res
+	}
+
+	resp, err := SearchElectrons(&config, tx, dispatch_id, ElectronQuery{Count: 2, Page: 1, Ascending: true, LoadAssets: true})
+	if err != nil {
+		t.Fatalf("Error searching electrons: %v", err)
+	}
+	assert.Equal(t, 6, resp.Total)
+	assert.Equal(t, []int{2, 3}, node_ids(resp))
+	for _, e := range resp.Records {
+		expected_uri := fmt.Sprintf("node_%d/function", e.NodeId)
+		assert.True(t, strings.HasSuffix(e.Assets.Function.RemoteUri, expected_uri))
+	}
+
+	resp, err = SearchElectrons(&config, tx, dispatch_id, ElectronQuery{Statuses: []string{"RUNNING", "FAILED"}, Count: 10, Ascending: true})
+	if err != nil {
+		t.Fatalf("Error searching electrons: %v", err)
+	}
+	assert.Equal(t, []int{1, 3, 4}, node_ids(resp))
+	assert.Equal(t, "", resp.Records[0].Assets.Function.RemoteUri)
+
+	task_group_id := 1
+	resp, err = SearchElectrons(&config, tx, dispatch_id, ElectronQuery{TaskGroupId: &task_group_id, Count: 10, Ascending: true})
+	if err != nil {
+		t.Fatalf("Error searching electrons: %v", err)
+	}
+	assert.Equal(t, []int{2, 3}, node_ids(resp))
+
+	resp, err = SearchElectrons(&config, tx, dispatch_id, ElectronQuery{Executor: "dask", SortKey: "node_id", Count: 10})
+	if err != nil {
+		t.Fatalf("Error searching electrons: %v", err)
+	}
+	assert.Equal(t, []int{3, 0}, node_ids(resp))
+
+	_, err = SearchElectrons(&config, tx, "missing", ElectronQuery{Count: 10})
+	assert.Equal(t, 404, err.StatusCode)
+
+	electron, err := GetElectron(&config, tx, dispatch_id, 4, true)
+	if err != nil {
+		t.Fatalf("Error retrieving electron: %v", err)
+	}
+	assert.Equal(t, "RUNNING", electron.Metadata.Status)
+	assert.True(t, strings.HasSuffix(electron.Assets.Function.RemoteUri, "node_4/function"))
+
+	_, err = GetElectron(&config, tx, dispatch_id, 6, true)
+	assert.Equal(t, 404, err.StatusCode)
+}
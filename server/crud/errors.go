@@ -0,0 +1,31 @@
+package crud
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/casey/govalent/server/models"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Translate a database error into an APIError with a meaningful status
+//
+// Uniqueness violations are reported as 409 Conflict and foreign key
+// violations (references to missing records) as 422; all other errors
+// are internal server errors.
+func dbError(err error) *models.APIError {
+	var sqlite_err sqlite3.Error
+	if !errors.As(err, &sqlite_err) || sqlite_err.Code != sqlite3.ErrConstraint {
+		return models.NewGenericServerError(err)
+	}
+	slog.Debug(fmt.Sprintf("Constraint violation: %s", err.Error()))
+	switch sqlite_err.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return models.NewConflictError(fmt.Errorf("Record already exists: %s", err.Error()))
+	case sqlite3.ErrConstraintForeignKey:
+		return models.NewValidationError(fmt.Errorf("Referenced record does not exist: %s", err.Error()))
+	default:
+		return models.NewValidationError(err)
+	}
+}
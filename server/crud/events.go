@@ -0,0 +1,124 @@
+package crud
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/casey/govalent/server/db"
+	"github.com/casey/govalent/server/models"
+)
+
+// Maximum number of events returned by one call to GetEvents
+const MAX_EVENTS_PER_QUERY = 500
+
+var EVENT_ENTITY_KEYS = []string{
+	db.EVENTS_TABLE_DISPATCH_ID,
+	db.EVENTS_TABLE_NODE_ID,
+	db.EVENTS_TABLE_KIND,
+	db.EVENTS_TABLE_STATUS,
+	db.EVENTS_TABLE_START_TIME,
+	db.EVENTS_TABLE_END_TIME,
+	db.EVENTS_TABLE_CREATED_AT,
+}
+
+// Mapped to a row in the events table
+type EventEntity struct {
+	ev *models.StatusEvent
+}
+
+func (e *EventEntity) Fields() []string {
+	return EVENT_ENTITY_KEYS
+}
+
+func (e *EventEntity) Values() []any {
+	return []any{
+		e.ev.DispatchId,
+		e.ev.NodeId,
+		e.ev.Kind,
+		e.ev.Status,
+		e.ev.StartTime,
+		e.ev.EndTime,
+		e.ev.CreatedAt,
+	}
+}
+
+func (e *EventEntity) Fieldrefs() []any {
+	return []any{
+		&e.ev.DispatchId,
+		&e.ev.NodeId,
+		&e.ev.Kind,
+		&e.ev.Status,
+		&e.ev.StartTime,
+		&e.ev.EndTime,
+		&e.ev.CreatedAt,
+	}
+}
+
+func (e *EventEntity) Joins() []JoinCondition {
+	return []JoinCondition{}
+}
+
+// Record ev in the event log, set its Id and queue its webhook
+// deliveries
+//
+// The event becomes visible when t commits; publish it to subscribers
+// only after that.
+func AppendEvent(t *sql.Tx, ev *models.StatusEvent) *models.APIError {
+	ent := EventEntity{ev: ev}
+	template := generateBatchInsertTemplate(db.EVENTS_TABLE, (&ent).Fields(), 1)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return models.NewGenericServerError(err)
+	}
+	res, err := stmt.Exec((&ent).Values()...)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error recording event: %s\n", err.Error()))
+		return dbError(err)
+	}
+	ev.Id, err = res.LastInsertId()
+	if err != nil {
+		return models.NewGenericServerError(err)
+	}
+	return enqueueWebhookDeliveries(t, ev)
+}
+
+// Events of a dispatch with ids greater than after_id, oldest first.
+// At most limit events are returned; a non-positive limit means
+// MAX_EVENTS_PER_QUERY.
+func GetEvents(t *sql.Tx, dispatch_id string, after_id int64, limit int) ([]models.StatusEvent, *models.APIError) {
+	if limit <= 0 || limit > MAX_EVENTS_PER_QUERY {
+		limit = MAX_EVENTS_PER_QUERY
+	}
+	f := Filters{}
+	(&f).AddEq(db.EVENTS_TABLE_DISPATCH_ID, dispatch_id)
+	(&f).AddRange(db.EVENTS_TABLE_ID, after_id+1, nil)
+	f.Limit = limit
+
+	columns := append([]string{db.EVENTS_TABLE_ID}, EVENT_ENTITY_KEYS...)
+	template := generateSelectTemplate(db.EVENTS_TABLE, columns, (&f).RenderTemplate(), db.EVENTS_TABLE_ID, true, true)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+	rows, err := stmt.Query((&f).RenderValues()...)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+	defer rows.Close()
+	res := make([]models.StatusEvent, 0)
+	for rows.Next() {
+		ev := models.StatusEvent{}
+		ent := EventEntity{ev: &ev}
+		err := rows.Scan(append([]any{&ev.Id}, (&ent).Fieldrefs()...)...)
+		if err != nil {
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
+			return nil, models.NewGenericServerError(err)
+		}
+		res = append(res, ev)
+	}
+	return res, nil
+}
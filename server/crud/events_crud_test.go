@@ -0,0 +1,76 @@
+package crud
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/casey/govalent/server/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusEventLog(t *testing.T) {
+	config := newMockConfig(t)
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	defer tx.Rollback()
+
+	dispatch := newMockDispatch(nil, nil)
+	dispatch.Lattice.TransportGraph = newMockGraph(2)
+	if err := ImportManifest(context.Background(), &config, tx, &dispatch); err != nil {
+		t.Fatalf("Error importing manifest: %v", err)
+	}
+	other := newMockDispatch(nil, nil)
+	if err := ImportManifest(context.Background(), &config, tx, &other); err != nil {
+		t.Fatalf("Error importing manifest: %v", err)
+	}
+	dispatch_id := dispatch.Metadata.DispatchId
+
+	// Importing records the initial status
+	evs, err := GetEvents(tx, dispatch_id, 0, 0)
+	if err != nil {
+		t.Fatalf("Error retrieving events: %v", err)
+	}
+	assert.Equal(t, 1, len(evs))
+	assert.Equal(t, models.EVENT_KIND_DISPATCH_STATUS, evs[0].Kind)
+	assert.Equal(t, "NEW_OBJECT", evs[0].Status)
+	assert.Nil(t, evs[0].NodeId)
+
+	start := time.Now().UTC()
+	electron_ev, err := UpdateElectronMetadata(tx, dispatch_id, 1, models.ElectronStatusUpdate{Status: "RUNNING", StartTime: &start})
+	if err != nil {
+		t.Fatalf("Error updating electron: %v", err)
+	}
+	dispatch_ev, err := UpdateDispatch(tx, dispatch_id, "COMPLETED", nil, &start)
+	if err != nil {
+		t.Fatalf("Error updating dispatch: %v", err)
+	}
+	assert.Greater(t, dispatch_ev.Id, electron_ev.Id)
+
+	evs, err = GetEvents(tx, dispatch_id, evs[0].Id, 0)
+	if err != nil {
+		t.Fatalf("Error retrieving events: %v", err)
+	}
+	assert.Equal(t, 2, len(evs))
+	assert.Equal(t, electron_ev.Id, evs[0].Id)
+	assert.Equal(t, models.EVENT_KIND_ELECTRON_STATUS, evs[0].Kind)
+	assert.Equal(t, 1, *evs[0].NodeId)
+	assert.True(t, start.Equal(*evs[0].StartTime))
+	assert.Equal(t, "COMPLETED", evs[1].Status)
+
+	// Resuming after the last event returns nothing
+	evs, err = GetEvents(tx, dispatch_id, dispatch_ev.Id, 0)
+	if err != nil {
+		t.Fatalf("Error retrieving events: %v", err)
+	}
+	assert.Equal(t, 0, len(evs))
+
+	evs, err = GetEvents(tx, other.Metadata.DispatchId, 0, 0)
+	if err != nil {
+		t.Fatalf("Error retrieving events: %v", err)
+	}
+	assert.Equal(t, 1, len(evs))
+}
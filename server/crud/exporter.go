@@ -1,13 +1,23 @@
 package crud
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
 
 	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/db"
 	"github.com/casey/govalent/server/models"
+	"github.com/casey/govalent/server/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func ExportManifest(c *common.Config, t *sql.Tx, dispatch_id string) (models.DispatchSchema, *models.APIError) {
+func ExportManifest(ctx context.Context, c *common.Config, t *sql.Tx, dispatch_id string) (_ models.DispatchSchema, err *models.APIError) {
+	_, span := tracing.Start(ctx, "ExportManifest", attribute.String("dispatch_id", dispatch_id))
+	defer func() { tracing.End(span, err) }()
+
 	// Export Graph
 	g, err := GetGraph(c, t, dispatch_id, true)
 	if err != nil {
@@ -21,3 +31,168 @@ func ExportManifest(c *common.Config, t *sql.Tx, dispatch_id string) (models.Dis
 	d.Lattice.TransportGraph = g
 	return d, nil
 }
+
+// Prepare a manifest whose transport graph is read from database
+// cursors as it is encoded. The stream must be consumed before t is
+// closed. Nodes and links are read in spans of their own.
+func StreamManifest(ctx context.Context, c *common.Config, t *sql.Tx, dispatch_id string) (_ *models.DispatchSchemaStream, err *models.APIError) {
+	_, span := tracing.Start(ctx, "StreamManifest", attribute.String("dispatch_id", dispatch_id))
+	defer func() { tracing.End(span, err) }()
+
+	d, err := GetDispatch(c, t, dispatch_id, true)
+	if err != nil {
+		return nil, err
+	}
+	return &models.DispatchSchemaStream{
+		Header: d,
+		Nodes: func(emit func(*models.ElectronSchema) error) error {
+			_, span := tracing.Start(ctx, "StreamManifest.Nodes", attribute.String("dispatch_id", dispatch_id))
+			defer span.End()
+			return streamElectrons(c, t, dispatch_id, emit)
+		},
+		Links: func(emit func(*models.Edge) error) error {
+			_, span := tracing.Start(ctx, "StreamManifest.Links", attribute.String("dispatch_id", dispatch_id))
+			defer span.End()
+			return streamEdges(t, dispatch_id, emit)
+		},
+	}, nil
+}
+
+// Emit each electron, with its assets, in node_id order.
+//
+// Electrons and asset links are read from two cursors sorted by
+// node_id and merged, so only one electron is held in memory at a time.
+func streamElectrons(c *common.Config, t *sql.Tx, dispatch_id string, emit func(*models.ElectronSchema) error) error {
+	f := Filters{}
+	(&f).AddEq(db.ELECTRON_TABLE_DISPATCH_ID, dispatch_id)
+	template := generateSelectTemplate(
+		db.ELECTRON_TABLE,
+		ELECTRON_ENTITY_KEYS,
+		(&f).RenderTemplate(),
+		db.ELECTRON_TABLE_NODE_ID,
+		true,
+		false,
+	)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return err
+	}
+	electron_rows, err := stmt.Query((&f).RenderValues()...)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		return err
+	}
+	defer electron_rows.Close()
+
+	// The node id column of assetlinks is TEXT
+	asset_ent := NodeAssetEntity{}
+	asset_filters := Filters{}
+	(&asset_filters).AddEq(strings.Join([]string{db.ASSET_LINKS_TABLE, db.ASSET_LINKS_TABLE_DISPATCH_ID}, "."), dispatch_id)
+	asset_sort_key := fmt.Sprintf(
+		"CAST(%s.%s AS INTEGER) ASC, %s.%s",
+		db.ASSET_LINKS_TABLE,
+		db.ASSET_LINKS_TABLE_NODE_ID,
+		db.ASSET_LINKS_TABLE,
+		db.ASSET_LINKS_TABLE_NAME,
+	)
+	template = generateSelectJoinTemplate(
+		db.ASSET_LINKS_TABLE,
+		(&asset_ent).Fields(),
+		(&asset_ent).Joins(),
+		(&asset_filters).RenderTemplate(),
+		asset_sort_key,
+		true,
+		false,
+	)
+	stmt, err = prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return err
+	}
+	asset_rows, err := stmt.Query((&asset_filters).RenderValues()...)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		return err
+	}
+	defer asset_rows.Close()
+
+	// One row of lookahead on the asset cursor
+	next_asset := func() (*NodeAssetEntity, error) {
+		if !asset_rows.Next() {
+			return nil, asset_rows.Err()
+		}
+		ent := NewNodeAssetEntity()
+		if err := asset_rows.Scan((&ent).Fieldrefs()...); err != nil {
+			return nil, err
+		}
+		return &ent, nil
+	}
+	pending, err := next_asset()
+	if err != nil {
+		return err
+	}
+
+	for electron_rows.Next() {
+		ent := ElectronEntity{meta: &models.ElectronMeta{}}
+		if err := electron_rows.Scan((&ent).Fieldrefs()...); err != nil {
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
+			return err
+		}
+		electron := models.ElectronSchema{NodeId: ent.node_id, Metadata: *ent.meta}
+		asset_refs_by_name := (&electron.Assets).AttrsByName()
+
+		// Skip workflow assets and links to missing nodes
+		for pending != nil && pending.NodeId <= electron.NodeId {
+			if pending.NodeId == electron.NodeId {
+				if details_ref, ok := asset_refs_by_name[pending.Name]; ok {
+					details_ref.Copy(&(&pending.Asset).GetPublicEntity(c).AssetDetails)
+				}
+			}
+			pending, err = next_asset()
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := emit(&electron); err != nil {
+			return err
+		}
+	}
+	return electron_rows.Err()
+}
+
+func streamEdges(t *sql.Tx, dispatch_id string, emit func(*models.Edge) error) error {
+	f := Filters{}
+	(&f).AddEq(db.EDGES_TABLE_DISPATCH, dispatch_id)
+	template := generateSelectTemplate(
+		db.EDGES_TABLE,
+		EDGE_ENTITY_KEYS,
+		(&f).RenderTemplate(),
+		db.EDGES_TABLE_CHILD,
+		true,
+		false,
+	)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return err
+	}
+	rows, err := stmt.Query((&f).RenderValues()...)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		ent := EdgeEntity{e: &models.Edge{}}
+		if err := rows.Scan((&ent).Fieldrefs()...); err != nil {
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
+			return err
+		}
+		if err := emit(ent.e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -1,6 +1,7 @@
 package crud
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log/slog"
@@ -8,6 +9,8 @@ import (
 	"github.com/casey/govalent/server/common"
 	"github.com/casey/govalent/server/db"
 	"github.com/casey/govalent/server/models"
+	"github.com/casey/govalent/server/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 var EDGE_ENTITY_KEYS = []string{
@@ -63,14 +66,14 @@ func GetEdgeEntities(t *sql.Tx, f Filters, sort_key string, ascending bool) ([]E
 		ascending,
 		f.Limit > 0,
 	)
-	stmt, err := t.Prepare(template)
+	stmt, err := prepareStmt(t, template)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
 		return nil, models.NewGenericServerError(err)
 	}
 	rows, err := stmt.Query((&f).RenderValues()...)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
 		return nil, models.NewGenericServerError(err)
 	}
 	res := make([]EdgeEntity, 0)
@@ -78,7 +81,7 @@ func GetEdgeEntities(t *sql.Tx, f Filters, sort_key string, ascending bool) ([]E
 		e := EdgeEntity{e: &models.Edge{}}
 		err := rows.Scan((&e).Fieldrefs()...)
 		if err != nil {
-			slog.Error(fmt.Sprintf("Error querying row: %s\n", err.Error()))
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
 			return nil, models.NewGenericServerError(err)
 		}
 		res = append(res, e)
@@ -152,8 +155,9 @@ func (gv *GraphView) sortTopologically() ([]int, *models.APIError) {
 		in_deg[edge.Target] += 1
 	}
 
-	for n, deg := range in_deg {
-		if deg == 0 {
+	// Seed in node order so that the sort is deterministic
+	for _, n := range gv.Nodes() {
+		if in_deg[n] == 0 {
 			ready_nodes = append(ready_nodes, n)
 			slog.Info(fmt.Sprintf("Found parentless node %d\n", n))
 		}
@@ -184,50 +188,42 @@ func createEdges(t *sql.Tx, dispatch_id string, edges []models.Edge) (int, *mode
 	if len(edges) == 0 {
 		return 0, nil
 	}
-	ents := make([]EdgeEntity, len(edges))
+	ents := make([]DBEntity, len(edges))
 	for i := 0; i < len(edges); i++ {
-		ents[i].dispatch_id = dispatch_id
-		ents[i].e = &edges[i]
+		ents[i] = &EdgeEntity{dispatch_id: dispatch_id, e: &edges[i]}
 	}
-	template, _ := generateInsertTemplate(db.EDGES_TABLE, ents[0].Fields())
-	slog.Debug(fmt.Sprintf("Insert template: %s\n", template))
-	stmt, err := t.Prepare(template)
+	n, err := InsertEntities(t, db.EDGES_TABLE, ents)
 	if err != nil {
-		slog.Error(fmt.Sprintf("Error preparing statement: %s", err.Error()))
-		return 0, models.NewGenericServerError(err)
-	}
-	for i := 0; i < len(ents); i++ {
-		_, err := stmt.Exec((&ents[i]).Values()...)
-		if err != nil {
-			slog.Error(fmt.Sprintf("Error inserting row: %s", err.Error()))
-			return i, models.NewGenericServerError(err)
-		}
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error inserting edges: %s", err.Error()))
+		return n, err
 	}
-	slog.Debug(fmt.Sprintf("Inserted %d rows", len(ents)))
-	return len(ents), nil
+	slog.DebugContext(txContext(t), fmt.Sprintf("Inserted %d rows", n))
+	return n, nil
 }
 
 // TODO: check if passing tg by values allows mutating node and link slices
-func CreateGraph(c *common.Config, t *sql.Tx, dispatch_id string, tg *models.Graph) *models.APIError {
+func CreateGraph(ctx context.Context, c *common.Config, t *sql.Tx, namespace string, dispatch_id string, tg *models.Graph) (err *models.APIError) {
+	ctx, span := tracing.Start(ctx, "CreateGraph",
+		attribute.String("dispatch_id", dispatch_id),
+		attribute.Int("nodes", len(tg.Nodes)),
+		attribute.Int("links", len(tg.Links)),
+	)
+	defer func() { tracing.End(span, err) }()
 
-	for _, item := range tg.Nodes {
-		err := CreateElectronMetadata(t, dispatch_id, item.NodeId, &item.Metadata)
-		if err != nil {
-			slog.Error(fmt.Sprintf("Error creating electron: %s", err.Error()))
-			return err
-		}
+	_, err = createElectrons(t, dispatch_id, tg.Nodes)
+	if err != nil {
+		slog.ErrorContext(ctx, fmt.Sprintf("Error creating electrons: %s", err.Error()))
+		return err
 	}
-	for i := range tg.Nodes {
-		err := createElectronAssets(c, t, dispatch_id, &tg.Nodes[i])
-		if err != nil {
-			slog.Error(fmt.Sprintf("Error creating electron assets: %s", err.Error()))
-			return err
-		}
+	err = createElectronAssets(ctx, c, t, namespace, dispatch_id, tg.Nodes)
+	if err != nil {
+		slog.ErrorContext(ctx, fmt.Sprintf("Error creating electron assets: %s", err.Error()))
+		return err
 	}
 
-	_, err := createEdges(t, dispatch_id, tg.Links)
+	_, err = createEdges(t, dispatch_id, tg.Links)
 	if err != nil {
-		slog.Info(fmt.Sprintf("Error creating edges: %s", err.Error()))
+		slog.InfoContext(ctx, fmt.Sprintf("Error creating edges: %s", err.Error()))
 		return err
 	}
 	return err
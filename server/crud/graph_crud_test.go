@@ -1,15 +1,19 @@
 package crud
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
 
-	"github.com/casey/govalent/server/common"
 	"github.com/casey/govalent/server/models"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestCreateGraph(t *testing.T) {
-	config := common.NewConfigFromEnv()
+	config := newMockConfig(t)
 	dispatch := newMockDispatch(nil, nil)
 	e1 := models.ElectronSchema{NodeId: 0, Metadata: newMockElectronMeta(0, "NEW_OBJECT")}
 	e2 := models.ElectronSchema{NodeId: 1, Metadata: newMockElectronMeta(1, "NEW_OBJECT")}
@@ -33,7 +37,7 @@ func TestCreateGraph(t *testing.T) {
 		t.Fatalf("Error creating dispatch record: %v", err)
 	}
 
-	err = CreateGraph(&config, tx, dispatch.Metadata.DispatchId, &graph)
+	err = CreateGraph(context.Background(), &config, tx, "", dispatch.Metadata.DispatchId, &graph)
 	if err != nil {
 		t.Fatalf("Error creating dispatch record: %v", err)
 	}
@@ -101,3 +105,77 @@ func TestTopologicalSort(t *testing.T) {
 	assert.Equal(t, 2, sorted_nodes[2])
 	assert.Equal(t, 0, sorted_nodes[3])
 }
+
+func TestValidateTransportGraph(t *testing.T) {
+	body := `{
+		"metadata": {"dispatch_id": "abc", "status": "NEW_OBJECT"},
+		"lattice": {"transport_graph": {
+			"nodes": [
+				{"id": 0, "metadata": {"status": "NEW_OBJECT"}},
+				{"id": 1, "metadata": {"status": "BOGUS"}},
+				{"id": 1, "metadata": {"status": "NEW_OBJECT"}},
+				{"id": 2, "metadata": {"status": "NEW_OBJECT"}},
+				{"id": 7, "metadata": {"status": "NEW_OBJECT"}}
+			],
+			"links": [
+				{"source": 0, "target": 1, "metadata": {"edge_name": "x", "param_type": "arg", "arg_index": 0}},
+				{"source": 2, "target": 1, "metadata": {"edge_name": "y", "param_type": "arg", "arg_index": 0}},
+				{"source": 0, "target": 2, "metadata": {"edge_name": "z", "param_type": "bogus"}},
+				{"source": 0, "target": 9, "metadata": {"edge_name": "w", "param_type": "kwarg"}},
+				{"source": 1, "target": 2, "metadata": {"edge_name": "v", "param_type": "kwarg"}}
+			]
+		}}
+	}`
+	expected := []models.ValidationErrorDetail{
+		{Location: "lattice.transport_graph.nodes[1]", Attr: "metadata.status", Detail: "Invalid value"},
+		{Location: "lattice.transport_graph.nodes[2]", Attr: "id", Detail: "Invalid value: duplicates lattice.transport_graph.nodes[1]"},
+		{Location: "lattice.transport_graph.links[1]", Attr: "metadata.arg_index", Detail: "Invalid value: collides with lattice.transport_graph.links[0]"},
+		{Location: "lattice.transport_graph.links[2]", Attr: "metadata.param_type", Detail: "Invalid value"},
+		{Location: "lattice.transport_graph.nodes[4]", Attr: "id", Detail: "Invalid value: node ids must be contiguous from 0 to 4"},
+		{Location: "lattice.transport_graph.links[3]", Attr: "target", Detail: "Invalid value: unknown node 9"},
+		{Location: "lattice.transport_graph.links", Attr: "", Detail: "Invalid value: graph contains a cycle involving nodes [1 2]"},
+	}
+
+	// Buffered decoding
+	var m models.DispatchSchema
+	err := (&m).DecodeJSON(json.NewDecoder(strings.NewReader(body)))
+	if err == nil {
+		t.Fatalf("Expected validation error")
+	}
+	assert.Equal(t, 422, err.StatusCode)
+	var validation_err *models.ValidationError
+	if !errors.As(err.Err, &validation_err) {
+		t.Fatalf("Expected ValidationError, got %v", err.Err)
+	}
+	assert.Equal(t, expected, validation_err.Details)
+
+	// Streamed decoding
+	config := newMockConfig(t)
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	defer tx.Rollback()
+	dec := json.NewDecoder(strings.NewReader(body))
+	_, err = models.DecodeDispatchSchemaStream(dec, models.ManifestLimits{}, NewManifestImporter(context.Background(), &config, tx, "", "", ""))
+	if err == nil {
+		t.Fatalf("Expected validation error")
+	}
+	assert.Equal(t, 422, err.StatusCode)
+	if !errors.As(err.Err, &validation_err) {
+		t.Fatalf("Expected ValidationError, got %v", err.Err)
+	}
+	assert.Equal(t, expected, validation_err.Details)
+}
+
+func TestValidateTransportGraphAccepts(t *testing.T) {
+	dispatch := newMockDispatch(nil, nil)
+	dispatch.Lattice.TransportGraph = newMockGraph(20)
+	body, _ := json.Marshal(&dispatch)
+	var m models.DispatchSchema
+	err := (&m).DecodeJSON(json.NewDecoder(bytes.NewReader(body)))
+	if err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+}
@@ -1,15 +1,31 @@
 package crud
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/db"
 	"github.com/casey/govalent/server/models"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 func TestImportExport(t *testing.T) {
 	dispatch := newMockDispatch(nil, nil)
-	config := common.NewConfigFromEnv()
+	config := newMockConfig(t)
 	e1 := models.ElectronSchema{NodeId: 0, Metadata: newMockElectronMeta(0, "NEW_OBJECT")}
 	e2 := models.ElectronSchema{NodeId: 1, Metadata: newMockElectronMeta(1, "NEW_OBJECT")}
 	e3 := models.ElectronSchema{NodeId: 2, Metadata: newMockElectronMeta(2, "NEW_OBJECT")}
@@ -27,11 +43,11 @@ func TestImportExport(t *testing.T) {
 	if db_err != nil {
 		t.Fatalf("Error starting transaction: %v", db_err)
 	}
-	err := ImportManifest(&config, tx, &dispatch)
+	err := ImportManifest(context.Background(), &config, tx, &dispatch)
 	if err != nil {
 		t.Fatalf("Error importing manifest: %v", err)
 	}
-	export, err := ExportManifest(&config, tx, dispatch.Metadata.DispatchId)
+	export, err := ExportManifest(context.Background(), &config, tx, dispatch.Metadata.DispatchId)
 	if err != nil {
 		t.Fatalf("Error exporting manifest: %v", err)
 	}
@@ -50,3 +66,471 @@ func TestImportExport(t *testing.T) {
 	}
 	tx.Rollback()
 }
+
+func newBenchmarkDB(b *testing.B) *sql.DB {
+	dsn := fmt.Sprintf("file:%s", filepath.Join(b.TempDir(), "bench.db"))
+	d, err := db.GetDB(&common.Config{Dsn: dsn})
+	if err != nil {
+		b.Fatalf("Error establishing DB connection: %v", err)
+	}
+	b.Cleanup(func() { d.Close() })
+	if err := db.EmitDDL(d); err != nil {
+		b.Fatalf("Error emitting DDL: %v", err)
+	}
+	return d
+}
+
+func TestImportLargeManifest(t *testing.T) {
+	config := newMockConfig(t)
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	// Enough nodes for every table to need several batches
+	n_nodes := 3*MAX_INSERT_BATCH_ROWS + 1
+	dispatch := newMockDispatch(nil, nil)
+	dispatch.Lattice.TransportGraph = newMockGraph(n_nodes)
+	err := ImportManifest(context.Background(), &config, tx, &dispatch)
+	if err != nil {
+		t.Fatalf("Error importing manifest: %v", err)
+	}
+	export, err := ExportManifest(context.Background(), &config, tx, dispatch.Metadata.DispatchId)
+	if err != nil {
+		t.Fatalf("Error exporting manifest: %v", err)
+	}
+	if len(export.Lattice.TransportGraph.Nodes) != n_nodes {
+		t.Fatalf("Wrong number of nodes: expected %v, actual %v", n_nodes, len(export.Lattice.TransportGraph.Nodes))
+	}
+	if len(export.Lattice.TransportGraph.Links) != n_nodes-1 {
+		t.Fatalf("Wrong number of edges: expected %v, actual %v", n_nodes-1, len(export.Lattice.TransportGraph.Links))
+	}
+	for i, node := range export.Lattice.TransportGraph.Nodes {
+		if node.Assets.Function.Size != 10 {
+			t.Fatalf("Node %d: expected function size %d, actual %d", i, 10, node.Assets.Function.Size)
+		}
+	}
+	tx.Rollback()
+}
+
+func benchmarkImportManifest(b *testing.B, n_nodes int) {
+	config := newMockConfig(b)
+	d := newBenchmarkDB(b)
+	graph := newMockGraph(n_nodes)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		dispatch := newMockDispatch(nil, nil)
+		dispatch.Lattice.TransportGraph.Nodes = append([]models.ElectronSchema{}, graph.Nodes...)
+		dispatch.Lattice.TransportGraph.Links = graph.Links
+		b.StartTimer()
+
+		tx, _ := d.Begin()
+		if api_err := ImportManifest(context.Background(), &config, tx, &dispatch); api_err != nil {
+			b.Fatalf("Error importing manifest: %v", api_err)
+		}
+		tx.Rollback()
+	}
+}
+
+func BenchmarkImportManifest1000(b *testing.B)   { benchmarkImportManifest(b, 1000) }
+func BenchmarkImportManifest10000(b *testing.B)  { benchmarkImportManifest(b, 10000) }
+func BenchmarkImportManifest100000(b *testing.B) { benchmarkImportManifest(b, 100000) }
+
+func benchmarkExportManifest(b *testing.B, n_nodes int) {
+	config := newMockConfig(b)
+	d := newBenchmarkDB(b)
+
+	dispatch := newMockDispatch(nil, nil)
+	dispatch.Lattice.TransportGraph = newMockGraph(n_nodes)
+	tx, _ := d.Begin()
+	if api_err := ImportManifest(context.Background(), &config, tx, &dispatch); api_err != nil {
+		b.Fatalf("Error importing manifest: %v", api_err)
+	}
+	tx.Commit()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tx, _ := d.Begin()
+		export, api_err := ExportManifest(context.Background(), &config, tx, dispatch.Metadata.DispatchId)
+		if api_err != nil {
+			b.Fatalf("Error exporting manifest: %v", api_err)
+		}
+		if len(export.Lattice.TransportGraph.Nodes) != n_nodes {
+			b.Fatalf("Expected %d nodes, got %d", n_nodes, len(export.Lattice.TransportGraph.Nodes))
+		}
+		tx.Rollback()
+	}
+}
+
+func benchmarkStreamManifest(b *testing.B, n_nodes int) {
+	config := newMockConfig(b)
+	d := newBenchmarkDB(b)
+
+	dispatch := newMockDispatch(nil, nil)
+	dispatch.Lattice.TransportGraph = newMockGraph(n_nodes)
+	dispatch.Lattice.Metadata.WorkflowExecutorData = "{}"
+	tx, _ := d.Begin()
+	if api_err := ImportManifest(context.Background(), &config, tx, &dispatch); api_err != nil {
+		b.Fatalf("Error importing manifest: %v", api_err)
+	}
+	tx.Commit()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tx, _ := d.Begin()
+		stream, api_err := StreamManifest(context.Background(), &config, tx, dispatch.Metadata.DispatchId)
+		if api_err != nil {
+			b.Fatalf("Error exporting manifest: %v", api_err)
+		}
+		if api_err := stream.EncodeJSONStream(io.Discard); api_err != nil {
+			b.Fatalf("Error encoding manifest: %v", api_err)
+		}
+		tx.Rollback()
+	}
+}
+
+func BenchmarkStreamManifest1000(b *testing.B)  { benchmarkStreamManifest(b, 1000) }
+func BenchmarkStreamManifest10000(b *testing.B) { benchmarkStreamManifest(b, 10000) }
+
+func BenchmarkExportManifest100(b *testing.B)   { benchmarkExportManifest(b, 100) }
+func BenchmarkExportManifest1000(b *testing.B)  { benchmarkExportManifest(b, 1000) }
+func BenchmarkExportManifest10000(b *testing.B) { benchmarkExportManifest(b, 10000) }
+
+func TestStreamManifestMatchesExport(t *testing.T) {
+	config := newMockConfig(t)
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	for _, n_nodes := range []int{0, 1, 12} {
+		dispatch := newMockDispatch(nil, nil)
+		dispatch.Lattice.TransportGraph = newMockGraph(n_nodes)
+		dispatch.Lattice.Metadata.WorkflowExecutorData = "{}"
+		dispatch.Assets.Result = models.AssetDetails{Size: 4, Digest: "<&>"}
+		err := ImportManifest(context.Background(), &config, tx, &dispatch)
+		if err != nil {
+			t.Fatalf("Error importing manifest: %v", err)
+		}
+
+		export, err := ExportManifest(context.Background(), &config, tx, dispatch.Metadata.DispatchId)
+		if err != nil {
+			t.Fatalf("Error exporting manifest: %v", err)
+		}
+		var expected bytes.Buffer
+		if err := (&export).EncodeJSON(json.NewEncoder(&expected)); err != nil {
+			t.Fatalf("Error encoding manifest: %v", err)
+		}
+
+		stream, err := StreamManifest(context.Background(), &config, tx, dispatch.Metadata.DispatchId)
+		if err != nil {
+			t.Fatalf("Error streaming manifest: %v", err)
+		}
+		var actual bytes.Buffer
+		if err := stream.EncodeJSONStream(&actual); err != nil {
+			t.Fatalf("Error encoding manifest: %v", err)
+		}
+		assert.Equal(t, expected.String(), actual.String())
+	}
+	tx.Rollback()
+}
+
+// Records how the decoder batches writes before passing them on
+type recordingSink struct {
+	*ManifestImporter
+	node_batches []int
+	link_batches []int
+}
+
+func (s *recordingSink) WriteNodes(nodes []models.ElectronSchema) *models.APIError {
+	s.node_batches = append(s.node_batches, len(nodes))
+	return s.ManifestImporter.WriteNodes(nodes)
+}
+
+func (s *recordingSink) WriteLinks(edges []models.Edge) *models.APIError {
+	s.link_batches = append(s.link_batches, len(edges))
+	return s.ManifestImporter.WriteLinks(edges)
+}
+
+func TestDecodeManifestStream(t *testing.T) {
+	config := newMockConfig(t)
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	n_nodes := 2*models.MANIFEST_STREAM_BATCH_SIZE + 1
+	dispatch := newMockDispatch(nil, nil)
+	dispatch.Lattice.TransportGraph = newMockGraph(n_nodes)
+	body, _ := json.Marshal(&dispatch)
+
+	sink := recordingSink{ManifestImporter: NewManifestImporter(context.Background(), &config, tx, "", "", "")}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	header, err := models.DecodeDispatchSchemaStream(dec, models.ManifestLimits{}, &sink)
+	if err != nil {
+		t.Fatalf("Error decoding manifest: %v", err)
+	}
+	assert.Equal(t, dispatch.Metadata.DispatchId, header.Metadata.DispatchId)
+	assert.Equal(t, []int{1000, 1000, 1}, sink.node_batches)
+	assert.Equal(t, []int{1000, 1000}, sink.link_batches)
+
+	export, err := ExportManifest(context.Background(), &config, tx, dispatch.Metadata.DispatchId)
+	if err != nil {
+		t.Fatalf("Error exporting manifest: %v", err)
+	}
+	assert.Equal(t, dispatch.Metadata.DispatchId, export.Metadata.RootDispatchId)
+	assert.Equal(t, n_nodes, len(export.Lattice.TransportGraph.Nodes))
+	assert.Equal(t, n_nodes-1, len(export.Lattice.TransportGraph.Links))
+	assert.Equal(t, 10, export.Lattice.TransportGraph.Nodes[n_nodes-1].Assets.Function.Size)
+	tx.Rollback()
+}
+
+func TestDecodeManifestStreamOutOfOrder(t *testing.T) {
+	config := newMockConfig(t)
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	dispatch := newMockDispatch(nil, nil)
+	graph, _ := json.Marshal(newMockGraph(3))
+	metadata, _ := json.Marshal(&dispatch.Metadata)
+	body := fmt.Sprintf(`{"lattice": {"transport_graph": %s, "metadata": {"name": "wf"}}, "metadata": %s}`, graph, metadata)
+
+	dec := json.NewDecoder(strings.NewReader(body))
+	_, err := models.DecodeDispatchSchemaStream(dec, models.ManifestLimits{}, NewManifestImporter(context.Background(), &config, tx, "", "", ""))
+	if err != nil {
+		t.Fatalf("Error decoding manifest: %v", err)
+	}
+	export, err := ExportManifest(context.Background(), &config, tx, dispatch.Metadata.DispatchId)
+	if err != nil {
+		t.Fatalf("Error exporting manifest: %v", err)
+	}
+	assert.Equal(t, "wf", export.Lattice.Metadata.Name)
+	assert.Equal(t, 3, len(export.Lattice.TransportGraph.Nodes))
+	tx.Rollback()
+}
+
+func TestDecodeManifestStreamCollectsErrors(t *testing.T) {
+	config := newMockConfig(t)
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	body := `{
+		"metadata": {"dispatch_id": "abc", "status": "BOGUS"},
+		"lattice": {"transport_graph": {
+			"nodes": [
+				{"id": 0, "metadata": {"task_group_id": 0, "status": "NEW_OBJECT"}},
+				{"id": 1, "metadata": {"task_group_id": "one", "status": "NEW_OBJECT"}},
+				{"id": 2, "metadata": {"task_group_id": 2, "status": "NEW_OBJECT", "start_time": "yesterday"}}
+			],
+			"links": [{"source": "0", "target": 1, "metadata": {"param_type": "arg"}}]
+		}}
+	}`
+	dec := json.NewDecoder(strings.NewReader(body))
+	_, err := models.DecodeDispatchSchemaStream(dec, models.ManifestLimits{}, NewManifestImporter(context.Background(), &config, tx, "", "", ""))
+	if err == nil {
+		t.Fatalf("Expected validation error")
+	}
+	assert.Equal(t, 422, err.StatusCode)
+	validation_err, ok := err.Err.(*models.ValidationError)
+	if !ok {
+		t.Fatalf("Expected ValidationError, got %v", err.Err)
+	}
+	locations := make([]string, len(validation_err.Details))
+	for i, item := range validation_err.Details {
+		locations[i] = item.Location
+	}
+	assert.Equal(t, []string{
+		"metadata",
+		"lattice.transport_graph.nodes[1]",
+		"lattice.transport_graph.nodes[2]",
+		"lattice.transport_graph.links[0]",
+	}, locations)
+	assert.Equal(t, "status", validation_err.Details[0].Attr)
+	assert.Equal(t, "metadata.task_group_id", validation_err.Details[1].Attr)
+
+	// Nothing was written after the first error
+	summaries, err := GetDispatchSummaries(tx, "abc", 0, 10)
+	if err != nil {
+		t.Fatalf("Error retrieving dispatches: %v", err)
+	}
+	assert.Equal(t, 0, len(summaries.Records))
+	tx.Rollback()
+}
+
+func TestDecodeManifestStreamLimits(t *testing.T) {
+	config := newMockConfig(t)
+	d := newMockDB(t)
+	dispatch := newMockDispatch(nil, nil)
+	dispatch.Lattice.TransportGraph = newMockGraph(5)
+	body, _ := json.Marshal(&dispatch)
+
+	// Each case starts from an empty database since a rejected manifest
+	// may already have written its header
+	decode := func(r io.Reader, limits models.ManifestLimits) *models.APIError {
+		tx, db_err := d.Begin()
+		if db_err != nil {
+			t.Fatalf("Error starting transaction: %v", db_err)
+		}
+		defer tx.Rollback()
+		_, err := models.DecodeDispatchSchemaStream(json.NewDecoder(r), limits, NewManifestImporter(context.Background(), &config, tx, "", "", ""))
+		return err
+	}
+
+	err := decode(bytes.NewReader(body), models.ManifestLimits{MaxNodes: 4})
+	if err == nil {
+		t.Fatalf("Expected node limit to be enforced")
+	}
+	assert.Equal(t, 413, err.StatusCode)
+
+	limited := http.MaxBytesReader(nil, io.NopCloser(bytes.NewReader(body)), int64(len(body)/2))
+	err = decode(limited, models.ManifestLimits{})
+	if err == nil {
+		t.Fatalf("Expected body size limit to be enforced")
+	}
+	assert.Equal(t, 413, err.StatusCode)
+
+	err = decode(bytes.NewReader(body[:len(body)-10]), models.ManifestLimits{})
+	if err == nil {
+		t.Fatalf("Expected truncated body to be rejected")
+	}
+	assert.Equal(t, 422, err.StatusCode)
+}
+
+func TestImportDuplicateManifest(t *testing.T) {
+	config := newMockConfig(t)
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	defer tx.Rollback()
+	dispatch := newMockDispatch(nil, nil)
+	dispatch.Lattice.TransportGraph = newMockGraph(4)
+	body, _ := json.Marshal(&dispatch)
+
+	importer := NewManifestImporter(context.Background(), &config, tx, "", "", "")
+	_, err := models.DecodeDispatchSchemaStream(json.NewDecoder(bytes.NewReader(body)), models.ManifestLimits{}, importer)
+	if err != nil {
+		t.Fatalf("Error importing manifest: %v", err)
+	}
+	assert.False(t, importer.Duplicate())
+	sub := NewSubmissionEntity(dispatch.Metadata.DispatchId, "key-1", "alice", "default", "digest-1")
+	if err := CreateSubmission(tx, &sub); err != nil {
+		t.Fatalf("Error recording submission: %v", err)
+	}
+
+	// A second import of the same dispatch_id writes nothing
+	importer = NewManifestImporter(context.Background(), &config, tx, "", "", "")
+	_, err = models.DecodeDispatchSchemaStream(json.NewDecoder(bytes.NewReader(body)), models.ManifestLimits{}, importer)
+	if err != nil {
+		t.Fatalf("Error importing manifest: %v", err)
+	}
+	assert.True(t, importer.Duplicate())
+	graph, err := GetGraph(&config, tx, dispatch.Metadata.DispatchId, true)
+	if err != nil {
+		t.Fatalf("Error retrieving graph: %v", err)
+	}
+	assert.Equal(t, 4, len(graph.Nodes))
+	assert.Equal(t, len(dispatch.Lattice.TransportGraph.Links), len(graph.Links))
+	assets, err := GetDispatchAssets(&config, tx, dispatch.Metadata.DispatchId)
+	if err != nil {
+		t.Fatalf("Error retrieving assets: %v", err)
+	}
+	assert.Equal(t, len((&dispatch.Assets).AttrsByName())+len((&dispatch.Lattice.Assets).AttrsByName()), len(assets))
+
+	// The non-streaming importer rejects the duplicate outright
+	err = ImportManifest(context.Background(), &config, tx, &dispatch)
+	if err == nil {
+		t.Fatal("Expected duplicate dispatch to be rejected")
+	}
+	assert.Equal(t, 409, err.StatusCode)
+
+	stored, found, err := GetSubmission(tx, dispatch.Metadata.DispatchId)
+	if err != nil {
+		t.Fatalf("Error retrieving submission: %v", err)
+	}
+	assert.True(t, found)
+	assert.Equal(t, "digest-1", stored.Digest)
+	stored, found, err = GetSubmissionByIdempotencyKey(tx, "key-1", "alice", "default")
+	if err != nil {
+		t.Fatalf("Error retrieving submission: %v", err)
+	}
+	assert.True(t, found)
+	assert.Equal(t, dispatch.Metadata.DispatchId, stored.DispatchId)
+	// Keys are scoped to their owner and namespace
+	for _, scope := range [][3]string{{"key-2", "alice", "default"}, {"key-1", "bob", "default"}, {"key-1", "alice", "other"}} {
+		_, found, err = GetSubmissionByIdempotencyKey(tx, scope[0], scope[1], scope[2])
+		if err != nil {
+			t.Fatalf("Error retrieving submission: %v", err)
+		}
+		assert.False(t, found, scope)
+	}
+
+	// Idempotency keys cannot be reused for another dispatch
+	other := newMockDispatch(nil, nil)
+	if err := ImportManifest(context.Background(), &config, tx, &other); err != nil {
+		t.Fatalf("Error importing manifest: %v", err)
+	}
+	sub = NewSubmissionEntity(other.Metadata.DispatchId, "key-1", "alice", "default", "digest-2")
+	err = CreateSubmission(tx, &sub)
+	if err == nil {
+		t.Fatal("Expected reused idempotency key to be rejected")
+	}
+	assert.Equal(t, 409, err.StatusCode)
+}
+
+func TestImportManifestSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+	config := newMockConfig(t)
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	defer tx.Rollback()
+	dispatch := newMockDispatch(nil, nil)
+	dispatch.Lattice.TransportGraph = newMockGraph(3)
+	if err := ImportManifest(context.Background(), &config, tx, &dispatch); err != nil {
+		t.Fatalf("Error importing manifest: %v", err)
+	}
+	if _, err := ExportManifest(context.Background(), &config, tx, dispatch.Metadata.DispatchId); err != nil {
+		t.Fatalf("Error exporting manifest: %v", err)
+	}
+
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = append(spans[s.Name()], s)
+	}
+	assert.Equal(t, 1, len(spans["ImportManifest"]))
+	assert.Equal(t, 1, len(spans["CreateGraph"]))
+	assert.Equal(t, 1, len(spans["ExportManifest"]))
+	// Workflow, lattice and electron assets
+	assert.Equal(t, 3, len(spans["CreateAssets"]))
+
+	import_span := spans["ImportManifest"][0].SpanContext()
+	graph_span := spans["CreateGraph"][0]
+	assert.Equal(t, import_span.SpanID(), graph_span.Parent().SpanID())
+	for _, s := range spans["CreateAssets"] {
+		assert.Equal(t, import_span.TraceID(), s.SpanContext().TraceID())
+	}
+	assert.False(t, spans["ExportManifest"][0].Parent().IsValid())
+
+	// Failures are recorded on the span
+	recorder = tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	err := ImportManifest(context.Background(), &config, tx, &dispatch)
+	assert.NotNil(t, err)
+	ended := recorder.Ended()
+	assert.Equal(t, "ImportManifest", ended[len(ended)-1].Name())
+	assert.Equal(t, codes.Error, ended[len(ended)-1].Status().Code)
+}
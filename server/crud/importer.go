@@ -1,21 +1,123 @@
 package crud
 
 import (
+	"context"
 	"database/sql"
+	"net/http"
 
 	"github.com/casey/govalent/server/common"
 	"github.com/casey/govalent/server/models"
+	"github.com/casey/govalent/server/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func ImportManifest(c *common.Config, t *sql.Tx, m *models.DispatchSchema) *models.APIError {
-	// TODO: create assets
-	err := CreateDispatchMetadata(t, &m.Metadata, &m.Lattice.Metadata)
+func ImportManifest(ctx context.Context, c *common.Config, t *sql.Tx, m *models.DispatchSchema) (err *models.APIError) {
+	ctx, span := tracing.Start(ctx, "ImportManifest", attribute.String("dispatch_id", m.Metadata.DispatchId))
+	defer func() { tracing.End(span, err) }()
+
+	if len(m.Metadata.RootDispatchId) == 0 {
+		m.Metadata.RootDispatchId = m.Metadata.DispatchId
+	}
+	err = CreateDispatchMetadata(t, &m.Metadata, &m.Lattice.Metadata)
+	if err != nil {
+		return err
+	}
+	err = recordInitialStatus(t, &m.Metadata)
+	if err != nil {
+		return err
+	}
+	err = createDispatchAssets(ctx, c, t, m)
+	if err != nil {
+		return err
+	}
+	return CreateGraph(ctx, c, t, m.Metadata.Namespace, m.Metadata.DispatchId, &m.Lattice.TransportGraph)
+}
+
+// Imports a manifest batch by batch as it is decoded; see
+// models.DecodeDispatchSchemaStream
+//
+// If the dispatch already exists the importer writes nothing further
+// and only reports Duplicate; the caller decides whether the
+// submission is a retry or a conflict.
+type ManifestImporter struct {
+	// Parent of the spans of each batch
+	ctx              context.Context
+	c                *common.Config
+	t                *sql.Tx
+	root_dispatch_id string
+	owner            string
+	namespace        string
+	dispatch_id      string
+	duplicate        bool
+}
+
+// An empty root_dispatch_id makes the manifest its own root dispatch.
+// owner and namespace replace any given in the manifest.
+func NewManifestImporter(ctx context.Context, c *common.Config, t *sql.Tx, root_dispatch_id string, owner string, namespace string) *ManifestImporter {
+	return &ManifestImporter{ctx: ctx, c: c, t: t, root_dispatch_id: root_dispatch_id, owner: owner, namespace: namespace}
+}
+
+func (m *ManifestImporter) WriteHeader(d *models.DispatchSchema) (err *models.APIError) {
+	ctx, span := tracing.Start(m.ctx, "ManifestImporter.WriteHeader", attribute.String("dispatch_id", d.Metadata.DispatchId))
+	defer func() { tracing.End(span, err) }()
+
+	if len(m.root_dispatch_id) > 0 {
+		d.Metadata.RootDispatchId = m.root_dispatch_id
+	} else {
+		d.Metadata.RootDispatchId = d.Metadata.DispatchId
+	}
+	d.Metadata.Owner = m.owner
+	d.Metadata.Namespace = m.namespace
+	m.dispatch_id = d.Metadata.DispatchId
+	err = CreateDispatchMetadata(m.t, &d.Metadata, &d.Lattice.Metadata)
+	if err != nil && err.StatusCode == http.StatusConflict {
+		m.duplicate = true
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	err = createDispatchAssets(c, t, m)
+	// Resolved by CreateDispatchMetadata if empty
+	m.namespace = d.Metadata.Namespace
+	if err := recordInitialStatus(m.t, &d.Metadata); err != nil {
+		return err
+	}
+	return createDispatchAssets(ctx, m.c, m.t, d)
+}
+
+// Whether the manifest's dispatch_id was already present
+func (m *ManifestImporter) Duplicate() bool {
+	return m.duplicate
+}
+
+func (m *ManifestImporter) WriteNodes(nodes []models.ElectronSchema) (err *models.APIError) {
+	if m.duplicate {
+		return nil
+	}
+	ctx, span := tracing.Start(m.ctx, "ManifestImporter.WriteNodes", attribute.Int("count", len(nodes)))
+	defer func() { tracing.End(span, err) }()
+
+	_, err = createElectrons(m.t, m.dispatch_id, nodes)
 	if err != nil {
 		return err
 	}
-	return CreateGraph(c, t, m.Metadata.DispatchId, &m.Lattice.TransportGraph)
+	return createElectronAssets(ctx, m.c, m.t, m.namespace, m.dispatch_id, nodes)
+}
+
+func (m *ManifestImporter) WriteLinks(edges []models.Edge) (err *models.APIError) {
+	if m.duplicate {
+		return nil
+	}
+	_, span := tracing.Start(m.ctx, "ManifestImporter.WriteLinks", attribute.Int("count", len(edges)))
+	defer func() { tracing.End(span, err) }()
+
+	_, err = createEdges(m.t, m.dispatch_id, edges)
+	return err
+}
+
+// The first entry of each dispatch's event log, so that subscribers
+// always learn the status of the dispatch
+func recordInitialStatus(t *sql.Tx, d *models.DispatchMeta) *models.APIError {
+	ev := models.NewDispatchStatusEvent(d.DispatchId, d.Status, d.StartTime, d.EndTime)
+	return AppendEvent(t, &ev)
 }
@@ -0,0 +1,175 @@
+package crud
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/casey/govalent/server/db"
+	"github.com/casey/govalent/server/models"
+)
+
+var NAMESPACE_ENTITY_KEYS = []string{
+	db.NAMESPACES_TABLE_NAME,
+	db.NAMESPACES_TABLE_MAX_DISPATCHES,
+	db.NAMESPACES_TABLE_MAX_ASSET_BYTES,
+	db.NAMESPACES_TABLE_CREATED_AT,
+}
+
+// Mapped to a row in the namespaces table
+type NamespaceEntity struct {
+	ns *models.Namespace
+}
+
+func (e *NamespaceEntity) Fields() []string {
+	return NAMESPACE_ENTITY_KEYS
+}
+
+func (e *NamespaceEntity) Values() []any {
+	return []any{
+		e.ns.Name,
+		e.ns.MaxDispatches,
+		e.ns.MaxAssetBytes,
+		e.ns.CreatedAt,
+	}
+}
+
+func (e *NamespaceEntity) Fieldrefs() []any {
+	return []any{
+		&e.ns.Name,
+		&e.ns.MaxDispatches,
+		&e.ns.MaxAssetBytes,
+		&e.ns.CreatedAt,
+	}
+}
+
+func (e *NamespaceEntity) Joins() []JoinCondition {
+	return []JoinCondition{}
+}
+
+// Record the namespace, without quotas, if it is not yet known
+func ensureNamespace(t *sql.Tx, name string) *models.APIError {
+	ns := models.Namespace{Name: name, CreatedAt: time.Now().UTC()}
+	_, err := InsertEntities(t, db.NAMESPACES_TABLE, []DBEntity{&NamespaceEntity{ns: &ns}})
+	return err
+}
+
+func getNamespaces(t *sql.Tx, f Filters) ([]models.Namespace, *models.APIError) {
+	template := generateSelectTemplate(
+		db.NAMESPACES_TABLE,
+		NAMESPACE_ENTITY_KEYS,
+		(&f).RenderTemplate(),
+		db.NAMESPACES_TABLE_NAME,
+		true,
+		false,
+	)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+	rows, err := stmt.Query((&f).RenderValues()...)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+	defer rows.Close()
+	res := make([]models.Namespace, 0)
+	for rows.Next() {
+		var ns models.Namespace
+		if err := rows.Scan((&NamespaceEntity{ns: &ns}).Fieldrefs()...); err != nil {
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
+			return nil, models.NewGenericServerError(err)
+		}
+		res = append(res, ns)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, models.NewGenericServerError(err)
+	}
+	for i := range res {
+		usage, api_err := getNamespaceUsage(t, res[i].Name)
+		if api_err != nil {
+			return nil, api_err
+		}
+		res[i].Usage = usage
+	}
+	return res, nil
+}
+
+func getNamespaceUsage(t *sql.Tx, name string) (models.NamespaceUsage, *models.APIError) {
+	var usage models.NamespaceUsage
+	f := Filters{}
+	(&f).AddEq(db.DISPATCH_TABLE_NAMESPACE, name)
+	n, err := CountEntities(t, db.DISPATCH_TABLE, f)
+	if err != nil {
+		return usage, err
+	}
+	usage.Dispatches = n
+	template := fmt.Sprintf(
+		"SELECT COALESCE(SUM(%s), 0) FROM %s %s",
+		db.ASSET_TABLE_SIZE,
+		db.ASSET_TABLE,
+		generateWhereString([]string{db.ASSET_TABLE_NAMESPACE}),
+	)
+	stmt, db_err := prepareStmt(t, template)
+	if db_err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", db_err.Error()))
+		return usage, models.NewGenericServerError(db_err)
+	}
+	if db_err = stmt.QueryRow(name).Scan(&usage.AssetBytes); db_err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", db_err.Error()))
+		return usage, models.NewGenericServerError(db_err)
+	}
+	return usage, nil
+}
+
+// Namespaces with their quotas and current usage
+func GetNamespaces(t *sql.Tx) ([]models.Namespace, *models.APIError) {
+	return getNamespaces(t, Filters{})
+}
+
+func GetNamespace(t *sql.Tx, name string) (models.Namespace, *models.APIError) {
+	f := Filters{}
+	(&f).AddEq(db.NAMESPACES_TABLE_NAME, name)
+	res, err := getNamespaces(t, f)
+	if err != nil {
+		return models.Namespace{}, err
+	}
+	if len(res) == 0 {
+		return models.Namespace{}, models.NewNotFoundError(fmt.Errorf("Namespace %s not found", name))
+	}
+	return res[0], nil
+}
+
+// Set the quotas of the namespace, creating it if necessary
+func UpdateNamespace(t *sql.Tx, name string, req *models.NamespaceUpdateRequest) (models.Namespace, *models.APIError) {
+	if err := ensureNamespace(t, name); err != nil {
+		return models.Namespace{}, err
+	}
+	update := []KeyValue{
+		{Key: db.NAMESPACES_TABLE_MAX_DISPATCHES, Value: req.MaxDispatches},
+		{Key: db.NAMESPACES_TABLE_MAX_ASSET_BYTES, Value: req.MaxAssetBytes},
+	}
+	where := []KeyValue{{Key: db.NAMESPACES_TABLE_NAME, Value: name}}
+	if err := UpdateTable(t, db.NAMESPACES_TABLE, update, where); err != nil {
+		return models.Namespace{}, err
+	}
+	return GetNamespace(t, name)
+}
+
+// Called after rows are written so that the usage includes them; the
+// caller rolls back on error
+func checkNamespaceQuota(t *sql.Tx, name string) *models.APIError {
+	ns, err := GetNamespace(t, name)
+	if err != nil {
+		return err
+	}
+	if ns.MaxDispatches > 0 && ns.Usage.Dispatches > ns.MaxDispatches {
+		return models.NewQuotaExceededError(fmt.Errorf("Namespace %s is limited to %d dispatches", name, ns.MaxDispatches))
+	}
+	if ns.MaxAssetBytes > 0 && ns.Usage.AssetBytes > ns.MaxAssetBytes {
+		return models.NewQuotaExceededError(fmt.Errorf("Namespace %s is limited to %d bytes of assets", name, ns.MaxAssetBytes))
+	}
+	return nil
+}
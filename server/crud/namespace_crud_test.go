@@ -0,0 +1,69 @@
+package crud
+
+import (
+	"context"
+	"testing"
+
+	"github.com/casey/govalent/server/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamespaces(t *testing.T) {
+	config := newMockConfig(t)
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	defer tx.Rollback()
+
+	// The same key is registered separately in each namespace
+	for _, ns := range []string{"team-a", "team-b"} {
+		created, err := CreateAssets(context.Background(), &config, tx, ns, []models.AssetPublicSchema{newMockAsset("shared/inputs", 10)})
+		if err != nil {
+			t.Fatalf("Error inserting assets: %v", err)
+		}
+		assert.Contains(t, created[0].GetPublicUri(&config), "/"+ns+"/shared/inputs")
+	}
+	ents, _ := GetAssetEntitiesByPrefix(tx, "team-a", "shared", 10, 0)
+	assert.Equal(t, 1, len(ents))
+	ents, _ = GetAssetEntitiesByPrefix(tx, models.DEFAULT_NAMESPACE, "shared", 10, 0)
+	assert.Equal(t, 0, len(ents))
+
+	a := newMockDispatch(nil, nil)
+	a.Metadata.Namespace = "team-a"
+	if err := ImportManifest(context.Background(), &config, tx, &a); err != nil {
+		t.Fatalf("Error importing manifest: %v", err)
+	}
+	_, err := GetDispatchOwner(tx, "team-b", a.Metadata.DispatchId)
+	assert.Equal(t, 404, err.StatusCode)
+	_, err = GetDispatchOwner(tx, "team-a", a.Metadata.DispatchId)
+	assert.Nil(t, err)
+	res, _ := SearchDispatches(tx, DispatchQuery{Namespace: "team-b", Count: 10})
+	assert.Equal(t, 0, res.Total)
+	res, _ = SearchDispatches(tx, DispatchQuery{Namespace: "team-a", Count: 10})
+	assert.Equal(t, 1, res.Total)
+	assert.Equal(t, "team-a", res.Records[0].Namespace)
+
+	// Quotas
+	ns, err := UpdateNamespace(tx, "team-a", &models.NamespaceUpdateRequest{MaxDispatches: 1, MaxAssetBytes: 15})
+	if err != nil {
+		t.Fatalf("Error updating namespace: %v", err)
+	}
+	assert.Equal(t, models.NamespaceUsage{Dispatches: 1, AssetBytes: 10}, ns.Usage)
+	b := newMockDispatch(nil, nil)
+	b.Metadata.Namespace = "team-a"
+	err = ImportManifest(context.Background(), &config, tx, &b)
+	assert.Equal(t, models.ERROR_CODE_QUOTA_EXCEEDED, err.Code)
+	_, err = CreateAssets(context.Background(), &config, tx, "team-a", []models.AssetPublicSchema{newMockAsset("big", 6)})
+	assert.Equal(t, models.ERROR_CODE_QUOTA_EXCEEDED, err.Code)
+
+	all, _ := GetNamespaces(tx)
+	names := make([]string, len(all))
+	for i, item := range all {
+		names[i] = item.Name
+	}
+	assert.Equal(t, []string{"team-a", "team-b"}, names)
+	_, err = GetNamespace(tx, "missing")
+	assert.Equal(t, 404, err.StatusCode)
+}
@@ -0,0 +1,103 @@
+package crud
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/casey/govalent/server/db"
+	"github.com/casey/govalent/server/models"
+)
+
+// Number of dispatches or electrons in a namespace with a given status
+type StatusCount struct {
+	Namespace string
+	Status    string
+	Count     int
+}
+
+func queryStatusCounts(t *sql.Tx, template string) ([]StatusCount, *models.APIError) {
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+	rows, err := stmt.Query()
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+	defer rows.Close()
+	res := make([]StatusCount, 0)
+	for rows.Next() {
+		var item StatusCount
+		if err := rows.Scan(&item.Namespace, &item.Status, &item.Count); err != nil {
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
+			return nil, models.NewGenericServerError(err)
+		}
+		res = append(res, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, models.NewGenericServerError(err)
+	}
+	return res, nil
+}
+
+func CountDispatchesByStatus(t *sql.Tx) ([]StatusCount, *models.APIError) {
+	template := fmt.Sprintf(
+		"SELECT %[1]s, %[2]s, COUNT(*) FROM %[3]s GROUP BY %[1]s, %[2]s",
+		db.DISPATCH_TABLE_NAMESPACE,
+		db.DISPATCH_TABLE_STATUS,
+		db.DISPATCH_TABLE,
+	)
+	return queryStatusCounts(t, template)
+}
+
+// Electrons are counted in the namespace of their dispatch
+func CountElectronsByStatus(t *sql.Tx) ([]StatusCount, *models.APIError) {
+	template := fmt.Sprintf(
+		"SELECT d.%[1]s, COALESCE(e.%[2]s, ''), COUNT(*) FROM %[3]s e JOIN %[4]s d ON e.%[5]s = d.%[6]s GROUP BY d.%[1]s, e.%[2]s",
+		db.DISPATCH_TABLE_NAMESPACE,
+		db.ELECTRON_TABLE_STATUS,
+		db.ELECTRON_TABLE,
+		db.DISPATCH_TABLE,
+		db.ELECTRON_TABLE_DISPATCH_ID,
+		db.DISPATCH_TABLE_ID,
+	)
+	return queryStatusCounts(t, template)
+}
+
+// Total declared size of registered assets by namespace
+func SumAssetBytes(t *sql.Tx) (map[string]int64, *models.APIError) {
+	template := fmt.Sprintf(
+		"SELECT %[1]s, COALESCE(SUM(%[2]s), 0) FROM %[3]s GROUP BY %[1]s",
+		db.ASSET_TABLE_NAMESPACE,
+		db.ASSET_TABLE_SIZE,
+		db.ASSET_TABLE,
+	)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+	rows, err := stmt.Query()
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+	defer rows.Close()
+	res := make(map[string]int64)
+	for rows.Next() {
+		var ns string
+		var n int64
+		if err := rows.Scan(&ns, &n); err != nil {
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
+			return nil, models.NewGenericServerError(err)
+		}
+		res[ns] = n
+	}
+	if err := rows.Err(); err != nil {
+		return nil, models.NewGenericServerError(err)
+	}
+	return res, nil
+}
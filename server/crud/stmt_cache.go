@@ -0,0 +1,193 @@
+package crud
+
+import (
+	"container/list"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// Maximum number of prepared statements kept by the default cache
+const DEFAULT_STMT_CACHE_SIZE = 256
+
+// Prepared statements keyed by SQL template
+//
+// Statements are prepared on the *sql.DB and bound to each transaction
+// using tx.Stmt. A miss is served by preparing on the transaction
+// itself, since the pool may have no other connection to spare; the
+// template is then prepared on the pool in the background and cached
+// for later transactions. The cache is bounded; the least recently
+// used statement is closed when a new template would exceed the limit.
+type StmtCache struct {
+	db       *sql.DB
+	capacity int
+
+	mu      sync.Mutex
+	stmts   map[string]*list.Element
+	lru     *list.List
+	pending map[string]bool
+	closed  bool
+	wg      sync.WaitGroup
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+	evicted atomic.Uint64
+}
+
+type stmtCacheEntry struct {
+	template string
+	stmt     *sql.Stmt
+}
+
+type StmtCacheStats struct {
+	Hits      uint64  `json:"hits"`
+	Misses    uint64  `json:"misses"`
+	Evictions uint64  `json:"evictions"`
+	Size      int     `json:"size"`
+	HitRate   float64 `json:"hit_rate"`
+}
+
+func NewStmtCache(d *sql.DB, capacity int) *StmtCache {
+	if capacity <= 0 {
+		capacity = DEFAULT_STMT_CACHE_SIZE
+	}
+	return &StmtCache{
+		db:       d,
+		capacity: capacity,
+		stmts:    make(map[string]*list.Element),
+		lru:      list.New(),
+		pending:  make(map[string]bool),
+	}
+}
+
+// The cached statement for template, if any. On a miss the template is
+// prepared on the pool in the background.
+func (c *StmtCache) lookup(template string) (*sql.Stmt, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.stmts[template]; ok {
+		c.lru.MoveToFront(elem)
+		c.hits.Add(1)
+		return elem.Value.(*stmtCacheEntry).stmt, true
+	}
+	c.misses.Add(1)
+	if !c.closed && !c.pending[template] {
+		c.pending[template] = true
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			if _, err := c.prepare(template); err != nil {
+				slog.Debug(fmt.Sprintf("Error caching statement: %s", err.Error()))
+			}
+		}()
+	}
+	return nil, false
+}
+
+// Prepare template on the pool and cache it. Blocks until the pool has
+// a free connection, so must not be called while holding a transaction.
+func (c *StmtCache) prepare(template string) (*sql.Stmt, error) {
+	stmt, err := c.db.Prepare(template)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, template)
+	if err != nil {
+		return nil, err
+	}
+	if c.closed {
+		stmt.Close()
+		return nil, errors.New("Statement cache closed")
+	}
+	if elem, ok := c.stmts[template]; ok {
+		stmt.Close()
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*stmtCacheEntry).stmt, nil
+	}
+	c.stmts[template] = c.lru.PushFront(&stmtCacheEntry{template: template, stmt: stmt})
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		entry := oldest.Value.(*stmtCacheEntry)
+		c.lru.Remove(oldest)
+		delete(c.stmts, entry.template)
+		// Connections still using the statement keep it alive until
+		// they are released
+		entry.stmt.Close()
+		c.evicted.Add(1)
+	}
+	return stmt, nil
+}
+
+// Wait for background preparations to finish
+func (c *StmtCache) wait() {
+	c.wg.Wait()
+}
+
+func (c *StmtCache) Stats() StmtCacheStats {
+	c.mu.Lock()
+	size := c.lru.Len()
+	c.mu.Unlock()
+	stats := StmtCacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evicted.Load(),
+		Size:      size,
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// Close all cached statements; statements prepared in the background
+// after Close are discarded
+func (c *StmtCache) Close() {
+	c.mu.Lock()
+	c.closed = true
+	for _, elem := range c.stmts {
+		elem.Value.(*stmtCacheEntry).stmt.Close()
+	}
+	c.stmts = make(map[string]*list.Element)
+	c.lru.Init()
+	c.mu.Unlock()
+	c.wg.Wait()
+}
+
+// Statement caches keyed by the *sql.DB they prepare on
+var stmtCaches sync.Map
+
+// Install c as the statement cache for transactions on its *sql.DB
+// begun with BeginTx
+func SetStmtCache(c *StmtCache) {
+	stmtCaches.Store(c.db, c)
+}
+
+// Stop caching statements for d
+func RemoveStmtCache(d *sql.DB) {
+	stmtCaches.Delete(d)
+}
+
+func GetStmtCache(d *sql.DB) *StmtCache {
+	if c, ok := stmtCaches.Load(d); ok {
+		return c.(*StmtCache)
+	}
+	return nil
+}
+
+// Prepare a statement bound to t, reusing a statement cached for its
+// pool when there is one
+func prepareStmt(t *sql.Tx, template string) (*sql.Stmt, error) {
+	pool := txPool(t)
+	if pool == nil {
+		return t.Prepare(template)
+	}
+	c := GetStmtCache(pool)
+	if c == nil {
+		return t.Prepare(template)
+	}
+	if stmt, ok := c.lookup(template); ok {
+		return t.Stmt(stmt), nil
+	}
+	return t.Prepare(template)
+}
@@ -0,0 +1,173 @@
+package crud
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/db"
+	"github.com/casey/govalent/server/models"
+)
+
+// The cache prepares statements on connections other than the one held
+// by the transaction, so every connection must see the same database.
+func newMockFileDB(t *testing.T) *sql.DB {
+	c := common.Config{
+		Dsn:  fmt.Sprintf("file:%s", filepath.Join(t.TempDir(), "test.db")),
+		Port: common.DEFAULT_PORT,
+	}
+	d, err := db.GetDB(&c)
+	if err != nil {
+		t.Fatalf("Error establishing DB connection: %v", err)
+	}
+	if err := db.EmitDDL(d); err != nil {
+		t.Fatalf("Error emitting DDL: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestStmtCache(t *testing.T) {
+	config := newMockConfig(t)
+	d := newMockFileDB(t)
+	cache := NewStmtCache(d, DEFAULT_STMT_CACHE_SIZE)
+	SetStmtCache(cache)
+	t.Cleanup(func() {
+		RemoveStmtCache(d)
+		cache.Close()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dispatch := newMockDispatch(nil, nil)
+	dispatch.Lattice.TransportGraph = newMockGraph(3)
+	tx, db_err := BeginTx(ctx, d)
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	err := ImportManifest(context.Background(), &config, tx, &dispatch)
+	if err != nil {
+		t.Fatalf("Error importing manifest: %v", err)
+	}
+	tx.Commit()
+
+	for i := 0; i < 3; i++ {
+		tx, db_err := BeginTx(ctx, d)
+		if db_err != nil {
+			t.Fatalf("Error starting transaction: %v", db_err)
+		}
+		_, err = UpdateElectronMetadata(tx, dispatch.Metadata.DispatchId, i, models.ElectronStatusUpdate{Status: "RUNNING"})
+		if err != nil {
+			t.Fatalf("Error updating electron: %v", err)
+		}
+		tx.Commit()
+		cache.wait()
+	}
+
+	stats := cache.Stats()
+	if stats.Misses == 0 {
+		t.Fatalf("Expected cache misses")
+	}
+	// The second and third updates reuse the first statement
+	if stats.Hits < 2 {
+		t.Fatalf("Expected at least %d cache hits, got %d", 2, stats.Hits)
+	}
+	if stats.HitRate <= 0 || stats.HitRate > 1 {
+		t.Fatalf("Invalid hit rate %f", stats.HitRate)
+	}
+
+	tx, db_err = BeginTx(ctx, d)
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	electrons, err := GetAllElectrons(&config, tx, dispatch.Metadata.DispatchId, true)
+	if err != nil {
+		t.Fatalf("Error retrieving electrons: %v", err)
+	}
+	for _, e := range electrons {
+		if e.Metadata.Status != "RUNNING" {
+			t.Fatalf("Expected status %s, actual %s", "RUNNING", e.Metadata.Status)
+		}
+	}
+	tx.Rollback()
+}
+
+func TestStmtCacheEviction(t *testing.T) {
+	d := newMockFileDB(t)
+	cache := NewStmtCache(d, 2)
+	defer cache.Close()
+
+	for _, template := range []string{"SELECT 1", "SELECT 2", "SELECT 3", "SELECT 3"} {
+		if _, err := cache.prepare(template); err != nil {
+			t.Fatalf("Error preparing statement: %v", err)
+		}
+	}
+	stats := cache.Stats()
+	if stats.Size != 2 {
+		t.Fatalf("Expected %d cached statements, got %d", 2, stats.Size)
+	}
+	if stats.Evictions != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+// A miss must not wait for a second connection while the transaction
+// holds the only one
+func TestStmtCacheSingleConnection(t *testing.T) {
+	d := newMockFileDB(t)
+	d.SetMaxOpenConns(1)
+	cache := NewStmtCache(d, DEFAULT_STMT_CACHE_SIZE)
+	SetStmtCache(cache)
+	t.Cleanup(func() {
+		RemoveStmtCache(d)
+		cache.Close()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for i := 0; i < 2; i++ {
+		tx, err := BeginTx(ctx, d)
+		if err != nil {
+			t.Fatalf("Error starting transaction: %v", err)
+		}
+		if _, api_err := CountEntities(tx, db.DISPATCH_TABLE, Filters{}); api_err != nil {
+			t.Fatalf("Error counting dispatches: %v", api_err)
+		}
+		tx.Rollback()
+		cache.wait()
+	}
+	if stats := cache.Stats(); stats.Misses != 1 || stats.Hits != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+// Transactions on a pool without a cache of its own prepare their
+// statements directly
+func TestStmtCacheOtherPool(t *testing.T) {
+	d := newMockFileDB(t)
+	cache := NewStmtCache(d, DEFAULT_STMT_CACHE_SIZE)
+	SetStmtCache(cache)
+	t.Cleanup(func() {
+		RemoveStmtCache(d)
+		cache.Close()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	other := newMockFileDB(t)
+	tx, err := BeginTx(ctx, other)
+	if err != nil {
+		t.Fatalf("Error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if _, api_err := CountEntities(tx, db.DISPATCH_TABLE, Filters{}); api_err != nil {
+		t.Fatalf("Error counting dispatches: %v", api_err)
+	}
+	if stats := cache.Stats(); stats.Misses != 0 || stats.Hits != 0 {
+		t.Fatalf("Expected the cache of another pool to be unused, got %+v", stats)
+	}
+}
@@ -0,0 +1,129 @@
+package crud
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/casey/govalent/server/db"
+	"github.com/casey/govalent/server/models"
+)
+
+var SUBMISSION_ENTITY_KEYS = []string{
+	db.SUBMISSIONS_TABLE_DISPATCH_ID,
+	db.SUBMISSIONS_TABLE_IDEMPOTENCY_KEY,
+	db.SUBMISSIONS_TABLE_DIGEST,
+	db.SUBMISSIONS_TABLE_CREATED_AT,
+}
+
+// Records the manifest digest of an imported dispatch so that a
+// resubmission can be told apart from a retry
+type SubmissionEntity struct {
+	DispatchId     string
+	IdempotencyKey sql.NullString
+	Digest         string
+	CreatedAt      time.Time
+}
+
+// Idempotency keys are stored qualified by the namespace and owner of
+// the dispatch, so that callers cannot see or collide with each
+// other's keys
+func scopedIdempotencyKey(idempotency_key string, owner string, namespace string) string {
+	scoped, _ := json.Marshal([]string{namespace, owner, idempotency_key})
+	return string(scoped)
+}
+
+func NewSubmissionEntity(dispatch_id string, idempotency_key string, owner string, namespace string, digest string) SubmissionEntity {
+	scoped := sql.NullString{}
+	if len(idempotency_key) > 0 {
+		scoped = sql.NullString{String: scopedIdempotencyKey(idempotency_key, owner, namespace), Valid: true}
+	}
+	return SubmissionEntity{
+		DispatchId:     dispatch_id,
+		IdempotencyKey: scoped,
+		Digest:         digest,
+		CreatedAt:      time.Now().UTC(),
+	}
+}
+
+func (s *SubmissionEntity) Fields() []string {
+	return SUBMISSION_ENTITY_KEYS
+}
+
+func (s *SubmissionEntity) Values() []any {
+	return []any{
+		s.DispatchId,
+		s.IdempotencyKey,
+		s.Digest,
+		s.CreatedAt,
+	}
+}
+
+func (s *SubmissionEntity) Fieldrefs() []any {
+	return []any{
+		&s.DispatchId,
+		&s.IdempotencyKey,
+		&s.Digest,
+		&s.CreatedAt,
+	}
+}
+
+func (s *SubmissionEntity) Joins() []JoinCondition {
+	return []JoinCondition{}
+}
+
+// A key that was already used for another dispatch is a 409
+func CreateSubmission(t *sql.Tx, s *SubmissionEntity) *models.APIError {
+	n, err := InsertEntities(t, db.SUBMISSIONS_TABLE, []DBEntity{s})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.NewConflictError(
+			fmt.Errorf("Dispatch %s or its Idempotency-Key was already submitted", s.DispatchId),
+		)
+	}
+	return nil
+}
+
+func getSubmissionEntity(t *sql.Tx, f Filters) (SubmissionEntity, bool, *models.APIError) {
+	template := generateSelectTemplate(
+		db.SUBMISSIONS_TABLE,
+		SUBMISSION_ENTITY_KEYS,
+		(&f).RenderTemplate(),
+		db.SUBMISSIONS_TABLE_CREATED_AT,
+		true,
+		false,
+	)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return SubmissionEntity{}, false, models.NewGenericServerError(err)
+	}
+	s := SubmissionEntity{}
+	err = stmt.QueryRow((&f).RenderValues()...).Scan((&s).Fieldrefs()...)
+	if err == sql.ErrNoRows {
+		return SubmissionEntity{}, false, nil
+	}
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
+		return SubmissionEntity{}, false, models.NewGenericServerError(err)
+	}
+	return s, true, nil
+}
+
+// The bool result is false if the dispatch has no recorded submission
+func GetSubmission(t *sql.Tx, dispatch_id string) (SubmissionEntity, bool, *models.APIError) {
+	f := Filters{}
+	(&f).AddEq(db.SUBMISSIONS_TABLE_DISPATCH_ID, dispatch_id)
+	return getSubmissionEntity(t, f)
+}
+
+// Only keys used by owner in namespace are found
+func GetSubmissionByIdempotencyKey(t *sql.Tx, idempotency_key string, owner string, namespace string) (SubmissionEntity, bool, *models.APIError) {
+	f := Filters{}
+	(&f).AddEq(db.SUBMISSIONS_TABLE_IDEMPOTENCY_KEY, scopedIdempotencyKey(idempotency_key, owner, namespace))
+	return getSubmissionEntity(t, f)
+}
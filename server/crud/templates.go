@@ -3,17 +3,39 @@ package crud
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 // SQL templates
 
+// Conjunction of predicates; the Or filters contribute a single
+// disjunction "(or[0]) OR (or[1]) ..." of their own conjunctions
 type Filters struct {
 	Eq     []KeyValue
 	Like   []KeyValue
+	In     []KeyValues
+	Range  []RangeFilter
+	Null   []string
+	Or     []Filters
 	Limit  int
 	Offset int
 }
 
+type KeyValues struct {
+	Key    string
+	Values []any
+}
+
+// Inclusive bounds; a nil bound is unbounded. Time ranges are compared
+// using julianday() so that timestamps in different offsets compare
+// correctly.
+type RangeFilter struct {
+	Key  string
+	Min  any
+	Max  any
+	Time bool
+}
+
 func (f *Filters) AddLike(key string, val string) {
 	f.Like = append(f.Like, KeyValue{Key: key, Value: val})
 }
@@ -22,16 +44,100 @@ func (f *Filters) AddEq(key string, val any) {
 	f.Eq = append(f.Eq, KeyValue{Key: key, Value: val})
 }
 
-// The values must be generated in the same order as the parameters in the template
-func (f *Filters) RenderValues() []any {
-	vals := make([]any, len(f.Eq)+len(f.Like))
-	for i, item := range f.Eq {
-		vals[i] = item.Value
+// Matches rows whose key is one of vals. An empty vals matches nothing.
+func (f *Filters) AddIn(key string, vals []any) {
+	f.In = append(f.In, KeyValues{Key: key, Values: vals})
+}
+
+func (f *Filters) AddRange(key string, min any, max any) {
+	f.Range = append(f.Range, RangeFilter{Key: key, Min: min, Max: max})
+}
+
+// Either bound may be nil
+func (f *Filters) AddTimeRange(key string, min *time.Time, max *time.Time) {
+	r := RangeFilter{Key: key, Time: true}
+	if min != nil {
+		r.Min = *min
+	}
+	if max != nil {
+		r.Max = *max
+	}
+	f.Range = append(f.Range, r)
+}
+
+func (f *Filters) AddIsNull(key string) {
+	f.Null = append(f.Null, key)
+}
+
+// Matches rows satisfying any of alternatives
+func (f *Filters) AddOr(alternatives ...Filters) {
+	f.Or = append(f.Or, alternatives...)
+}
+
+// Escape the LIKE wildcards in s so that it is matched literally
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// Predicates of the WHERE clause and their parameters, in order
+func (f *Filters) predicates() ([]string, []any) {
+	var preds []string
+	var vals []any
+	for _, item := range f.Eq {
+		preds = append(preds, fmt.Sprintf("%s = ?", item.Key))
+		vals = append(vals, item.Value)
+	}
+	for _, item := range f.Like {
+		preds = append(preds, fmt.Sprintf(`%s LIKE ? ESCAPE '\'`, item.Key))
+		vals = append(vals, item.Value)
+	}
+	for _, item := range f.In {
+		if len(item.Values) == 0 {
+			preds = append(preds, "0")
+			continue
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(item.Values)), ", ")
+		preds = append(preds, fmt.Sprintf("%s IN (%s)", item.Key, placeholders))
+		vals = append(vals, item.Values...)
+	}
+	for _, item := range f.Range {
+		col, param := item.Key, "?"
+		if item.Time {
+			col, param = fmt.Sprintf("julianday(%s)", item.Key), "julianday(?)"
+		}
+		if item.Min != nil {
+			preds = append(preds, fmt.Sprintf("%s >= %s", col, param))
+			vals = append(vals, item.Min)
+		}
+		if item.Max != nil {
+			preds = append(preds, fmt.Sprintf("%s <= %s", col, param))
+			vals = append(vals, item.Max)
+		}
 	}
-	n := len(f.Eq)
-	for i, item := range f.Like {
-		vals[n+i] = item.Value
+	for _, key := range f.Null {
+		preds = append(preds, fmt.Sprintf("%s IS NULL", key))
 	}
+	if len(f.Or) > 0 {
+		alternatives := make([]string, len(f.Or))
+		for i := range f.Or {
+			sub_preds, sub_vals := (&f.Or[i]).predicates()
+			if len(sub_preds) == 0 {
+				alternatives[i] = "(1)"
+			} else {
+				alternatives[i] = fmt.Sprintf("(%s)", strings.Join(sub_preds, " AND "))
+			}
+			vals = append(vals, sub_vals...)
+		}
+		preds = append(preds, fmt.Sprintf("(%s)", strings.Join(alternatives, " OR ")))
+	}
+	return preds, vals
+}
+
+// The values must be generated in the same order as the parameters in the template
+func (f *Filters) RenderValues() []any {
+	_, vals := f.predicates()
 	if f.Limit > 0 {
 		vals = append(vals, f.Limit)
 		vals = append(vals, f.Offset)
@@ -40,26 +146,11 @@ func (f *Filters) RenderValues() []any {
 }
 
 func (f *Filters) RenderTemplate() string {
-	var where_clause_builder strings.Builder
-	if len(f.Eq) > 0 || len(f.Like) > 0 {
-		where_clause_builder.WriteString("WHERE ")
-	}
-	if len(f.Eq) > 0 {
-		for i := 0; i+1 < len(f.Eq); i++ {
-			where_clause_builder.WriteString(fmt.Sprintf("%s = ? AND ", f.Eq[i].Key))
-		}
-		where_clause_builder.WriteString(fmt.Sprintf("%s = ?", f.Eq[len(f.Eq)-1].Key))
-		if len(f.Like) > 0 {
-			where_clause_builder.WriteString(" AND ")
-		}
+	preds, _ := f.predicates()
+	if len(preds) == 0 {
+		return ""
 	}
-	if len(f.Like) > 0 {
-		for i := 0; i+1 < len(f.Like); i++ {
-			where_clause_builder.WriteString(fmt.Sprintf("%s LIKE ? AND ", f.Like[i].Key))
-		}
-		where_clause_builder.WriteString(fmt.Sprintf("%s LIKE ?", f.Like[len(f.Like)-1].Key))
-	}
-	return where_clause_builder.String()
+	return fmt.Sprintf("WHERE %s", strings.Join(preds, " AND "))
 }
 
 func generateUpdateSQLTemplate(table string, update_cols []string, where_cols []string) (string, error) {
@@ -76,18 +167,33 @@ func generateUpdateSQLTemplate(table string, update_cols []string, where_cols []
 }
 
 func generateInsertTemplate(table string, columns []string) (string, error) {
+	return generateBatchInsertTemplate(table, columns, 1), nil
+}
+
+// Multi-row insert: INSERT OR IGNORE INTO table (a, b) VALUES (?, ?), (?, ?), ...
+func generateBatchInsertTemplate(table string, columns []string, n_rows int) string {
 	var cols_clause strings.Builder
-	var vals_clause strings.Builder
+	var row_builder strings.Builder
 	cols_clause.WriteString("(")
-	vals_clause.WriteString("(")
+	row_builder.WriteString("(")
 	for i := 0; i+1 < len(columns); i++ {
 		cols_clause.WriteString(fmt.Sprintf("%s, ", columns[i]))
-		vals_clause.WriteString("?, ")
+		row_builder.WriteString("?, ")
 	}
 	cols_clause.WriteString(fmt.Sprintf("%s)", columns[len(columns)-1]))
-	vals_clause.WriteString("?)")
+	row_builder.WriteString("?)")
+
+	row := row_builder.String()
+	var vals_clause strings.Builder
+	vals_clause.Grow(n_rows * (len(row) + 2))
+	for i := 0; i < n_rows; i++ {
+		if i > 0 {
+			vals_clause.WriteString(", ")
+		}
+		vals_clause.WriteString(row)
+	}
 
-	return fmt.Sprintf("INSERT OR IGNORE INTO %s %s VALUES %s", table, cols_clause.String(), vals_clause.String()), nil
+	return fmt.Sprintf("INSERT OR IGNORE INTO %s %s VALUES %s", table, cols_clause.String(), vals_clause.String())
 }
 
 func generateColumnString(columns []string) string {
@@ -150,25 +256,32 @@ func generateSelectJoinTemplate(
 	filter_template string,
 	sort_key string,
 	ascending bool,
-	limit int,
-	offset int,
+	with_limits bool,
 ) string {
 
 	sort_order := "ASC"
 	if !ascending {
 		sort_order = "DESC"
 	}
-	return fmt.Sprintf(
-		"SELECT %s FROM %s %s %s ORDER BY %s %s LIMIT %d OFFSET %d",
+	template := fmt.Sprintf(
+		"SELECT %s FROM %s %s %s ORDER BY %s %s",
 		generateColumnString(attributes),
 		table,
 		generateJoinString(joins),
 		filter_template,
 		sort_key,
 		sort_order,
-		limit,
-		offset,
 	)
+	// As in generateSelectTemplate, the limit and offset are bound
+	// after the filter values so that every page shares one statement
+	if with_limits {
+		template = strings.Join([]string{template, "LIMIT ? OFFSET ?"}, " ")
+	}
+	return template
+}
+
+func generateCountTemplate(table string, filter_template string) string {
+	return fmt.Sprintf("SELECT COUNT(*) FROM %s %s", table, filter_template)
 }
 
 func generateDeleteTemplate(table string, filter_template string) string {
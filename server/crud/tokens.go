@@ -0,0 +1,156 @@
+package crud
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+
+	"github.com/casey/govalent/server/db"
+	"github.com/casey/govalent/server/models"
+)
+
+var API_TOKEN_ENTITY_KEYS = []string{
+	db.API_TOKENS_TABLE_USER,
+	db.API_TOKENS_TABLE_NAME,
+	db.API_TOKENS_TABLE_HASH,
+	db.API_TOKENS_TABLE_ROLE,
+	db.API_TOKENS_TABLE_NAMESPACE,
+	db.API_TOKENS_TABLE_CREATED_AT,
+}
+
+// Mapped to a row in the api_tokens table. Only the digest of the
+// token is stored.
+type APITokenEntity struct {
+	token *models.APIToken
+	hash  string
+}
+
+func (e *APITokenEntity) Fields() []string {
+	return API_TOKEN_ENTITY_KEYS
+}
+
+func (e *APITokenEntity) Values() []any {
+	return []any{
+		e.token.User,
+		e.token.Name,
+		e.hash,
+		e.token.Role,
+		e.token.Namespace,
+		e.token.CreatedAt,
+	}
+}
+
+func (e *APITokenEntity) Fieldrefs() []any {
+	return []any{
+		&e.token.User,
+		&e.token.Name,
+		&e.hash,
+		&e.token.Role,
+		&e.token.Namespace,
+		&e.token.CreatedAt,
+	}
+}
+
+func (e *APITokenEntity) Joins() []JoinCondition {
+	return []JoinCondition{}
+}
+
+// Tokens are random and long, so an unsalted digest suffices
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Store the digest of tok.Token and set tok.Id
+func CreateAPIToken(t *sql.Tx, tok *models.APIToken) *models.APIError {
+	ent := APITokenEntity{token: tok, hash: HashToken(tok.Token)}
+	template := generateBatchInsertTemplate(db.API_TOKENS_TABLE, (&ent).Fields(), 1)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return models.NewGenericServerError(err)
+	}
+	res, err := stmt.Exec((&ent).Values()...)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error creating token: %s\n", err.Error()))
+		return dbError(err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return models.NewGenericServerError(err)
+	}
+	if n == 0 {
+		return models.NewConflictError(fmt.Errorf("Token already exists"))
+	}
+	tok.Id, err = res.LastInsertId()
+	if err != nil {
+		return models.NewGenericServerError(err)
+	}
+	return nil
+}
+
+func getAPITokens(t *sql.Tx, f Filters) ([]models.APIToken, *models.APIError) {
+	columns := append([]string{db.API_TOKENS_TABLE_ID}, API_TOKEN_ENTITY_KEYS...)
+	template := generateSelectTemplate(db.API_TOKENS_TABLE, columns, (&f).RenderTemplate(), db.API_TOKENS_TABLE_ID, true, false)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+	rows, err := stmt.Query((&f).RenderValues()...)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+	defer rows.Close()
+	res := make([]models.APIToken, 0)
+	for rows.Next() {
+		tok := models.APIToken{}
+		ent := APITokenEntity{token: &tok}
+		err := rows.Scan(append([]any{&tok.Id}, (&ent).Fieldrefs()...)...)
+		if err != nil {
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
+			return nil, models.NewGenericServerError(err)
+		}
+		res = append(res, tok)
+	}
+	return res, nil
+}
+
+// All tokens, without their secrets
+func GetAPITokens(t *sql.Tx) ([]models.APIToken, *models.APIError) {
+	return getAPITokens(t, Filters{})
+}
+
+// The principal holding token; the bool result is false if the token
+// is unknown
+func GetPrincipalByToken(t *sql.Tx, token string) (models.Principal, bool, *models.APIError) {
+	f := Filters{}
+	(&f).AddEq(db.API_TOKENS_TABLE_HASH, HashToken(token))
+	toks, err := getAPITokens(t, f)
+	if err != nil || len(toks) == 0 {
+		return models.Principal{}, false, err
+	}
+	p := models.Principal{
+		TokenId:   toks[0].Id,
+		User:      toks[0].User,
+		Role:      toks[0].Role,
+		Namespace: toks[0].Namespace,
+	}
+	return p, true, nil
+}
+
+func DeleteAPIToken(t *sql.Tx, id int64) *models.APIError {
+	f := Filters{}
+	(&f).AddEq(db.API_TOKENS_TABLE_ID, id)
+	n, err := DeleteEntities(t, db.API_TOKENS_TABLE, f)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.NewNotFoundError(fmt.Errorf("Token %d not found", id))
+	}
+	return nil
+}
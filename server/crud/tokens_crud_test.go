@@ -0,0 +1,160 @@
+package crud
+
+import (
+	"testing"
+	"time"
+
+	"github.com/casey/govalent/server/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPITokens(t *testing.T) {
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	defer tx.Rollback()
+
+	tok := models.APIToken{User: "alice", Name: "ci", Role: models.ROLE_EXECUTOR, Token: "gvt_abc", CreatedAt: time.Now().UTC()}
+	if err := CreateAPIToken(tx, &tok); err != nil {
+		t.Fatalf("Error creating token: %v", err)
+	}
+	assert.NotZero(t, tok.Id)
+	dup := models.APIToken{User: "bob", Token: "gvt_abc", CreatedAt: time.Now().UTC()}
+	err := CreateAPIToken(tx, &dup)
+	assert.Equal(t, 409, err.StatusCode)
+
+	p, found, err := GetPrincipalByToken(tx, "gvt_abc")
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, models.Principal{TokenId: tok.Id, User: "alice", Role: models.ROLE_EXECUTOR}, p)
+	assert.True(t, p.HasScope(models.SCOPE_STATUS_WRITE))
+	assert.False(t, p.HasScope(models.SCOPE_DISPATCHES_DELETE))
+	assert.True(t, p.Owns("bob"))
+	_, found, _ = GetPrincipalByToken(tx, "gvt_abd")
+	assert.False(t, found)
+
+	// Only the digest is stored
+	var stored string
+	tx.QueryRow("SELECT token_hash FROM api_tokens WHERE id = ?", tok.Id).Scan(&stored)
+	assert.Equal(t, HashToken("gvt_abc"), stored)
+	assert.NotContains(t, stored, "abc")
+
+	tokens, _ := GetAPITokens(tx)
+	assert.Equal(t, 1, len(tokens))
+	assert.Empty(t, tokens[0].Token)
+
+	assert.Nil(t, DeleteAPIToken(tx, tok.Id))
+	_, found, _ = GetPrincipalByToken(tx, "gvt_abc")
+	assert.False(t, found)
+	assert.Equal(t, 404, DeleteAPIToken(tx, tok.Id).StatusCode)
+}
+
+func TestWebhookOwnerScope(t *testing.T) {
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	defer tx.Rollback()
+
+	mine := newMockWebhook(nil, nil, nil)
+	mine.Owner = "alice"
+	theirs := newMockWebhook(nil, nil, nil)
+	theirs.Owner = "bob"
+	for _, h := range []*models.Webhook{&mine, &theirs} {
+		if err := CreateWebhook(tx, h); err != nil {
+			t.Fatalf("Error creating webhook: %v", err)
+		}
+	}
+	dispatch := newMockDispatch(nil, nil)
+	dispatch.Metadata.Owner = "alice"
+	if err := CreateDispatchMetadata(tx, &dispatch.Metadata, &dispatch.Lattice.Metadata); err != nil {
+		t.Fatalf("Error creating dispatch: %v", err)
+	}
+	if _, err := UpdateDispatch(tx, dispatch.Metadata.DispatchId, "RUNNING", nil, nil); err != nil {
+		t.Fatalf("Error updating dispatch: %v", err)
+	}
+
+	due, _ := GetDueWebhookDeliveries(tx, time.Now().UTC(), 0)
+	assert.Equal(t, 1, len(due))
+	assert.Equal(t, mine.Id, due[0].Delivery.WebhookId)
+	hooks, _ := GetWebhooks(tx, models.DEFAULT_NAMESPACE, "", "bob")
+	assert.Equal(t, 1, len(hooks))
+	assert.Equal(t, theirs.Id, hooks[0].Id)
+}
+
+func TestAuditLog(t *testing.T) {
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	defer tx.Rollback()
+
+	entry := models.AuditEntry{
+		CreatedAt: time.Now().UTC(),
+		RequestId: "req",
+		User:      "alice",
+		TokenId:   1,
+		Role:      models.ROLE_EXECUTOR,
+		Method:    "DELETE",
+		Path:      "/api/v0/dispatches/d1",
+		Outcome:   models.AUDIT_OUTCOME_DENIED,
+		Detail:    "missing scope dispatches:delete",
+	}
+	if err := AppendAuditEntry(tx, &entry); err != nil {
+		t.Fatalf("Error appending audit entry: %v", err)
+	}
+	assert.NotZero(t, entry.Id)
+	var outcome, detail string
+	tx.QueryRow("SELECT outcome, detail FROM audit_log WHERE id = ?", entry.Id).Scan(&outcome, &detail)
+	assert.Equal(t, models.AUDIT_OUTCOME_DENIED, outcome)
+	assert.Equal(t, entry.Detail, detail)
+}
+
+func TestSearchAuditLog(t *testing.T) {
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	defer tx.Rollback()
+
+	start := time.Now().UTC()
+	entries := []models.AuditEntry{
+		{User: "alice", Action: models.AUDIT_ACTION_IMPORT_DISPATCH, Outcome: models.AUDIT_OUTCOME_SUCCEEDED, Namespace: "default", DispatchId: "d1", StatusCode: 201},
+		{User: "alice", Action: models.AUDIT_ACTION_DISPATCH_STATUS, Outcome: models.AUDIT_OUTCOME_FAILED, Namespace: "default", DispatchId: "d1", StatusCode: 400},
+		{User: "bob", Action: models.AUDIT_ACTION_DELETE_DISPATCH, Outcome: models.AUDIT_OUTCOME_SUCCEEDED, Namespace: "team", DispatchId: "d2", StatusCode: 200},
+	}
+	for i := range entries {
+		entries[i].CreatedAt = start.Add(time.Duration(i) * time.Second)
+		if err := AppendAuditEntry(tx, &entries[i]); err != nil {
+			t.Fatalf("Error appending audit entry: %v", err)
+		}
+	}
+
+	res, err := SearchAuditLog(tx, AuditQuery{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, res.Total)
+	assert.Equal(t, entries[2].Id, res.Records[0].Id)
+	assert.Equal(t, "d2", res.Records[0].DispatchId)
+	assert.Equal(t, 200, res.Records[0].StatusCode)
+
+	res, err = SearchAuditLog(tx, AuditQuery{User: "alice", DispatchId: "d1"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, res.Total)
+
+	res, err = SearchAuditLog(tx, AuditQuery{Outcome: models.AUDIT_OUTCOME_FAILED})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(res.Records))
+	assert.Equal(t, models.AUDIT_ACTION_DISPATCH_STATUS, res.Records[0].Action)
+
+	since := start.Add(time.Second)
+	res, err = SearchAuditLog(tx, AuditQuery{Since: &since, Page: 1, Count: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, res.Total)
+	assert.Equal(t, 1, len(res.Records))
+	assert.Equal(t, entries[1].Id, res.Records[0].Id)
+}
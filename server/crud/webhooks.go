@@ -0,0 +1,454 @@
+package crud
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/casey/govalent/server/db"
+	"github.com/casey/govalent/server/models"
+)
+
+// Deliveries claimed by one call to GetDueWebhookDeliveries
+const MAX_DUE_WEBHOOK_DELIVERIES = 100
+
+var WEBHOOK_ENTITY_KEYS = []string{
+	db.WEBHOOKS_TABLE_URL,
+	db.WEBHOOKS_TABLE_SECRET,
+	db.WEBHOOKS_TABLE_DISPATCH_ID,
+	db.WEBHOOKS_TABLE_EVENT_KINDS,
+	db.WEBHOOKS_TABLE_STATUSES,
+	db.WEBHOOKS_TABLE_OWNER,
+	db.WEBHOOKS_TABLE_NAMESPACE,
+	db.WEBHOOKS_TABLE_CREATED_AT,
+}
+
+// Mapped to a row in the webhooks table. The kind and status lists are
+// stored comma-separated.
+type WebhookEntity struct {
+	hook        *models.Webhook
+	event_kinds string
+	statuses    string
+}
+
+func newWebhookEntity(h *models.Webhook) WebhookEntity {
+	return WebhookEntity{
+		hook:        h,
+		event_kinds: strings.Join(h.EventKinds, ","),
+		statuses:    strings.Join(h.Statuses, ","),
+	}
+}
+
+func (e *WebhookEntity) Fields() []string {
+	return WEBHOOK_ENTITY_KEYS
+}
+
+func (e *WebhookEntity) Values() []any {
+	return []any{
+		e.hook.Url,
+		e.hook.Secret,
+		e.hook.DispatchId,
+		e.event_kinds,
+		e.statuses,
+		e.hook.Owner,
+		e.hook.Namespace,
+		e.hook.CreatedAt,
+	}
+}
+
+func (e *WebhookEntity) Fieldrefs() []any {
+	return []any{
+		&e.hook.Url,
+		&e.hook.Secret,
+		&e.hook.DispatchId,
+		&e.event_kinds,
+		&e.statuses,
+		&e.hook.Owner,
+		&e.hook.Namespace,
+		&e.hook.CreatedAt,
+	}
+}
+
+func (e *WebhookEntity) Joins() []JoinCondition {
+	return []JoinCondition{}
+}
+
+// Populate the lists after scanning
+func (e *WebhookEntity) unpack() {
+	e.hook.EventKinds = splitList(e.event_kinds)
+	e.hook.Statuses = splitList(e.statuses)
+}
+
+func splitList(s string) []string {
+	if len(s) == 0 {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+// Record h and set its Id
+func CreateWebhook(t *sql.Tx, h *models.Webhook) *models.APIError {
+	if len(h.Namespace) == 0 {
+		h.Namespace = models.DEFAULT_NAMESPACE
+	}
+	ent := newWebhookEntity(h)
+	template := generateBatchInsertTemplate(db.WEBHOOKS_TABLE, (&ent).Fields(), 1)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return models.NewGenericServerError(err)
+	}
+	res, err := stmt.Exec((&ent).Values()...)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error creating webhook: %s\n", err.Error()))
+		return dbError(err)
+	}
+	h.Id, err = res.LastInsertId()
+	if err != nil {
+		return models.NewGenericServerError(err)
+	}
+	return nil
+}
+
+// Webhooks matching f, including their secrets
+func getWebhooks(t *sql.Tx, f Filters) ([]models.Webhook, *models.APIError) {
+	columns := append([]string{db.WEBHOOKS_TABLE_ID}, WEBHOOK_ENTITY_KEYS...)
+	template := generateSelectTemplate(db.WEBHOOKS_TABLE, columns, (&f).RenderTemplate(), db.WEBHOOKS_TABLE_ID, true, false)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+	rows, err := stmt.Query((&f).RenderValues()...)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+	defer rows.Close()
+	res := make([]models.Webhook, 0)
+	for rows.Next() {
+		h := models.Webhook{}
+		ent := WebhookEntity{hook: &h}
+		err := rows.Scan(append([]any{&h.Id}, (&ent).Fieldrefs()...)...)
+		if err != nil {
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
+			return nil, models.NewGenericServerError(err)
+		}
+		(&ent).unpack()
+		res = append(res, h)
+	}
+	return res, nil
+}
+
+func getWebhookWithSecret(t *sql.Tx, id int64) (models.Webhook, *models.APIError) {
+	f := Filters{}
+	(&f).AddEq(db.WEBHOOKS_TABLE_ID, id)
+	hooks, err := getWebhooks(t, f)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	if len(hooks) == 0 {
+		return models.Webhook{}, models.NewNotFoundError(fmt.Errorf("Webhook %d not found", id))
+	}
+	return hooks[0], nil
+}
+
+func GetWebhook(t *sql.Tx, id int64) (models.Webhook, *models.APIError) {
+	h, err := getWebhookWithSecret(t, id)
+	h.Secret = ""
+	return h, err
+}
+
+// The webhooks of namespace, or only those subscribed to dispatch_id
+// or created by owner if these are non-empty. Secrets are omitted.
+func GetWebhooks(t *sql.Tx, namespace string, dispatch_id string, owner string) ([]models.Webhook, *models.APIError) {
+	f := Filters{}
+	(&f).AddEq(db.WEBHOOKS_TABLE_NAMESPACE, namespace)
+	if len(dispatch_id) > 0 {
+		(&f).AddEq(db.WEBHOOKS_TABLE_DISPATCH_ID, dispatch_id)
+	}
+	if len(owner) > 0 {
+		(&f).AddEq(db.WEBHOOKS_TABLE_OWNER, owner)
+	}
+	hooks, err := getWebhooks(t, f)
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	return hooks, err
+}
+
+// Deletes the webhook together with its delivery log
+func DeleteWebhook(t *sql.Tx, id int64) *models.APIError {
+	f := Filters{}
+	(&f).AddEq(db.WEBHOOKS_TABLE_ID, id)
+	n, err := DeleteEntities(t, db.WEBHOOKS_TABLE, f)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.NewNotFoundError(fmt.Errorf("Webhook %d not found", id))
+	}
+	f = Filters{}
+	(&f).AddEq(db.WEBHOOK_DELIVERIES_TABLE_WEBHOOK_ID, id)
+	_, err = DeleteEntities(t, db.WEBHOOK_DELIVERIES_TABLE, f)
+	return err
+}
+
+var WEBHOOK_DELIVERY_ENTITY_KEYS = []string{
+	db.WEBHOOK_DELIVERIES_TABLE_WEBHOOK_ID,
+	db.WEBHOOK_DELIVERIES_TABLE_EVENT_ID,
+	db.WEBHOOK_DELIVERIES_TABLE_STATE,
+	db.WEBHOOK_DELIVERIES_TABLE_ATTEMPTS,
+	db.WEBHOOK_DELIVERIES_TABLE_LAST_STATUS_CODE,
+	db.WEBHOOK_DELIVERIES_TABLE_LAST_ERROR,
+	db.WEBHOOK_DELIVERIES_TABLE_NEXT_ATTEMPT_AT,
+	db.WEBHOOK_DELIVERIES_TABLE_CREATED_AT,
+	db.WEBHOOK_DELIVERIES_TABLE_UPDATED_AT,
+}
+
+// Mapped to a row in the webhook_deliveries table
+type WebhookDeliveryEntity struct {
+	delivery *models.WebhookDelivery
+}
+
+func (e *WebhookDeliveryEntity) Fields() []string {
+	return WEBHOOK_DELIVERY_ENTITY_KEYS
+}
+
+func (e *WebhookDeliveryEntity) Values() []any {
+	return []any{
+		e.delivery.WebhookId,
+		e.delivery.EventId,
+		e.delivery.State,
+		e.delivery.Attempts,
+		e.delivery.LastStatusCode,
+		e.delivery.LastError,
+		e.delivery.NextAttemptAt,
+		e.delivery.CreatedAt,
+		e.delivery.UpdatedAt,
+	}
+}
+
+func (e *WebhookDeliveryEntity) Fieldrefs() []any {
+	return []any{
+		&e.delivery.WebhookId,
+		&e.delivery.EventId,
+		&e.delivery.State,
+		&e.delivery.Attempts,
+		&e.delivery.LastStatusCode,
+		&e.delivery.LastError,
+		&e.delivery.NextAttemptAt,
+		&e.delivery.CreatedAt,
+		&e.delivery.UpdatedAt,
+	}
+}
+
+func (e *WebhookDeliveryEntity) Joins() []JoinCondition {
+	return []JoinCondition{}
+}
+
+// Queue a pending delivery of ev for every matching webhook. Called in
+// the transaction that records ev so that no event is lost if the
+// server stops before delivering it.
+func enqueueWebhookDeliveries(t *sql.Tx, ev *models.StatusEvent) *models.APIError {
+	global, scoped := Filters{}, Filters{}
+	(&global).AddIsNull(db.WEBHOOKS_TABLE_DISPATCH_ID)
+	(&scoped).AddEq(db.WEBHOOKS_TABLE_DISPATCH_ID, ev.DispatchId)
+	dispatch_owner, namespace, err := getDispatchOwnerAndNamespace(t, ev.DispatchId)
+	if err != nil {
+		return err
+	}
+	f := Filters{}
+	(&f).AddEq(db.WEBHOOKS_TABLE_NAMESPACE, namespace)
+	(&f).AddOr(global, scoped)
+	hooks, err := getWebhooks(t, f)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	now := time.Now().UTC()
+	entities := make([]DBEntity, 0, len(hooks))
+	for i := range hooks {
+		if !hooks[i].Matches(ev) {
+			continue
+		}
+		if len(hooks[i].Owner) > 0 && hooks[i].Owner != dispatch_owner {
+			continue
+		}
+		entities = append(entities, &WebhookDeliveryEntity{delivery: &models.WebhookDelivery{
+			WebhookId:     hooks[i].Id,
+			EventId:       ev.Id,
+			State:         models.WEBHOOK_DELIVERY_PENDING,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}})
+	}
+	_, err = InsertEntities(t, db.WEBHOOK_DELIVERIES_TABLE, entities)
+	return err
+}
+
+func getWebhookDeliveries(t *sql.Tx, f Filters) ([]models.WebhookDelivery, *models.APIError) {
+	columns := append([]string{db.WEBHOOK_DELIVERIES_TABLE_ID}, WEBHOOK_DELIVERY_ENTITY_KEYS...)
+	template := generateSelectTemplate(
+		db.WEBHOOK_DELIVERIES_TABLE,
+		columns,
+		(&f).RenderTemplate(),
+		db.WEBHOOK_DELIVERIES_TABLE_ID,
+		true,
+		f.Limit > 0,
+	)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+	rows, err := stmt.Query((&f).RenderValues()...)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error executing query: %s\n", err.Error()))
+		return nil, models.NewGenericServerError(err)
+	}
+	defer rows.Close()
+	res := make([]models.WebhookDelivery, 0)
+	for rows.Next() {
+		d := models.WebhookDelivery{}
+		ent := WebhookDeliveryEntity{delivery: &d}
+		err := rows.Scan(append([]any{&d.Id}, (&ent).Fieldrefs()...)...)
+		if err != nil {
+			slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
+			return nil, models.NewGenericServerError(err)
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
+
+// The delivery log of a webhook, oldest first. state filters by
+// delivery state if non-empty.
+func SearchWebhookDeliveries(t *sql.Tx, webhook_id int64, state string, page int, count int) (models.GetBulkWebhookDeliveriesResponse, *models.APIError) {
+	if _, err := GetWebhook(t, webhook_id); err != nil {
+		return models.GetBulkWebhookDeliveriesResponse{}, err
+	}
+	f := Filters{}
+	(&f).AddEq(db.WEBHOOK_DELIVERIES_TABLE_WEBHOOK_ID, webhook_id)
+	if len(state) > 0 {
+		(&f).AddEq(db.WEBHOOK_DELIVERIES_TABLE_STATE, state)
+	}
+	total, err := CountEntities(t, db.WEBHOOK_DELIVERIES_TABLE, f)
+	if err != nil {
+		return models.GetBulkWebhookDeliveriesResponse{}, err
+	}
+	f.Limit = count
+	f.Offset = page * count
+	records, err := getWebhookDeliveries(t, f)
+	if err != nil {
+		return models.GetBulkWebhookDeliveriesResponse{}, err
+	}
+	return models.GetBulkWebhookDeliveriesResponse{Records: records, Total: total}, nil
+}
+
+// A pending delivery with everything needed to attempt it
+type DueWebhookDelivery struct {
+	Delivery models.WebhookDelivery
+	Url      string
+	Secret   string
+	Event    models.StatusEvent
+}
+
+func getEvent(t *sql.Tx, id int64) (models.StatusEvent, *models.APIError) {
+	columns := append([]string{db.EVENTS_TABLE_ID}, EVENT_ENTITY_KEYS...)
+	f := Filters{}
+	(&f).AddEq(db.EVENTS_TABLE_ID, id)
+	template := generateSelectTemplate(db.EVENTS_TABLE, columns, (&f).RenderTemplate(), db.EVENTS_TABLE_ID, true, false)
+	stmt, err := prepareStmt(t, template)
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error preparing statement: %s\n", err.Error()))
+		return models.StatusEvent{}, models.NewGenericServerError(err)
+	}
+	ev := models.StatusEvent{}
+	ent := EventEntity{ev: &ev}
+	err = stmt.QueryRow((&f).RenderValues()...).Scan(append([]any{&ev.Id}, (&ent).Fieldrefs()...)...)
+	if err == sql.ErrNoRows {
+		return ev, models.NewNotFoundError(fmt.Errorf("Event %d not found", id))
+	}
+	if err != nil {
+		slog.ErrorContext(txContext(t), fmt.Sprintf("Error querying row: %s\n", err.Error()))
+		return ev, models.NewGenericServerError(err)
+	}
+	return ev, nil
+}
+
+// Pending deliveries whose next attempt is due at now, oldest first
+func GetDueWebhookDeliveries(t *sql.Tx, now time.Time, limit int) ([]DueWebhookDelivery, *models.APIError) {
+	if limit <= 0 || limit > MAX_DUE_WEBHOOK_DELIVERIES {
+		limit = MAX_DUE_WEBHOOK_DELIVERIES
+	}
+	f := Filters{}
+	(&f).AddEq(db.WEBHOOK_DELIVERIES_TABLE_STATE, models.WEBHOOK_DELIVERY_PENDING)
+	(&f).AddTimeRange(db.WEBHOOK_DELIVERIES_TABLE_NEXT_ATTEMPT_AT, nil, &now)
+	f.Limit = limit
+	deliveries, err := getWebhookDeliveries(t, f)
+	if err != nil {
+		return nil, err
+	}
+	hooks := make(map[int64]models.Webhook)
+	res := make([]DueWebhookDelivery, 0, len(deliveries))
+	for _, d := range deliveries {
+		h, ok := hooks[d.WebhookId]
+		if !ok {
+			h, err = getWebhookWithSecret(t, d.WebhookId)
+			if err != nil {
+				return nil, err
+			}
+			hooks[d.WebhookId] = h
+		}
+		ev, err := getEvent(t, d.EventId)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, DueWebhookDelivery{Delivery: d, Url: h.Url, Secret: h.Secret, Event: ev})
+	}
+	return res, nil
+}
+
+// Persist the outcome of a delivery attempt
+func UpdateWebhookDelivery(t *sql.Tx, d *models.WebhookDelivery) *models.APIError {
+	update := []KeyValue{
+		{Key: db.WEBHOOK_DELIVERIES_TABLE_STATE, Value: d.State},
+		{Key: db.WEBHOOK_DELIVERIES_TABLE_ATTEMPTS, Value: d.Attempts},
+		{Key: db.WEBHOOK_DELIVERIES_TABLE_LAST_STATUS_CODE, Value: d.LastStatusCode},
+		{Key: db.WEBHOOK_DELIVERIES_TABLE_LAST_ERROR, Value: d.LastError},
+		{Key: db.WEBHOOK_DELIVERIES_TABLE_NEXT_ATTEMPT_AT, Value: d.NextAttemptAt},
+		{Key: db.WEBHOOK_DELIVERIES_TABLE_UPDATED_AT, Value: d.UpdatedAt},
+	}
+	where := []KeyValue{{Key: db.WEBHOOK_DELIVERIES_TABLE_ID, Value: d.Id}}
+	return UpdateTable(t, db.WEBHOOK_DELIVERIES_TABLE, update, where)
+}
+
+// Give up on d, keeping the payload for inspection or manual replay
+func DeadLetterWebhookDelivery(t *sql.Tx, d *models.WebhookDelivery, payload []byte) *models.APIError {
+	d.State = models.WEBHOOK_DELIVERY_DEAD_LETTERED
+	if err := UpdateWebhookDelivery(t, d); err != nil {
+		return err
+	}
+	columns := []string{
+		db.WEBHOOK_DEAD_LETTERS_TABLE_DELIVERY_ID,
+		db.WEBHOOK_DEAD_LETTERS_TABLE_WEBHOOK_ID,
+		db.WEBHOOK_DEAD_LETTERS_TABLE_EVENT_ID,
+		db.WEBHOOK_DEAD_LETTERS_TABLE_PAYLOAD,
+		db.WEBHOOK_DEAD_LETTERS_TABLE_ATTEMPTS,
+		db.WEBHOOK_DEAD_LETTERS_TABLE_LAST_ERROR,
+		db.WEBHOOK_DEAD_LETTERS_TABLE_CREATED_AT,
+	}
+	row := []any{d.Id, d.WebhookId, d.EventId, string(payload), d.Attempts, d.LastError, d.UpdatedAt}
+	_, err := InsertRows(t, db.WEBHOOK_DEAD_LETTERS_TABLE, columns, [][]any{row})
+	return err
+}
+
+// Number of dead-lettered deliveries of a webhook
+func CountWebhookDeadLetters(t *sql.Tx, webhook_id int64) (int, *models.APIError) {
+	f := Filters{}
+	(&f).AddEq(db.WEBHOOK_DEAD_LETTERS_TABLE_WEBHOOK_ID, webhook_id)
+	return CountEntities(t, db.WEBHOOK_DEAD_LETTERS_TABLE, f)
+}
@@ -0,0 +1,157 @@
+package crud
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/casey/govalent/server/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockWebhook(dispatch_id *string, kinds []string, statuses []string) models.Webhook {
+	return models.Webhook{
+		Url:        "http://localhost:9999/hook",
+		Secret:     "secret",
+		DispatchId: dispatch_id,
+		EventKinds: kinds,
+		Statuses:   statuses,
+		CreatedAt:  time.Now().UTC(),
+	}
+}
+
+func TestWebhookDeliveryQueue(t *testing.T) {
+	config := newMockConfig(t)
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	defer tx.Rollback()
+
+	dispatch := newMockDispatch(nil, nil)
+	dispatch.Lattice.TransportGraph = newMockGraph(2)
+	dispatch_id := dispatch.Metadata.DispatchId
+	other_id := "other"
+
+	global := newMockWebhook(nil, nil, nil)
+	scoped := newMockWebhook(&dispatch_id, []string{models.EVENT_KIND_DISPATCH_STATUS}, []string{"COMPLETED", "FAILED"})
+	unrelated := newMockWebhook(&other_id, nil, nil)
+	for _, h := range []*models.Webhook{&global, &scoped, &unrelated} {
+		if err := CreateWebhook(tx, h); err != nil {
+			t.Fatalf("Error creating webhook: %v", err)
+		}
+	}
+	assert.NotEqual(t, global.Id, scoped.Id)
+
+	// The initial status is delivered to the global webhook only
+	if err := ImportManifest(context.Background(), &config, tx, &dispatch); err != nil {
+		t.Fatalf("Error importing manifest: %v", err)
+	}
+	if _, err := UpdateElectronMetadata(tx, dispatch_id, 1, models.ElectronStatusUpdate{Status: "RUNNING"}); err != nil {
+		t.Fatalf("Error updating electron: %v", err)
+	}
+	completed, err := UpdateDispatch(tx, dispatch_id, "COMPLETED", nil, nil)
+	if err != nil {
+		t.Fatalf("Error updating dispatch: %v", err)
+	}
+
+	due, err := GetDueWebhookDeliveries(tx, time.Now().UTC(), 0)
+	if err != nil {
+		t.Fatalf("Error retrieving deliveries: %v", err)
+	}
+	assert.Equal(t, 4, len(due))
+	for _, item := range due {
+		assert.Equal(t, "secret", item.Secret)
+		assert.Equal(t, item.Delivery.EventId, item.Event.Id)
+		assert.NotEqual(t, unrelated.Id, item.Delivery.WebhookId)
+		if item.Delivery.WebhookId == scoped.Id {
+			assert.Equal(t, completed.Id, item.Event.Id)
+		}
+	}
+
+	// Deliveries become due at their next attempt time
+	res, err := SearchWebhookDeliveries(tx, global.Id, models.WEBHOOK_DELIVERY_PENDING, 0, 2)
+	if err != nil {
+		t.Fatalf("Error searching deliveries: %v", err)
+	}
+	assert.Equal(t, 3, res.Total)
+	assert.Equal(t, 2, len(res.Records))
+	first := res.Records[0]
+	first.Attempts = 1
+	first.NextAttemptAt = time.Now().UTC().Add(time.Hour)
+	if err := UpdateWebhookDelivery(tx, &first); err != nil {
+		t.Fatalf("Error updating delivery: %v", err)
+	}
+	due, _ = GetDueWebhookDeliveries(tx, time.Now().UTC(), 0)
+	assert.Equal(t, 3, len(due))
+
+	if err := DeadLetterWebhookDelivery(tx, &first, []byte("{}")); err != nil {
+		t.Fatalf("Error dead-lettering delivery: %v", err)
+	}
+	n, _ := CountWebhookDeadLetters(tx, global.Id)
+	assert.Equal(t, 1, n)
+	res, _ = SearchWebhookDeliveries(tx, global.Id, models.WEBHOOK_DELIVERY_DEAD_LETTERED, 0, 10)
+	assert.Equal(t, 1, res.Total)
+
+	// Secrets are not returned by reads
+	h, err := GetWebhook(tx, scoped.Id)
+	if err != nil {
+		t.Fatalf("Error retrieving webhook: %v", err)
+	}
+	assert.Empty(t, h.Secret)
+	assert.Equal(t, []string{"COMPLETED", "FAILED"}, h.Statuses)
+	hooks, _ := GetWebhooks(tx, models.DEFAULT_NAMESPACE, dispatch_id, "")
+	assert.Equal(t, 1, len(hooks))
+
+	if err := DeleteWebhook(tx, global.Id); err != nil {
+		t.Fatalf("Error deleting webhook: %v", err)
+	}
+	err = DeleteWebhook(tx, global.Id)
+	assert.Equal(t, 404, err.StatusCode)
+	_, err = SearchWebhookDeliveries(tx, global.Id, "", 0, 10)
+	assert.Equal(t, 404, err.StatusCode)
+	due, _ = GetDueWebhookDeliveries(tx, time.Now().UTC(), 0)
+	assert.Equal(t, 1, len(due))
+}
+
+func TestWebhookNamespaceScope(t *testing.T) {
+	d := newMockDB(t)
+	tx, db_err := d.Begin()
+	if db_err != nil {
+		t.Fatalf("Error starting transaction: %v", db_err)
+	}
+	defer tx.Rollback()
+
+	home := newMockWebhook(nil, nil, nil)
+	home.Owner = "alice"
+	away := newMockWebhook(nil, nil, nil)
+	away.Owner = "alice"
+	away.Namespace = "team-a"
+	for _, h := range []*models.Webhook{&home, &away} {
+		if err := CreateWebhook(tx, h); err != nil {
+			t.Fatalf("Error creating webhook: %v", err)
+		}
+	}
+	assert.Equal(t, models.DEFAULT_NAMESPACE, home.Namespace)
+
+	// Only the webhook of the dispatch's namespace receives its events
+	dispatch := newMockDispatch(nil, nil)
+	dispatch.Metadata.Owner = "alice"
+	dispatch.Metadata.Namespace = "team-a"
+	if err := CreateDispatchMetadata(tx, &dispatch.Metadata, &dispatch.Lattice.Metadata); err != nil {
+		t.Fatalf("Error creating dispatch: %v", err)
+	}
+	if _, err := UpdateDispatch(tx, dispatch.Metadata.DispatchId, "RUNNING", nil, nil); err != nil {
+		t.Fatalf("Error updating dispatch: %v", err)
+	}
+	due, _ := GetDueWebhookDeliveries(tx, time.Now().UTC(), 0)
+	assert.Equal(t, 1, len(due))
+	assert.Equal(t, away.Id, due[0].Delivery.WebhookId)
+
+	hooks, _ := GetWebhooks(tx, "team-a", "", "alice")
+	assert.Equal(t, 1, len(hooks))
+	assert.Equal(t, away.Id, hooks[0].Id)
+	hooks, _ = GetWebhooks(tx, "team-b", "", "")
+	assert.Equal(t, 0, len(hooks))
+}
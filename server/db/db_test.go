@@ -1,8 +1,11 @@
 package db
 
+import "context"
 import "github.com/casey/govalent/server/common"
 import _ "github.com/mattn/go-sqlite3"
 import "database/sql"
+import "fmt"
+import "path/filepath"
 import "testing"
 
 var registered bool
@@ -10,7 +13,7 @@ var registered bool
 
 func TestEmitDDL(t *testing.T) {
 	c := common.Config{
-		Dsn: "file:test.db",
+		Dsn: fmt.Sprintf("file:%s", filepath.Join(t.TempDir(), "test.db")),
 		Port: common.DEFAULT_PORT,
 	}
 	drivers := sql.Drivers()
@@ -24,3 +27,130 @@ func TestEmitDDL(t *testing.T) {
 		t.Fatalf("Error emitting DDL: %v", err)
 	}
 }
+
+func TestAddMissingColumns(t *testing.T) {
+	c := common.Config{Dsn: ":memory:"}
+	db, err := GetDB(&c)
+	if err != nil {
+		t.Fatalf("Error establishing DB connection: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	// A dispatches table created before the owner column was added
+	_, err = db.Exec("CREATE TABLE dispatches (id TEXT PRIMARY KEY, name TEXT NOT NULL)")
+	if err != nil {
+		t.Fatalf("Error creating table: %v", err)
+	}
+	_, err = db.Exec("INSERT INTO dispatches (id, name) VALUES ('d1', 'wf')")
+	if err != nil {
+		t.Fatalf("Error inserting row: %v", err)
+	}
+	if err := EmitDDL(db); err != nil {
+		t.Fatalf("Error emitting DDL: %v", err)
+	}
+	found, err := hasColumn(context.Background(), db, DISPATCH_TABLE, DISPATCH_TABLE_OWNER)
+	if err != nil || !found {
+		t.Fatalf("Expected column %s to be added (%v)", DISPATCH_TABLE_OWNER, err)
+	}
+	var owner string
+	if err := db.QueryRow("SELECT owner FROM dispatches WHERE id = 'd1'").Scan(&owner); err != nil || owner != "" {
+		t.Fatalf("Expected empty owner for existing row, got %q (%v)", owner, err)
+	}
+	// Idempotent
+	if err := EmitDDL(db); err != nil {
+		t.Fatalf("Error emitting DDL twice: %v", err)
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	c := common.Config{Dsn: ":memory:"}
+	db, err := GetDB(&c)
+	if err != nil {
+		t.Fatalf("Error establishing DB connection: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if err := CheckSchema(context.Background(), db); err == nil {
+		t.Fatalf("Expected error for empty database")
+	}
+	if err := EmitDDL(db); err != nil {
+		t.Fatalf("Error emitting DDL: %v", err)
+	}
+	if err := CheckSchema(context.Background(), db); err != nil {
+		t.Fatalf("Unexpected error after EmitDDL: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := CheckSchema(ctx, db); err == nil {
+		t.Fatalf("Expected error for cancelled context")
+	}
+
+	// A database last migrated by an older version
+	if _, err := db.Exec("ALTER TABLE webhooks DROP COLUMN owner"); err != nil {
+		t.Fatalf("Error dropping column: %v", err)
+	}
+	if err := CheckSchema(context.Background(), db); err == nil {
+		t.Fatalf("Expected error for missing column")
+	}
+}
+
+func TestMigrateAssetKeys(t *testing.T) {
+	c := common.Config{Dsn: "file::memory:?_foreign_keys=1"}
+	db, err := GetDB(&c)
+	if err != nil {
+		t.Fatalf("Error establishing DB connection: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if err := EmitDDL(db); err != nil {
+		t.Fatalf("Error emitting DDL: %v", err)
+	}
+	// An assets table created before namespaces, with a linked asset
+	for _, stmt := range []string{
+		"PRAGMA foreign_keys = OFF",
+		"DROP TABLE assets",
+		`CREATE TABLE assets (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			scheme TEXT NOT NULL,
+			base_path TEXT NOT NULL,
+			key TEXT UNIQUE NOT NULL,
+			size INTEGER NOT NULL,
+			digest_alg TEXT,
+			digest TEXT,
+			remote_uri TEXT,
+			namespace TEXT NOT NULL DEFAULT 'default'
+		)`,
+		"PRAGMA foreign_keys = ON",
+		"INSERT INTO dispatches (id, name, status) VALUES ('d1', 'wf', 'NEW_OBJECT')",
+		"INSERT INTO assets (id, scheme, base_path, key, size) VALUES (7, 'file', '/tmp', 'd1/result', 0)",
+		"INSERT INTO assetlinks (dispatch_id, transport_graph_node_id, asset_id, name) VALUES ('d1', '-1', 7, 'result')",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("Error executing %q: %v", stmt, err)
+		}
+	}
+	if err := CheckSchema(context.Background(), db); err == nil {
+		t.Fatalf("Expected error for globally unique asset keys")
+	}
+
+	if err := EmitDDL(db); err != nil {
+		t.Fatalf("Error emitting DDL: %v", err)
+	}
+	if err := CheckSchema(context.Background(), db); err != nil {
+		t.Fatalf("Unexpected error after migration: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO assets (scheme, base_path, key, size, namespace) VALUES ('file', '/tmp', 'd1/result', 0, 'other')"); err != nil {
+		t.Fatalf("Expected keys to be unique per namespace: %v", err)
+	}
+	var links int
+	if err := db.QueryRow("SELECT COUNT(*) FROM assetlinks WHERE asset_id = 7").Scan(&links); err != nil || links != 1 {
+		t.Fatalf("Expected asset links to survive the migration, got %d (%v)", links, err)
+	}
+	var foreign_keys int
+	if err := db.QueryRow("PRAGMA foreign_keys").Scan(&foreign_keys); err != nil || foreign_keys != 1 {
+		t.Fatalf("Expected foreign keys to be enforced again, got %d (%v)", foreign_keys, err)
+	}
+}
@@ -1,10 +1,12 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"log/slog"
+	"strings"
 
 	"github.com/casey/govalent/server/common"
 
@@ -26,7 +28,9 @@ CREATE TABLE IF NOT EXISTS dispatches (
     start_time DATETIME,
     end_time DATETIME,
     created_at DATETIME,
-    updated_at DATETIME
+    updated_at DATETIME,
+    owner TEXT NOT NULL DEFAULT '',
+    namespace TEXT NOT NULL DEFAULT 'default'
 )
 `
 
@@ -64,16 +68,20 @@ CREATE TABLE IF NOT EXISTS edges (
 )
 `
 
+// Keys are unique per namespace. Tables created before namespaces,
+// whose keys were globally unique, are rebuilt by migrateAssetKeys.
 const assetsDDL = `
 CREATE TABLE IF NOT EXISTS assets (
 	id INTEGER PRIMARY KEY AUTOINCREMENT,
 	scheme TEXT NOT NULL,
 	base_path TEXT NOT NULL,
-	key TEXT UNIQUE NOT NULL,
+	key TEXT NOT NULL,
 	size INTEGER NOT NULL,
 	digest_alg TEXT,
 	digest TEXT,
-	remote_uri TEXT
+	remote_uri TEXT,
+	namespace TEXT NOT NULL DEFAULT 'default',
+	UNIQUE (namespace, key)
 )
 `
 
@@ -90,8 +98,301 @@ CREATE INDEX IF NOT EXISTS assetlinks_index ON assetlinks (
 );
 `
 
+// Digest of each accepted manifest, used to recognize retried
+// submissions; see crud.GetSubmission
+const submissionsDDL = `
+CREATE TABLE IF NOT EXISTS submissions (
+	dispatch_id TEXT PRIMARY KEY REFERENCES dispatches(id) ON DELETE CASCADE,
+	idempotency_key TEXT UNIQUE,
+	digest TEXT NOT NULL,
+	created_at DATETIME
+)
+`
+
+// Append-only log of status transitions; ids order events globally
+const eventsDDL = `
+CREATE TABLE IF NOT EXISTS events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	dispatch_id TEXT NOT NULL REFERENCES dispatches(id) ON DELETE CASCADE,
+	transport_graph_node_id INTEGER,
+	kind TEXT NOT NULL,
+	status TEXT NOT NULL,
+	start_time DATETIME,
+	end_time DATETIME,
+	created_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS events_index ON events (
+	dispatch_id, id
+);
+`
+
+// Subscriptions to status events; a NULL dispatch_id subscribes to
+// every dispatch. event_kinds and statuses are comma-separated, and
+// empty matches everything.
+const webhooksDDL = `
+CREATE TABLE IF NOT EXISTS webhooks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	dispatch_id TEXT REFERENCES dispatches(id) ON DELETE CASCADE,
+	event_kinds TEXT NOT NULL,
+	statuses TEXT NOT NULL,
+	owner TEXT NOT NULL DEFAULT '',
+	namespace TEXT NOT NULL DEFAULT 'default',
+	created_at DATETIME NOT NULL
+);
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+	event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+	state TEXT NOT NULL,
+	attempts INTEGER NOT NULL,
+	last_status_code INTEGER,
+	last_error TEXT NOT NULL,
+	next_attempt_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_state_index ON webhook_deliveries (
+	state, next_attempt_at
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_index ON webhook_deliveries (
+	webhook_id, id
+);
+CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	delivery_id INTEGER NOT NULL UNIQUE,
+	webhook_id INTEGER NOT NULL,
+	event_id INTEGER NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL,
+	last_error TEXT NOT NULL,
+	created_at DATETIME NOT NULL
+);
+`
+
+// API tokens are stored as SHA-256 digests; see crud.HashToken
+const apiTokensDDL = `
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_name TEXT NOT NULL,
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	role TEXT NOT NULL,
+	namespace TEXT NOT NULL,
+	created_at DATETIME NOT NULL
+)
+`
+
+// Quotas of each namespace; rows are created on first use
+const namespacesDDL = `
+CREATE TABLE IF NOT EXISTS namespaces (
+	name TEXT PRIMARY KEY,
+	max_dispatches INTEGER NOT NULL,
+	max_asset_bytes INTEGER NOT NULL,
+	created_at DATETIME NOT NULL
+)
+`
+
+// Append-only record of denied requests and mutating operations
+const auditLogDDL = `
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at DATETIME NOT NULL,
+	request_id TEXT NOT NULL,
+	user_name TEXT NOT NULL,
+	token_id INTEGER NOT NULL,
+	role TEXT NOT NULL,
+	method TEXT NOT NULL,
+	path TEXT NOT NULL,
+	outcome TEXT NOT NULL,
+	detail TEXT NOT NULL,
+	action TEXT NOT NULL DEFAULT '',
+	namespace TEXT NOT NULL DEFAULT '',
+	dispatch_id TEXT NOT NULL DEFAULT '',
+	status_code INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS audit_log_created_at_index ON audit_log (
+	created_at
+)
+`
+
+// Columns added after their tables were first released. CREATE TABLE
+// IF NOT EXISTS leaves existing tables alone, so databases created by
+// older versions gain these columns in EmitDDL.
+var addedColumns = []struct {
+	table  string
+	column string
+	decl   string
+}{
+	{DISPATCH_TABLE, DISPATCH_TABLE_OWNER, "TEXT NOT NULL DEFAULT ''"},
+	{WEBHOOKS_TABLE, WEBHOOKS_TABLE_OWNER, "TEXT NOT NULL DEFAULT ''"},
+	{DISPATCH_TABLE, DISPATCH_TABLE_NAMESPACE, "TEXT NOT NULL DEFAULT 'default'"},
+	{ASSET_TABLE, ASSET_TABLE_NAMESPACE, "TEXT NOT NULL DEFAULT 'default'"},
+	{AUDIT_LOG_TABLE, AUDIT_LOG_TABLE_ACTION, "TEXT NOT NULL DEFAULT ''"},
+	{AUDIT_LOG_TABLE, AUDIT_LOG_TABLE_NAMESPACE, "TEXT NOT NULL DEFAULT ''"},
+	{AUDIT_LOG_TABLE, AUDIT_LOG_TABLE_DISPATCH_ID, "TEXT NOT NULL DEFAULT ''"},
+	{AUDIT_LOG_TABLE, AUDIT_LOG_TABLE_STATUS_CODE, "INTEGER NOT NULL DEFAULT 0"},
+	{WEBHOOKS_TABLE, WEBHOOKS_TABLE_NAMESPACE, "TEXT NOT NULL DEFAULT 'default'"},
+}
+
+func hasColumn(ctx context.Context, db *sql.DB, table string, column string) (bool, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT name FROM pragma_table_info('%s')", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+func addMissingColumns(db *sql.DB) error {
+	for _, item := range addedColumns {
+		found, err := hasColumn(context.Background(), db, item.table, item.column)
+		if err != nil {
+			return err
+		}
+		if found {
+			continue
+		}
+		slog.Info(fmt.Sprintf("Adding column %s to table %s", item.column, item.table))
+		_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", item.table, item.column, item.decl))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Whether assets still has the unique constraint on key alone that
+// predates namespaces
+func hasGlobalAssetKeys(ctx context.Context, db *sql.DB) (bool, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT i.name FROM pragma_index_list('%s') i WHERE i.\"unique\" = 1 AND "+
+			"(SELECT group_concat(c.name) FROM pragma_index_info(i.name) c) = '%s'",
+		ASSET_TABLE, ASSET_TABLE_KEY,
+	))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	found := rows.Next()
+	return found, rows.Err()
+}
+
+// Rebuild assets with keys unique per namespace, following SQLite's
+// procedure for schema changes ALTER TABLE cannot make. Foreign key
+// enforcement is suspended so that dropping the old table does not
+// cascade to assetlinks.
+func migrateAssetKeys(db *sql.DB) error {
+	ctx := context.Background()
+	found, err := hasGlobalAssetKeys(ctx, db)
+	if err != nil || !found {
+		return err
+	}
+	slog.Info(fmt.Sprintf("Rebuilding table %s with keys unique per namespace", ASSET_TABLE))
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var foreign_keys int
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreign_keys); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, fmt.Sprintf("PRAGMA foreign_keys = %d", foreign_keys))
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	columns := "id, scheme, base_path, key, size, digest_alg, digest, remote_uri, namespace"
+	statements := []string{
+		strings.Replace(assetsDDL, "CREATE TABLE IF NOT EXISTS assets ", "CREATE TABLE assets_new ", 1),
+		fmt.Sprintf("INSERT INTO assets_new (%s) SELECT %s FROM %s", columns, columns, ASSET_TABLE),
+		fmt.Sprintf("DROP TABLE %s", ASSET_TABLE),
+		fmt.Sprintf("ALTER TABLE assets_new RENAME TO %s", ASSET_TABLE),
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	rows, err := tx.QueryContext(ctx, "PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+	violated := rows.Next()
+	rows.Close()
+	if violated {
+		return fmt.Errorf("Foreign key violations after rebuilding table %s", ASSET_TABLE)
+	}
+	return tx.Commit()
+}
+
+// Tables created by EmitDDL
+var schemaTables = []string{
+	DISPATCH_TABLE,
+	ELECTRON_TABLE,
+	EDGES_TABLE,
+	ASSET_TABLE,
+	ASSET_LINKS_TABLE,
+	SUBMISSIONS_TABLE,
+	EVENTS_TABLE,
+	WEBHOOKS_TABLE,
+	WEBHOOK_DELIVERIES_TABLE,
+	WEBHOOK_DEAD_LETTERS_TABLE,
+	API_TOKENS_TABLE,
+	AUDIT_LOG_TABLE,
+	NAMESPACES_TABLE,
+}
+
+// Check that every table and added column exists, i.e. that EmitDDL
+// has been run against db by this version
+func CheckSchema(ctx context.Context, db *sql.DB) error {
+	for _, table := range schemaTables {
+		var n int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&n)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("Missing table %s", table)
+		}
+	}
+	for _, item := range addedColumns {
+		found, err := hasColumn(ctx, db, item.table, item.column)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("Missing column %s of table %s", item.column, item.table)
+		}
+	}
+	global, err := hasGlobalAssetKeys(ctx, db)
+	if err != nil {
+		return err
+	}
+	if global {
+		return fmt.Errorf("Table %s has not been migrated to keys unique per namespace", ASSET_TABLE)
+	}
+	return nil
+}
+
 func GetDB(c *common.Config) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", c.Dsn)
+	db, err := sql.Open(INSTRUMENTED_DRIVER, c.Dsn)
 	if err != nil {
 		log.Println("Error opening db: ", err.Error())
 		return nil, err
@@ -130,5 +431,45 @@ func EmitDDL(db *sql.DB) error {
 		log.Println("Error emitting: ", err.Error())
 		return err
 	}
+	_, err = db.Exec(submissionsDDL)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error emitting DDL: %s", err.Error()))
+		return err
+	}
+	_, err = db.Exec(eventsDDL)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error emitting DDL: %s", err.Error()))
+		return err
+	}
+	_, err = db.Exec(webhooksDDL)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error emitting DDL: %s", err.Error()))
+		return err
+	}
+	_, err = db.Exec(apiTokensDDL)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error emitting DDL: %s", err.Error()))
+		return err
+	}
+	_, err = db.Exec(auditLogDDL)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error emitting DDL: %s", err.Error()))
+		return err
+	}
+	_, err = db.Exec(namespacesDDL)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error emitting DDL: %s", err.Error()))
+		return err
+	}
+	err = addMissingColumns(db)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error adding columns: %s", err.Error()))
+		return err
+	}
+	err = migrateAssetKeys(db)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error migrating assets: %s", err.Error()))
+		return err
+	}
 	return nil
 }
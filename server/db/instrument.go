@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// The sqlite3 driver, reporting the duration of every transaction to
+// the observer set with SetTxObserver
+const INSTRUMENTED_DRIVER = "sqlite3_instrumented"
+
+// Called when a transaction ends with its duration and whether it
+// was committed
+type TxObserver func(d time.Duration, committed bool)
+
+var txObserver atomic.Pointer[TxObserver]
+
+func SetTxObserver(f TxObserver) {
+	txObserver.Store(&f)
+}
+
+func observeTx(start time.Time, committed bool) {
+	if f := txObserver.Load(); f != nil && *f != nil {
+		(*f)(time.Since(start), committed)
+	}
+}
+
+type instrumentedDriver struct {
+	sqlite3.SQLiteDriver
+}
+
+func (d *instrumentedDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.SQLiteDriver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+// Embeds the sqlite3 connection so that its optional driver interfaces
+// remain visible to database/sql
+type instrumentedConn struct {
+	*sqlite3.SQLiteConn
+}
+
+func (c *instrumentedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := time.Now()
+	tx, err := c.SQLiteConn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedTx{tx: tx, start: start}, nil
+}
+
+type instrumentedTx struct {
+	tx    driver.Tx
+	start time.Time
+}
+
+func (t *instrumentedTx) Commit() error {
+	err := t.tx.Commit()
+	observeTx(t.start, err == nil)
+	return err
+}
+
+func (t *instrumentedTx) Rollback() error {
+	err := t.tx.Rollback()
+	observeTx(t.start, false)
+	return err
+}
+
+func init() {
+	sql.Register(INSTRUMENTED_DRIVER, &instrumentedDriver{})
+}
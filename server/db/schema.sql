@@ -12,7 +12,9 @@ CREATE TABLE IF NOT EXISTS dispatches (
     start_time DATETIME,
     end_time DATETIME,
     created_at DATETIME,
-    updated_at DATETIME
+    updated_at DATETIME,
+    owner TEXT NOT NULL DEFAULT '',
+    namespace TEXT NOT NULL DEFAULT 'default'
 );
 
 CREATE TABLE IF NOT EXISTS electrons (
@@ -32,7 +34,6 @@ CREATE TABLE IF NOT EXISTS electrons (
     job_id TEXT,
 );
 
-
 CREATE TABLE IF NOT EXISTS edges (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     dispatch_id TEXT NOT NULL REFERENCES dispatches(id) ON DELETE CASCADE,
@@ -43,16 +44,17 @@ CREATE TABLE IF NOT EXISTS edges (
     arg_index INTEGER
 );
 
-
 CREATE TABLE IF NOT EXISTS assets (
 	id INTEGER PRIMARY KEY AUTOINCREMENT,
 	scheme TEXT NOT NULL,
 	base_path TEXT NOT NULL,
-	key TEXT UNIQUE NOT NULL,
+	key TEXT NOT NULL,
 	size INTEGER NOT NULL,
 	digest_alg TEXT,
 	digest TEXT,
-	remote_uri TEXT
+	remote_uri TEXT,
+	namespace TEXT NOT NULL DEFAULT 'default',
+	UNIQUE (namespace, key)
 );
 
 CREATE TABLE IF NOT EXISTS assetlinks (
@@ -62,3 +64,93 @@ CREATE TABLE IF NOT EXISTS assetlinks (
 	asset_id INTEGER REFERENCES assets(id) ON DELETE CASCADE NOT NULL,
 	name TEXT NOT NULL
 );
+
+CREATE TABLE IF NOT EXISTS submissions (
+	dispatch_id TEXT PRIMARY KEY REFERENCES dispatches(id) ON DELETE CASCADE,
+	idempotency_key TEXT UNIQUE,
+	digest TEXT NOT NULL,
+	created_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	dispatch_id TEXT NOT NULL REFERENCES dispatches(id) ON DELETE CASCADE,
+	transport_graph_node_id INTEGER,
+	kind TEXT NOT NULL,
+	status TEXT NOT NULL,
+	start_time DATETIME,
+	end_time DATETIME,
+	created_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url TEXT NOT NULL,
+	secret TEXT NOT NULL,
+	dispatch_id TEXT REFERENCES dispatches(id) ON DELETE CASCADE,
+	event_kinds TEXT NOT NULL,
+	statuses TEXT NOT NULL,
+	owner TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+	event_id INTEGER NOT NULL REFERENCES events(id) ON DELETE CASCADE,
+	state TEXT NOT NULL,
+	attempts INTEGER NOT NULL,
+	last_status_code INTEGER,
+	last_error TEXT NOT NULL,
+	next_attempt_at DATETIME NOT NULL,
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_dead_letters (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	delivery_id INTEGER NOT NULL UNIQUE,
+	webhook_id INTEGER NOT NULL,
+	event_id INTEGER NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL,
+	last_error TEXT NOT NULL,
+	created_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_name TEXT NOT NULL,
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	role TEXT NOT NULL,
+	namespace TEXT NOT NULL,
+	created_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at DATETIME NOT NULL,
+	request_id TEXT NOT NULL,
+	user_name TEXT NOT NULL,
+	token_id INTEGER NOT NULL,
+	role TEXT NOT NULL,
+	method TEXT NOT NULL,
+	path TEXT NOT NULL,
+	outcome TEXT NOT NULL,
+	detail TEXT NOT NULL,
+	action TEXT NOT NULL DEFAULT '',
+	namespace TEXT NOT NULL DEFAULT '',
+	dispatch_id TEXT NOT NULL DEFAULT '',
+	status_code INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS audit_log_created_at_index ON audit_log (
+	created_at
+);
+
+CREATE TABLE IF NOT EXISTS namespaces (
+	name TEXT PRIMARY KEY,
+	max_dispatches INTEGER NOT NULL,
+	max_asset_bytes INTEGER NOT NULL,
+	created_at DATETIME NOT NULL
+);
@@ -6,56 +6,131 @@ import "fmt"
 //
 
 const (
-	DISPATCH_TABLE                        = "dispatches"
-	ELECTRON_TABLE                        = "electrons"
-	EDGES_TABLE                           = "edges"
-	ASSET_TABLE                           = "assets"
-	ASSET_LINKS_TABLE                     = "assetlinks"
-	ASSET_TABLE_ID                        = "id"
-	ASSET_TABLE_SCHEME                    = "scheme"
-	ASSET_TABLE_BASE                      = "base_path"
-	ASSET_TABLE_KEY                       = "key"
-	ASSET_TABLE_SIZE                      = "size"
-	ASSET_TABLE_DIGEST_ALG                = "digest_alg"
-	ASSET_TABLE_DIGEST                    = "digest"
-	ASSET_TABLE_REMOTE_URI                = "remote_uri"
-	ASSET_LINKS_TABLE_DISPATCH_ID         = "dispatch_id"
-	ASSET_LINKS_TABLE_NODE_ID             = "transport_graph_node_id"
-	ASSET_LINKS_TABLE_ASSET_ID            = "asset_id"
-	ASSET_LINKS_TABLE_NAME                = "name"
-	DISPATCH_TABLE_CREATED_AT             = "created_at"
-	DISPATCH_TABLE_UPDATED_AT             = "updated_at"
-	DISPATCH_TABLE_NAME                   = "name"
-	DISPATCH_TABLE_STATUS                 = "status"
-	DISPATCH_TABLE_EXECUTOR               = "executor"
-	DISPATCH_TABLE_EXECUTOR_DATA          = "executor_data"
-	DISPATCH_TABLE_WORKFLOW_EXECUTOR      = "workflow_executor"
-	DISPATCH_TABLE_WORKFLOW_EXECUTOR_DATA = "workflow_executor_data"
-	DISPATCH_TABLE_PYTHON_VERSION         = "python_version"
-	DISPATCH_TABLE_COVALENT_VERSION       = "covalent_version"
-	DISPATCH_TABLE_START_TIME             = "start_time"
-	DISPATCH_TABLE_END_TIME               = "end_time"
-	DISPATCH_TABLE_ID                     = "id"
-	DISPATCH_TABLE_ROOT_ID                = "root_dispatch_id"
-	ELECTRON_TABLE_ID                     = "id"
-	ELECTRON_TABLE_NODE_ID                = "transport_graph_node_id"
-	ELECTRON_TABLE_GID                    = "task_group_id"
-	ELECTRON_TABLE_DISPATCH_ID            = "parent_dispatch_id"
-	ELECTRON_TABLE_SUBDISPATCH_ID         = "sub_dispatch_id"
-	ELECTRON_TABLE_NAME                   = "name"
-	ELECTRON_TABLE_STATUS                 = "status"
-	ELECTRON_TABLE_EXECUTOR               = "executor"
-	ELECTRON_TABLE_EXECUTOR_DATA          = "executor_data"
-	ELECTRON_TABLE_START_TIME             = "start_time"
-	ELECTRON_TABLE_END_TIME               = "end_time"
-	ELECTRON_TABLE_SORT_ORDER             = "sort_order"
-	EDGES_TABLE_ID                        = "id"
-	EDGES_TABLE_CHILD                     = "child_node_id"
-	EDGES_TABLE_PARENT                    = "parent_node_id"
-	EDGES_TABLE_DISPATCH                  = "dispatch_id"
-	EDGES_TABLE_NAME                      = "edge_name"
-	EDGES_TABLE_TYPE                      = "param_type"
-	EDGES_TABLE_ARG_INDEX                 = "arg_index"
+	DISPATCH_TABLE                            = "dispatches"
+	ELECTRON_TABLE                            = "electrons"
+	EDGES_TABLE                               = "edges"
+	ASSET_TABLE                               = "assets"
+	ASSET_LINKS_TABLE                         = "assetlinks"
+	ASSET_TABLE_ID                            = "id"
+	ASSET_TABLE_SCHEME                        = "scheme"
+	ASSET_TABLE_BASE                          = "base_path"
+	ASSET_TABLE_KEY                           = "key"
+	ASSET_TABLE_SIZE                          = "size"
+	ASSET_TABLE_DIGEST_ALG                    = "digest_alg"
+	ASSET_TABLE_DIGEST                        = "digest"
+	ASSET_TABLE_REMOTE_URI                    = "remote_uri"
+	ASSET_TABLE_NAMESPACE                     = "namespace"
+	ASSET_LINKS_TABLE_DISPATCH_ID             = "dispatch_id"
+	ASSET_LINKS_TABLE_NODE_ID                 = "transport_graph_node_id"
+	ASSET_LINKS_TABLE_ASSET_ID                = "asset_id"
+	ASSET_LINKS_TABLE_NAME                    = "name"
+	DISPATCH_TABLE_CREATED_AT                 = "created_at"
+	DISPATCH_TABLE_UPDATED_AT                 = "updated_at"
+	DISPATCH_TABLE_NAME                       = "name"
+	DISPATCH_TABLE_STATUS                     = "status"
+	DISPATCH_TABLE_EXECUTOR                   = "executor"
+	DISPATCH_TABLE_EXECUTOR_DATA              = "executor_data"
+	DISPATCH_TABLE_WORKFLOW_EXECUTOR          = "workflow_executor"
+	DISPATCH_TABLE_WORKFLOW_EXECUTOR_DATA     = "workflow_executor_data"
+	DISPATCH_TABLE_PYTHON_VERSION             = "python_version"
+	DISPATCH_TABLE_COVALENT_VERSION           = "covalent_version"
+	DISPATCH_TABLE_START_TIME                 = "start_time"
+	DISPATCH_TABLE_END_TIME                   = "end_time"
+	DISPATCH_TABLE_ID                         = "id"
+	DISPATCH_TABLE_ROOT_ID                    = "root_dispatch_id"
+	DISPATCH_TABLE_OWNER                      = "owner"
+	DISPATCH_TABLE_NAMESPACE                  = "namespace"
+	ELECTRON_TABLE_ID                         = "id"
+	ELECTRON_TABLE_NODE_ID                    = "transport_graph_node_id"
+	ELECTRON_TABLE_GID                        = "task_group_id"
+	ELECTRON_TABLE_DISPATCH_ID                = "parent_dispatch_id"
+	ELECTRON_TABLE_SUBDISPATCH_ID             = "sub_dispatch_id"
+	ELECTRON_TABLE_NAME                       = "name"
+	ELECTRON_TABLE_STATUS                     = "status"
+	ELECTRON_TABLE_EXECUTOR                   = "executor"
+	ELECTRON_TABLE_EXECUTOR_DATA              = "executor_data"
+	ELECTRON_TABLE_START_TIME                 = "start_time"
+	ELECTRON_TABLE_END_TIME                   = "end_time"
+	ELECTRON_TABLE_SORT_ORDER                 = "sort_order"
+	EDGES_TABLE_ID                            = "id"
+	EDGES_TABLE_CHILD                         = "child_node_id"
+	EDGES_TABLE_PARENT                        = "parent_node_id"
+	EDGES_TABLE_DISPATCH                      = "dispatch_id"
+	EDGES_TABLE_NAME                          = "edge_name"
+	EDGES_TABLE_TYPE                          = "param_type"
+	EDGES_TABLE_ARG_INDEX                     = "arg_index"
+	SUBMISSIONS_TABLE                         = "submissions"
+	SUBMISSIONS_TABLE_DISPATCH_ID             = "dispatch_id"
+	SUBMISSIONS_TABLE_IDEMPOTENCY_KEY         = "idempotency_key"
+	SUBMISSIONS_TABLE_DIGEST                  = "digest"
+	SUBMISSIONS_TABLE_CREATED_AT              = "created_at"
+	EVENTS_TABLE                              = "events"
+	EVENTS_TABLE_ID                           = "id"
+	EVENTS_TABLE_DISPATCH_ID                  = "dispatch_id"
+	EVENTS_TABLE_NODE_ID                      = "transport_graph_node_id"
+	EVENTS_TABLE_KIND                         = "kind"
+	EVENTS_TABLE_STATUS                       = "status"
+	EVENTS_TABLE_START_TIME                   = "start_time"
+	EVENTS_TABLE_END_TIME                     = "end_time"
+	EVENTS_TABLE_CREATED_AT                   = "created_at"
+	WEBHOOKS_TABLE                            = "webhooks"
+	WEBHOOKS_TABLE_ID                         = "id"
+	WEBHOOKS_TABLE_URL                        = "url"
+	WEBHOOKS_TABLE_SECRET                     = "secret"
+	WEBHOOKS_TABLE_DISPATCH_ID                = "dispatch_id"
+	WEBHOOKS_TABLE_EVENT_KINDS                = "event_kinds"
+	WEBHOOKS_TABLE_OWNER                      = "owner"
+	WEBHOOKS_TABLE_STATUSES                   = "statuses"
+	WEBHOOKS_TABLE_NAMESPACE                  = "namespace"
+	WEBHOOKS_TABLE_CREATED_AT                 = "created_at"
+	WEBHOOK_DELIVERIES_TABLE                  = "webhook_deliveries"
+	WEBHOOK_DELIVERIES_TABLE_ID               = "id"
+	WEBHOOK_DELIVERIES_TABLE_WEBHOOK_ID       = "webhook_id"
+	WEBHOOK_DELIVERIES_TABLE_EVENT_ID         = "event_id"
+	WEBHOOK_DELIVERIES_TABLE_STATE            = "state"
+	WEBHOOK_DELIVERIES_TABLE_ATTEMPTS         = "attempts"
+	WEBHOOK_DELIVERIES_TABLE_LAST_STATUS_CODE = "last_status_code"
+	WEBHOOK_DELIVERIES_TABLE_LAST_ERROR       = "last_error"
+	WEBHOOK_DELIVERIES_TABLE_NEXT_ATTEMPT_AT  = "next_attempt_at"
+	WEBHOOK_DELIVERIES_TABLE_CREATED_AT       = "created_at"
+	WEBHOOK_DELIVERIES_TABLE_UPDATED_AT       = "updated_at"
+	WEBHOOK_DEAD_LETTERS_TABLE                = "webhook_dead_letters"
+	WEBHOOK_DEAD_LETTERS_TABLE_ID             = "id"
+	WEBHOOK_DEAD_LETTERS_TABLE_DELIVERY_ID    = "delivery_id"
+	WEBHOOK_DEAD_LETTERS_TABLE_WEBHOOK_ID     = "webhook_id"
+	WEBHOOK_DEAD_LETTERS_TABLE_EVENT_ID       = "event_id"
+	WEBHOOK_DEAD_LETTERS_TABLE_PAYLOAD        = "payload"
+	WEBHOOK_DEAD_LETTERS_TABLE_ATTEMPTS       = "attempts"
+	WEBHOOK_DEAD_LETTERS_TABLE_LAST_ERROR     = "last_error"
+	WEBHOOK_DEAD_LETTERS_TABLE_CREATED_AT     = "created_at"
+	API_TOKENS_TABLE                          = "api_tokens"
+	API_TOKENS_TABLE_ID                       = "id"
+	API_TOKENS_TABLE_USER                     = "user_name"
+	API_TOKENS_TABLE_NAME                     = "name"
+	API_TOKENS_TABLE_HASH                     = "token_hash"
+	API_TOKENS_TABLE_ROLE                     = "role"
+	API_TOKENS_TABLE_NAMESPACE                = "namespace"
+	API_TOKENS_TABLE_CREATED_AT               = "created_at"
+	AUDIT_LOG_TABLE                           = "audit_log"
+	AUDIT_LOG_TABLE_ID                        = "id"
+	AUDIT_LOG_TABLE_CREATED_AT                = "created_at"
+	AUDIT_LOG_TABLE_REQUEST_ID                = "request_id"
+	AUDIT_LOG_TABLE_USER                      = "user_name"
+	AUDIT_LOG_TABLE_TOKEN_ID                  = "token_id"
+	AUDIT_LOG_TABLE_ROLE                      = "role"
+	AUDIT_LOG_TABLE_METHOD                    = "method"
+	AUDIT_LOG_TABLE_PATH                      = "path"
+	AUDIT_LOG_TABLE_OUTCOME                   = "outcome"
+	AUDIT_LOG_TABLE_DETAIL                    = "detail"
+	AUDIT_LOG_TABLE_ACTION                    = "action"
+	AUDIT_LOG_TABLE_NAMESPACE                 = "namespace"
+	AUDIT_LOG_TABLE_DISPATCH_ID               = "dispatch_id"
+	AUDIT_LOG_TABLE_STATUS_CODE               = "status_code"
+	NAMESPACES_TABLE                          = "namespaces"
+	NAMESPACES_TABLE_NAME                     = "name"
+	NAMESPACES_TABLE_MAX_DISPATCHES           = "max_dispatches"
+	NAMESPACES_TABLE_MAX_ASSET_BYTES          = "max_asset_bytes"
+	NAMESPACES_TABLE_CREATED_AT               = "created_at"
 )
 
 var ERR_NOT_FOUND = fmt.Errorf("Record not found")
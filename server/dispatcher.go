@@ -60,6 +60,9 @@ func updateNodeStatus(db *sql.DB, c *common.Config, dispatch_id string, node_id
 func submitTaskGroup(db *sql.DB, dispatch_id string, task_group_id int) error {
 	// Enumerate the tasks in topological order
 	// Gather inputs
-	// Send a job using the executor API
+	// Send a job using the executor API through a client whose
+	// transport is tracing.NewTransport, so that the traceparent of
+	// the submission reaches the executor
+	// Record the outcome with metrics.ObserveTaskGroupSubmission
 	panic("Not Implemented")
 }
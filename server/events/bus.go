@@ -0,0 +1,140 @@
+// In-process publish/subscribe of status events
+//
+// The bus is a low-latency notification path only; the event log in
+// the database is authoritative. Subscribers that fall behind lose
+// events from their channel and must resynchronize from the log.
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/casey/govalent/server/models"
+)
+
+// Events buffered per subscriber before it is marked as lagging
+const SUBSCRIPTION_BUFFER_SIZE = 64
+
+type Subscription struct {
+	bus         *Bus
+	dispatch_id string
+	ch          chan models.StatusEvent
+	lagged      atomic.Bool
+	closed      bool
+}
+
+// Events of the subscribed dispatch in publication order
+func (s *Subscription) Events() <-chan models.StatusEvent {
+	return s.ch
+}
+
+// Whether events were dropped since the last call because the
+// subscriber fell behind
+func (s *Subscription) Lagged() bool {
+	return s.lagged.Swap(false)
+}
+
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// Called with every published batch of events. Listeners run on the
+// publishing goroutine and must not block.
+type Listener func(events ...models.StatusEvent)
+
+type Bus struct {
+	mu        sync.Mutex
+	subs      map[string]map[*Subscription]struct{}
+	listeners []Listener
+	closed    bool
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[string]map[*Subscription]struct{})}
+}
+
+func (b *Bus) Subscribe(dispatch_id string) *Subscription {
+	s := &Subscription{
+		bus:         b,
+		dispatch_id: dispatch_id,
+		ch:          make(chan models.StatusEvent, SUBSCRIPTION_BUFFER_SIZE),
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		s.closed = true
+		close(s.ch)
+		return s
+	}
+	if b.subs[dispatch_id] == nil {
+		b.subs[dispatch_id] = make(map[*Subscription]struct{})
+	}
+	b.subs[dispatch_id][s] = struct{}{}
+	return s
+}
+
+func (b *Bus) unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(b.subs[s.dispatch_id], s)
+	if len(b.subs[s.dispatch_id]) == 0 {
+		delete(b.subs, s.dispatch_id)
+	}
+	close(s.ch)
+}
+
+// Close every subscription, ending their streams. Subscriptions made
+// afterwards are returned closed; listeners still receive events.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for _, subs := range b.subs {
+		for s := range subs {
+			s.closed = true
+			close(s.ch)
+		}
+	}
+	b.subs = make(map[string]map[*Subscription]struct{})
+}
+
+// Receive the events of all dispatches
+func (b *Bus) AddListener(l Listener) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, l)
+}
+
+// Deliver events to the subscribers of their dispatches without
+// blocking. Publish only events whose transaction has committed.
+func (b *Bus) Publish(events ...models.StatusEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, l := range b.listeners {
+		l(events...)
+	}
+	for _, ev := range events {
+		for s := range b.subs[ev.DispatchId] {
+			select {
+			case s.ch <- ev:
+			default:
+				s.lagged.Store(true)
+			}
+		}
+	}
+}
+
+// Number of open subscriptions across all dispatches
+func (b *Bus) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for _, subs := range b.subs {
+		n += len(subs)
+	}
+	return n
+}
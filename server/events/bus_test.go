@@ -0,0 +1,82 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/casey/govalent/server/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishSubscribe(t *testing.T) {
+	bus := NewBus()
+	s1 := bus.Subscribe("a")
+	s2 := bus.Subscribe("b")
+	assert.Equal(t, 2, bus.Subscribers())
+
+	bus.Publish(
+		models.StatusEvent{Id: 1, DispatchId: "a", Status: "RUNNING"},
+		models.StatusEvent{Id: 2, DispatchId: "b", Status: "RUNNING"},
+		models.StatusEvent{Id: 3, DispatchId: "a", Status: "COMPLETED"},
+	)
+	assert.Equal(t, int64(1), (<-s1.Events()).Id)
+	assert.Equal(t, int64(3), (<-s1.Events()).Id)
+	assert.Equal(t, int64(2), (<-s2.Events()).Id)
+	assert.False(t, s1.Lagged())
+
+	s1.Close()
+	s1.Close()
+	_, ok := <-s1.Events()
+	assert.False(t, ok)
+	assert.Equal(t, 1, bus.Subscribers())
+
+	// Events for closed subscriptions are discarded
+	bus.Publish(models.StatusEvent{Id: 4, DispatchId: "a"})
+	s2.Close()
+	assert.Equal(t, 0, bus.Subscribers())
+}
+
+func TestSlowSubscriberLags(t *testing.T) {
+	bus := NewBus()
+	s := bus.Subscribe("a")
+	defer s.Close()
+	for i := 0; i < SUBSCRIPTION_BUFFER_SIZE+1; i++ {
+		bus.Publish(models.StatusEvent{Id: int64(i + 1), DispatchId: "a"})
+	}
+	assert.Equal(t, SUBSCRIPTION_BUFFER_SIZE, len(s.Events()))
+	assert.True(t, s.Lagged())
+	assert.False(t, s.Lagged())
+}
+
+func TestListener(t *testing.T) {
+	bus := NewBus()
+	var seen []int64
+	bus.AddListener(func(evs ...models.StatusEvent) {
+		for _, ev := range evs {
+			seen = append(seen, ev.Id)
+		}
+	})
+	bus.Publish(
+		models.StatusEvent{Id: 1, DispatchId: "a"},
+		models.StatusEvent{Id: 2, DispatchId: "b"},
+	)
+	assert.Equal(t, []int64{1, 2}, seen)
+}
+
+func TestClose(t *testing.T) {
+	bus := NewBus()
+	s := bus.Subscribe("a")
+	seen := 0
+	bus.AddListener(func(evs ...models.StatusEvent) { seen += len(evs) })
+	bus.Close()
+	_, ok := <-s.Events()
+	assert.False(t, ok)
+	assert.Equal(t, 0, bus.Subscribers())
+	s.Close()
+
+	late := bus.Subscribe("a")
+	_, ok = <-late.Events()
+	assert.False(t, ok)
+	late.Close()
+	bus.Publish(models.StatusEvent{Id: 1, DispatchId: "a"})
+	assert.Equal(t, 1, seen)
+}
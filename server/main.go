@@ -1,13 +1,26 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/casey/govalent/server/api"
 	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/crud"
 	"github.com/casey/govalent/server/db"
+	"github.com/casey/govalent/server/metrics"
+	"github.com/casey/govalent/server/models"
+	"github.com/casey/govalent/server/tracing"
+	"github.com/casey/govalent/server/webhooks"
 )
 
 // GET /executors
@@ -20,24 +33,176 @@ import (
 //
 //
 
+// Time allowed on shutdown for the metrics server to finish its
+// requests
+const METRICS_SHUTDOWN_TIMEOUT = 5 * time.Second
+
+// Time allowed on shutdown for exporting the remaining spans
+const TRACE_FLUSH_TIMEOUT = 5 * time.Second
+
 func main() {
-	c := common.NewConfigFromEnv()
+	c, opts, err := common.LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		slog.Error(fmt.Sprint("Invalid configuration: ", err))
+		os.Exit(2)
+	}
+	if opts.PrintConfig {
+		if err := c.Print(os.Stdout); err != nil {
+			slog.Error(fmt.Sprint("Error printing configuration: ", err))
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{AddSource: true, Level: c.LogLevel}))
+	log_level := new(slog.LevelVar)
+	log_level.Set(c.LogLevel)
+	logger := slog.New(common.NewContextHandler(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{AddSource: true, Level: log_level})))
 	slog.SetDefault(logger)
 	// log.SetFlags(log.Ldate | log.Ltime | log.Llongfile)
+	shutdown_tracing, err := tracing.Setup(context.Background(), &c)
+	if err != nil {
+		slog.Error(fmt.Sprint("Error setting up tracing: ", err))
+		os.Exit(1)
+	}
+	db.SetTxObserver(metrics.ObserveTx)
 	pool, err := db.GetDB(&c)
 	if err != nil {
 		slog.Error(fmt.Sprint("Error connecting to database: ", err))
+		os.Exit(1)
 	}
 	slog.Info(fmt.Sprint("Connected to DB at ", c.Dsn))
 	err = db.EmitDDL(pool)
 	if err != nil {
 		slog.Error(fmt.Sprint("Failed initialize db: ", err.Error()))
+		os.Exit(1)
 	}
 	slog.Info(fmt.Sprint("Initialized DB at ", c.Dsn))
-	s := api.NewGovalentAPIServer(&c, fmt.Sprintf(":%d", c.Port))
-	s.AddRoutes(&c, pool)
-	srv_err := s.Srv.ListenAndServe()
-	slog.Error(srv_err.Error())
+	if !c.AuthEnabled {
+		slog.Warn("Authentication is disabled; every request is treated as an admin")
+	} else if len(c.AdminToken) == 0 {
+		slog.Warn("GOVALENT_ADMIN_TOKEN is not set; only tokens stored in the database are accepted")
+	}
+	stmt_cache := crud.NewStmtCache(pool, crud.DEFAULT_STMT_CACHE_SIZE)
+	crud.SetStmtCache(stmt_cache)
+	metrics.Registry.MustRegister(metrics.NewStoreCollector(pool), metrics.NewStmtCacheCollector(stmt_cache))
+	var metrics_srv *http.Server
+	if c.MetricsPort > 0 {
+		metrics_mux := http.NewServeMux()
+		metrics_mux.Handle("GET /metrics", metrics.Handler())
+		metrics_srv = &http.Server{Addr: fmt.Sprintf(":%d", c.MetricsPort), Handler: metrics_mux}
+		go func() {
+			slog.Info(fmt.Sprintf("Serving metrics on port %d", c.MetricsPort))
+			err := metrics_srv.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				slog.Error(fmt.Sprint("Metrics server stopped: ", err))
+			}
+		}()
+	}
+	live := common.NewLiveConfig(c)
+	s := api.NewGovalentAPIServer(live, fmt.Sprintf(":%d", c.Port))
+	s.AddRoutes(live, pool)
+
+	webhook_dispatcher := webhooks.NewDispatcher(pool, webhooks.NewOptionsFromConfig(&c))
+	live.OnChange(func(c *common.Config) {
+		log_level.Set(c.LogLevel)
+		webhook_dispatcher.SetOptions(webhooks.NewOptionsFromConfig(c))
+	})
+	s.Events.AddListener(func(evs ...models.StatusEvent) { webhook_dispatcher.Wake() })
+	dispatcher_ctx, stop_dispatcher := context.WithCancel(context.Background())
+	dispatcher_done := make(chan struct{})
+	go func() {
+		webhook_dispatcher.Run(dispatcher_ctx)
+		close(dispatcher_done)
+	}()
+
+	go reloadOnSIGHUP(live)
+
+	signal_ctx, stop_signals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop_signals()
+	srv_err := make(chan error, 1)
+	go func() { srv_err <- s.Srv.ListenAndServe() }()
+	exit_code := 0
+	select {
+	case err := <-srv_err:
+		slog.Error(fmt.Sprint("Server stopped: ", err))
+		exit_code = 1
+	case <-signal_ctx.Done():
+		slog.Info(fmt.Sprintf("Shutting down; waiting up to %s for in-flight requests", live.Get().ShutdownTimeout))
+	}
+	// A second signal terminates immediately
+	stop_signals()
+
+	// Each phase has its own budget, so that one overrunning does not
+	// leave the next with none
+	shutdown_timeout := live.Get().ShutdownTimeout
+	requests_ctx, cancel_requests := context.WithTimeout(context.Background(), shutdown_timeout)
+	defer cancel_requests()
+	if err := s.Shutdown(requests_ctx); err != nil {
+		slog.Error(fmt.Sprint("Error waiting for in-flight requests: ", err))
+		exit_code = 1
+	}
+	if metrics_srv != nil {
+		metrics_ctx, cancel_metrics := context.WithTimeout(context.Background(), METRICS_SHUTDOWN_TIMEOUT)
+		defer cancel_metrics()
+		metrics_srv.Shutdown(metrics_ctx)
+	}
+
+	// Pending executor submissions will be flushed here once
+	// submitTaskGroup is implemented.
+
+	// Deliver the events of the last requests; deliveries left pending
+	// are persisted and attempted after restart
+	stop_dispatcher()
+	<-dispatcher_done
+	drain_ctx, cancel_drain := context.WithTimeout(context.Background(), shutdown_timeout)
+	defer cancel_drain()
+	if err := webhook_dispatcher.Drain(drain_ctx); err != nil {
+		slog.Warn(fmt.Sprint("Webhook deliveries remain pending: ", err))
+	}
+
+	stmt_cache.Close()
+	if err := pool.Close(); err != nil {
+		slog.Error(fmt.Sprint("Error closing database: ", err))
+		exit_code = 1
+	}
+	tracing_ctx, cancel_tracing := context.WithTimeout(context.Background(), TRACE_FLUSH_TIMEOUT)
+	defer cancel_tracing()
+	if err := shutdown_tracing(tracing_ctx); err != nil {
+		slog.Error(fmt.Sprint("Error flushing traces: ", err))
+	}
+	slog.Info("Shutdown complete")
+	os.Exit(exit_code)
+}
+
+// Reread the config file and environment on each SIGHUP, applying the
+// reloadable settings; changes made with PUT /config are overwritten.
+// The new settings are discarded if any other setting changed.
+func reloadOnSIGHUP(live *common.LiveConfig) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := reloadConfig(live); err != nil {
+			slog.Error(fmt.Sprint("Configuration not reloaded: ", err))
+		}
+	}
+}
+
+func reloadConfig(live *common.LiveConfig) error {
+	next, _, err := common.LoadConfig(os.Args[1:])
+	if err != nil {
+		return err
+	}
+	changed, err := live.Update(next)
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		slog.Info("Reloaded configuration; no settings changed")
+	} else {
+		slog.Info(fmt.Sprintf("Reloaded configuration; changed %s", strings.Join(changed, ", ")))
+	}
+	return nil
 }
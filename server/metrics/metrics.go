@@ -0,0 +1,95 @@
+// Prometheus metrics of the API server and dispatcher
+
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const NAMESPACE = "govalent"
+
+// Registry holds every govalent collector along with the Go runtime
+// and process collectors
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route pattern, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route pattern, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	DBTransactionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "db_transaction_duration_seconds",
+		Help:      "Database transaction durations by outcome (commit or rollback).",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"outcome"})
+
+	TaskGroupSubmitDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "task_group_submit_duration_seconds",
+		Help:      "Latency of submitting a task group to its executor.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"executor"})
+
+	ExecutorErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "executor_errors_total",
+		Help:      "Failed calls to executors.",
+	}, []string{"executor"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPRequestDuration,
+		DBTransactionDuration,
+		TaskGroupSubmitDuration,
+		ExecutorErrors,
+	)
+}
+
+func ObserveRequest(route string, method string, code int, d time.Duration) {
+	code_label := strconv.Itoa(code)
+	HTTPRequests.WithLabelValues(route, method, code_label).Inc()
+	HTTPRequestDuration.WithLabelValues(route, method, code_label).Observe(d.Seconds())
+}
+
+// Suitable for db.SetTxObserver
+func ObserveTx(d time.Duration, committed bool) {
+	outcome := "rollback"
+	if committed {
+		outcome = "commit"
+	}
+	DBTransactionDuration.WithLabelValues(outcome).Observe(d.Seconds())
+}
+
+// Records one task group submission; a non-nil err counts as an
+// executor error
+func ObserveTaskGroupSubmission(executor string, start time.Time, err error) {
+	TaskGroupSubmitDuration.WithLabelValues(executor).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ExecutorErrors.WithLabelValues(executor).Inc()
+	}
+}
+
+// Serves Registry in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/crud"
+	"github.com/casey/govalent/server/db"
+	"github.com/casey/govalent/server/models"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+)
+
+// Metric families of reg by name
+func gather(t *testing.T, reg prometheus.Gatherer) map[string]*dto.MetricFamily {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Error gathering metrics: %v", err)
+	}
+	res := make(map[string]*dto.MetricFamily)
+	for _, f := range families {
+		res[f.GetName()] = f
+	}
+	return res
+}
+
+func labels(m *dto.Metric) map[string]string {
+	res := make(map[string]string)
+	for _, item := range m.GetLabel() {
+		res[item.GetName()] = item.GetValue()
+	}
+	return res
+}
+
+func TestStoreCollector(t *testing.T) {
+	config, _, err := common.LoadConfig(nil)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	c := common.Config{Dsn: ":memory:"}
+	d, err := db.GetDB(&c)
+	if err != nil {
+		t.Fatalf("Error establishing DB connection: %v", err)
+	}
+	defer d.Close()
+	d.SetMaxOpenConns(1)
+	if err := db.EmitDDL(d); err != nil {
+		t.Fatalf("Error emitting DDL: %v", err)
+	}
+	db.SetTxObserver(ObserveTx)
+	defer db.SetTxObserver(nil)
+
+	tx, _ := d.Begin()
+	ts := time.Now().UTC()
+	electrons := []models.ElectronSchema{
+		{NodeId: 0, Metadata: models.ElectronMeta{Status: "RUNNING", Executor: "local", ExecutorData: "{}"}},
+		{NodeId: 1, Metadata: models.ElectronMeta{Status: "COMPLETED", Executor: "local", ExecutorData: "{}"}},
+	}
+	dispatch := models.DispatchSchema{
+		Metadata: models.DispatchMeta{DispatchId: uuid.NewString(), Status: "RUNNING", StartTime: &ts, CreatedAt: ts, Namespace: "team-a"},
+		Lattice: models.LatticeSchema{
+			Metadata:       models.LatticeMeta{Name: "workflow", Executor: "local", ExecutorData: "{}"},
+			Assets:         models.LatticeAssets{Inputs: models.AssetDetails{Size: 7}},
+			TransportGraph: models.Graph{Nodes: electrons},
+		},
+	}
+	if err := crud.ImportManifest(context.Background(), &config, tx, &dispatch); err != nil {
+		t.Fatalf("Error importing manifest: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing: %v", err)
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewStoreCollector(d))
+	families := gather(t, reg)
+
+	dispatches := families["govalent_dispatches"].GetMetric()
+	assert.Equal(t, 1, len(dispatches))
+	assert.Equal(t, map[string]string{"namespace": "team-a", "status": "RUNNING"}, labels(dispatches[0]))
+	assert.Equal(t, 1.0, dispatches[0].GetGauge().GetValue())
+	assert.Equal(t, 2, len(families["govalent_electrons"].GetMetric()))
+	asset_bytes := families["govalent_asset_bytes"].GetMetric()
+	assert.Equal(t, 7.0, asset_bytes[0].GetGauge().GetValue())
+
+	// The import was committed and the collector rolled back
+	for _, m := range gather(t, Registry)["govalent_db_transaction_duration_seconds"].GetMetric() {
+		assert.NotZero(t, m.GetHistogram().GetSampleCount(), labels(m)["outcome"])
+	}
+}
+
+func TestObserveRequest(t *testing.T) {
+	ObserveRequest("/dispatches/{dispatch_id}", "GET", 404, 5*time.Millisecond)
+	n := 0.0
+	for _, m := range gather(t, Registry)["govalent_http_requests_total"].GetMetric() {
+		if l := labels(m); l["route"] == "/dispatches/{dispatch_id}" && l["code"] == "404" {
+			n = m.GetCounter().GetValue()
+		}
+	}
+	assert.Equal(t, 1.0, n)
+}
+
+func TestStmtCacheCollector(t *testing.T) {
+	d, err := db.GetDB(&common.Config{Dsn: ":memory:"})
+	if err != nil {
+		t.Fatalf("Error establishing DB connection: %v", err)
+	}
+	defer d.Close()
+	cache := crud.NewStmtCache(d, crud.DEFAULT_STMT_CACHE_SIZE)
+	defer cache.Close()
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewStmtCacheCollector(cache))
+	families := gather(t, reg)
+	for _, name := range []string{
+		"govalent_stmt_cache_hits_total",
+		"govalent_stmt_cache_misses_total",
+		"govalent_stmt_cache_evictions_total",
+	} {
+		metrics := families[name].GetMetric()
+		if assert.Equal(t, 1, len(metrics), name) {
+			assert.Equal(t, 0.0, metrics[0].GetCounter().GetValue(), name)
+		}
+	}
+	assert.Equal(t, 1, len(families["govalent_stmt_cache_statements"].GetMetric()))
+}
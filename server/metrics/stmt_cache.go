@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"github.com/casey/govalent/server/crud"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	stmtCacheHitsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(NAMESPACE, "stmt_cache", "hits_total"),
+		"Prepared statements served from the statement cache.",
+		nil, nil,
+	)
+	stmtCacheMissesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(NAMESPACE, "stmt_cache", "misses_total"),
+		"Statements prepared on the transaction because they were not cached.",
+		nil, nil,
+	)
+	stmtCacheEvictionsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(NAMESPACE, "stmt_cache", "evictions_total"),
+		"Statements closed to keep the statement cache within its capacity.",
+		nil, nil,
+	)
+	stmtCacheSizeDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(NAMESPACE, "stmt_cache", "statements"),
+		"Statements currently held by the statement cache.",
+		nil, nil,
+	)
+)
+
+// Reports the counters of a statement cache on each scrape
+type StmtCacheCollector struct {
+	c *crud.StmtCache
+}
+
+func NewStmtCacheCollector(c *crud.StmtCache) *StmtCacheCollector {
+	return &StmtCacheCollector{c: c}
+}
+
+func (s *StmtCacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- stmtCacheHitsDesc
+	ch <- stmtCacheMissesDesc
+	ch <- stmtCacheEvictionsDesc
+	ch <- stmtCacheSizeDesc
+}
+
+func (s *StmtCacheCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := s.c.Stats()
+	ch <- prometheus.MustNewConstMetric(stmtCacheHitsDesc, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(stmtCacheMissesDesc, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(stmtCacheEvictionsDesc, prometheus.CounterValue, float64(stats.Evictions))
+	ch <- prometheus.MustNewConstMetric(stmtCacheSizeDesc, prometheus.GaugeValue, float64(stats.Size))
+}
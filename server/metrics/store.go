@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+
+	"github.com/casey/govalent/server/crud"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	dispatchesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(NAMESPACE, "", "dispatches"),
+		"Dispatches by namespace and status.",
+		[]string{"namespace", "status"}, nil,
+	)
+	electronsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(NAMESPACE, "", "electrons"),
+		"Electrons by namespace and status.",
+		[]string{"namespace", "status"}, nil,
+	)
+	assetBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(NAMESPACE, "", "asset_bytes"),
+		"Declared size of registered assets by namespace.",
+		[]string{"namespace"}, nil,
+	)
+)
+
+// Reads dispatch, electron and asset totals from the database on each
+// scrape
+type StoreCollector struct {
+	d *sql.DB
+}
+
+func NewStoreCollector(d *sql.DB) *StoreCollector {
+	return &StoreCollector{d: d}
+}
+
+func (s *StoreCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dispatchesDesc
+	ch <- electronsDesc
+	ch <- assetBytesDesc
+}
+
+// Errors are logged and the affected metrics omitted
+func (s *StoreCollector) Collect(ch chan<- prometheus.Metric) {
+	t, err := s.d.Begin()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error collecting metrics: %s", err.Error()))
+		return
+	}
+	defer t.Rollback()
+
+	dispatches, api_err := crud.CountDispatchesByStatus(t)
+	if api_err == nil {
+		for _, item := range dispatches {
+			ch <- prometheus.MustNewConstMetric(dispatchesDesc, prometheus.GaugeValue, float64(item.Count), item.Namespace, item.Status)
+		}
+	}
+	electrons, api_err := crud.CountElectronsByStatus(t)
+	if api_err == nil {
+		for _, item := range electrons {
+			ch <- prometheus.MustNewConstMetric(electronsDesc, prometheus.GaugeValue, float64(item.Count), item.Namespace, item.Status)
+		}
+	}
+	asset_bytes, api_err := crud.SumAssetBytes(t)
+	if api_err == nil {
+		for ns, n := range asset_bytes {
+			ch <- prometheus.MustNewConstMetric(assetBytesDesc, prometheus.GaugeValue, float64(n), ns)
+		}
+	}
+}
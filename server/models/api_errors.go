@@ -6,17 +6,48 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 )
 
 const ERROR_DETAIL_INVALID = "Invalid value"
 const ERROR_DETAIL_MISSING = "Missing"
 
+// Stable, machine-readable error codes
+const (
+	ERROR_CODE_BAD_REQUEST       = "bad_request"
+	ERROR_CODE_UNAUTHORIZED      = "unauthorized"
+	ERROR_CODE_FORBIDDEN         = "forbidden"
+	ERROR_CODE_NOT_FOUND         = "not_found"
+	ERROR_CODE_CONFLICT          = "conflict"
+	ERROR_CODE_PAYLOAD_TOO_LARGE = "payload_too_large"
+	ERROR_CODE_QUOTA_EXCEEDED    = "quota_exceeded"
+	ERROR_CODE_VALIDATION        = "validation_error"
+	ERROR_CODE_INTERNAL          = "internal_error"
+	ERROR_CODE_NOT_IMPLEMENTED   = "not_implemented"
+	ERROR_CODE_UNAVAILABLE       = "service_unavailable"
+)
+
+var defaultErrorCodes = map[int]string{
+	http.StatusBadRequest:            ERROR_CODE_BAD_REQUEST,
+	http.StatusUnauthorized:          ERROR_CODE_UNAUTHORIZED,
+	http.StatusForbidden:             ERROR_CODE_FORBIDDEN,
+	http.StatusNotFound:              ERROR_CODE_NOT_FOUND,
+	http.StatusConflict:              ERROR_CODE_CONFLICT,
+	http.StatusRequestEntityTooLarge: ERROR_CODE_PAYLOAD_TOO_LARGE,
+	http.StatusUnprocessableEntity:   ERROR_CODE_VALIDATION,
+	http.StatusInternalServerError:   ERROR_CODE_INTERNAL,
+	http.StatusServiceUnavailable:    ERROR_CODE_UNAVAILABLE,
+}
+
+// Set by the API server on every response
+const REQUEST_ID_HEADER = "X-Request-ID"
+
 var NullReferenceError = fmt.Errorf("Unexpected null reference")
 
 type ValidationErrorDetail struct {
-	Location string
-	Attr     string
-	Detail   string
+	Location string `json:"location"`
+	Attr     string `json:"attr,omitempty"`
+	Detail   string `json:"detail"`
 }
 
 type ValidationError struct {
@@ -33,60 +64,194 @@ func NewSingleValidationError(loc string, attr string, detail string) *Validatio
 	}
 }
 
+func (e *ValidationError) Add(loc string, attr string, detail string) {
+	e.Details = append(e.Details, ValidationErrorDetail{Location: loc, Attr: attr, Detail: detail})
+}
+
+// Merge the details of err into e, prefixing each Attr with attr_prefix
+func (e *ValidationError) merge(attr_prefix string, err *APIError) {
+	if err == nil {
+		return
+	}
+	inner, ok := err.Err.(*ValidationError)
+	if !ok {
+		e.Add("body", strings.TrimSuffix(attr_prefix, "."), err.Err.Error())
+		return
+	}
+	for _, item := range inner.Details {
+		e.Add(item.Location, attr_prefix+item.Attr, item.Detail)
+	}
+}
+
+// nil if no details were recorded
+func (e *ValidationError) asAPIError() *APIError {
+	if len(e.Details) == 0 {
+		return nil
+	}
+	return NewValidationError(e)
+}
+
 func (e *ValidationError) Error() string {
-	ser, _ := json.Marshal(e)
-	return string(ser)
+	parts := make([]string, len(e.Details))
+	for i, item := range e.Details {
+		loc := item.Location
+		if len(item.Attr) > 0 {
+			loc = fmt.Sprintf("%s: %s", loc, item.Attr)
+		}
+		parts[i] = fmt.Sprintf("%s: %s", loc, item.Detail)
+	}
+	return fmt.Sprintf("Validation failed: %s", strings.Join(parts, "; "))
 }
 
 type APIError struct {
 	Err        error
 	StatusCode int
+	// One of the ERROR_CODE_* constants; derived from StatusCode if empty
+	Code string
+}
+
+func (e *APIError) ErrorCode() string {
+	if len(e.Code) > 0 {
+		return e.Code
+	}
+	if code, ok := defaultErrorCodes[e.StatusCode]; ok {
+		return code
+	}
+	if e.StatusCode >= 500 {
+		return ERROR_CODE_INTERNAL
+	}
+	return ERROR_CODE_BAD_REQUEST
 }
 
 func (e *APIError) Error() string {
 	return fmt.Sprintf("APIError with status code %d: %s", e.StatusCode, e.Err.Error())
 }
 
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
 func NewGenericClientError(msg string) *APIError {
 	return &APIError{
 		Err:        errors.New(msg),
-		StatusCode: 400,
+		StatusCode: http.StatusBadRequest,
+		Code:       ERROR_CODE_BAD_REQUEST,
 	}
 }
 
 func NewNotImplementedError() *APIError {
 	return &APIError{
 		Err:        errors.New("Not implemented"),
-		StatusCode: 500,
+		StatusCode: http.StatusInternalServerError,
+		Code:       ERROR_CODE_NOT_IMPLEMENTED,
 	}
 }
 
 func NewGenericServerError(err error) *APIError {
 	return &APIError{
 		Err:        err,
-		StatusCode: 500,
+		StatusCode: http.StatusInternalServerError,
+		Code:       ERROR_CODE_INTERNAL,
 	}
 }
 
 func NewValidationError(err error) *APIError {
 	return &APIError{
 		Err:        err,
-		StatusCode: 422,
+		StatusCode: http.StatusUnprocessableEntity,
+		Code:       ERROR_CODE_VALIDATION,
 	}
 }
 
 func NewNotFoundError(err error) *APIError {
 	return &APIError{
 		Err:        err,
-		StatusCode: 404,
+		StatusCode: http.StatusNotFound,
+		Code:       ERROR_CODE_NOT_FOUND,
+	}
+}
+
+func NewUnauthorizedError(err error) *APIError {
+	return &APIError{
+		Err:        err,
+		StatusCode: http.StatusUnauthorized,
+		Code:       ERROR_CODE_UNAUTHORIZED,
+	}
+}
+
+func NewForbiddenError(err error) *APIError {
+	return &APIError{
+		Err:        err,
+		StatusCode: http.StatusForbidden,
+		Code:       ERROR_CODE_FORBIDDEN,
+	}
+}
+
+func NewConflictError(err error) *APIError {
+	return &APIError{
+		Err:        err,
+		StatusCode: http.StatusConflict,
+		Code:       ERROR_CODE_CONFLICT,
 	}
 }
 
+func NewPayloadTooLargeError(err error) *APIError {
+	return &APIError{
+		Err:        err,
+		StatusCode: http.StatusRequestEntityTooLarge,
+		Code:       ERROR_CODE_PAYLOAD_TOO_LARGE,
+	}
+}
+
+// A namespace quota would be exceeded by the request
+func NewQuotaExceededError(err error) *APIError {
+	return &APIError{
+		Err:        err,
+		StatusCode: http.StatusForbidden,
+		Code:       ERROR_CODE_QUOTA_EXCEEDED,
+	}
+}
+
+// The server is shutting down and accepts no new work
+func NewServiceUnavailableError(err error) *APIError {
+	return &APIError{
+		Err:        err,
+		StatusCode: http.StatusServiceUnavailable,
+		Code:       ERROR_CODE_UNAVAILABLE,
+	}
+}
+
+// Body of every error response
+type ErrorResponse struct {
+	Code      string                  `json:"code"`
+	Message   string                  `json:"message"`
+	Details   []ValidationErrorDetail `json:"details,omitempty"`
+	RequestId string                  `json:"request_id,omitempty"`
+}
+
+func NewErrorResponse(api_err *APIError, request_id string) ErrorResponse {
+	resp := ErrorResponse{
+		Code:      api_err.ErrorCode(),
+		RequestId: request_id,
+	}
+	var validation_err *ValidationError
+	if errors.As(api_err.Err, &validation_err) {
+		resp.Message = "Request validation failed"
+		resp.Details = validation_err.Details
+	} else if api_err.Err != nil {
+		resp.Message = api_err.Err.Error()
+	} else {
+		resp.Message = http.StatusText(api_err.StatusCode)
+	}
+	return resp
+}
+
 func WriteError(w http.ResponseWriter, api_err *APIError) {
 	if api_err != nil {
-		enc := json.NewEncoder(w)
+		respBody := NewErrorResponse(api_err, w.Header().Get(REQUEST_ID_HEADER))
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(api_err.StatusCode)
-		respBody := map[string]string{"detail": api_err.Err.Error()}
+		enc := json.NewEncoder(w)
 		if err := enc.Encode(&respBody); err != nil {
 			slog.Warn(fmt.Sprint("Error writing error:", err.Error()))
 		}
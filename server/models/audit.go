@@ -0,0 +1,61 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Outcomes of audited requests
+const (
+	AUDIT_OUTCOME_DENIED    = "denied"
+	AUDIT_OUTCOME_SUCCEEDED = "succeeded"
+	AUDIT_OUTCOME_FAILED    = "failed"
+)
+
+// Mutating operations recorded in the audit log
+const (
+	AUDIT_ACTION_IMPORT_DISPATCH  = "dispatch.import"
+	AUDIT_ACTION_DELETE_DISPATCH  = "dispatch.delete"
+	AUDIT_ACTION_DISPATCH_STATUS  = "dispatch.status"
+	AUDIT_ACTION_ELECTRON_STATUS  = "electron.status"
+	AUDIT_ACTION_UPDATE_CONFIG    = "config.update"
+	AUDIT_ACTION_CREATE_TOKEN     = "token.create"
+	AUDIT_ACTION_DELETE_TOKEN     = "token.delete"
+	AUDIT_ACTION_UPDATE_NAMESPACE = "namespace.update"
+	AUDIT_ACTION_CREATE_WEBHOOK   = "webhook.create"
+	AUDIT_ACTION_DELETE_WEBHOOK   = "webhook.delete"
+)
+
+// A security-relevant action, as recorded in the audit log
+type AuditEntry struct {
+	Id        int64     `json:"id"`
+	CreatedAt time.Time `json:"timestamp"`
+	RequestId string    `json:"request_id"`
+	User      string    `json:"user"`
+	TokenId   int64     `json:"token_id"`
+	Role      string    `json:"role"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Outcome   string    `json:"outcome"`
+	Detail    string    `json:"detail"`
+	// One of the AUDIT_ACTION_* constants; empty for denied requests
+	Action     string `json:"action"`
+	Namespace  string `json:"namespace"`
+	DispatchId string `json:"dispatch_id"`
+	StatusCode int    `json:"status_code"`
+}
+
+// GET /audit
+type GetBulkAuditEntriesResponse struct {
+	Records []AuditEntry `json:"records"`
+	// Number of entries matching the query, across all pages
+	Total int `json:"total"`
+}
+
+func (r *GetBulkAuditEntriesResponse) EncodeJSON(enc *json.Encoder) *APIError {
+	json_err := enc.Encode(r)
+	if json_err != nil {
+		return NewGenericServerError(json_err)
+	}
+	return nil
+}
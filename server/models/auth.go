@@ -0,0 +1,185 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Roles of API tokens
+const (
+	ROLE_ADMIN    = "admin"
+	ROLE_USER     = "user"
+	ROLE_EXECUTOR = "executor"
+)
+
+// Scopes required by routes; see GovalentAPIServer.AddRoute
+const (
+	SCOPE_DISPATCHES_READ   = "dispatches:read"
+	SCOPE_DISPATCHES_WRITE  = "dispatches:write"
+	SCOPE_DISPATCHES_DELETE = "dispatches:delete"
+	// Setting the status of a dispatch to CANCELLED
+	SCOPE_DISPATCHES_CANCEL = "dispatches:cancel"
+	// Dispatch and electron status updates
+	SCOPE_STATUS_WRITE = "status:write"
+	SCOPE_ASSETS_READ  = "assets:read"
+	SCOPE_ASSETS_WRITE = "assets:write"
+	SCOPE_WEBHOOKS     = "webhooks:manage"
+	SCOPE_TOKENS       = "tokens:manage"
+	SCOPE_CONFIG       = "config:read"
+	SCOPE_METRICS      = "metrics:read"
+	SCOPE_AUDIT        = "audit:read"
+	// Maintenance such as garbage collection and retention
+	SCOPE_ADMIN = "admin"
+)
+
+var roleScopes = map[string][]string{
+	ROLE_ADMIN: {
+		SCOPE_DISPATCHES_READ,
+		SCOPE_DISPATCHES_WRITE,
+		SCOPE_DISPATCHES_DELETE,
+		SCOPE_DISPATCHES_CANCEL,
+		SCOPE_STATUS_WRITE,
+		SCOPE_ASSETS_READ,
+		SCOPE_ASSETS_WRITE,
+		SCOPE_WEBHOOKS,
+		SCOPE_TOKENS,
+		SCOPE_CONFIG,
+		SCOPE_METRICS,
+		SCOPE_AUDIT,
+		SCOPE_ADMIN,
+	},
+	// Users reach the assets of their own dispatches through
+	// /dispatches/{dispatch_id}; /assets is not filtered by owner.
+	// Progress is reported by executors; users may only cancel.
+	ROLE_USER: {
+		SCOPE_DISPATCHES_READ,
+		SCOPE_DISPATCHES_WRITE,
+		SCOPE_DISPATCHES_DELETE,
+		SCOPE_DISPATCHES_CANCEL,
+		SCOPE_WEBHOOKS,
+	},
+	// Executors report progress and upload outputs for the dispatches
+	// of every user, but cannot submit or delete dispatches
+	ROLE_EXECUTOR: {
+		SCOPE_DISPATCHES_READ,
+		SCOPE_DISPATCHES_CANCEL,
+		SCOPE_STATUS_WRITE,
+		SCOPE_ASSETS_READ,
+		SCOPE_ASSETS_WRITE,
+	},
+}
+
+func ValidateRole(role string) bool {
+	_, ok := roleScopes[role]
+	return ok
+}
+
+// The scopes granted to role
+func RoleScopes(role string) []string {
+	return roleScopes[role]
+}
+
+// The authenticated caller of a request
+type Principal struct {
+	// 0 for the bootstrap admin token and when auth is disabled
+	TokenId int64
+	User    string
+	Role    string
+	// Empty if the principal may act in every namespace
+	Namespace string
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	for _, item := range roleScopes[p.Role] {
+		if item == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Principal) IsAdmin() bool {
+	return p.Role == ROLE_ADMIN
+}
+
+// Admins and executors act on the dispatches of every user
+func (p *Principal) AllDispatches() bool {
+	return p.Role == ROLE_ADMIN || p.Role == ROLE_EXECUTOR
+}
+
+func (p *Principal) Owns(owner string) bool {
+	return p.AllDispatches() || p.User == owner
+}
+
+func (p *Principal) InNamespace(ns string) bool {
+	return len(p.Namespace) == 0 || p.Namespace == ns
+}
+
+type APIToken struct {
+	Id   int64  `json:"id"`
+	User string `json:"user"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+	// Empty for tokens valid in every namespace
+	Namespace string `json:"namespace"`
+	// The bearer token; only returned when it is created
+	Token     string    `json:"token,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (a *APIToken) EncodeJSON(enc *json.Encoder) *APIError {
+	json_err := enc.Encode(a)
+	if json_err != nil {
+		return NewGenericServerError(json_err)
+	}
+	return nil
+}
+
+// POST /tokens
+type TokenCreateRequest struct {
+	User string `json:"user"`
+	// Free-form description, e.g. "ci"
+	Name string `json:"name"`
+	// One of the ROLE_* constants; defaults to user
+	Role string `json:"role"`
+	// Binds the token to a namespace. Admin and executor tokens may
+	// omit it to act in every namespace; user tokens default to
+	// DEFAULT_NAMESPACE.
+	Namespace string `json:"namespace"`
+}
+
+func (p *TokenCreateRequest) DecodeJSON(dec *json.Decoder) *APIError {
+	dec_err := dec.Decode(p)
+	if dec_err != nil {
+		return NewValidationError(dec_err)
+	}
+	errs := ValidationError{}
+	if len(p.User) == 0 {
+		errs.Add("body", "user", ERROR_DETAIL_MISSING)
+	}
+	if len(p.Role) == 0 {
+		p.Role = ROLE_USER
+	}
+	if !ValidateRole(p.Role) {
+		errs.Add("body", "role", ERROR_DETAIL_INVALID)
+	}
+	if len(p.Namespace) == 0 && p.Role == ROLE_USER {
+		p.Namespace = DEFAULT_NAMESPACE
+	}
+	if len(p.Namespace) > 0 && !ValidateNamespace(p.Namespace) {
+		errs.Add("body", "namespace", ERROR_DETAIL_INVALID)
+	}
+	return errs.asAPIError()
+}
+
+type GetBulkTokensResponse struct {
+	Records []APIToken `json:"records"`
+}
+
+func (r *GetBulkTokensResponse) EncodeJSON(enc *json.Encoder) *APIError {
+	json_err := enc.Encode(r)
+	if json_err != nil {
+		return NewGenericServerError(json_err)
+	}
+	return nil
+}
@@ -1,7 +1,11 @@
 package models
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
+	"io"
 	"time"
 
 	"github.com/casey/govalent/server/common"
@@ -51,6 +55,10 @@ type DispatchMeta struct {
 	Status         string     `json:"status"`
 	StartTime      *time.Time `json:"start_time"`
 	EndTime        *time.Time `json:"end_time"`
+	// User who submitted the dispatch; set by the server
+	Owner string `json:"owner"`
+	// Tenant of the dispatch; set by the server
+	Namespace string `json:"namespace"`
 
 	CreatedAt time.Time
 	UpdatedAt time.Time
@@ -182,16 +190,101 @@ func (m *DispatchSchema) DecodeJSON(dec *json.Decoder) *APIError {
 		wrapped := NewValidationError(dec_err)
 		return wrapped
 	}
+	// Validation errors are already 422s carrying structured details
 	err := m.validateRequest()
 	if err != nil {
-		wrapped := NewValidationError(err)
-		return wrapped
+		return err
+	}
+	return nil
+}
+
+// Callbacks which feed transport graph nodes and edges to a stream
+// encoder one at a time, in the order they should appear in the output
+type ElectronSource func(emit func(*ElectronSchema) error) error
+type EdgeSource func(emit func(*Edge) error) error
+
+// A DispatchSchema whose transport graph is written incrementally
+//
+// The encoded output is byte-for-byte identical to DispatchSchema.EncodeJSON
+// with the same nodes and links.
+type DispatchSchemaStream struct {
+	// Everything except Lattice.TransportGraph, which is ignored
+	Header DispatchSchema
+	Nodes  ElectronSource
+	Links  EdgeSource
+}
+
+// Trailing bytes of an encoded DispatchSchema with an empty transport graph
+const emptyGraphSuffix = `[],"links":[]}}}`
+
+func (m *DispatchSchemaStream) EncodeJSONStream(w io.Writer) *APIError {
+	header := m.Header
+	header.Lattice.TransportGraph = Graph{Nodes: []ElectronSchema{}, Links: []Edge{}}
+	if err := header.validateResponse(); err != nil {
+		return err
+	}
+	serialized, err := json.Marshal(&header)
+	if err != nil {
+		return NewGenericServerError(err)
+	}
+	if !bytes.HasSuffix(serialized, []byte(emptyGraphSuffix)) {
+		return NewGenericServerError(errors.New("Unexpected DispatchSchema layout"))
+	}
+	// Everything up to the opening bracket of transport_graph.nodes
+	prefix := serialized[:len(serialized)-len(emptyGraphSuffix)]
+
+	bw := bufio.NewWriter(w)
+	bw.Write(prefix)
+	bw.WriteByte('[')
+	first := true
+	err = m.Nodes(func(e *ElectronSchema) error {
+		if api_err := e.validateResponse(); api_err != nil {
+			return api_err
+		}
+		node, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if !first {
+			bw.WriteByte(',')
+		}
+		first = false
+		_, err = bw.Write(node)
+		return err
+	})
+	if err != nil {
+		return NewGenericServerError(err)
+	}
+
+	bw.WriteString(`],"links":[`)
+	first = true
+	err = m.Links(func(e *Edge) error {
+		edge, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if !first {
+			bw.WriteByte(',')
+		}
+		first = false
+		_, err = bw.Write(edge)
+		return err
+	})
+	if err != nil {
+		return NewGenericServerError(err)
+	}
+	// json.Encoder terminates each value with a newline
+	bw.WriteString("]}}}\n")
+	if err := bw.Flush(); err != nil {
+		return NewGenericServerError(err)
 	}
 	return nil
 }
 
 type GetBulkDispatchesResponse struct {
 	Records []DispatchMeta `json:"records"`
+	// Number of matching dispatches across all pages
+	Total int `json:"total"`
 }
 
 func (r *GetBulkDispatchesResponse) EncodeJSON(enc *json.Encoder) *APIError {
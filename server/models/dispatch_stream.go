@@ -0,0 +1,426 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Number of nodes or edges handed to a DispatchSchemaSink at once
+const MANIFEST_STREAM_BATCH_SIZE = 1000
+
+// Validation stops collecting details beyond this many
+const MAX_VALIDATION_ERROR_DETAILS = 1000
+
+// Receives a manifest from DecodeDispatchSchemaStream as it is decoded.
+//
+// WriteHeader is called exactly once, with everything except the
+// transport graph, before any nodes or links.
+type DispatchSchemaSink interface {
+	WriteHeader(*DispatchSchema) *APIError
+	WriteNodes([]ElectronSchema) *APIError
+	WriteLinks([]Edge) *APIError
+}
+
+type ManifestLimits struct {
+	// Maximum number of transport graph nodes; non-positive means unlimited
+	MaxNodes int
+}
+
+type dispatchStreamDecoder struct {
+	dec    *json.Decoder
+	sink   DispatchSchemaSink
+	limits ManifestLimits
+
+	header DispatchSchema
+	// Header sections decoded so far
+	seen           map[string]bool
+	header_written bool
+
+	// Nodes and links waiting to be handed to the sink
+	nodes   []ElectronSchema
+	links   []Edge
+	n_nodes int
+	graph   *graphValidator
+
+	details   []ValidationErrorDetail
+	truncated bool
+}
+
+// Decode a DispatchSchema from dec one token at a time, passing nodes and
+// links to sink in batches as they arrive.
+//
+// Validation errors do not stop decoding; every error is recorded with
+// its JSON path and returned together once the body has been consumed.
+// The sink is not called again after the first validation error.
+// Malformed JSON and exceeded limits abort decoding immediately.
+//
+// Nodes and links can only be written once the dispatch and lattice
+// metadata and assets have been seen. Manifests which list
+// transport_graph before them are buffered in memory until the end.
+func DecodeDispatchSchemaStream(dec *json.Decoder, limits ManifestLimits, sink DispatchSchemaSink) (*DispatchSchema, *APIError) {
+	s := dispatchStreamDecoder{
+		dec:    dec,
+		sink:   sink,
+		limits: limits,
+		seen:   make(map[string]bool),
+	}
+	if err := s.decodeDispatch(); err != nil {
+		return nil, err
+	}
+
+	// Validate any sections absent from the body
+	if !s.seen["metadata"] {
+		s.addError("metadata", (&s.header.Metadata).validateRequest())
+	}
+	if !s.seen["lattice.metadata"] {
+		s.addError("lattice.metadata", (&s.header.Lattice.Metadata).validateRequest())
+	}
+	if s.graph != nil {
+		s.addDetails(s.graph.finish())
+	}
+	if len(s.details) > 0 {
+		return nil, s.validationError()
+	}
+	s.seen["metadata"] = true
+	s.seen["assets"] = true
+	s.seen["lattice.metadata"] = true
+	s.seen["lattice.assets"] = true
+	if err := s.writeHeader(); err != nil {
+		return nil, err
+	}
+	if err := s.flush(0); err != nil {
+		return nil, err
+	}
+	return &s.header, nil
+}
+
+func (s *dispatchStreamDecoder) validationError() *APIError {
+	details := s.details
+	if s.truncated {
+		details = append(details, ValidationErrorDetail{
+			Location: "body",
+			Detail:   fmt.Sprintf("Too many errors; only the first %d are reported", MAX_VALIDATION_ERROR_DETAILS),
+		})
+	}
+	return NewValidationError(&ValidationError{Details: details})
+}
+
+// Record a non-fatal error at the JSON path loc
+func (s *dispatchStreamDecoder) addError(loc string, err error) {
+	if err == nil {
+		return
+	}
+	if api_err, ok := err.(*APIError); ok {
+		if api_err == nil {
+			return
+		}
+		err = api_err.Err
+	}
+	var details []ValidationErrorDetail
+	var validation_err *ValidationError
+	var type_err *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &validation_err):
+		for _, item := range validation_err.Details {
+			details = append(details, ValidationErrorDetail{Location: loc, Attr: item.Attr, Detail: item.Detail})
+		}
+	case errors.As(err, &type_err):
+		details = append(details, ValidationErrorDetail{
+			Location: loc,
+			Attr:     type_err.Field,
+			Detail:   fmt.Sprintf("%s: expected %s, got %s", ERROR_DETAIL_INVALID, type_err.Type.String(), type_err.Value),
+		})
+	default:
+		details = append(details, ValidationErrorDetail{Location: loc, Detail: err.Error()})
+	}
+	s.addDetails(details)
+}
+
+func (s *dispatchStreamDecoder) addDetails(details []ValidationErrorDetail) {
+	if len(details) == 0 {
+		return
+	}
+	for _, item := range details {
+		if len(s.details) >= MAX_VALIDATION_ERROR_DETAILS {
+			s.truncated = true
+			break
+		}
+		s.details = append(s.details, item)
+	}
+	// Nothing more will be written, so stop buffering
+	s.nodes = nil
+	s.links = nil
+}
+
+// Errors after which the token stream cannot be resumed
+func (s *dispatchStreamDecoder) fatal(err error) *APIError {
+	var size_err *http.MaxBytesError
+	if errors.As(err, &size_err) {
+		return NewPayloadTooLargeError(fmt.Errorf("Request body exceeds %d bytes", size_err.Limit))
+	}
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return NewValidationError(fmt.Errorf("Malformed JSON at offset %d: %w", s.dec.InputOffset(), err))
+}
+
+// Decode the next value into v. Returns false if the value could not be
+// decoded but the stream can continue.
+func (s *dispatchStreamDecoder) decodeValue(loc string, v any) (bool, *APIError) {
+	err := s.dec.Decode(v)
+	if err == nil {
+		return true, nil
+	}
+	// The value has been consumed from the stream; only its contents
+	// were invalid
+	var type_err *json.UnmarshalTypeError
+	var time_err *time.ParseError
+	if errors.As(err, &type_err) || errors.As(err, &time_err) {
+		s.addError(loc, err)
+		return false, nil
+	}
+	return false, s.fatal(err)
+}
+
+func (s *dispatchStreamDecoder) skipValue() *APIError {
+	var skip json.RawMessage
+	if err := s.dec.Decode(&skip); err != nil {
+		return s.fatal(err)
+	}
+	return nil
+}
+
+// Consume the opening delimiter of an object or array. Returns false if
+// the value is null.
+func (s *dispatchStreamDecoder) openDelim(loc string, delim json.Delim) (bool, *APIError) {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return false, s.fatal(err)
+	}
+	if tok == nil {
+		return false, nil
+	}
+	if d, ok := tok.(json.Delim); ok && d == delim {
+		return true, nil
+	}
+	return false, NewValidationError(fmt.Errorf("Malformed JSON at %s: expected %s", loc, delim.String()))
+}
+
+func (s *dispatchStreamDecoder) closeDelim() *APIError {
+	if _, err := s.dec.Token(); err != nil {
+		return s.fatal(err)
+	}
+	return nil
+}
+
+// Iterate over the keys of an object; f must consume each value
+func (s *dispatchStreamDecoder) decodeObject(loc string, f func(key string) *APIError) *APIError {
+	ok, err := s.openDelim(loc, '{')
+	if err != nil || !ok {
+		return err
+	}
+	for s.dec.More() {
+		tok, tok_err := s.dec.Token()
+		if tok_err != nil {
+			return s.fatal(tok_err)
+		}
+		key, _ := tok.(string)
+		// encoding/json matches field names case-insensitively
+		if err := f(strings.ToLower(key)); err != nil {
+			return err
+		}
+	}
+	return s.closeDelim()
+}
+
+func joinPath(loc string, key string) string {
+	if len(loc) == 0 {
+		return key
+	}
+	return loc + "." + key
+}
+
+func (s *dispatchStreamDecoder) decodeDispatch() *APIError {
+	return s.decodeObject("", func(key string) *APIError {
+		switch key {
+		case "metadata":
+			ok, err := s.decodeValue(key, &s.header.Metadata)
+			if err != nil {
+				return err
+			}
+			if ok {
+				s.addError(key, (&s.header.Metadata).validateRequest())
+			}
+			s.seen[key] = true
+		case "assets":
+			_, err := s.decodeValue(key, &s.header.Assets)
+			if err != nil {
+				return err
+			}
+			s.seen[key] = true
+		case "lattice":
+			return s.decodeLattice(key)
+		default:
+			return s.skipValue()
+		}
+		return nil
+	})
+}
+
+func (s *dispatchStreamDecoder) decodeLattice(loc string) *APIError {
+	return s.decodeObject(loc, func(key string) *APIError {
+		path := joinPath(loc, key)
+		switch key {
+		case "metadata":
+			ok, err := s.decodeValue(path, &s.header.Lattice.Metadata)
+			if err != nil {
+				return err
+			}
+			if ok {
+				s.addError(path, (&s.header.Lattice.Metadata).validateRequest())
+			}
+			s.seen[path] = true
+		case "assets":
+			ok, err := s.decodeValue(path, &s.header.Lattice.Assets)
+			if err != nil {
+				return err
+			}
+			if ok {
+				s.addError(path, (&s.header.Lattice.Assets).validateRequest())
+			}
+			s.seen[path] = true
+		case "transport_graph":
+			return s.decodeGraph(path)
+		default:
+			return s.skipValue()
+		}
+		return nil
+	})
+}
+
+func (s *dispatchStreamDecoder) decodeGraph(loc string) *APIError {
+	if s.graph == nil {
+		s.graph = newGraphValidator(loc)
+	}
+	return s.decodeObject(loc, func(key string) *APIError {
+		path := joinPath(loc, key)
+		switch key {
+		case "nodes":
+			return s.decodeNodes(path)
+		case "links":
+			return s.decodeLinks(path)
+		default:
+			return s.skipValue()
+		}
+	})
+}
+
+func (s *dispatchStreamDecoder) decodeNodes(loc string) *APIError {
+	ok, err := s.openDelim(loc, '[')
+	if err != nil || !ok {
+		return err
+	}
+	for i := 0; s.dec.More(); i++ {
+		s.n_nodes += 1
+		if s.limits.MaxNodes > 0 && s.n_nodes > s.limits.MaxNodes {
+			return NewPayloadTooLargeError(fmt.Errorf("Transport graph exceeds %d nodes", s.limits.MaxNodes))
+		}
+		path := fmt.Sprintf("%s[%d]", loc, i)
+		var node ElectronSchema
+		ok, err := s.decodeValue(path, &node)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// The id may not be known; count the node so that the
+			// remaining ids can still be checked
+			s.graph.skipNode()
+			continue
+		}
+		s.addError(path, (&node).validateRequest())
+		s.graph.addNode(i, &node)
+		s.addDetails(s.graph.drain())
+		if len(s.details) > 0 {
+			continue
+		}
+		s.nodes = append(s.nodes, node)
+		if err := s.flush(MANIFEST_STREAM_BATCH_SIZE); err != nil {
+			return err
+		}
+	}
+	return s.closeDelim()
+}
+
+func (s *dispatchStreamDecoder) decodeLinks(loc string) *APIError {
+	ok, err := s.openDelim(loc, '[')
+	if err != nil || !ok {
+		return err
+	}
+	for i := 0; s.dec.More(); i++ {
+		path := fmt.Sprintf("%s[%d]", loc, i)
+		var edge Edge
+		ok, err := s.decodeValue(path, &edge)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		s.graph.addEdge(i, &edge)
+		s.addDetails(s.graph.drain())
+		if len(s.details) > 0 {
+			continue
+		}
+		s.links = append(s.links, edge)
+		if err := s.flush(MANIFEST_STREAM_BATCH_SIZE); err != nil {
+			return err
+		}
+	}
+	return s.closeDelim()
+}
+
+// Write the header once all of its sections have been seen
+func (s *dispatchStreamDecoder) writeHeader() *APIError {
+	if s.header_written || len(s.details) > 0 {
+		return nil
+	}
+	for _, section := range []string{"metadata", "assets", "lattice.metadata", "lattice.assets"} {
+		if !s.seen[section] {
+			return nil
+		}
+	}
+	if err := s.sink.WriteHeader(&s.header); err != nil {
+		return err
+	}
+	s.header_written = true
+	return nil
+}
+
+// Hand pending nodes and links to the sink once at least min_batch
+// of either have accumulated
+func (s *dispatchStreamDecoder) flush(min_batch int) *APIError {
+	if err := s.writeHeader(); err != nil {
+		return err
+	}
+	if !s.header_written || len(s.details) > 0 {
+		return nil
+	}
+	if len(s.nodes) > 0 && len(s.nodes) >= min_batch {
+		if err := s.sink.WriteNodes(s.nodes); err != nil {
+			return err
+		}
+		s.nodes = s.nodes[:0]
+	}
+	if len(s.links) > 0 && len(s.links) >= min_batch {
+		if err := s.sink.WriteLinks(s.links); err != nil {
+			return err
+		}
+		s.links = s.links[:0]
+	}
+	return nil
+}
@@ -2,7 +2,11 @@ package models
 
 import (
 	"encoding/json"
+	"fmt"
+	"sort"
 	"time"
+
+	"github.com/casey/govalent/server/common"
 )
 
 type ElectronMeta struct {
@@ -51,19 +55,33 @@ func (a *ElectronAssets) AttrsByName() map[string]*AssetDetails {
 }
 
 func (e *ElectronMeta) validateRequest() *APIError {
-
-	// TODO: validate attributes:
-	// Status
-	// StartTime
-	// EndTime
-
-	return nil
+	errs := ValidationError{}
+	if !common.ValidateStatus(e.Status) {
+		errs.Add("body", "status", ERROR_DETAIL_INVALID)
+	}
+	if e.TaskGroupId < 0 {
+		errs.Add("body", "task_group_id", ERROR_DETAIL_INVALID)
+	}
+	if e.StartTime != nil && e.EndTime != nil && e.EndTime.Before(*e.StartTime) {
+		errs.Add("body", "end_time", fmt.Sprintf("%s: precedes start_time", ERROR_DETAIL_INVALID))
+	}
+	return errs.asAPIError()
 }
 
 func (e *ElectronAssets) validateRequest() *APIError {
-
-	// TODO: validate
-	return nil
+	errs := ValidationError{}
+	attrs := e.AttrsByName()
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if attrs[name].Size < 0 {
+			errs.Add("body", name+".size", ERROR_DETAIL_INVALID)
+		}
+	}
+	return errs.asAPIError()
 }
 
 func (e *ElectronSchema) validateRequest() *APIError {
@@ -71,19 +89,12 @@ func (e *ElectronSchema) validateRequest() *APIError {
 	if err != nil {
 		return NewValidationError(err)
 	}
-
-	// TODO: validate all fields:
 	e.Metadata.ExecutorData = string(serialized)
-	validation_err := e.Metadata.validateRequest()
-	if validation_err != nil {
-		return validation_err
-	}
-	validation_err = e.Assets.validateRequest()
-	if validation_err != nil {
-		return validation_err
-	}
 
-	return nil
+	errs := ValidationError{}
+	errs.merge("metadata.", e.Metadata.validateRequest())
+	errs.merge("assets.", e.Assets.validateRequest())
+	return errs.asAPIError()
 }
 
 func (e *ElectronSchema) validateResponse() *APIError {
@@ -98,6 +109,38 @@ func (e *ElectronSchema) validateResponse() *APIError {
 	return nil
 }
 
+func (e *ElectronSchema) EncodeJSON(enc *json.Encoder) *APIError {
+	err := e.validateResponse()
+	if err != nil {
+		return err
+	}
+	json_err := enc.Encode(e)
+	if json_err != nil {
+		return NewGenericServerError(json_err)
+	}
+	return nil
+}
+
+type GetBulkElectronsResponse struct {
+	Records []ElectronSchema `json:"records"`
+	// Number of matching electrons across all pages
+	Total int `json:"total"`
+}
+
+func (r *GetBulkElectronsResponse) EncodeJSON(enc *json.Encoder) *APIError {
+	for i := range r.Records {
+		err := (&r.Records[i]).validateResponse()
+		if err != nil {
+			return err
+		}
+	}
+	json_err := enc.Encode(r)
+	if json_err != nil {
+		return NewGenericServerError(json_err)
+	}
+	return nil
+}
+
 type ElectronStatusUpdate struct {
 	Status    string     `json:"status"`
 	StartTime *time.Time `json:"start_time"`
@@ -110,5 +153,5 @@ func (u *ElectronStatusUpdate) DecodeJSON(dec *json.Decoder) *APIError {
 		wrapped := NewValidationError(dec_err)
 		return wrapped
 	}
-	return nil
+	return validateStatusUpdate(u.Status, u.StartTime, u.EndTime)
 }
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/casey/govalent/server/common"
+)
+
+const (
+	EVENT_KIND_DISPATCH_STATUS = "dispatch_status"
+	EVENT_KIND_ELECTRON_STATUS = "electron_status"
+)
+
+// A dispatch or electron status transition, as recorded in the event log
+type StatusEvent struct {
+	// Increases monotonically across all dispatches
+	Id         int64  `json:"id"`
+	DispatchId string `json:"dispatch_id"`
+	// Absent for dispatch events
+	NodeId    *int       `json:"node_id,omitempty"`
+	Kind      string     `json:"kind"`
+	Status    string     `json:"status"`
+	StartTime *time.Time `json:"start_time"`
+	EndTime   *time.Time `json:"end_time"`
+	CreatedAt time.Time  `json:"timestamp"`
+}
+
+func NewDispatchStatusEvent(dispatch_id string, status string, start_time *time.Time, end_time *time.Time) StatusEvent {
+	return StatusEvent{
+		DispatchId: dispatch_id,
+		Kind:       EVENT_KIND_DISPATCH_STATUS,
+		Status:     status,
+		StartTime:  start_time,
+		EndTime:    end_time,
+		CreatedAt:  time.Now().UTC(),
+	}
+}
+
+func NewElectronStatusEvent(dispatch_id string, node_id int, update *ElectronStatusUpdate) StatusEvent {
+	return StatusEvent{
+		DispatchId: dispatch_id,
+		NodeId:     &node_id,
+		Kind:       EVENT_KIND_ELECTRON_STATUS,
+		Status:     update.Status,
+		StartTime:  update.StartTime,
+		EndTime:    update.EndTime,
+		CreatedAt:  time.Now().UTC(),
+	}
+}
+
+// PUT /dispatches/{dispatch_id}/status
+type DispatchStatusUpdate struct {
+	Status    string     `json:"status"`
+	StartTime *time.Time `json:"start_time"`
+	EndTime   *time.Time `json:"end_time"`
+}
+
+func (u *DispatchStatusUpdate) DecodeJSON(dec *json.Decoder) *APIError {
+	dec_err := dec.Decode(u)
+	if dec_err != nil {
+		return NewValidationError(dec_err)
+	}
+	return validateStatusUpdate(u.Status, u.StartTime, u.EndTime)
+}
+
+func validateStatusUpdate(status string, start_time *time.Time, end_time *time.Time) *APIError {
+	errs := ValidationError{}
+	if !common.ValidateStatus(status) {
+		errs.Add("body", "status", ERROR_DETAIL_INVALID)
+	}
+	if start_time != nil && end_time != nil && end_time.Before(*start_time) {
+		errs.Add("body", "end_time", ERROR_DETAIL_INVALID+": precedes start_time")
+	}
+	return errs.asAPIError()
+}
+
+func (e *StatusEvent) EncodeJSON(enc *json.Encoder) *APIError {
+	json_err := enc.Encode(e)
+	if json_err != nil {
+		return NewGenericServerError(json_err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package models
+
+import "encoding/json"
+
+// Outcomes of a readiness check
+const (
+	HEALTH_STATUS_OK      = "ok"
+	HEALTH_STATUS_FAILED  = "failed"
+	HEALTH_STATUS_SKIPPED = "skipped"
+)
+
+// GET /healthz
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+func (r *HealthResponse) EncodeJSON(enc *json.Encoder) *APIError {
+	json_err := enc.Encode(r)
+	if json_err != nil {
+		return NewGenericServerError(json_err)
+	}
+	return nil
+}
+
+type HealthCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// GET /readyz
+//
+// Status is failed if any check failed; skipped checks do not count.
+type ReadinessResponse struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+func (r *ReadinessResponse) Add(check HealthCheck) {
+	r.Checks = append(r.Checks, check)
+	if check.Status == HEALTH_STATUS_FAILED {
+		r.Status = HEALTH_STATUS_FAILED
+	}
+}
+
+func (r *ReadinessResponse) Ready() bool {
+	return r.Status != HEALTH_STATUS_FAILED
+}
+
+func (r *ReadinessResponse) EncodeJSON(enc *json.Encoder) *APIError {
+	json_err := enc.Encode(r)
+	if json_err != nil {
+		return NewGenericServerError(json_err)
+	}
+	return nil
+}
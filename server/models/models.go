@@ -2,12 +2,23 @@ package models
 
 import (
 	"encoding/json"
+	"fmt"
+	"sort"
 
 	"github.com/casey/govalent/server/common"
 )
 
+// GET /config
+//
+// Settings are in the form PUT /config accepts; secrets are omitted.
 type ConfigResponse struct {
-	*common.Config
+	Settings map[string]any `json:"settings"`
+	// Keys of the settings that PUT /config can change
+	Reloadable []string `json:"reloadable"`
+}
+
+func NewConfigResponse(c *common.Config) ConfigResponse {
+	return ConfigResponse{Settings: c.Settings(), Reloadable: common.ReloadableSettings()}
 }
 
 func (c *ConfigResponse) EncodeJSON(enc *json.Encoder) *APIError {
@@ -18,6 +29,43 @@ func (c *ConfigResponse) EncodeJSON(enc *json.Encoder) *APIError {
 	return nil
 }
 
+// PUT /config
+//
+// An object of setting keys, as in the config file, to new values.
+// Durations are strings such as "30s".
+type ConfigUpdateRequest struct {
+	Values map[string]string
+}
+
+func (p *ConfigUpdateRequest) DecodeJSON(dec *json.Decoder) *APIError {
+	var raw map[string]json.RawMessage
+	if dec_err := dec.Decode(&raw); dec_err != nil {
+		return NewValidationError(dec_err)
+	}
+	p.Values = make(map[string]string, len(raw))
+	errs := ValidationError{}
+	for key, val := range raw {
+		var s string
+		if json.Unmarshal(val, &s) == nil {
+			p.Values[key] = s
+			continue
+		}
+		// Numbers and booleans are taken as written
+		var scalar any
+		json.Unmarshal(val, &scalar)
+		switch scalar.(type) {
+		case float64, bool:
+			p.Values[key] = string(val)
+		default:
+			errs.Add("body", key, ERROR_DETAIL_INVALID)
+		}
+	}
+	if len(p.Values) == 0 && len(errs.Details) == 0 {
+		errs.Add("body", "", "Expected at least one setting")
+	}
+	return errs.asAPIError()
+}
+
 type EdgeMetadata struct {
 	Name      string `json:"edge_name"`
 	ParamType string `json:"param_type"`
@@ -35,14 +83,182 @@ type Graph struct {
 	Links []Edge           `json:"links"`
 }
 
+// JSON path of the transport graph within a manifest
+const TRANSPORT_GRAPH_LOCATION = "lattice.transport_graph"
+
+// Cycles are reported with at most this many of their nodes
+const MAX_CYCLE_NODES_REPORTED = 10
+
 func (g *Graph) validateRequest() *APIError {
+	errs := ValidationError{}
+	v := newGraphValidator(TRANSPORT_GRAPH_LOCATION)
 	for i := range g.Nodes {
-		err := (&g.Nodes[i]).validateRequest()
-		if err != nil {
-			return err
+		loc := fmt.Sprintf("%s.nodes[%d]", TRANSPORT_GRAPH_LOCATION, i)
+		inner := ValidationError{}
+		inner.merge("", (&g.Nodes[i]).validateRequest())
+		for _, item := range inner.Details {
+			errs.Add(loc, item.Attr, item.Detail)
 		}
+		v.addNode(i, &g.Nodes[i])
 	}
-	return nil
+	for i := range g.Links {
+		v.addEdge(i, &g.Links[i])
+	}
+	errs.Details = append(errs.Details, v.finish()...)
+	return errs.asAPIError()
+}
+
+type edgeArgKey struct {
+	target     int
+	param_type string
+	arg_index  int
+}
+
+// Structural checks on a transport graph. Nodes and edges are added one
+// at a time so that a manifest can be checked while it is streamed;
+// finish reports the problems which need the whole graph.
+type graphValidator struct {
+	loc string
+
+	// node id -> position in the nodes array
+	node_index map[int]int
+	n_nodes    int
+
+	sources []int
+	targets []int
+	// Position in the links array of each recorded edge
+	edge_index []int
+	// First edge claiming each positional slot
+	arg_slots map[edgeArgKey]int
+
+	details []ValidationErrorDetail
+}
+
+func newGraphValidator(loc string) *graphValidator {
+	return &graphValidator{
+		loc:        loc,
+		node_index: make(map[int]int),
+		arg_slots:  make(map[edgeArgKey]int),
+	}
+}
+
+func (v *graphValidator) add(loc string, attr string, detail string) {
+	v.details = append(v.details, ValidationErrorDetail{Location: loc, Attr: attr, Detail: detail})
+}
+
+func (v *graphValidator) addNode(i int, node *ElectronSchema) {
+	loc := fmt.Sprintf("%s.nodes[%d]", v.loc, i)
+	v.n_nodes += 1
+	if j, ok := v.node_index[node.NodeId]; ok {
+		v.add(loc, "id", fmt.Sprintf("%s: duplicates %s.nodes[%d]", ERROR_DETAIL_INVALID, v.loc, j))
+		return
+	}
+	v.node_index[node.NodeId] = i
+}
+
+// Count a node which could not be decoded
+func (v *graphValidator) skipNode() {
+	v.n_nodes += 1
+}
+
+// Remove and return the details recorded so far
+func (v *graphValidator) drain() []ValidationErrorDetail {
+	details := v.details
+	v.details = nil
+	return details
+}
+
+// Validate the parts of an edge which don't depend on other edges or
+// nodes, and remember the rest for finish
+func (v *graphValidator) addEdge(i int, edge *Edge) {
+	loc := fmt.Sprintf("%s.links[%d]", v.loc, i)
+	if !common.ValidateParamType(edge.Metadata.ParamType) {
+		v.add(loc, "metadata.param_type", ERROR_DETAIL_INVALID)
+	}
+	if edge.Metadata.ArgIndex != nil {
+		arg_index := *edge.Metadata.ArgIndex
+		if arg_index < 0 {
+			v.add(loc, "metadata.arg_index", ERROR_DETAIL_INVALID)
+		} else {
+			key := edgeArgKey{target: edge.Target, param_type: edge.Metadata.ParamType, arg_index: arg_index}
+			if j, ok := v.arg_slots[key]; ok {
+				v.add(loc, "metadata.arg_index", fmt.Sprintf("%s: collides with %s.links[%d]", ERROR_DETAIL_INVALID, v.loc, j))
+			} else {
+				v.arg_slots[key] = i
+			}
+		}
+	}
+	v.sources = append(v.sources, edge.Source)
+	v.targets = append(v.targets, edge.Target)
+	v.edge_index = append(v.edge_index, i)
+}
+
+// Check node id contiguity, edge endpoints and acyclicity
+func (v *graphValidator) finish() []ValidationErrorDetail {
+	// Unique ids are contiguous iff they all lie in [0, n)
+	out_of_range := make([]int, 0)
+	for id, i := range v.node_index {
+		if id < 0 || id >= v.n_nodes {
+			out_of_range = append(out_of_range, i)
+		}
+	}
+	sort.Ints(out_of_range)
+	for _, i := range out_of_range {
+		loc := fmt.Sprintf("%s.nodes[%d]", v.loc, i)
+		v.add(loc, "id", fmt.Sprintf("%s: node ids must be contiguous from 0 to %d", ERROR_DETAIL_INVALID, v.n_nodes-1))
+	}
+
+	// Kahn's algorithm over edges whose endpoints exist
+	in_deg := make(map[int]int, len(v.node_index))
+	children := make(map[int][]int, len(v.node_index))
+	for k := range v.sources {
+		loc := fmt.Sprintf("%s.links[%d]", v.loc, v.edge_index[k])
+		_, source_ok := v.node_index[v.sources[k]]
+		_, target_ok := v.node_index[v.targets[k]]
+		if !source_ok {
+			v.add(loc, "source", fmt.Sprintf("%s: unknown node %d", ERROR_DETAIL_INVALID, v.sources[k]))
+		}
+		if !target_ok {
+			v.add(loc, "target", fmt.Sprintf("%s: unknown node %d", ERROR_DETAIL_INVALID, v.targets[k]))
+		}
+		if source_ok && target_ok {
+			children[v.sources[k]] = append(children[v.sources[k]], v.targets[k])
+			in_deg[v.targets[k]] += 1
+		}
+	}
+	ready := make([]int, 0)
+	for id := range v.node_index {
+		if in_deg[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+	visited := 0
+	for len(ready) > 0 {
+		id := ready[len(ready)-1]
+		ready = ready[:len(ready)-1]
+		visited += 1
+		for _, child := range children[id] {
+			in_deg[child] -= 1
+			if in_deg[child] == 0 {
+				ready = append(ready, child)
+			}
+		}
+	}
+	if visited < len(v.node_index) {
+		// Nodes left with positive indegree lie on or downstream of a cycle
+		remaining := make([]int, 0)
+		for id := range v.node_index {
+			if in_deg[id] > 0 {
+				remaining = append(remaining, id)
+			}
+		}
+		sort.Ints(remaining)
+		if len(remaining) > MAX_CYCLE_NODES_REPORTED {
+			remaining = remaining[:MAX_CYCLE_NODES_REPORTED]
+		}
+		v.add(v.loc+".links", "", fmt.Sprintf("%s: graph contains a cycle involving nodes %v", ERROR_DETAIL_INVALID, remaining))
+	}
+	return v.details
 }
 
 func (g *Graph) validateResponse() *APIError {
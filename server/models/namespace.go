@@ -0,0 +1,78 @@
+package models
+
+import (
+	"encoding/json"
+	"regexp"
+	"time"
+)
+
+// Selects the namespace of a request; see api.resolveNamespace
+const NAMESPACE_HEADER = "X-Govalent-Namespace"
+
+// Used when neither the request nor the caller's token names one
+const DEFAULT_NAMESPACE = "default"
+
+var namespacePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Lowercase alphanumerics, '-' and '_'; at most 63 characters
+func ValidateNamespace(ns string) bool {
+	return namespacePattern.MatchString(ns)
+}
+
+// Dispatches and assets are partitioned by namespace. Quotas of 0 are
+// unlimited.
+type Namespace struct {
+	Name string `json:"name"`
+	// Dispatches, including sub-dispatches
+	MaxDispatches int `json:"max_dispatches"`
+	// Total declared size of registered assets
+	MaxAssetBytes int64          `json:"max_asset_bytes"`
+	Usage         NamespaceUsage `json:"usage"`
+	CreatedAt     time.Time      `json:"created_at"`
+}
+
+type NamespaceUsage struct {
+	Dispatches int   `json:"dispatches"`
+	AssetBytes int64 `json:"asset_bytes"`
+}
+
+func (n *Namespace) EncodeJSON(enc *json.Encoder) *APIError {
+	json_err := enc.Encode(n)
+	if json_err != nil {
+		return NewGenericServerError(json_err)
+	}
+	return nil
+}
+
+// PUT /namespaces/{namespace}
+type NamespaceUpdateRequest struct {
+	MaxDispatches int   `json:"max_dispatches"`
+	MaxAssetBytes int64 `json:"max_asset_bytes"`
+}
+
+func (p *NamespaceUpdateRequest) DecodeJSON(dec *json.Decoder) *APIError {
+	dec_err := dec.Decode(p)
+	if dec_err != nil {
+		return NewValidationError(dec_err)
+	}
+	errs := ValidationError{}
+	if p.MaxDispatches < 0 {
+		errs.Add("body", "max_dispatches", ERROR_DETAIL_INVALID)
+	}
+	if p.MaxAssetBytes < 0 {
+		errs.Add("body", "max_asset_bytes", ERROR_DETAIL_INVALID)
+	}
+	return errs.asAPIError()
+}
+
+type GetBulkNamespacesResponse struct {
+	Records []Namespace `json:"records"`
+}
+
+func (r *GetBulkNamespacesResponse) EncodeJSON(enc *json.Encoder) *APIError {
+	json_err := enc.Encode(r)
+	if json_err != nil {
+		return NewGenericServerError(json_err)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"github.com/casey/govalent/server/common"
+)
+
+// Delivery states
+const (
+	WEBHOOK_DELIVERY_PENDING       = "pending"
+	WEBHOOK_DELIVERY_DELIVERED     = "delivered"
+	WEBHOOK_DELIVERY_DEAD_LETTERED = "dead_lettered"
+)
+
+var webhookDeliveryStates = map[string]bool{
+	WEBHOOK_DELIVERY_PENDING:       true,
+	WEBHOOK_DELIVERY_DELIVERED:     true,
+	WEBHOOK_DELIVERY_DEAD_LETTERED: true,
+}
+
+func ValidateWebhookDeliveryState(state string) bool {
+	return webhookDeliveryStates[state]
+}
+
+var eventKinds = map[string]bool{
+	EVENT_KIND_DISPATCH_STATUS: true,
+	EVENT_KIND_ELECTRON_STATUS: true,
+}
+
+// A subscription to status events. Webhooks without a DispatchId
+// receive the events of every dispatch.
+type Webhook struct {
+	Id         int64   `json:"id"`
+	Url        string  `json:"url"`
+	DispatchId *string `json:"dispatch_id"`
+	// Empty matches all kinds or statuses
+	EventKinds []string `json:"event_kinds"`
+	Statuses   []string `json:"statuses"`
+	// Webhooks created by non-admin users only receive the events of
+	// dispatches they own. Empty for webhooks created by admins.
+	Owner string `json:"owner"`
+	// Only the events of dispatches in this namespace are delivered
+	Namespace string `json:"namespace"`
+	// Only returned when the webhook is created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Whether ev should be delivered to the webhook
+func (h *Webhook) Matches(ev *StatusEvent) bool {
+	if h.DispatchId != nil && *h.DispatchId != ev.DispatchId {
+		return false
+	}
+	return matchesAny(h.EventKinds, ev.Kind) && matchesAny(h.Statuses, ev.Status)
+}
+
+func matchesAny(allowed []string, val string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, item := range allowed {
+		if item == val {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *Webhook) EncodeJSON(enc *json.Encoder) *APIError {
+	json_err := enc.Encode(h)
+	if json_err != nil {
+		return NewGenericServerError(json_err)
+	}
+	return nil
+}
+
+// POST /webhooks
+type WebhookCreateRequest struct {
+	Url        string   `json:"url"`
+	DispatchId *string  `json:"dispatch_id"`
+	EventKinds []string `json:"event_kinds"`
+	Statuses   []string `json:"statuses"`
+	// Generated by the server if omitted
+	Secret string `json:"secret"`
+}
+
+func (p *WebhookCreateRequest) DecodeJSON(dec *json.Decoder) *APIError {
+	dec_err := dec.Decode(p)
+	if dec_err != nil {
+		return NewValidationError(dec_err)
+	}
+	return p.validateRequest()
+}
+
+func (p *WebhookCreateRequest) validateRequest() *APIError {
+	errs := ValidationError{}
+	u, err := url.Parse(p.Url)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		errs.Add("body", "url", ERROR_DETAIL_INVALID+": expected absolute http(s) URL")
+	}
+	if p.DispatchId != nil && len(*p.DispatchId) == 0 {
+		errs.Add("body", "dispatch_id", ERROR_DETAIL_INVALID)
+	}
+	for _, kind := range p.EventKinds {
+		if !eventKinds[kind] {
+			errs.Add("body", "event_kinds", ERROR_DETAIL_INVALID+": "+kind)
+		}
+	}
+	for _, status := range p.Statuses {
+		if !common.ValidateStatus(status) {
+			errs.Add("body", "statuses", ERROR_DETAIL_INVALID+": "+status)
+		}
+	}
+	return errs.asAPIError()
+}
+
+type GetBulkWebhooksResponse struct {
+	Records []Webhook `json:"records"`
+}
+
+func (r *GetBulkWebhooksResponse) EncodeJSON(enc *json.Encoder) *APIError {
+	json_err := enc.Encode(r)
+	if json_err != nil {
+		return NewGenericServerError(json_err)
+	}
+	return nil
+}
+
+// One attempt series to deliver an event to a webhook
+type WebhookDelivery struct {
+	Id        int64  `json:"id"`
+	WebhookId int64  `json:"webhook_id"`
+	EventId   int64  `json:"event_id"`
+	State     string `json:"state"`
+	Attempts  int    `json:"attempts"`
+	// Response status of the last attempt; absent if no response was
+	// received
+	LastStatusCode *int      `json:"last_status_code"`
+	LastError      string    `json:"last_error,omitempty"`
+	NextAttemptAt  time.Time `json:"next_attempt_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type GetBulkWebhookDeliveriesResponse struct {
+	Records []WebhookDelivery `json:"records"`
+	// Number of deliveries matching the query, across all pages
+	Total int `json:"total"`
+}
+
+func (r *GetBulkWebhookDeliveriesResponse) EncodeJSON(enc *json.Encoder) *APIError {
+	json_err := enc.Encode(r)
+	if json_err != nil {
+		return NewGenericServerError(json_err)
+	}
+	return nil
+}
+
+// Body POSTed to webhook receivers
+type WebhookPayload struct {
+	DeliveryId int64       `json:"delivery_id"`
+	WebhookId  int64       `json:"webhook_id"`
+	Event      StatusEvent `json:"event"`
+}
@@ -0,0 +1,180 @@
+// OpenTelemetry tracing of API routes, CRUD operations and outbound
+// HTTP calls
+//
+// Spans are exported according to Config.TraceExporter. The OTLP
+// exporter is configured with the standard OTEL_EXPORTER_OTLP_*
+// environment variables. Trace context is propagated in W3C
+// traceparent headers.
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const TRACER_NAME = "github.com/casey/govalent/server"
+const SERVICE_NAME = "govalent"
+
+// Flushes and stops the exporter
+type ShutdownFunc func(ctx context.Context) error
+
+// Install the global tracer provider and propagator described by c.
+// With TRACE_EXPORTER_NONE spans are not recorded, but trace context
+// is still propagated.
+func Setup(ctx context.Context, c *common.Config) (ShutdownFunc, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var file *os.File
+	switch c.TraceExporter {
+	case "", common.TRACE_EXPORTER_NONE:
+		return func(ctx context.Context) error { return nil }, nil
+	case common.TRACE_EXPORTER_OTLP:
+		exp, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		exporter = exp
+	case common.TRACE_EXPORTER_FILE:
+		if len(c.TraceFile) == 0 {
+			return nil, errors.New("Trace file not set")
+		}
+		f, err := os.OpenFile(c.TraceFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		exporter = exp
+		file = f
+	default:
+		return nil, fmt.Errorf("Unknown trace exporter %s", c.TraceExporter)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", SERVICE_NAME))),
+	)
+	otel.SetTracerProvider(provider)
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			if close_err := file.Close(); err == nil {
+				err = close_err
+			}
+		}
+		return err
+	}, nil
+}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(TRACER_NAME)
+}
+
+// Start a child span of the span in ctx, if any
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End span, marking it failed if err is set
+func End(span trace.Span, err *models.APIError) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Start the server span of a request to route, continuing any trace
+// in the request's traceparent header. The returned request carries
+// the span.
+func StartRequest(r *http.Request, route string) (*http.Request, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := Tracer().Start(
+		ctx,
+		fmt.Sprintf("%s %s", r.Method, route),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("http.route", route),
+			attribute.String("url.path", r.URL.Path),
+		),
+	)
+	return r.WithContext(ctx), span
+}
+
+// Record the response status of a server span; 5xx responses are
+// errors
+func SetResponseStatus(span trace.Span, code int) {
+	span.SetAttributes(attribute.Int("http.response.status_code", code))
+	if code >= 500 {
+		span.SetStatus(codes.Error, strconv.Itoa(code))
+	}
+}
+
+// An http.RoundTripper that starts a client span for each request and
+// sends its trace context to the server. Use it for calls to executors
+// and other services.
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// base defaults to http.DefaultTransport
+func NewTransport(base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := Tracer().Start(
+		req.Context(),
+		fmt.Sprintf("%s %s", req.Method, req.URL.Host),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.URL.Hostname()),
+			attribute.String("url.path", req.URL.Path),
+		),
+	)
+	defer span.End()
+
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, strconv.Itoa(resp.StatusCode))
+	}
+	return resp, nil
+}
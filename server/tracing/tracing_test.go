@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Fields of a span written by the file exporter
+type exportedSpan struct {
+	Name        string
+	SpanContext struct {
+		TraceID string
+		SpanID  string
+	}
+	Parent struct {
+		TraceID string
+		SpanID  string
+	}
+	SpanKind int
+}
+
+func readSpans(t *testing.T, path string) map[string]exportedSpan {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Error opening trace file: %v", err)
+	}
+	defer f.Close()
+	res := make(map[string]exportedSpan)
+	dec := json.NewDecoder(f)
+	for {
+		var s exportedSpan
+		err := dec.Decode(&s)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Error decoding span: %v", err)
+		}
+		res[s.Name] = s
+	}
+	return res
+}
+
+func TestFileExporter(t *testing.T) {
+	c := common.Config{
+		TraceExporter: common.TRACE_EXPORTER_FILE,
+		TraceFile:     filepath.Join(t.TempDir(), "spans.json"),
+	}
+	shutdown, err := Setup(context.Background(), &c)
+	if err != nil {
+		t.Fatalf("Error setting up tracing: %v", err)
+	}
+
+	var traceparent string
+	executor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer executor.Close()
+
+	// An incoming request continues the caller's trace
+	incoming := httptest.NewRequest(http.MethodPost, "/api/v0/dispatches", nil)
+	incoming.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	r, span := StartRequest(incoming, "/api/v0/dispatches")
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", span.SpanContext().TraceID().String())
+
+	_, child := Start(r.Context(), "ImportManifest")
+	End(child, nil)
+
+	client := http.Client{Transport: NewTransport(nil)}
+	req, _ := http.NewRequestWithContext(r.Context(), http.MethodPost, executor.URL+"/jobs", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Error calling executor: %v", err)
+	}
+	resp.Body.Close()
+	assert.Empty(t, req.Header.Get("traceparent"))
+	SetResponseStatus(span, http.StatusCreated)
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Error shutting down tracing: %v", err)
+	}
+	spans := readSpans(t, c.TraceFile)
+	server, ok := spans["POST /api/v0/dispatches"]
+	if !ok {
+		t.Fatalf("Server span not exported: %v", spans)
+	}
+	assert.Equal(t, int(trace.SpanKindServer), server.SpanKind)
+	assert.Equal(t, "b7ad6b7169203331", server.Parent.SpanID)
+
+	assert.Equal(t, server.SpanContext.SpanID, spans["ImportManifest"].Parent.SpanID)
+
+	outbound, ok := spans["POST "+req.URL.Host]
+	if !ok {
+		t.Fatalf("Client span not exported: %v", spans)
+	}
+	assert.Equal(t, int(trace.SpanKindClient), outbound.SpanKind)
+	assert.Equal(t, server.SpanContext.SpanID, outbound.Parent.SpanID)
+	// The executor receives the client span as its parent
+	assert.Equal(t, "00-"+outbound.SpanContext.TraceID+"-"+outbound.SpanContext.SpanID+"-01", traceparent)
+}
+
+func TestSetupNone(t *testing.T) {
+	c := common.Config{TraceExporter: common.TRACE_EXPORTER_NONE}
+	shutdown, err := Setup(context.Background(), &c)
+	if err != nil {
+		t.Fatalf("Error setting up tracing: %v", err)
+	}
+	assert.Nil(t, shutdown(context.Background()))
+
+	c = common.Config{TraceExporter: common.TRACE_EXPORTER_FILE}
+	_, err = Setup(context.Background(), &c)
+	assert.NotNil(t, err)
+	c = common.Config{TraceExporter: "jaeger"}
+	_, err = Setup(context.Background(), &c)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,282 @@
+// Delivery of status events to webhook subscribers
+//
+// Deliveries are queued in the database in the transaction that
+// records each event (see crud.AppendEvent), so a delivery survives a
+// restart. The dispatcher attempts due deliveries, retrying failures
+// with exponential backoff until they succeed or exhaust their
+// attempts, at which point they are dead-lettered.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/crud"
+	"github.com/casey/govalent/server/models"
+	"github.com/casey/govalent/server/tracing"
+)
+
+// Request headers sent with every delivery
+const (
+	EVENT_HEADER     = "X-Govalent-Event"
+	DELIVERY_HEADER  = "X-Govalent-Delivery"
+	TIMESTAMP_HEADER = "X-Govalent-Timestamp"
+	// "sha256=" followed by the hex HMAC-SHA256, keyed with the webhook
+	// secret, of "<timestamp>.<body>"
+	SIGNATURE_HEADER = "X-Govalent-Signature"
+)
+
+const SIGNATURE_PREFIX = "sha256="
+
+// Interval at which the dispatcher looks for retries that have become due
+const DEFAULT_POLL_INTERVAL = time.Second
+
+// Bytes of a failed response body recorded as the delivery error
+const maxErrorBodyBytes = 256
+
+type Options struct {
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	Timeout      time.Duration
+	PollInterval time.Duration
+	// Defaults to a traced client. Timeout applies to each delivery in
+	// addition to any timeout of the client.
+	Client *http.Client
+}
+
+func NewOptionsFromConfig(c *common.Config) Options {
+	return Options{
+		MaxAttempts:  c.WebhookMaxAttempts,
+		BaseDelay:    c.WebhookRetryBaseDelay,
+		MaxDelay:     c.WebhookRetryMaxDelay,
+		Timeout:      c.WebhookTimeout,
+		PollInterval: DEFAULT_POLL_INTERVAL,
+	}
+}
+
+// Delay before the retry following the given number of failed attempts
+func (o *Options) Backoff(attempts int) time.Duration {
+	delay := o.BaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= o.MaxDelay {
+			return o.MaxDelay
+		}
+	}
+	return min(delay, o.MaxDelay)
+}
+
+// Signature header value for a payload sent at timestamp (Unix seconds)
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return SIGNATURE_PREFIX + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Check a signature computed by Sign; for use by receivers
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+type Dispatcher struct {
+	d      *sql.DB
+	client *http.Client
+	wake   chan struct{}
+	// Guards opts, which can be changed by SetOptions while running
+	mu   sync.Mutex
+	opts Options
+}
+
+func NewDispatcher(d *sql.DB, opts Options) *Dispatcher {
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Transport: tracing.NewTransport(nil)}
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DEFAULT_POLL_INTERVAL
+	}
+	return &Dispatcher{
+		d:      d,
+		opts:   opts,
+		client: client,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Apply new retry and timeout settings to subsequent attempts. The
+// client and poll interval are kept.
+func (p *Dispatcher) SetOptions(opts Options) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	opts.Client = p.opts.Client
+	opts.PollInterval = p.opts.PollInterval
+	p.opts = opts
+}
+
+func (p *Dispatcher) options() Options {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.opts
+}
+
+// Prompt the dispatcher to look for due deliveries without waiting for
+// the next poll. Never blocks.
+func (p *Dispatcher) Wake() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Deliver until ctx is cancelled
+func (p *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.options().PollInterval)
+	defer ticker.Stop()
+	for {
+		// Keep going while full batches are returned
+		for {
+			n, err := p.DeliverPending(ctx)
+			if err != nil {
+				slog.Error(fmt.Sprintf("Error delivering webhooks: %s", err.Error()))
+				break
+			}
+			if n < crud.MAX_DUE_WEBHOOK_DELIVERIES {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.wake:
+		}
+	}
+}
+
+// Attempt due deliveries until none remain or ctx expires; for use at
+// shutdown after Run has returned. Failed deliveries are left for
+// their next attempt after restart.
+func (p *Dispatcher) Drain(ctx context.Context) error {
+	for ctx.Err() == nil {
+		n, err := p.DeliverPending(ctx)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// Attempt each due delivery once, returning the number attempted
+func (p *Dispatcher) DeliverPending(ctx context.Context) (int, error) {
+	t, err := p.d.Begin()
+	if err != nil {
+		return 0, err
+	}
+	due, api_err := crud.GetDueWebhookDeliveries(t, time.Now().UTC(), crud.MAX_DUE_WEBHOOK_DELIVERIES)
+	t.Rollback()
+	if api_err != nil {
+		return 0, api_err
+	}
+	for i := range due {
+		if ctx.Err() != nil {
+			return i, nil
+		}
+		if err := p.attempt(ctx, &due[i]); err != nil {
+			return i + 1, err
+		}
+	}
+	return len(due), nil
+}
+
+// POST the event and record the outcome
+func (p *Dispatcher) attempt(ctx context.Context, due *crud.DueWebhookDelivery) error {
+	d := &due.Delivery
+	payload := models.WebhookPayload{DeliveryId: d.Id, WebhookId: d.WebhookId, Event: due.Event}
+	body, err := json.Marshal(&payload)
+	if err != nil {
+		return err
+	}
+
+	opts := p.options()
+	status_code, send_err := p.send(ctx, due, body, opts.Timeout)
+	now := time.Now().UTC()
+	d.Attempts += 1
+	d.UpdatedAt = now
+	d.LastStatusCode = status_code
+	d.LastError = ""
+	if send_err == nil {
+		d.State = models.WEBHOOK_DELIVERY_DELIVERED
+	} else {
+		d.LastError = send_err.Error()
+		d.NextAttemptAt = now.Add(opts.Backoff(d.Attempts))
+		slog.Warn(fmt.Sprintf("Webhook delivery %d to %s failed (attempt %d): %s", d.Id, due.Url, d.Attempts, d.LastError))
+	}
+
+	t, err := p.d.Begin()
+	if err != nil {
+		return err
+	}
+	defer t.Rollback()
+	var api_err *models.APIError
+	if send_err != nil && d.Attempts >= opts.MaxAttempts {
+		slog.Error(fmt.Sprintf("Dead-lettering webhook delivery %d after %d attempts", d.Id, d.Attempts))
+		api_err = crud.DeadLetterWebhookDelivery(t, d, body)
+	} else {
+		api_err = crud.UpdateWebhookDelivery(t, d)
+	}
+	if api_err != nil {
+		return api_err
+	}
+	return t.Commit()
+}
+
+// Status code of the response, if one was received, and an error
+// unless it was 2xx
+func (p *Dispatcher) send(ctx context.Context, due *crud.DueWebhookDelivery, body []byte, timeout time.Duration) (*int, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, due.Url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EVENT_HEADER, due.Event.Kind)
+	req.Header.Set(DELIVERY_HEADER, strconv.FormatInt(due.Delivery.Id, 10))
+	req.Header.Set(TIMESTAMP_HEADER, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SIGNATURE_HEADER, Sign(due.Secret, timestamp, body))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &resp.StatusCode, fmt.Errorf("Receiver responded %d: %s", resp.StatusCode, bytes.TrimSpace(snippet))
+	}
+	return &resp.StatusCode, nil
+}
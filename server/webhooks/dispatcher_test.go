@@ -0,0 +1,317 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/casey/govalent/server/common"
+	"github.com/casey/govalent/server/crud"
+	"github.com/casey/govalent/server/db"
+	"github.com/casey/govalent/server/models"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockFileDB(t *testing.T) *sql.DB {
+	c := common.Config{Dsn: fmt.Sprintf("file:%s", filepath.Join(t.TempDir(), "test.db"))}
+	d, err := db.GetDB(&c)
+	if err != nil {
+		t.Fatalf("Error establishing DB connection: %v", err)
+	}
+	if err := db.EmitDDL(d); err != nil {
+		t.Fatalf("Error emitting DDL: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// Records the requests it receives and responds with the next status
+// in statuses, repeating the last one
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	status := r.statuses[0]
+	if len(r.statuses) > 1 {
+		r.statuses = r.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+// Register a webhook to url and record one dispatch status event
+func setup(t *testing.T, d *sql.DB, url string) (models.Webhook, models.StatusEvent) {
+	tx, err := d.Begin()
+	if err != nil {
+		t.Fatalf("Error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+	h := models.Webhook{Url: url, Secret: "s3cret", CreatedAt: time.Now().UTC()}
+	if err := crud.CreateWebhook(tx, &h); err != nil {
+		t.Fatalf("Error creating webhook: %v", err)
+	}
+	ts := time.Now().UTC()
+	config := common.Config{}
+	dispatch := models.DispatchSchema{
+		Metadata: models.DispatchMeta{DispatchId: uuid.NewString(), Status: common.STATUS_NEW, CreatedAt: ts},
+		Lattice:  models.LatticeSchema{Metadata: models.LatticeMeta{Name: "workflow", Executor: "local", ExecutorData: "{}"}},
+	}
+	if err := crud.ImportManifest(context.Background(), &config, tx, &dispatch); err != nil {
+		t.Fatalf("Error importing manifest: %v", err)
+	}
+	evs, api_err := crud.GetEvents(tx, dispatch.Metadata.DispatchId, 0, 0)
+	if api_err != nil || len(evs) != 1 {
+		t.Fatalf("Expected one event, got %v (%v)", evs, api_err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Error committing: %v", err)
+	}
+	return h, evs[0]
+}
+
+func getDeliveries(t *testing.T, d *sql.DB, webhook_id int64) models.GetBulkWebhookDeliveriesResponse {
+	tx, err := d.Begin()
+	if err != nil {
+		t.Fatalf("Error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+	res, api_err := crud.SearchWebhookDeliveries(tx, webhook_id, "", 0, 10)
+	if api_err != nil {
+		t.Fatalf("Error retrieving deliveries: %v", api_err)
+	}
+	return res
+}
+
+func newTestOptions(max_attempts int) Options {
+	return Options{
+		MaxAttempts: max_attempts,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    4 * time.Millisecond,
+		Timeout:     time.Second,
+	}
+}
+
+// Attempt deliveries until none are pending
+func drain(t *testing.T, p *Dispatcher, d *sql.DB, webhook_id int64) {
+	for i := 0; i < 100; i++ {
+		if _, err := p.DeliverPending(context.Background()); err != nil {
+			t.Fatalf("Error delivering: %v", err)
+		}
+		res := getDeliveries(t, d, webhook_id)
+		if res.Records[0].State != models.WEBHOOK_DELIVERY_PENDING {
+			return
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	t.Fatalf("Delivery still pending")
+}
+
+func TestDeliverSigned(t *testing.T) {
+	d := newMockFileDB(t)
+	recv := &receiver{statuses: []int{http.StatusOK}}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+	h, ev := setup(t, d, srv.URL)
+
+	p := NewDispatcher(d, newTestOptions(3))
+	n, err := p.DeliverPending(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	assert.Equal(t, 1, len(recv.requests))
+	req, body := recv.requests[0], recv.bodies[0]
+	assert.Equal(t, "application/json", req.Header.Get("Content-Type"))
+	assert.Equal(t, models.EVENT_KIND_DISPATCH_STATUS, req.Header.Get(EVENT_HEADER))
+	timestamp, _ := strconv.ParseInt(req.Header.Get(TIMESTAMP_HEADER), 10, 64)
+	assert.True(t, Verify("s3cret", timestamp, body, req.Header.Get(SIGNATURE_HEADER)))
+	assert.False(t, Verify("wrong", timestamp, body, req.Header.Get(SIGNATURE_HEADER)))
+
+	var payload models.WebhookPayload
+	assert.Nil(t, json.Unmarshal(body, &payload))
+	assert.Equal(t, h.Id, payload.WebhookId)
+	assert.Equal(t, ev.Id, payload.Event.Id)
+	assert.Equal(t, ev.DispatchId, payload.Event.DispatchId)
+	assert.Equal(t, strconv.FormatInt(payload.DeliveryId, 10), req.Header.Get(DELIVERY_HEADER))
+
+	res := getDeliveries(t, d, h.Id)
+	assert.Equal(t, models.WEBHOOK_DELIVERY_DELIVERED, res.Records[0].State)
+	assert.Equal(t, 1, res.Records[0].Attempts)
+	assert.Equal(t, http.StatusOK, *res.Records[0].LastStatusCode)
+
+	// Delivered events are not sent again
+	n, _ = p.DeliverPending(context.Background())
+	assert.Equal(t, 0, n)
+}
+
+func TestRetryWithBackoff(t *testing.T) {
+	d := newMockFileDB(t)
+	recv := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusNoContent}}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+	h, _ := setup(t, d, srv.URL)
+
+	opts := newTestOptions(5)
+	opts.BaseDelay = time.Hour
+	opts.MaxDelay = time.Hour
+	p := NewDispatcher(d, opts)
+	p.DeliverPending(context.Background())
+	res := getDeliveries(t, d, h.Id)
+	failed := res.Records[0]
+	assert.Equal(t, models.WEBHOOK_DELIVERY_PENDING, failed.State)
+	assert.Equal(t, 1, failed.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, *failed.LastStatusCode)
+	assert.NotEmpty(t, failed.LastError)
+	assert.True(t, failed.NextAttemptAt.After(time.Now().Add(59*time.Minute)))
+
+	// Not retried before the backoff elapses
+	n, _ := p.DeliverPending(context.Background())
+	assert.Equal(t, 0, n)
+
+	p = NewDispatcher(d, newTestOptions(5))
+	tx, _ := d.Begin()
+	failed.NextAttemptAt = time.Now().UTC()
+	crud.UpdateWebhookDelivery(tx, &failed)
+	tx.Commit()
+	drain(t, p, d, h.Id)
+
+	res = getDeliveries(t, d, h.Id)
+	assert.Equal(t, models.WEBHOOK_DELIVERY_DELIVERED, res.Records[0].State)
+	assert.Equal(t, 3, res.Records[0].Attempts)
+	assert.Empty(t, res.Records[0].LastError)
+	assert.Equal(t, 3, len(recv.requests))
+	// Every attempt carries the same delivery id
+	assert.Equal(t, recv.requests[0].Header.Get(DELIVERY_HEADER), recv.requests[2].Header.Get(DELIVERY_HEADER))
+}
+
+func TestDeadLetter(t *testing.T) {
+	d := newMockFileDB(t)
+	recv := &receiver{statuses: []int{http.StatusServiceUnavailable}}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+	h, _ := setup(t, d, srv.URL)
+
+	p := NewDispatcher(d, newTestOptions(3))
+	drain(t, p, d, h.Id)
+
+	res := getDeliveries(t, d, h.Id)
+	assert.Equal(t, models.WEBHOOK_DELIVERY_DEAD_LETTERED, res.Records[0].State)
+	assert.Equal(t, 3, res.Records[0].Attempts)
+	assert.Equal(t, 3, len(recv.requests))
+
+	tx, _ := d.Begin()
+	defer tx.Rollback()
+	n, _ := crud.CountWebhookDeadLetters(tx, h.Id)
+	assert.Equal(t, 1, n)
+}
+
+func TestSetOptions(t *testing.T) {
+	d := newMockFileDB(t)
+	recv := &receiver{statuses: []int{http.StatusServiceUnavailable}}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+	h, _ := setup(t, d, srv.URL)
+
+	p := NewDispatcher(d, newTestOptions(8))
+	if _, err := p.DeliverPending(context.Background()); err != nil {
+		t.Fatalf("Error delivering: %v", err)
+	}
+	// Applies to the attempts that follow
+	p.SetOptions(newTestOptions(2))
+	drain(t, p, d, h.Id)
+
+	res := getDeliveries(t, d, h.Id)
+	assert.Equal(t, models.WEBHOOK_DELIVERY_DEAD_LETTERED, res.Records[0].State)
+	assert.Equal(t, 2, res.Records[0].Attempts)
+}
+
+func TestUnreachableReceiver(t *testing.T) {
+	d := newMockFileDB(t)
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+	h, _ := setup(t, d, url)
+
+	p := NewDispatcher(d, newTestOptions(2))
+	drain(t, p, d, h.Id)
+	res := getDeliveries(t, d, h.Id)
+	assert.Equal(t, models.WEBHOOK_DELIVERY_DEAD_LETTERED, res.Records[0].State)
+	assert.Nil(t, res.Records[0].LastStatusCode)
+	assert.NotEmpty(t, res.Records[0].LastError)
+}
+
+func TestRunWakes(t *testing.T) {
+	d := newMockFileDB(t)
+	delivered := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- struct{}{}
+	}))
+	defer srv.Close()
+
+	opts := newTestOptions(3)
+	opts.PollInterval = time.Hour
+	p := NewDispatcher(d, opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+
+	setup(t, d, srv.URL)
+	p.Wake()
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Event was not delivered after wake")
+	}
+}
+
+func TestDrainAtShutdown(t *testing.T) {
+	d := newMockFileDB(t)
+	recv := &receiver{statuses: []int{http.StatusOK, http.StatusInternalServerError}}
+	srv := httptest.NewServer(recv)
+	defer srv.Close()
+	delivered, _ := setup(t, d, srv.URL)
+	failing, _ := setup(t, d, srv.URL)
+
+	// Run has stopped; events recorded since are still delivered
+	opts := newTestOptions(3)
+	opts.BaseDelay = time.Hour
+	opts.MaxDelay = time.Hour
+	p := NewDispatcher(d, opts)
+	assert.Nil(t, p.Drain(context.Background()))
+	res := getDeliveries(t, d, delivered.Id)
+	assert.Equal(t, models.WEBHOOK_DELIVERY_DELIVERED, res.Records[0].State)
+	// Failures are not retried until after restart
+	res = getDeliveries(t, d, failing.Id)
+	assert.Equal(t, models.WEBHOOK_DELIVERY_PENDING, res.Records[0].State)
+	assert.Equal(t, 1, res.Records[0].Attempts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, p.Drain(ctx), context.Canceled)
+}
+
+func TestBackoff(t *testing.T) {
+	opts := Options{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	assert.Equal(t, time.Second, opts.Backoff(1))
+	assert.Equal(t, 2*time.Second, opts.Backoff(2))
+	assert.Equal(t, 8*time.Second, opts.Backoff(4))
+	assert.Equal(t, 10*time.Second, opts.Backoff(5))
+	assert.Equal(t, 10*time.Second, opts.Backoff(100))
+}
@@ -1,5 +1,6 @@
 import httpx
 import json
+import os
 import uuid
 import pytest
 import tempfile
@@ -12,6 +13,11 @@ from hmac import digest
 
 DISPATCHER_ADDR="http://localhost:48008"
 
+# The server must be started with the same GOVALENT_ADMIN_TOKEN
+ADMIN_TOKEN=os.environ["GOVALENT_ADMIN_TOKEN"]
+
+client = httpx.Client(headers={"Authorization": f"Bearer {ADMIN_TOKEN}"})
+
 
 @pytest.fixture
 def mock_manifest():
@@ -33,7 +39,7 @@ def mock_manifest():
 def test_submit_manifest(mock_manifest):
 
     stripped = strip_local_uris(mock_manifest)
-    resp = httpx.post(f"{DISPATCHER_ADDR}/dispatches", data=stripped.model_dump_json())
+    resp = client.post(f"{DISPATCHER_ADDR}/dispatches", data=stripped.model_dump_json())
     body = resp.json()
     resp.raise_for_status()
 
@@ -102,13 +108,13 @@ def test_submit_manifest(mock_manifest):
 def test_export_manifest(mock_manifest):
 
     stripped = strip_local_uris(mock_manifest)
-    resp = httpx.post(f"{DISPATCHER_ADDR}/dispatches", data=stripped.model_dump_json())
+    resp = client.post(f"{DISPATCHER_ADDR}/dispatches", data=stripped.model_dump_json())
     resp.raise_for_status()
     body = resp.json()
     returned_manifest = ResultSchema.model_validate(body)
 
     dispatch_id = returned_manifest.metadata.dispatch_id
-    resp = httpx.get(f"{DISPATCHER_ADDR}/dispatches/{dispatch_id}")
+    resp = client.get(f"{DISPATCHER_ADDR}/dispatches/{dispatch_id}")
     body = resp.json()
     resp.raise_for_status()
     exported_manifest = ResultSchema.model_validate(body)
@@ -191,13 +197,13 @@ def test_export_manifest(mock_manifest):
 
 def test_bulk_get_dispatches(mock_manifest):
     stripped = strip_local_uris(mock_manifest)
-    resp = httpx.post(f"{DISPATCHER_ADDR}/dispatches", data=stripped.model_dump_json())
+    resp = client.post(f"{DISPATCHER_ADDR}/dispatches", data=stripped.model_dump_json())
     resp.raise_for_status()
     body = resp.json()
     returned_manifest = ResultSchema.model_validate(body)
 
     dispatch_id = returned_manifest.metadata.dispatch_id
-    resp = httpx.get(f"{DISPATCHER_ADDR}/dispatches", params={"dispatch_id": dispatch_id})
+    resp = client.get(f"{DISPATCHER_ADDR}/dispatches", params={"dispatch_id": dispatch_id})
     body = resp.json()
     assert len(body["records"]) == 1
     assert body["records"][0]["dispatch_id"] == dispatch_id
@@ -206,15 +212,15 @@ def test_bulk_get_dispatches(mock_manifest):
 def test_delete_dispatch(mock_manifest):
     stripped = strip_local_uris(mock_manifest)
     print("")
-    resp = httpx.post(f"{DISPATCHER_ADDR}/dispatches", data=stripped.model_dump_json())
+    resp = client.post(f"{DISPATCHER_ADDR}/dispatches", data=stripped.model_dump_json())
     resp.raise_for_status()
     body = resp.json()
     returned_manifest = ResultSchema.model_validate(body)
     dispatch_id = returned_manifest.metadata.dispatch_id
 
-    httpx.delete(f"{DISPATCHER_ADDR}/dispatches/{dispatch_id}").raise_for_status()
+    client.delete(f"{DISPATCHER_ADDR}/dispatches/{dispatch_id}").raise_for_status()
 
-    resp = httpx.get(f"{DISPATCHER_ADDR}/dispatches", params={"dispatch_id": dispatch_id})
+    resp = client.get(f"{DISPATCHER_ADDR}/dispatches", params={"dispatch_id": dispatch_id})
     body = resp.json()
     assert len(body["records"]) == 0
 
@@ -224,7 +230,7 @@ def test_create_get_assets():
     asset_details_1 = {"key": "dispatch-1", "size": 5, "uri": "file://local-uri"}
     asset_details_2 = {"key": "dispatch-2", "size": 2}
     reqBody = {"assets": [asset_details_1, asset_details_2]}
-    resp = httpx.post(f"{DISPATCHER_ADDR}/assets", json=reqBody)
+    resp = client.post(f"{DISPATCHER_ADDR}/assets", json=reqBody)
     resp.raise_for_status()
     body = resp.json()
     assert len(body["assets"]) == 2
@@ -235,7 +241,7 @@ def test_create_get_assets():
     assert body["assets"][1]["size"] == reqBody["assets"][1]["size"]
     assert len(body["assets"][1]["remote_uri"]) > 0
 
-    resp = httpx.get(f"{DISPATCHER_ADDR}/assets", params={"prefix": "dispatch"})
+    resp = client.get(f"{DISPATCHER_ADDR}/assets", params={"prefix": "dispatch"})
     resp.raise_for_status()
     body = resp.json()
     assert len(body["assets"]) == 2
@@ -249,7 +255,7 @@ def test_create_get_assets():
 
 def test_get_asset_links(mock_manifest):
     stripped = strip_local_uris(mock_manifest)
-    resp = httpx.post(f"{DISPATCHER_ADDR}/dispatches", data=stripped.model_dump_json())
+    resp = client.post(f"{DISPATCHER_ADDR}/dispatches", data=stripped.model_dump_json())
     resp.raise_for_status()
     body = resp.json()
     returned_manifest = ResultSchema.model_validate(body)
@@ -264,7 +270,7 @@ def test_get_asset_links(mock_manifest):
 
 
     workflow_assets_url = f"{DISPATCHER_ADDR}/dispatches/{dispatch_id}/assets"
-    resp = httpx.get(workflow_assets_url)
+    resp = client.get(workflow_assets_url)
     resp.raise_for_status()
     body = resp.json()
     workflow_asset_map = {x["Name"]: x["Asset"] for x in body["Records"]}
@@ -280,7 +286,7 @@ def test_get_asset_links(mock_manifest):
         assert name in workflow_asset_map
 
     electron_assets_url = f"{DISPATCHER_ADDR}/dispatches/{dispatch_id}/electrons/0/assets"
-    resp = httpx.get(electron_assets_url)
+    resp = client.get(electron_assets_url)
     resp.raise_for_status()
     body = resp.json()
     electron_asset_map = {x["Name"]: x["Asset"] for x in body["Records"]}